@@ -60,8 +60,10 @@ func (wb *WriteBatch) Delete(key []byte) error {
 	wb.mu.Lock()
 	defer wb.mu.Unlock()
 
-	//数据不存在就直接返回
+	//数据不存在就直接返回，哈希索引读取key时需要持有数据库的锁
+	wb.db.mu.RLock()
 	logRecordPos := wb.db.indexer.Get(key)
+	wb.db.mu.RUnlock()
 	if logRecordPos == nil {
 		if wb.pendingWrites[string(key)] != nil {
 			delete(wb.pendingWrites, string(key))
@@ -81,6 +83,9 @@ func (wb *WriteBatch) Commit() error {
 	wb.mu.Lock()
 	defer wb.mu.Unlock()
 
+	if wb.db.options.ReadOnly {
+		return ErrReadOnly
+	}
 	if len(wb.pendingWrites) == 0 {
 		return nil
 	}
@@ -122,7 +127,7 @@ func (wb *WriteBatch) Commit() error {
 
 	//根据配置决定是否立即刷盘
 	if wb.opts.SyncWrites && wb.db.activeFile != nil {
-		if err := wb.db.activeFile.Sync(); err != nil {
+		if err := wb.db.syncFile(wb.db.activeFile); err != nil {
 			return err
 		}
 	}
@@ -142,6 +147,8 @@ func (wb *WriteBatch) Commit() error {
 			wb.db.reclaimSize += int64(oldPos.Size)
 		}
 	}
+	wb.db.updateIndexKeysGauge()
+	wb.db.maybeFlushIndex()
 
 	//清空暂存的数据，方便下一次commit
 	wb.pendingWrites = make(map[string]*data.LogRecord)
@@ -0,0 +1,163 @@
+package JDawDB
+
+import (
+	"bytes"
+	"errors"
+	"github.com/GrandeLai/JDawDB/data"
+	"github.com/GrandeLai/JDawDB/fio"
+	"github.com/GrandeLai/JDawDB/index"
+	"github.com/gofrs/flock"
+	"os"
+	"path/filepath"
+)
+
+// BulkLoader 向一个全新的目录批量导入数据
+// 数据直接写入数据文件，索引写入hint文件，不经过内存索引，Open时从hint文件加载索引而不需要重放数据文件
+type BulkLoader struct {
+	options    Options
+	fileLock   *flock.Flock
+	activeFile *data.DataFile //当前正在写入的数据文件
+	hintFile   *data.DataFile
+	lastKey    []byte //上一个写入的key，用于检查顺序
+	finished   bool
+}
+
+// NewBulkLoader 初始化BulkLoader，目录必须为空或者不存在，导入期间会持有目录的文件锁
+// b+tree索引不使用hint文件，不支持批量导入
+func NewBulkLoader(options Options) (*BulkLoader, error) {
+	if err := checkOptions(options); err != nil {
+		return nil, err
+	}
+	if options.IndexType == index.BPTree {
+		return nil, errors.New("bulk load does not support b+tree index")
+	}
+	if err := os.MkdirAll(options.DirPath, os.ModePerm); err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(options.DirPath)
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) > 0 {
+		return nil, ErrBulkLoadDirNotEmpty
+	}
+
+	fLock := flock.New(filepath.Join(options.DirPath, fileLockName))
+	hold, err := fLock.TryLock()
+	if err != nil {
+		return nil, err
+	}
+	if !hold {
+		return nil, ErrDatabaseIsUsing
+	}
+	return &BulkLoader{
+		options:  options,
+		fileLock: fLock,
+	}, nil
+}
+
+// Add 写入一条数据，key必须严格递增
+func (bl *BulkLoader) Add(key []byte, value []byte) error {
+	if bl.finished {
+		return ErrBulkLoadFinished
+	}
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	if bl.lastKey != nil && bytes.Compare(key, bl.lastKey) <= 0 {
+		return ErrBulkLoadNotSorted
+	}
+
+	if bl.activeFile == nil {
+		if err := bl.openFiles(); err != nil {
+			return err
+		}
+	}
+
+	encRecord, size := data.EncodeLogRecord(&data.LogRecord{
+		Key:   LogRecordKeyWithSeqNo(key, NonTxnSeqNo),
+		Value: value,
+		Type:  data.LogRecordNormal,
+	})
+	//写满之后切换到新的数据文件
+	if bl.activeFile.WriteOff+size > bl.options.DataFileSize {
+		if err := bl.activeFile.Sync(); err != nil {
+			return err
+		}
+		if err := bl.activeFile.Close(); err != nil {
+			return err
+		}
+		dataFile, err := data.OpenDataFile(bl.activeFile.FileId+1, bl.options.DirPath, fio.StandardFIO)
+		if err != nil {
+			return err
+		}
+		bl.activeFile = dataFile
+	}
+
+	pos := &data.LogRecordPos{
+		Fid:    bl.activeFile.FileId,
+		Offset: bl.activeFile.WriteOff,
+		Size:   uint32(size),
+	}
+	if err := bl.activeFile.Write(encRecord); err != nil {
+		return err
+	}
+	if err := bl.hintFile.WriteHintRecord(key, pos); err != nil {
+		return err
+	}
+	bl.lastKey = append(bl.lastKey[:0], key...)
+	return nil
+}
+
+func (bl *BulkLoader) openFiles() error {
+	dataFile, err := data.OpenDataFile(0, bl.options.DirPath, fio.StandardFIO)
+	if err != nil {
+		return err
+	}
+	hintFile, err := data.OpenHintFile(bl.options.DirPath)
+	if err != nil {
+		return err
+	}
+	bl.activeFile = dataFile
+	bl.hintFile = hintFile
+	return nil
+}
+
+// Finish 持久化所有数据并释放文件锁，之后可以用同样的配置Open
+// 没有调用Finish的目录也可以打开，但需要重放所有数据文件
+func (bl *BulkLoader) Finish() error {
+	if bl.finished {
+		return ErrBulkLoadFinished
+	}
+	bl.finished = true
+	defer func() {
+		_ = bl.fileLock.Unlock()
+	}()
+	if bl.activeFile == nil {
+		return nil
+	}
+
+	if err := bl.activeFile.Sync(); err != nil {
+		return err
+	}
+	if err := bl.activeFile.Close(); err != nil {
+		return err
+	}
+	if err := bl.hintFile.Sync(); err != nil {
+		return err
+	}
+	if err := bl.hintFile.Close(); err != nil {
+		return err
+	}
+
+	//创建一个空的数据文件作为Open之后的活跃文件，之前的数据文件都按照merge完成处理，只从hint文件加载索引
+	nonMergeFileId := bl.activeFile.FileId + 1
+	emptyFile, err := data.OpenDataFile(nonMergeFileId, bl.options.DirPath, fio.StandardFIO)
+	if err != nil {
+		return err
+	}
+	if err := emptyFile.Close(); err != nil {
+		return err
+	}
+	return writeMergeFinishedFile(bl.options.DirPath, nonMergeFileId)
+}
@@ -0,0 +1,65 @@
+package JDawDB
+
+import (
+	"github.com/GrandeLai/JDawDB/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func TestBulkLoader(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "JDawDB-bulk")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	defer os.RemoveAll(dir)
+
+	bl, err := NewBulkLoader(opts)
+	assert.Nil(t, err)
+	for i := 0; i < 10000; i++ {
+		err := bl.Add(utils.GetTestKey(i), utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	err = bl.Add(utils.GetTestKey(1), []byte("v"))
+	assert.Equal(t, ErrBulkLoadNotSorted, err)
+	err = bl.Add(nil, []byte("v"))
+	assert.Equal(t, ErrKeyIsEmpty, err)
+	err = bl.Finish()
+	assert.Nil(t, err)
+	err = bl.Add(utils.GetTestKey(10001), []byte("v"))
+	assert.Equal(t, ErrBulkLoadFinished, err)
+
+	_, err = NewBulkLoader(opts)
+	assert.Equal(t, ErrBulkLoadDirNotEmpty, err)
+
+	// 只从hint文件加载索引，不重放导入的数据文件
+	var replayed []uint32
+	opts.EventListener.OnRecoveryProgress = func(info RecoveryInfo) {
+		replayed = append(replayed, info.FileId)
+	}
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(replayed))
+	assert.Equal(t, 10000, len(db.ListKeys()))
+	val, err := db.Get(utils.GetTestKey(9999))
+	assert.Nil(t, err)
+	assert.Equal(t, utils.GetTestKey(9999), val)
+
+	// 导入之后可以正常读写
+	err = db.Put(utils.GetTestKey(10000), []byte("new"))
+	assert.Nil(t, err)
+	err = db.Delete(utils.GetTestKey(0))
+	assert.Nil(t, err)
+	err = db.Close()
+	assert.Nil(t, err)
+
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	defer db.Close()
+	assert.Equal(t, 10000, len(db.ListKeys()))
+	val, err = db.Get(utils.GetTestKey(10000))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("new"), val)
+	_, err = db.Get(utils.GetTestKey(0))
+	assert.Equal(t, ErrKeyNotFound, err)
+}
@@ -0,0 +1,263 @@
+package JDawDB
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"github.com/GrandeLai/JDawDB/data"
+	"github.com/GrandeLai/JDawDB/fio"
+	"github.com/GrandeLai/JDawDB/index"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// checkpoint文件的开头，用于识别文件格式
+const checkpointMagic = "JDCK"
+
+var errInvalidCheckpoint = errors.New("invalid index checkpoint")
+
+// indexCheckpoint 某一时刻的内存索引，以及它覆盖到的数据文件位置
+type indexCheckpoint struct {
+	fileId      uint32
+	offset      int64
+	seqNo       uint64
+	reclaimSize int64
+	iterator    index.Iterator
+}
+
+// Checkpoint 把内存索引保存到checkpoint文件中，下次启动时只需要重放之后写入的数据
+// b+tree索引本身就是持久化的，哈希索引不保存key，都不需要checkpoint
+func (db *DB) Checkpoint() error {
+	if db.options.ReadOnly {
+		return ErrReadOnly
+	}
+	if !db.writeCheckpoints() {
+		return nil
+	}
+	db.checkpointMu.Lock()
+	defer db.checkpointMu.Unlock()
+
+	db.mu.Lock()
+	cp := db.snapshotIndex()
+	db.mu.Unlock()
+	return db.writeCheckpoint(cp)
+}
+
+// writeCheckpoints 是否保存内存索引，哈希索引保存时需要读回所有的key，不如直接从hint文件加载
+// 磁盘索引自己会持久化，跳表的迭代器直接在跳表上遍历，释放锁之后的写入会混进checkpoint，无法和记录的位置对应
+func (db *DB) writeCheckpoints() bool {
+	switch db.options.IndexType {
+	case index.Hash, index.Disk, index.SkipList:
+		return false
+	}
+	return db.writeHints
+}
+
+// snapshotIndex 获取内存索引的快照，需要持有互斥锁，保证索引和数据文件的写入位置一致
+func (db *DB) snapshotIndex() *indexCheckpoint {
+	if db.activeFile == nil {
+		return nil
+	}
+	return &indexCheckpoint{
+		fileId:      db.activeFile.FileId,
+		offset:      db.activeFile.WriteOff,
+		seqNo:       db.seqNo,
+		reclaimSize: db.reclaimSize,
+		iterator:    db.indexer.Iterator(false),
+	}
+}
+
+// writeCheckpoint 写入checkpoint文件，先写临时文件再重命名
+// 文件格式：magic + 文件ID + 偏移量 + 事务序列号 + 无效数据量 + 多个(key长度 + key + 位置) + 0 + key数量 + crc
+func (db *DB) writeCheckpoint(cp *indexCheckpoint) error {
+	if cp == nil {
+		return nil
+	}
+	defer cp.iterator.Close()
+
+	fileName := filepath.Join(db.options.DirPath, data.IndexCheckpointFileName)
+	tmpFileName := fileName + ".tmp"
+	file, err := os.OpenFile(tmpFileName, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, fio.DataFilePerm)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = file.Close()
+		_ = os.Remove(tmpFileName)
+	}()
+
+	hash := crc32.NewIEEE()
+	w := bufio.NewWriter(io.MultiWriter(file, hash))
+	buf := make([]byte, binary.MaxVarintLen64)
+	putUvarint := func(v uint64) {
+		n := binary.PutUvarint(buf, v)
+		_, _ = w.Write(buf[:n])
+	}
+	putVarint := func(v int64) {
+		n := binary.PutVarint(buf, v)
+		_, _ = w.Write(buf[:n])
+	}
+
+	_, _ = w.WriteString(checkpointMagic)
+	putUvarint(uint64(cp.fileId))
+	putVarint(cp.offset)
+	putUvarint(cp.seqNo)
+	putVarint(cp.reclaimSize)
+	var count uint64
+	for cp.iterator.Rewind(); cp.iterator.Valid(); cp.iterator.Next() {
+		key, pos := cp.iterator.Key(), cp.iterator.Value()
+		putUvarint(uint64(len(key)))
+		_, _ = w.Write(key)
+		putUvarint(uint64(pos.Fid))
+		putVarint(pos.Offset)
+		putUvarint(uint64(pos.Size))
+		count++
+	}
+	//key不能为空，用长度0表示索引结束
+	putUvarint(0)
+	putUvarint(count)
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	crcBuf := make([]byte, crc32.Size)
+	binary.BigEndian.PutUint32(crcBuf, hash.Sum32())
+	if _, err := file.Write(crcBuf); err != nil {
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpFileName, fileName)
+}
+
+// loadIndexCheckpoint 从checkpoint文件中加载内存索引，返回checkpoint覆盖到的数据文件位置
+// checkpoint文件损坏或者和数据文件对不上时返回false，并清空已经加载的索引
+func (db *DB) loadIndexCheckpoint() (uint32, int64, bool, error) {
+	fileName := filepath.Join(db.options.DirPath, data.IndexCheckpointFileName)
+	if _, err := os.Stat(fileName); os.IsNotExist(err) {
+		return 0, 0, false, nil
+	}
+	cp, err := db.readCheckpoint(fileName)
+	if err != nil {
+		db.logger.Warn("index checkpoint is invalid, fall back to full replay", "err", err)
+		db.indexer, err = db.newIndexer()
+		return 0, 0, false, err
+	}
+	db.seqNo = cp.seqNo
+	db.reclaimSize = cp.reclaimSize
+	db.logger.Info("index checkpoint loaded", "fileId", cp.fileId, "offset", cp.offset)
+	return cp.fileId, cp.offset, true, nil
+}
+
+func (db *DB) readCheckpoint(fileName string) (*indexCheckpoint, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if stat.Size() < int64(len(checkpointMagic)+crc32.Size) {
+		return nil, errInvalidCheckpoint
+	}
+
+	hash := crc32.NewIEEE()
+	r := bufio.NewReader(io.TeeReader(io.LimitReader(file, stat.Size()-crc32.Size), hash))
+	magic := make([]byte, len(checkpointMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != checkpointMagic {
+		return nil, errInvalidCheckpoint
+	}
+
+	cp := &indexCheckpoint{}
+	fileId, err1 := binary.ReadUvarint(r)
+	offset, err2 := binary.ReadVarint(r)
+	seqNo, err3 := binary.ReadUvarint(r)
+	reclaimSize, err4 := binary.ReadVarint(r)
+	if err := errors.Join(err1, err2, err3, err4); err != nil {
+		return nil, errInvalidCheckpoint
+	}
+	cp.fileId, cp.offset, cp.seqNo, cp.reclaimSize = uint32(fileId), offset, seqNo, reclaimSize
+
+	//checkpoint覆盖到的位置必须还在数据文件中
+	dataFile := db.olderFiles[cp.fileId]
+	if db.activeFile != nil && db.activeFile.FileId == cp.fileId {
+		dataFile = db.activeFile
+	}
+	if dataFile == nil {
+		return nil, errInvalidCheckpoint
+	}
+	dataFileSize, err := dataFile.IoManager.Size()
+	if err != nil {
+		return nil, err
+	}
+	if dataFileSize < cp.offset {
+		return nil, errInvalidCheckpoint
+	}
+
+	var count uint64
+	for {
+		keySize, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, errInvalidCheckpoint
+		}
+		if keySize == 0 {
+			break
+		}
+		key := make([]byte, keySize)
+		if _, err := io.ReadFull(r, key); err != nil {
+			return nil, errInvalidCheckpoint
+		}
+		fid, err1 := binary.ReadUvarint(r)
+		off, err2 := binary.ReadVarint(r)
+		size, err3 := binary.ReadUvarint(r)
+		if errors.Join(err1, err2, err3) != nil || uint32(fid) > cp.fileId {
+			return nil, errInvalidCheckpoint
+		}
+		db.indexer.Put(key, &data.LogRecordPos{Fid: uint32(fid), Offset: off, Size: uint32(size)})
+		count++
+	}
+	expected, err := binary.ReadUvarint(r)
+	if err != nil || expected != count {
+		return nil, errInvalidCheckpoint
+	}
+	if _, err := r.ReadByte(); err != io.EOF {
+		return nil, errInvalidCheckpoint
+	}
+
+	crcBuf := make([]byte, crc32.Size)
+	if _, err := file.ReadAt(crcBuf, stat.Size()-crc32.Size); err != nil {
+		return nil, err
+	}
+	if binary.BigEndian.Uint32(crcBuf) != hash.Sum32() {
+		return nil, errInvalidCheckpoint
+	}
+	return cp, nil
+}
+
+// checkpointLoop 定期保存内存索引，直到数据库关闭
+func (db *DB) checkpointLoop(interval time.Duration) {
+	defer db.bgWg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := db.Checkpoint(); err != nil {
+				db.logger.Warn("failed to write index checkpoint", "err", err)
+			}
+		case <-db.closeCh:
+			return
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package JDawDB
+
+import (
+	"github.com/GrandeLai/JDawDB/data"
+	"github.com/GrandeLai/JDawDB/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDB_Checkpoint(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "JDawDB-checkpoint")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	var replayed []uint32
+	opts.EventListener.OnRecoveryProgress = func(info RecoveryInfo) {
+		replayed = append(replayed, info.FileId)
+	}
+	db, err := Open(opts)
+	defer func() {
+		destroyDB(db)
+	}()
+	assert.Nil(t, err)
+
+	for i := 0; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+	err = db.Checkpoint()
+	assert.Nil(t, err)
+	cpFileId := db.activeFile.FileId
+
+	// checkpoint之后的写入需要重放
+	for i := 1000; i < 1500; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+	for i := 0; i < 100; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	_ = wb.Put(utils.GetTestKey(2000), []byte("batch"))
+	err = wb.Commit()
+	assert.Nil(t, err)
+	reclaimSize := db.reclaimSize
+
+	// 模拟没有正常关闭
+	err = db.fileLock.Unlock()
+	assert.Nil(t, err)
+	replayed = nil
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, cpFileId, replayed[0])
+	assert.Equal(t, 1401, len(db.ListKeys()))
+	assert.Equal(t, reclaimSize, db.reclaimSize)
+	val, err := db.Get(utils.GetTestKey(2000))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("batch"), val)
+	_, err = db.Get(utils.GetTestKey(0))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// 正常关闭时保存checkpoint，重启时只需要加载活跃文件
+	activeFileId := db.activeFile.FileId
+	err = db.Close()
+	assert.Nil(t, err)
+	replayed = nil
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, []uint32{activeFileId}, replayed)
+	assert.Equal(t, 1401, len(db.ListKeys()))
+	err = db.Put(utils.GetTestKey(3000), []byte("after"))
+	assert.Nil(t, err)
+	err = db.Close()
+	assert.Nil(t, err)
+
+	// checkpoint损坏时重放所有数据文件
+	fileName := filepath.Join(dir, data.IndexCheckpointFileName)
+	content, err := os.ReadFile(fileName)
+	assert.Nil(t, err)
+	content[len(content)/2] ^= 0xff
+	err = os.WriteFile(fileName, content, 0644)
+	assert.Nil(t, err)
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 1402, len(db.ListKeys()))
+	val, err = db.Get(utils.GetTestKey(3000))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("after"), val)
+}
+
+func TestDB_CheckpointInterval(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "JDawDB-checkpoint-interval")
+	opts.DirPath = dir
+	opts.IndexCheckpointInterval = 10 * time.Millisecond
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	err = db.Put(utils.GetTestKey(1), utils.RandomValue(10))
+	assert.Nil(t, err)
+	fileName := filepath.Join(dir, data.IndexCheckpointFileName)
+	for i := 0; i < 100; i++ {
+		if _, err = os.Stat(fileName); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Nil(t, err)
+}
@@ -0,0 +1,165 @@
+package cluster
+
+import (
+	"encoding/binary"
+	"errors"
+	"github.com/GrandeLai/JDawDB"
+	"github.com/GrandeLai/JDawDB/data"
+	"github.com/GrandeLai/JDawDB/raft"
+)
+
+var ErrInvalidCommand = errors.New("invalid raft command")
+
+// Node 基于raft复制的JDawDB节点，写操作先提交到raft日志，再按顺序应用到每个节点的DB上
+type Node struct {
+	raft *raft.Node
+	fsm  *stateMachine
+}
+
+// NewNode 打开DB并初始化raft节点，transport需要把请求转发到返回节点的Raft()上
+// raft的目录config.DirPath不能放在DB的数据目录中，快照恢复时会替换整个数据目录
+func NewNode(config raft.Config, options JDawDB.Options, transport raft.Transport) (*Node, error) {
+	fsm, err := newStateMachine(options)
+	if err != nil {
+		return nil, err
+	}
+	node, err := raft.NewNode(config, fsm, transport)
+	if err != nil {
+		_ = fsm.close()
+		return nil, err
+	}
+	return &Node{
+		raft: node,
+		fsm:  fsm,
+	}, nil
+}
+
+// Raft 返回节点内部的raft实例
+func (n *Node) Raft() *raft.Node {
+	return n.raft
+}
+
+// Start 开始参与选举和日志复制
+func (n *Node) Start() {
+	n.raft.Start()
+}
+
+// Put 写入数据，只能在leader上调用
+func (n *Node) Put(key, value []byte) error {
+	if len(key) == 0 {
+		return JDawDB.ErrKeyIsEmpty
+	}
+	return n.raft.Propose(encodeCommand([]*data.LogRecord{{Key: key, Value: value, Type: data.LogRecordNormal}}))
+}
+
+// Delete 删除数据，只能在leader上调用
+func (n *Node) Delete(key []byte) error {
+	if len(key) == 0 {
+		return JDawDB.ErrKeyIsEmpty
+	}
+	return n.raft.Propose(encodeCommand([]*data.LogRecord{{Key: key, Type: data.LogRecordDeleted}}))
+}
+
+// Get 从本地DB读取数据，follower上可能读到旧数据
+func (n *Node) Get(key []byte) ([]byte, error) {
+	return n.fsm.get(key)
+}
+
+// NewWriteBatch 初始化批量写，提交时作为一条raft日志原子地应用
+func (n *Node) NewWriteBatch() *WriteBatch {
+	return &WriteBatch{node: n}
+}
+
+// Close 停止raft并关闭DB
+func (n *Node) Close() error {
+	n.raft.Stop()
+	return n.fsm.close()
+}
+
+// WriteBatch 集群中的批量写
+type WriteBatch struct {
+	node    *Node
+	records []*data.LogRecord
+}
+
+func (wb *WriteBatch) Put(key, value []byte) error {
+	if len(key) == 0 {
+		return JDawDB.ErrKeyIsEmpty
+	}
+	wb.records = append(wb.records, &data.LogRecord{Key: key, Value: value, Type: data.LogRecordNormal})
+	return nil
+}
+
+func (wb *WriteBatch) Delete(key []byte) error {
+	if len(key) == 0 {
+		return JDawDB.ErrKeyIsEmpty
+	}
+	wb.records = append(wb.records, &data.LogRecord{Key: key, Type: data.LogRecordDeleted})
+	return nil
+}
+
+// Commit 提交批量写
+func (wb *WriteBatch) Commit() error {
+	if len(wb.records) == 0 {
+		return nil
+	}
+	if err := wb.node.raft.Propose(encodeCommand(wb.records)); err != nil {
+		return err
+	}
+	wb.records = nil
+	return nil
+}
+
+// encodeCommand 编码一条raft日志：记录数 + 每条记录的 type + keySize + key + valueSize + value
+func encodeCommand(records []*data.LogRecord) []byte {
+	buf := make([]byte, binary.MaxVarintLen64)
+	index := binary.PutUvarint(buf, uint64(len(records)))
+	buf = buf[:index]
+	for _, record := range records {
+		header := make([]byte, 1+binary.MaxVarintLen64*2)
+		header[0] = record.Type
+		n := 1
+		n += binary.PutUvarint(header[n:], uint64(len(record.Key)))
+		buf = append(buf, header[:n]...)
+		buf = append(buf, record.Key...)
+		n = binary.PutUvarint(header, uint64(len(record.Value)))
+		buf = append(buf, header[:n]...)
+		buf = append(buf, record.Value...)
+	}
+	return buf
+}
+
+func decodeCommand(buf []byte) ([]*data.LogRecord, error) {
+	count, n := binary.Uvarint(buf)
+	if n <= 0 {
+		return nil, ErrInvalidCommand
+	}
+	index := n
+	records := make([]*data.LogRecord, 0, count)
+	for i := uint64(0); i < count; i++ {
+		if index >= len(buf) {
+			return nil, ErrInvalidCommand
+		}
+		record := &data.LogRecord{Type: buf[index]}
+		index++
+
+		keySize, n := binary.Uvarint(buf[index:])
+		if n <= 0 || index+n+int(keySize) > len(buf) {
+			return nil, ErrInvalidCommand
+		}
+		index += n
+		record.Key = buf[index : index+int(keySize)]
+		index += int(keySize)
+
+		valueSize, n := binary.Uvarint(buf[index:])
+		if n <= 0 || index+n+int(valueSize) > len(buf) {
+			return nil, ErrInvalidCommand
+		}
+		index += n
+		record.Value = buf[index : index+int(valueSize)]
+		index += int(valueSize)
+
+		records = append(records, record)
+	}
+	return records, nil
+}
@@ -0,0 +1,161 @@
+package cluster
+
+import (
+	"fmt"
+	"github.com/GrandeLai/JDawDB"
+	"github.com/GrandeLai/JDawDB/raft"
+	"github.com/GrandeLai/JDawDB/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+	"time"
+)
+
+func newTestCluster(t *testing.T, snapshotThreshold uint64) ([]*Node, *raft.InmemTransport) {
+	transport := raft.NewInmemTransport()
+	peers := []string{"node-0", "node-1", "node-2"}
+	var nodes []*Node
+	for _, id := range peers {
+		config := raft.DefaultConfig
+		config.ID = id
+		config.Peers = peers
+		config.ElectionTimeout = 100 * time.Millisecond
+		config.HeartbeatInterval = 20 * time.Millisecond
+		config.SnapshotThreshold = snapshotThreshold
+
+		opts := JDawDB.DefaultOptions
+		dir, _ := os.MkdirTemp("", "JDawDB-cluster-"+id)
+		opts.DirPath = dir
+		config.DirPath = dir + "-raft"
+		node, err := NewNode(config, opts, transport)
+		assert.Nil(t, err)
+		transport.Register(node.Raft())
+		nodes = append(nodes, node)
+	}
+	for _, node := range nodes {
+		node.Start()
+	}
+	return nodes, transport
+}
+
+func destroyCluster(nodes []*Node) {
+	for _, node := range nodes {
+		_ = node.Close()
+		_ = os.RemoveAll(node.fsm.options.DirPath)
+		_ = os.RemoveAll(node.fsm.options.DirPath + "-raft")
+	}
+}
+
+func waitLeader(nodes []*Node) *Node {
+	for i := 0; i < 200; i++ {
+		for _, node := range nodes {
+			if node.Raft().IsLeader() {
+				return node
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return nil
+}
+
+func waitValue(node *Node, key, expected []byte) bool {
+	for i := 0; i < 200; i++ {
+		val, err := node.Get(key)
+		if expected == nil && err == JDawDB.ErrKeyNotFound {
+			return true
+		}
+		if err == nil && string(val) == string(expected) {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return false
+}
+
+func TestNode_PutDelete(t *testing.T) {
+	nodes, _ := newTestCluster(t, 0)
+	defer destroyCluster(nodes)
+
+	leader := waitLeader(nodes)
+	assert.NotNil(t, leader)
+
+	err := leader.Put(utils.GetTestKey(1), []byte("v1"))
+	assert.Nil(t, err)
+	err = leader.Put(utils.GetTestKey(2), []byte("v2"))
+	assert.Nil(t, err)
+	err = leader.Delete(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	err = leader.Put(nil, []byte("v"))
+	assert.Equal(t, JDawDB.ErrKeyIsEmpty, err)
+
+	for _, node := range nodes {
+		assert.True(t, waitValue(node, utils.GetTestKey(1), []byte("v1")))
+		assert.True(t, waitValue(node, utils.GetTestKey(2), nil))
+		if node != leader {
+			err := node.Put(utils.GetTestKey(3), []byte("v3"))
+			assert.Equal(t, raft.ErrNotLeader, err)
+		}
+	}
+}
+
+func TestNode_WriteBatch(t *testing.T) {
+	nodes, _ := newTestCluster(t, 0)
+	defer destroyCluster(nodes)
+
+	leader := waitLeader(nodes)
+	assert.NotNil(t, leader)
+
+	err := leader.Put(utils.GetTestKey(1), []byte("v1"))
+	assert.Nil(t, err)
+
+	wb := leader.NewWriteBatch()
+	for i := 10; i < 20; i++ {
+		_ = wb.Put(utils.GetTestKey(i), []byte(fmt.Sprint(i)))
+	}
+	_ = wb.Delete(utils.GetTestKey(1))
+	err = wb.Commit()
+	assert.Nil(t, err)
+
+	for _, node := range nodes {
+		assert.True(t, waitValue(node, utils.GetTestKey(19), []byte("19")))
+		assert.True(t, waitValue(node, utils.GetTestKey(1), nil))
+	}
+}
+
+func TestNode_Snapshot(t *testing.T) {
+	nodes, transport := newTestCluster(t, 10)
+	defer destroyCluster(nodes)
+
+	leader := waitLeader(nodes)
+	assert.NotNil(t, leader)
+
+	var lagging *Node
+	for _, node := range nodes {
+		if node != leader {
+			lagging = node
+			break
+		}
+	}
+	transport.Disconnect(lagging.Raft().ID())
+
+	for i := 0; i < 50; i++ {
+		err := leader.Put(utils.GetTestKey(i), []byte(fmt.Sprint(i)))
+		assert.Nil(t, err)
+	}
+	assert.True(t, leader.Raft().SnapshotIndex() > 0)
+
+	// 落后的节点通过备份的数据文件恢复
+	transport.Connect(lagging.Raft().ID())
+	assert.True(t, waitValue(lagging, utils.GetTestKey(49), []byte("49")))
+	assert.True(t, waitValue(lagging, utils.GetTestKey(0), []byte("0")))
+}
+
+func TestDecodeCommand(t *testing.T) {
+	buf := encodeCommand(nil)
+	records, err := decodeCommand(buf)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(records))
+
+	_, err = decodeCommand([]byte{2, 0, 10})
+	assert.Equal(t, ErrInvalidCommand, err)
+}
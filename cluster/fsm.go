@@ -0,0 +1,193 @@
+package cluster
+
+import (
+	"bufio"
+	"encoding/binary"
+	"github.com/GrandeLai/JDawDB"
+	"github.com/GrandeLai/JDawDB/data"
+	"github.com/GrandeLai/JDawDB/fio"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// stateMachine 把raft日志应用到DB上，快照使用DB.Backup生成
+type stateMachine struct {
+	mu      *sync.RWMutex
+	db      *JDawDB.DB
+	options JDawDB.Options
+}
+
+func newStateMachine(options JDawDB.Options) (*stateMachine, error) {
+	db, err := JDawDB.Open(options)
+	if err != nil {
+		return nil, err
+	}
+	return &stateMachine{
+		mu:      new(sync.RWMutex),
+		db:      db,
+		options: options,
+	}, nil
+}
+
+func (sm *stateMachine) Apply(buf []byte) error {
+	records, err := decodeCommand(buf)
+	if err != nil {
+		return err
+	}
+
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+
+	//单条操作直接写入，多条操作通过WriteBatch保证原子性
+	if len(records) == 1 {
+		if records[0].Type == data.LogRecordDeleted {
+			return sm.db.Delete(records[0].Key)
+		}
+		return sm.db.Put(records[0].Key, records[0].Value)
+	}
+
+	opts := JDawDB.DefaultWriteBatchOptions
+	if uint(len(records)) > opts.MaxBatchNum {
+		opts.MaxBatchNum = uint(len(records))
+	}
+	wb := sm.db.NewWriteBatch(opts)
+	for _, record := range records {
+		if record.Type == data.LogRecordDeleted {
+			err = wb.Delete(record.Key)
+		} else {
+			err = wb.Put(record.Key, record.Value)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return wb.Commit()
+}
+
+// Snapshot 先备份到临时目录，再把目录中的文件依次写入w：nameSize + name + contentSize + content
+// 文件内容直接从备份目录复制到w，不会整体读入内存
+func (sm *stateMachine) Snapshot(w io.Writer) error {
+	backupDir, err := os.MkdirTemp("", "JDawDB-raft-snapshot")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.RemoveAll(backupDir)
+	}()
+
+	sm.mu.RLock()
+	err = sm.db.Backup(backupDir)
+	sm.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	header := make([]byte, binary.MaxVarintLen64)
+	return filepath.Walk(backupDir, func(path string, info fs.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		name, err := filepath.Rel(backupDir, path)
+		if err != nil {
+			return err
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		n := binary.PutUvarint(header, uint64(len(name)))
+		if _, err := w.Write(header[:n]); err != nil {
+			return err
+		}
+		if _, err := io.WriteString(w, name); err != nil {
+			return err
+		}
+		n = binary.PutUvarint(header, uint64(info.Size()))
+		if _, err := w.Write(header[:n]); err != nil {
+			return err
+		}
+		_, err = io.CopyN(w, file, info.Size())
+		return err
+	})
+}
+
+// Restore 关闭DB，用快照中的文件替换数据目录后重新打开
+func (sm *stateMachine) Restore(r io.Reader) error {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	if err := sm.db.Close(); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(sm.options.DirPath); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(sm.options.DirPath, os.ModePerm); err != nil {
+		return err
+	}
+
+	rd := bufio.NewReader(r)
+	for {
+		nameSize, err := binary.ReadUvarint(rd)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return ErrInvalidCommand
+		}
+		name := make([]byte, nameSize)
+		if _, err := io.ReadFull(rd, name); err != nil {
+			return ErrInvalidCommand
+		}
+		contentSize, err := binary.ReadUvarint(rd)
+		if err != nil {
+			return ErrInvalidCommand
+		}
+		if err := restoreFile(filepath.Join(sm.options.DirPath, string(name)), rd, int64(contentSize)); err != nil {
+			return err
+		}
+	}
+
+	db, err := JDawDB.Open(sm.options)
+	if err != nil {
+		return err
+	}
+	sm.db = db
+	return nil
+}
+
+// restoreFile 从快照中复制size字节到文件
+func restoreFile(fileName string, r io.Reader, size int64) error {
+	if err := os.MkdirAll(filepath.Dir(fileName), os.ModePerm); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(fileName, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, fio.DataFilePerm)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if _, err := io.CopyN(file, r, size); err != nil {
+		if err == io.EOF {
+			return ErrInvalidCommand
+		}
+		return err
+	}
+	return nil
+}
+
+func (sm *stateMachine) get(key []byte) ([]byte, error) {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	return sm.db.Get(key)
+}
+
+func (sm *stateMachine) close() error {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	return sm.db.Close()
+}
@@ -6,6 +6,7 @@ import (
 	"github.com/GrandeLai/JDawDB/fio"
 	"hash/crc32"
 	"io"
+	"os"
 	"path/filepath"
 )
 
@@ -14,10 +15,13 @@ var (
 )
 
 const (
-	DataFileNameSuffix    = ".data"
-	HintFileName          = "hint-index"
-	MergeFinishedFileName = "merge-finished"
-	SeqNoFileName         = "seq-no"
+	DataFileNameSuffix      = ".data"
+	HintFileNameSuffix      = ".hint"
+	HintFileName            = "hint-index"
+	MergeFinishedFileName   = "merge-finished"
+	SeqNoFileName           = "seq-no"
+	ReplOffsetFileName      = "repl-offset"
+	IndexCheckpointFileName = "index-checkpoint"
 )
 
 // DataFile 数据文件
@@ -33,6 +37,38 @@ func OpenDataFile(fileId uint32, dirPath string, ioType fio.FileIOType) (file *D
 	return NewDataFile(fileName, fileId, ioType)
 }
 
+// GetHintFileName 获取数据文件对应的hint文件名
+func GetHintFileName(dirPath string, fileId uint32) string {
+	return filepath.Join(dirPath, fmt.Sprintf("%09d", fileId)+HintFileNameSuffix)
+}
+
+// OpenDataHintFile 打开数据文件对应的hint文件
+func OpenDataHintFile(fileId uint32, dirPath string) (hintFile *DataFile, err error) {
+	return NewDataFile(GetHintFileName(dirPath, fileId), fileId, fio.StandardFIO)
+}
+
+// WriteDataHintFile 写入数据文件对应的hint文件，先写临时文件再重命名，保证hint文件总是完整的
+func WriteDataHintFile(dirPath string, fileId uint32, buf []byte) error {
+	fileName := GetHintFileName(dirPath, fileId)
+	tmpFileName := fileName + ".tmp"
+	file, err := os.OpenFile(tmpFileName, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, fio.DataFilePerm)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(buf); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpFileName, fileName)
+}
+
 // OpenHintFile 打开hint索引文件
 func OpenHintFile(dirPath string) (hintFile *DataFile, err error) {
 	fileName := filepath.Join(dirPath, HintFileName)
@@ -51,6 +87,12 @@ func OpenSeqNoFile(dirPath string) (seqNoFile *DataFile, err error) {
 	return NewDataFile(fileName, 0, fio.StandardFIO)
 }
 
+// OpenReplOffsetFile 打开一个存储从节点复制位置的文件
+func OpenReplOffsetFile(dirPath string) (replOffsetFile *DataFile, err error) {
+	fileName := filepath.Join(dirPath, ReplOffsetFileName)
+	return NewDataFile(fileName, 0, fio.StandardFIO)
+}
+
 func GetDataFileName(dirPath string, fileId uint32) string {
 	return filepath.Join(dirPath, fmt.Sprintf("%09d", fileId)+DataFileNameSuffix)
 }
@@ -169,3 +211,27 @@ func (file *DataFile) SetIOManager(dirPath string, ioType fio.FileIOType) error
 	file.IoManager = ioManager
 	return nil
 }
+
+// ReadLogRecordKey 根据偏移量只读取记录的key，不读取value，因此不校验crc
+func (file *DataFile) ReadLogRecordKey(offset int64) ([]byte, error) {
+	fileSize, err := file.IoManager.Size()
+	if err != nil {
+		return nil, err
+	}
+	var headerBytes int64 = maxLogRecordHeaderSize
+	if offset+headerBytes > fileSize {
+		headerBytes = fileSize - offset
+	}
+	if headerBytes <= 0 {
+		return nil, io.EOF
+	}
+	headerBuf, err := file.readNBytes(headerBytes, offset)
+	if err != nil {
+		return nil, err
+	}
+	header, headerSize := DecodeLogRecordHeader(headerBuf)
+	if header == nil || (header.crc == 0 && header.keySize == 0 && header.valueSize == 0) {
+		return nil, io.EOF
+	}
+	return file.readNBytes(int64(header.keySize), offset+headerSize)
+}
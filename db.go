@@ -1,11 +1,13 @@
 package JDawDB
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/GrandeLai/JDawDB/data"
 	"github.com/GrandeLai/JDawDB/fio"
 	"github.com/GrandeLai/JDawDB/index"
+	"github.com/GrandeLai/JDawDB/metrics"
 	"github.com/GrandeLai/JDawDB/utils"
 	"github.com/gofrs/flock"
 	"io"
@@ -15,6 +17,8 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 const (
@@ -26,17 +30,29 @@ const (
 type DB struct {
 	options         Options //文件配置项
 	mu              *sync.RWMutex
-	fileIds         []int                     //有序的数据文件ID列表
-	activeFile      *data.DataFile            //当前活跃数据文件
-	olderFiles      map[uint32]*data.DataFile //旧的数据文件，只读
-	indexer         index.Indexer             //内存索引
-	seqNo           uint64                    //事务序列号，全局递增
-	isMerging       bool                      //当前是否有merge操作在进行
-	seqNoFileExists bool                      //seqNo文件是否存在，存在才能进行writebatch操作
-	isInitial       bool                      //是否是第一次初始化
-	fileLock        *flock.Flock              //文件锁保证多进程之间的互斥
-	bytesWrite      uint                      //累计已写字节数
-	reclaimSize     int64                     //表示当前无效的数据数
+	fileIds         []int                                   //有序的数据文件ID列表
+	activeFile      *data.DataFile                          //当前活跃数据文件
+	olderFiles      map[uint32]*data.DataFile               //旧的数据文件，只读
+	indexer         index.Indexer                           //内存索引
+	seqNo           uint64                                  //事务序列号，全局递增
+	isMerging       bool                                    //当前是否有merge操作在进行
+	mergeEpoch      uint32                                  //最近一次merge的nonMergeFileId，从节点据此判断复制位置是否失效
+	seqNoFileExists bool                                    //seqNo文件是否存在，存在才能进行writebatch操作
+	isInitial       bool                                    //是否是第一次初始化
+	fileLock        *flock.Flock                            //文件锁保证多进程之间的互斥
+	bytesWrite      uint                                    //累计已写字节数
+	reclaimSize     int64                                   //表示当前无效的数据数
+	metrics         metrics.Metrics                         //指标上报
+	logger          Logger                                  //日志输出
+	iteratorNum     int64                                   //还没有关闭的迭代器数量
+	txnRecords      map[uint64][]*data.TransactionLogRecord //加载索引时还没有读到提交标识的事务数据
+	writeHints      bool                                    //活跃文件切换时是否为旧文件写入hint文件
+	activeHints     []byte                                  //活跃文件中所有记录编码后的hint
+	activeHintsFull bool                                    //activeHints是否包含了活跃文件中的所有记录
+	checkpointMu    *sync.Mutex                             //保证同一时间只有一个checkpoint在写入
+	closeCh         chan struct{}                           //关闭时通知后台任务退出
+	bgWg            *sync.WaitGroup                         //等待后台任务退出
+	hintWg          *sync.WaitGroup                         //等待后台写入的hint文件
 }
 
 // Stat 存储引擎的统计信息
@@ -56,6 +72,10 @@ func Open(options Options) (db *DB, err error) {
 	var isInitial bool
 	//对传递的目录进行校验，如果不存在则创建
 	if _, err := os.Stat(options.DirPath); os.IsNotExist(err) {
+		//只读模式下不能创建目录
+		if options.ReadOnly {
+			return nil, err
+		}
 		isInitial = true
 		if err := os.MkdirAll(options.DirPath, os.ModePerm); err != nil {
 			return nil, err
@@ -63,13 +83,17 @@ func Open(options Options) (db *DB, err error) {
 	}
 
 	//判断当前数据目录是否正在使用，加上文件锁
-	fLock := flock.New(filepath.Join(options.DirPath, fileLockName))
-	hold, err := fLock.TryLock()
-	if err != nil {
-		return nil, err
-	}
-	if !hold {
-		return nil, ErrDatabaseIsUsing
+	//只读模式下不加锁，可以和写入的进程同时打开同一个目录
+	var fLock *flock.Flock
+	if !options.ReadOnly {
+		fLock = flock.New(filepath.Join(options.DirPath, fileLockName))
+		hold, err := fLock.TryLock()
+		if err != nil {
+			return nil, err
+		}
+		if !hold {
+			return nil, ErrDatabaseIsUsing
+		}
 	}
 
 	entries, err := os.ReadDir(options.DirPath)
@@ -82,16 +106,40 @@ func Open(options Options) (db *DB, err error) {
 	}
 	//初始化DB实例结构体
 	db = &DB{
-		options:    options,
-		mu:         new(sync.RWMutex),
-		olderFiles: make(map[uint32]*data.DataFile),
-		indexer:    index.NewIndexer(options.IndexType, options.DirPath, options.SyncWrites),
-		isInitial:  isInitial,
-		fileLock:   fLock,
+		options:      options,
+		mu:           new(sync.RWMutex),
+		olderFiles:   make(map[uint32]*data.DataFile),
+		isInitial:    isInitial,
+		fileLock:     fLock,
+		metrics:      options.Metrics,
+		logger:       options.Logger,
+		checkpointMu: new(sync.Mutex),
+		closeCh:      make(chan struct{}),
+		bgWg:         new(sync.WaitGroup),
+		hintWg:       new(sync.WaitGroup),
+	}
+	if db.metrics == nil {
+		db.metrics = metrics.Nop
+	}
+	if db.logger == nil {
+		db.logger = nopLogger{}
+	}
+	//b+tree索引不需要从数据文件中加载索引，也就不需要hint文件
+	db.writeHints = options.IndexType != index.BPTree && !options.ReadOnly
+
+	//加载merge数据目录，只读模式下由写入的进程处理
+	if !options.ReadOnly {
+		if err := db.loadMergeFiles(); err != nil {
+			return nil, err
+		}
+	}
+
+	if db.mergeEpoch, err = db.loadMergeEpoch(); err != nil {
+		return nil, err
 	}
 
-	//加载merge数据目录
-	if err := db.loadMergeFiles(); err != nil {
+	//merge完成时会替换数据目录中的文件，之后再打开索引
+	if db.indexer, err = db.newIndexer(); err != nil {
 		return nil, err
 	}
 
@@ -102,13 +150,27 @@ func Open(options Options) (db *DB, err error) {
 
 	//b+tree索引不需要从数据文件中加载索引
 	if options.IndexType != index.BPTree {
-		//从hint文件中加载索引
-		if err := db.loadIndexFromHintFile(); err != nil {
+		//优先从checkpoint文件或者磁盘索引中加载索引，没有可用的索引时从hint文件中加载索引
+		var startFileId uint32
+		var startOffset int64
+		var loaded bool
+		var err error
+		if options.IndexType == index.Disk {
+			startFileId, startOffset, loaded, err = db.loadDiskIndexMeta()
+		} else {
+			startFileId, startOffset, loaded, err = db.loadIndexCheckpoint()
+		}
+		if err != nil {
 			return nil, err
 		}
+		if !loaded {
+			if err := db.loadIndexFromHintFile(); err != nil {
+				return nil, err
+			}
+		}
 
 		//从数据文件中加载索引
-		if err := db.loadIndexFromDataFiles(); err != nil {
+		if err := db.loadIndexFromDataFiles(startFileId, startOffset); err != nil {
 			return nil, err
 		}
 
@@ -133,6 +195,13 @@ func Open(options Options) (db *DB, err error) {
 			db.activeFile.WriteOff = size
 		}
 	}
+	db.updateIndexKeysGauge()
+
+	//定期保存内存索引
+	if options.IndexCheckpointInterval > 0 && db.writeCheckpoints() {
+		db.bgWg.Add(1)
+		go db.checkpointLoop(options.IndexCheckpointInterval)
+	}
 
 	return db, nil
 }
@@ -147,14 +216,21 @@ func checkOptions(options Options) error {
 	if options.DataFileMergeRatio < 0 || options.DataFileMergeRatio > 1 {
 		return errors.New("invalid merge ratio, must between 0 and 1")
 	}
+	if options.ReadOnly && (options.IndexType == index.BPTree || options.IndexType == index.Disk) {
+		return errors.New("read-only mode does not support b+tree or disk index")
+	}
 	return nil
 }
 
 // Put 向数据库中写入K/V数据，Key不能为空
 func (db *DB) Put(key []byte, value []byte) error {
+	if db.options.ReadOnly {
+		return ErrReadOnly
+	}
 	if len(key) == 0 {
 		return ErrKeyIsEmpty
 	}
+	defer db.observeSince(metrics.PutDuration, time.Now())
 
 	//构造LogRecord结构体
 	logRecord := &data.LogRecord{
@@ -163,8 +239,12 @@ func (db *DB) Put(key []byte, value []byte) error {
 		Type:  data.LogRecordNormal,
 	}
 
+	//写数据文件和更新内存索引都在锁的保护下进行，保证索引和数据文件的写入位置一致
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
 	//第一步：追加写入到数据文件
-	pos, err := db.appendLogRecordWithLock(logRecord)
+	pos, err := db.appendLogRecord(logRecord)
 	if err != nil {
 		return err
 	}
@@ -173,11 +253,22 @@ func (db *DB) Put(key []byte, value []byte) error {
 		db.reclaimSize += int64(oldPos.Size)
 	}
 	//_ = db.indexer.Put(key, pos)
+	db.updateIndexKeysGauge()
+	db.maybeFlushIndex()
 	return nil
 }
 
+// PutCtx 和Put相同，ctx已经结束时不写入并返回ctx.Err()
+func (db *DB) PutCtx(ctx context.Context, key []byte, value []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return db.Put(key, value)
+}
+
 // Get 根据Key从数据库中读取数据
 func (db *DB) Get(key []byte) ([]byte, error) {
+	defer db.observeSince(metrics.GetDuration, time.Now())
 
 	//读数据时需要进行锁的保护
 	db.mu.RLock()
@@ -197,12 +288,52 @@ func (db *DB) Get(key []byte) ([]byte, error) {
 	return db.GetValueByPosition(logRecordPos)
 }
 
+// GetCtx 和Get相同，ctx已经结束时不读取并返回ctx.Err()
+func (db *DB) GetCtx(ctx context.Context, key []byte) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return db.Get(key)
+}
+
+// Has 判断key是否存在，只查询索引，不读取value
+// 哈希索引只保存key的指纹，需要从数据文件中读回key进行确认，返回ErrNotSupported
+func (db *DB) Has(key []byte) (bool, error) {
+	if len(key) == 0 {
+		return false, ErrKeyIsEmpty
+	}
+	if db.options.IndexType == index.Hash {
+		return false, ErrNotSupported
+	}
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.indexer.Get(key) != nil, nil
+}
+
+// CountPrefix 统计以prefix开头的key数量，prefix为空时返回所有key的数量，只查询索引
+// 哈希索引需要从数据文件中读回key才能匹配前缀，prefix不为空时返回ErrNotSupported
+func (db *DB) CountPrefix(prefix []byte) (int, error) {
+	if len(prefix) > 0 && db.options.IndexType == index.Hash {
+		return 0, ErrNotSupported
+	}
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return index.CountPrefix(db.indexer, prefix), nil
+}
+
 // Delete 根据key删除对应的数据
 func (db *DB) Delete(key []byte) error {
+	if db.options.ReadOnly {
+		return ErrReadOnly
+	}
 	//判断key的有效性
 	if len(key) == 0 {
 		return ErrKeyIsEmpty
 	}
+	defer db.observeSince(metrics.DeleteDuration, time.Now())
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
 
 	//检查key是否存在
 	if pos := db.indexer.Get(key); pos == nil {
@@ -214,7 +345,7 @@ func (db *DB) Delete(key []byte) error {
 		Type: data.LogRecordDeleted,
 	}
 	//写入到数据文件中
-	pos, err := db.appendLogRecordWithLock(logRecord)
+	pos, err := db.appendLogRecord(logRecord)
 	if err != nil {
 		return err
 	}
@@ -228,42 +359,62 @@ func (db *DB) Delete(key []byte) error {
 	if oldPos != nil {
 		db.reclaimSize += int64(oldPos.Size)
 	}
+	db.updateIndexKeysGauge()
+	db.maybeFlushIndex()
 	return nil
 }
 
 // Close 关闭数据库
 func (db *DB) Close() error {
 	defer func() {
-		// 释放文件锁
-		if err := db.fileLock.Unlock(); err != nil {
-			panic(fmt.Sprintf("failed to unlock the directory, %v", err))
+		// 等待后台写入的hint文件
+		db.hintWg.Wait()
+		// 释放文件锁，只读模式下没有加锁
+		if db.fileLock != nil {
+			if err := db.fileLock.Unlock(); err != nil {
+				panic(fmt.Sprintf("failed to unlock the directory, %v", err))
+			}
 		}
 		// 关闭索引
 		if err := db.indexer.Close(); err != nil {
 			panic(fmt.Sprintf("failed to close index"))
 		}
 	}()
+	//先等待后台任务退出，后台任务中会获取锁
+	select {
+	case <-db.closeCh:
+	default:
+		close(db.closeCh)
+	}
+	db.bgWg.Wait()
+
 	if db.activeFile == nil {
 		return nil
 	}
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
-	//需要保存当前事务序列号
-	seqNoFile, err := data.OpenSeqNoFile(db.options.DirPath)
-	if err != nil {
-		return err
-	}
-	record := &data.LogRecord{
-		Key:   []byte(seqNoKey),
-		Value: []byte(strconv.FormatUint(db.seqNo, 10)),
+	//需要保存当前事务序列号，只读模式下不写入任何文件
+	if !db.options.ReadOnly {
+		if err := db.saveSeqNo(); err != nil {
+			return err
+		}
 	}
-	encRecord, _ := data.EncodeLogRecord(record)
-	if err := seqNoFile.Write(encRecord); err != nil {
-		return err
+
+	//保存活跃文件的hint和内存索引，下次启动时不需要重放数据文件，失败时只影响启动速度
+	db.writeActiveHintFile()
+	if diskIndex, ok := db.indexer.(*index.DiskIndex); ok && !db.options.ReadOnly {
+		if err := db.flushDiskIndex(diskIndex); err != nil {
+			db.logger.Warn("failed to flush disk index", "err", err)
+		}
 	}
-	if err := seqNoFile.Sync(); err != nil {
-		return err
+	if db.writeCheckpoints() {
+		db.checkpointMu.Lock()
+		err := db.writeCheckpoint(db.snapshotIndex())
+		db.checkpointMu.Unlock()
+		if err != nil {
+			db.logger.Warn("failed to write index checkpoint", "err", err)
+		}
 	}
 
 	//关闭当前活跃的数据文件
@@ -280,20 +431,58 @@ func (db *DB) Close() error {
 	return nil
 }
 
+// saveSeqNo 保存当前事务序列号
+func (db *DB) saveSeqNo() error {
+	seqNoFile, err := data.OpenSeqNoFile(db.options.DirPath)
+	if err != nil {
+		return err
+	}
+	record := &data.LogRecord{
+		Key:   []byte(seqNoKey),
+		Value: []byte(strconv.FormatUint(db.seqNo, 10)),
+	}
+	encRecord, _ := data.EncodeLogRecord(record)
+	if err := seqNoFile.Write(encRecord); err != nil {
+		return err
+	}
+	return seqNoFile.Sync()
+}
+
 // Sync 持久化数据文件
 func (db *DB) Sync() error {
-	if db.activeFile == nil {
+	if db.activeFile == nil || db.options.ReadOnly {
 		return nil
 	}
 
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
-	return db.activeFile.Sync()
+	return db.syncFile(db.activeFile)
+}
+
+// SyncCtx 和Sync相同，ctx结束时立即返回ctx.Err()
+// fsync无法被中断，已经开始的Sync会在后台继续执行并一直持有db的写锁，之后的读写仍然要等它完成；
+// 返回ctx.Err()时数据不一定已经持久化，需要确认时再调用一次Sync
+func (db *DB) SyncCtx(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- db.Sync()
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
 // ListKeys 获取数据文件中所有的key
 func (db *DB) ListKeys() [][]byte {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
 	iterator := db.indexer.Iterator(false)
 	defer iterator.Close()
 	keys := make([][]byte, db.indexer.Size())
@@ -307,12 +496,23 @@ func (db *DB) ListKeys() [][]byte {
 
 // Fold 获取数据文件中所有的key，并按照传入的方法执行相对应的操作，返回false时停止遍历
 func (db *DB) Fold(callback func(key []byte, value []byte) bool) error {
+	return db.FoldCtx(context.Background(), callback)
+}
+
+// FoldCtx 和Fold相同，每读取一条记录前都会检查ctx，ctx结束时停止遍历并返回ctx.Err()
+func (db *DB) FoldCtx(ctx context.Context, callback func(key []byte, value []byte) bool) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	db.mu.RLock()
 	defer db.mu.RUnlock()
 
 	iterator := db.indexer.Iterator(false)
 	defer iterator.Close()
 	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 		value, err := db.GetValueByPosition(iterator.Value())
 		if err != nil {
 			return err
@@ -326,7 +526,7 @@ func (db *DB) Fold(callback func(key []byte, value []byte) bool) error {
 }
 
 // Stat 返回数据库的相关统计信息
-func (db *DB) Stat() *Stat {
+func (db *DB) Stat() (*Stat, error) {
 	db.mu.RLock()
 	defer db.mu.RUnlock()
 
@@ -337,14 +537,58 @@ func (db *DB) Stat() *Stat {
 
 	dirSize, err := utils.DirSize(db.options.DirPath)
 	if err != nil {
-		panic(fmt.Sprintf("failed to get dir size : %v", err))
+		return nil, err
 	}
+	keyNum := db.indexer.Size()
+	db.metrics.SetGauge(metrics.IndexKeys, float64(keyNum))
 	return &Stat{
-		KeyNum:          uint(db.indexer.Size()),
+		KeyNum:          uint(keyNum),
 		DataFileNum:     dataFiles,
 		ReclaimableSize: db.reclaimSize,
 		DiskSize:        dirSize,
+	}, nil
+}
+
+// Backup 备份数据库，将数据文件拷贝到新的目录中
+func (db *DB) Backup(dir string) error {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return utils.CopyDir(db.options.DirPath, dir, []string{fileLockName, "*.tmp"})
+}
+
+// newIndexer 根据配置创建索引，哈希索引需要从数据文件中读取key，磁盘索引需要设置缓存大小
+func (db *DB) newIndexer() (index.Indexer, error) {
+	if db.options.IndexType == index.Disk {
+		return index.NewDiskIndex(db.options.DirPath, db.options.DiskIndexCacheSize)
+	}
+	indexer, err := index.NewIndexer(db.options.IndexType, db.options.DirPath, db.options.SyncWrites)
+	if err != nil {
+		return nil, err
+	}
+	if hashIndex, ok := indexer.(*index.HashIndex); ok {
+		hashIndex.SetKeyReader(db.readKeyByPosition)
 	}
+	return indexer, nil
+}
+
+// readKeyByPosition 读取位置对应的记录的key，不包含事务序列号
+// 哈希索引在读写时调用，调用方需要持有数据库的锁
+func (db *DB) readKeyByPosition(pos *data.LogRecordPos) ([]byte, error) {
+	var dataFile *data.DataFile
+	if db.activeFile != nil && db.activeFile.FileId == pos.Fid {
+		dataFile = db.activeFile
+	} else {
+		dataFile = db.olderFiles[pos.Fid]
+	}
+	if dataFile == nil {
+		return nil, ErrDataFileNotFound
+	}
+	key, err := dataFile.ReadLogRecordKey(pos.Offset)
+	if err != nil {
+		return nil, err
+	}
+	realKey, _ := ParseLogRecordKeyWithSeqNo(key)
+	return realKey, nil
 }
 
 // GetValueByPosition 根据索引信息LogRecordPos从文件中读取value值
@@ -363,6 +607,9 @@ func (db *DB) GetValueByPosition(pos *data.LogRecordPos) ([]byte, error) {
 	//根据偏移量从数据文件中读取数据
 	record, _, err := dataFile.ReadLogRecord(pos.Offset)
 	if err != nil {
+		if err == data.ErrInvalidCRC {
+			db.onCorruption(CorruptionInfo{FileId: pos.Fid, Offset: pos.Offset, Err: err})
+		}
 		return nil, err
 	}
 
@@ -374,12 +621,6 @@ func (db *DB) GetValueByPosition(pos *data.LogRecordPos) ([]byte, error) {
 	return record.Value, nil
 }
 
-func (db *DB) appendLogRecordWithLock(logRecord *data.LogRecord) (pos *data.LogRecordPos, err error) {
-	db.mu.Lock()
-	defer db.mu.Unlock()
-	return db.appendLogRecord(logRecord)
-}
-
 func (db *DB) appendLogRecord(logRecord *data.LogRecord) (pos *data.LogRecordPos, err error) {
 
 	//判断当前活跃数据文件是否存在，数据库在没有写入时是没有文件生成的
@@ -394,16 +635,24 @@ func (db *DB) appendLogRecord(logRecord *data.LogRecord) (pos *data.LogRecordPos
 	//如果写入的文件大小超过了阈值，则需要切换到新的数据文件
 	if db.activeFile.WriteOff+size > db.options.DataFileSize {
 		//先持久化当前活跃数据文件
-		if err = db.activeFile.Sync(); err != nil {
+		if err = db.syncFile(db.activeFile); err != nil {
 			return nil, err
 		}
 
-		db.olderFiles[db.activeFile.FileId] = db.activeFile
+		oldFile := db.activeFile
+		db.olderFiles[oldFile.FileId] = oldFile
+		db.writeActiveHintFile()
 
 		//打开新的数据文件
 		if err = db.setActiveFile(); err != nil {
 			return nil, err
 		}
+		db.metrics.IncCounter(metrics.FileRotations, 1)
+		db.onFileRotated(FileRotatedInfo{
+			OldFileId: oldFile.FileId,
+			NewFileId: db.activeFile.FileId,
+			OldSize:   oldFile.WriteOff,
+		})
 	}
 
 	//执行数据写入的操作
@@ -412,8 +661,15 @@ func (db *DB) appendLogRecord(logRecord *data.LogRecord) (pos *data.LogRecordPos
 		return nil, err
 	}
 
+	db.addActiveHint(logRecord.Key, logRecord.Type, &data.LogRecordPos{
+		Fid:    db.activeFile.FileId,
+		Offset: writeOff,
+		Size:   uint32(size),
+	})
+
 	//根据用户配置决定是否持久化
 	db.bytesWrite += uint(size)
+	db.metrics.IncCounter(metrics.BytesWritten, float64(size))
 	var needSync = db.options.SyncWrites
 	if !needSync && db.options.BytesPerSync > 0 && db.bytesWrite >= db.options.BytesPerSync {
 		//没有打开持久化开关但是定义了累计写到多少字节进行持久化
@@ -422,7 +678,7 @@ func (db *DB) appendLogRecord(logRecord *data.LogRecord) (pos *data.LogRecordPos
 
 	//打开了持久化开关每次都持久化
 	if needSync {
-		if err = db.activeFile.Sync(); err != nil {
+		if err = db.syncFile(db.activeFile); err != nil {
 			return nil, err
 		}
 		//清空累计值
@@ -438,6 +694,36 @@ func (db *DB) appendLogRecord(logRecord *data.LogRecord) (pos *data.LogRecordPos
 	return pos, nil
 }
 
+// syncFile 持久化数据文件，并上报fsync的次数和耗时
+func (db *DB) syncFile(dataFile *data.DataFile) error {
+	start := time.Now()
+	err := dataFile.Sync()
+	db.metrics.IncCounter(metrics.FsyncTotal, 1)
+	db.metrics.Observe(metrics.FsyncDuration, time.Since(start).Seconds())
+	if err != nil {
+		db.onSyncError(err)
+	}
+	return err
+}
+
+// observeSince 上报从start开始到现在的耗时
+func (db *DB) observeSince(name string, start time.Time) {
+	db.metrics.Observe(name, time.Since(start).Seconds())
+}
+
+// updateIndexKeysGauge 上报索引中key的数量，B+树的Size需要遍历，只在Stat时上报
+func (db *DB) updateIndexKeysGauge() {
+	if db.options.IndexType != BPTree {
+		db.metrics.SetGauge(metrics.IndexKeys, float64(db.indexer.Size()))
+	}
+}
+
+// updateIteratorGauge 上报还没有关闭的迭代器数量
+func (db *DB) updateIteratorGauge(delta int64) {
+	num := atomic.AddInt64(&db.iteratorNum, delta)
+	db.metrics.SetGauge(metrics.OpenIterators, float64(num))
+}
+
 // setActiveFile 初始化活跃文件的方法
 // 访问此方法时需要持有互斥锁
 func (db *DB) setActiveFile() error {
@@ -453,32 +739,16 @@ func (db *DB) setActiveFile() error {
 		return err
 	}
 	db.activeFile = dataFile
+	db.resetActiveHints()
 	return nil
 }
 
 // loadDataFiles 从磁盘中加载数据文件
 func (db *DB) loadDataFiles() error {
-	dirEntries, err := os.ReadDir(db.options.DirPath)
+	fileIds, err := db.readDataFileIds()
 	if err != nil {
 		return err
 	}
-
-	var fileIds []int
-
-	//遍历目录下的文件，获取.data文件的文件名
-	for _, entry := range dirEntries {
-		if strings.HasSuffix(entry.Name(), data.DataFileNameSuffix) {
-			//获取文件名中的文件ID
-			fileId, err := strconv.Atoi(strings.Split(entry.Name(), ".")[0])
-			if err != nil {
-				return ErrDataFileCorrupted
-			}
-			fileIds = append(fileIds, fileId)
-		}
-	}
-
-	//对文件ID进行排序
-	sort.Ints(fileIds)
 	db.fileIds = fileIds
 
 	//遍历文件ID，依次打开数据文件
@@ -502,8 +772,35 @@ func (db *DB) loadDataFiles() error {
 	return nil
 }
 
+// readDataFileIds 获取目录下所有数据文件的ID，从小到大排序
+func (db *DB) readDataFileIds() ([]int, error) {
+	dirEntries, err := os.ReadDir(db.options.DirPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var fileIds []int
+
+	//遍历目录下的文件，获取.data文件的文件名
+	for _, entry := range dirEntries {
+		if strings.HasSuffix(entry.Name(), data.DataFileNameSuffix) {
+			//获取文件名中的文件ID
+			fileId, err := strconv.Atoi(strings.Split(entry.Name(), ".")[0])
+			if err != nil {
+				return nil, ErrDataFileCorrupted
+			}
+			fileIds = append(fileIds, fileId)
+		}
+	}
+
+	//对文件ID进行排序
+	sort.Ints(fileIds)
+	return fileIds, nil
+}
+
 // loadIndexFromDataFiles 遍历文件中所有记录，并更新到内存索引中
-func (db *DB) loadIndexFromDataFiles() error {
+// startFileId和startOffset之前的记录已经包含在checkpoint中，不需要重放
+func (db *DB) loadIndexFromDataFiles(startFileId uint32, startOffset int64) error {
 	if len(db.fileIds) == 0 {
 		return nil
 	}
@@ -520,96 +817,191 @@ func (db *DB) loadIndexFromDataFiles() error {
 		nonMergeFileId = fid
 	}
 
-	//定义更新内存索引的函数
-	updateIndex := func(key []byte, typ data.LogRecordType, pos *data.LogRecordPos) {
-		var oldPos *data.LogRecordPos
-		if typ == data.LogRecordDeleted {
-			oldPos, _ = db.indexer.Delete(key)
-			db.reclaimSize += int64(pos.Size)
-		} else {
-			oldPos = db.indexer.Put(key, pos)
-		}
-		if oldPos != nil {
-			db.reclaimSize += int64(oldPos.Size)
-		}
-	}
-
 	//暂存事务数据，判断对应事务no是否可以提交，如果可以提交，则将事务中的数据列表更新到内存索引中
-	transactionRecords := make(map[uint64][]*data.TransactionLogRecord)
-	var currentSeqNo = NonTxnSeqNo
+	db.txnRecords = make(map[uint64][]*data.TransactionLogRecord)
+	start := time.Now()
 
-	for i, fid := range db.fileIds {
+	//旧的数据文件并行解析，最后一个活跃文件需要收集hint，单独加载
+	var olderFiles []*recoveryFile
+	for i, fid := range db.fileIds[:len(db.fileIds)-1] {
 		var fileId = uint32(fid)
 
 		//如果filedId小于merge后的文件ID，则跳过
 		if hasMerge && fileId < nonMergeFileId {
 			continue
 		}
-		var dataFile *data.DataFile
-		if fileId == db.activeFile.FileId {
-			dataFile = db.activeFile
-		} else {
-			dataFile = db.olderFiles[fileId]
+		//checkpoint之前的文件已经加载过了
+		if fileId < startFileId {
+			continue
+		}
+		file := &recoveryFile{dataFile: db.olderFiles[fileId], filesDone: i + 1}
+		if fileId == startFileId {
+			file.replayFrom = startOffset
 		}
+		olderFiles = append(olderFiles, file)
+	}
+	if err := db.loadIndexFromOlderFiles(olderFiles, start); err != nil {
+		return err
+	}
 
-		var offset int64 = 0
-		//循环处理文件的内容
-		for {
-			logRecord, size, err := dataFile.ReadLogRecord(offset)
+	if !hasMerge || db.activeFile.FileId >= nonMergeFileId {
+		var replayFrom int64
+		if db.activeFile.FileId == startFileId {
+			replayFrom = startOffset
+		}
+		if db.activeFile.FileId >= startFileId {
+			offset, err := db.loadIndexFromActiveFile(db.activeFile, replayFrom)
 			if err != nil {
-				//如果读取到文件末尾，则退出循环
-				if err == io.EOF {
-					break
-				}
 				return err
 			}
+			//最后一个活跃文件需要维护writeOff，加载时已经收集了其中所有记录的hint
+			db.activeFile.WriteOff = offset
+			db.activeHintsFull = db.writeHints
+			db.onRecoveryProgress(RecoveryInfo{
+				FileId:     db.activeFile.FileId,
+				FilesDone:  len(db.fileIds),
+				FilesTotal: len(db.fileIds),
+				Elapsed:    time.Since(start),
+			})
+		}
+	}
+	//只读模式下Refresh时还需要继续处理没有读到提交标识的事务
+	if !db.options.ReadOnly {
+		db.txnRecords = nil
+	}
+	return nil
+}
 
-			//构造内存索引并且保存到内存索引中
-			logRecordPos := &data.LogRecordPos{
-				Fid:    fileId,
-				Offset: offset,
-				Size:   uint32(size),
+// loadIndexFromDataFile 从offset开始读取数据文件中的记录，replayFrom之后的记录更新到内存索引中，返回读取结束的位置
+// 只读模式下最后一个文件可能正在被其他进程写入，末尾不完整的记录会被忽略
+func (db *DB) loadIndexFromDataFile(dataFile *data.DataFile, offset, replayFrom int64, isLast bool) (int64, error) {
+	//循环处理文件的内容
+	for {
+		logRecord, size, err := dataFile.ReadLogRecord(offset)
+		if err != nil {
+			//如果读取到文件末尾，则退出循环
+			if err == io.EOF {
+				break
 			}
-
-			//解析key，获取事务序列号
-			realKey, seqNo := ParseLogRecordKeyWithSeqNo(logRecord.Key)
-			if seqNo == NonTxnSeqNo {
-				//非事务操作，直接更新内存索引
-				updateIndex(realKey, logRecord.Type, logRecordPos)
-			} else {
-				//事务完成，需要将事务中的所有操作更新到内存索引中
-				if logRecord.Type == data.LogRecordTxnFinished {
-					for _, txnRecord := range transactionRecords[seqNo] {
-						updateIndex(txnRecord.Record.Key, txnRecord.Record.Type, logRecordPos)
-					}
-					delete(transactionRecords, seqNo)
-				} else {
-					//暂未判断事务是否提交，将事务中的操作暂存到transactionRecords中
-					logRecord.Key = realKey
-					transactionRecords[seqNo] = append(transactionRecords[seqNo], &data.TransactionLogRecord{
-						Record: logRecord,
-						Pos:    logRecordPos,
-					})
+			if err == data.ErrInvalidCRC {
+				if isLast && db.options.ReadOnly {
+					break
 				}
+				db.onCorruption(CorruptionInfo{FileId: dataFile.FileId, Offset: offset, Err: err})
 			}
-			//更新事务序列号
-			if seqNo > currentSeqNo {
-				currentSeqNo = seqNo
-			}
-			//递增偏移量，下次循环从下一个位置开始读取
-			offset += size
+			return 0, err
 		}
 
-		//如果判断到是最后一个活跃文件，需要维护writeOff
-		if i == len(db.fileIds)-1 {
-			db.activeFile.WriteOff = offset
+		//构造内存索引并且保存到内存索引中
+		logRecordPos := &data.LogRecordPos{
+			Fid:    dataFile.FileId,
+			Offset: offset,
+			Size:   uint32(size),
+		}
+		if offset >= replayFrom {
+			db.replayLogRecord(logRecord.Key, logRecord.Type, logRecordPos, db.txnRecords)
 		}
+		if isLast {
+			db.addActiveHint(logRecord.Key, logRecord.Type, logRecordPos)
+		}
+		//递增偏移量，下次循环从下一个位置开始读取
+		offset += size
 	}
-	//更新事务序列号
-	db.seqNo = currentSeqNo
+	return offset, nil
+}
+
+// Refresh 只读模式下加载其他进程新写入的数据，包括新切换出来的数据文件
+// 其他进程merge后生成的数据文件不会被加载，需要重新打开数据库
+func (db *DB) Refresh() error {
+	if !db.options.ReadOnly {
+		return nil
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	fileIds, err := db.readDataFileIds()
+	if err != nil {
+		return err
+	}
+	var newFileIds []int
+	for _, fid := range fileIds {
+		if db.activeFile == nil || uint32(fid) > db.activeFile.FileId {
+			newFileIds = append(newFileIds, fid)
+		}
+	}
+
+	//先读完当前活跃文件中新写入的数据
+	if db.activeFile != nil {
+		offset, err := db.loadIndexFromDataFile(db.activeFile, db.activeFile.WriteOff, db.activeFile.WriteOff, len(newFileIds) == 0)
+		if err != nil {
+			return err
+		}
+		db.activeFile.WriteOff = offset
+	}
+
+	for i, fid := range newFileIds {
+		dataFile, err := data.OpenDataFile(uint32(fid), db.options.DirPath, fio.StandardFIO)
+		if err != nil {
+			return err
+		}
+		if db.activeFile != nil {
+			db.olderFiles[db.activeFile.FileId] = db.activeFile
+		}
+		db.activeFile = dataFile
+		db.fileIds = append(db.fileIds, fid)
+
+		offset, err := db.loadIndexFromDataFile(dataFile, 0, 0, i == len(newFileIds)-1)
+		if err != nil {
+			return err
+		}
+		dataFile.WriteOff = offset
+	}
+	db.updateIndexKeysGauge()
 	return nil
 }
 
+// replayLogRecord 按照数据文件中的顺序重放一条记录，key中带有事务序列号
+// 事务中的记录暂存到txnRecords中，读到事务完成的标识后才更新到内存索引中
+func (db *DB) replayLogRecord(key []byte, typ data.LogRecordType, pos *data.LogRecordPos,
+	txnRecords map[uint64][]*data.TransactionLogRecord) {
+	//解析key，获取事务序列号
+	realKey, seqNo := ParseLogRecordKeyWithSeqNo(key)
+	if seqNo == NonTxnSeqNo {
+		//非事务操作，直接更新内存索引
+		db.updateIndex(realKey, typ, pos)
+	} else if typ == data.LogRecordTxnFinished {
+		//事务完成，需要将事务中的所有操作更新到内存索引中
+		for _, txnRecord := range txnRecords[seqNo] {
+			db.updateIndex(txnRecord.Record.Key, txnRecord.Record.Type, txnRecord.Pos)
+		}
+		delete(txnRecords, seqNo)
+	} else {
+		//暂未判断事务是否提交，将事务中的操作暂存到txnRecords中，不需要保存value
+		txnRecords[seqNo] = append(txnRecords[seqNo], &data.TransactionLogRecord{
+			Record: &data.LogRecord{Key: realKey, Type: typ},
+			Pos:    pos,
+		})
+	}
+	//更新事务序列号
+	if seqNo > db.seqNo {
+		db.seqNo = seqNo
+	}
+}
+
+// updateIndex 根据记录类型更新内存索引，并累计可回收的数据量
+func (db *DB) updateIndex(key []byte, typ data.LogRecordType, pos *data.LogRecordPos) {
+	var oldPos *data.LogRecordPos
+	if typ == data.LogRecordDeleted {
+		oldPos, _ = db.indexer.Delete(key)
+		db.reclaimSize += int64(pos.Size)
+	} else {
+		oldPos = db.indexer.Put(key, pos)
+	}
+	if oldPos != nil {
+		db.reclaimSize += int64(oldPos.Size)
+	}
+}
+
 func (db *DB) loadSeqNo() error {
 	fileName := filepath.Join(db.options.DirPath, data.SeqNoFileName)
 	if _, err := os.Stat(fileName); os.IsNotExist(err) {
@@ -1,9 +1,14 @@
 package JDawDB
 
 import (
+	"bytes"
+	"context"
+	"github.com/GrandeLai/JDawDB/data"
+	"github.com/GrandeLai/JDawDB/metrics"
 	"github.com/GrandeLai/JDawDB/utils"
 	"github.com/stretchr/testify/assert"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 )
@@ -360,6 +365,304 @@ func TestDB_Stat(t *testing.T) {
 		assert.Nil(t, err)
 	}
 
-	stat := db.Stat()
+	stat, err := db.Stat()
+	assert.Nil(t, err)
 	assert.NotNil(t, stat)
 }
+
+func TestDB_Backup(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "JDawDB-backup")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 1; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+
+	backupDir, _ := os.MkdirTemp("", "JDawDB-backup-test")
+	err = db.Backup(backupDir)
+	assert.Nil(t, err)
+
+	opts1 := DefaultOptions
+	opts1.DirPath = backupDir
+	db2, err := Open(opts1)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	assert.NotNil(t, db2)
+
+	val1, err := db.Get(utils.GetTestKey(10))
+	assert.Nil(t, err)
+	val2, err := db2.Get(utils.GetTestKey(10))
+	assert.Nil(t, err)
+	assert.Equal(t, val1, val2)
+}
+
+func TestDB_Metrics(t *testing.T) {
+	registry := metrics.NewRegistry(nil)
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "JDawDB-metrics")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.Metrics = registry
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+	for i := 0; i < 100; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	_, err = db.Get(utils.GetTestKey(500))
+	assert.Nil(t, err)
+	err = db.Sync()
+	assert.Nil(t, err)
+
+	putCount, _ := registry.HistogramCount(metrics.PutDuration)
+	assert.Equal(t, uint64(1000), putCount)
+	getCount, _ := registry.HistogramCount(metrics.GetDuration)
+	assert.Equal(t, uint64(1), getCount)
+	assert.True(t, registry.Counter(metrics.BytesWritten) > 0)
+	assert.True(t, registry.Counter(metrics.FileRotations) > 0)
+	assert.True(t, registry.Counter(metrics.FsyncTotal) > 0)
+	assert.Equal(t, float64(900), registry.Gauge(metrics.IndexKeys))
+
+	it := db.NewIterator(DefaultIteratorOptions)
+	assert.Equal(t, float64(1), registry.Gauge(metrics.OpenIterators))
+	it.Close()
+	assert.Equal(t, float64(0), registry.Gauge(metrics.OpenIterators))
+}
+
+func TestDB_ReadOnly(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "JDawDB-readonly")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	for i := 0; i < 500; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+
+	// 写入的进程还在使用目录时也可以只读打开
+	roOpts := opts
+	roOpts.ReadOnly = true
+	roDB, err := Open(roOpts)
+	assert.Nil(t, err)
+	val, err := roDB.Get(utils.GetTestKey(499))
+	assert.Nil(t, err)
+	assert.NotNil(t, val)
+	assert.Equal(t, ErrReadOnly, roDB.Put(utils.GetTestKey(1), []byte("v")))
+	assert.Equal(t, ErrReadOnly, roDB.Delete(utils.GetTestKey(1)))
+	assert.Equal(t, ErrReadOnly, roDB.Merge())
+	wb := roDB.NewWriteBatch(DefaultWriteBatchOptions)
+	_ = wb.Put(utils.GetTestKey(1), []byte("v"))
+	assert.Equal(t, ErrReadOnly, wb.Commit())
+
+	// 新写入的数据切换了活跃文件，Refresh之后才能读到
+	for i := 500; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+	err = db.Delete(utils.GetTestKey(0))
+	assert.Nil(t, err)
+	wb = db.NewWriteBatch(DefaultWriteBatchOptions)
+	_ = wb.Put(utils.GetTestKey(2000), []byte("batch"))
+	err = wb.Commit()
+	assert.Nil(t, err)
+
+	_, err = roDB.Get(utils.GetTestKey(999))
+	assert.Equal(t, ErrKeyNotFound, err)
+	err = roDB.Refresh()
+	assert.Nil(t, err)
+	_, err = roDB.Get(utils.GetTestKey(999))
+	assert.Nil(t, err)
+	_, err = roDB.Get(utils.GetTestKey(0))
+	assert.Equal(t, ErrKeyNotFound, err)
+	val, err = roDB.Get(utils.GetTestKey(2000))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("batch"), val)
+	assert.Equal(t, len(db.ListKeys()), len(roDB.ListKeys()))
+
+	// 只读模式关闭时不会写入事务序列号文件
+	err = roDB.Close()
+	assert.Nil(t, err)
+	_, err = os.Stat(filepath.Join(dir, data.SeqNoFileName))
+	assert.True(t, os.IsNotExist(err))
+
+	roOpts.DirPath = filepath.Join(dir, "not-exist")
+	_, err = Open(roOpts)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestDB_Ctx(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "JDawDB-ctx")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	err = db.PutCtx(ctx, utils.GetTestKey(1), utils.RandomValue(10))
+	assert.Nil(t, err)
+	err = db.PutCtx(ctx, utils.GetTestKey(2), utils.RandomValue(10))
+	assert.Nil(t, err)
+	_, err = db.GetCtx(ctx, utils.GetTestKey(1))
+	assert.Nil(t, err)
+	err = db.SyncCtx(ctx)
+	assert.Nil(t, err)
+
+	var count int
+	err = db.FoldCtx(ctx, func(key []byte, value []byte) bool {
+		count++
+		cancel()
+		return true
+	})
+	assert.Equal(t, context.Canceled, err)
+	assert.Equal(t, 1, count)
+
+	err = db.PutCtx(ctx, utils.GetTestKey(3), utils.RandomValue(10))
+	assert.Equal(t, context.Canceled, err)
+	_, err = db.GetCtx(ctx, utils.GetTestKey(1))
+	assert.Equal(t, context.Canceled, err)
+	err = db.SyncCtx(ctx)
+	assert.Equal(t, context.Canceled, err)
+	_, err = db.Get(utils.GetTestKey(3))
+	assert.Equal(t, ErrKeyNotFound, err)
+}
+
+func TestDB_HashIndex(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "JDawDB-hash")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.DataFileMergeRatio = 0
+	opts.IndexType = Hash
+	db, err := Open(opts)
+	defer func() {
+		destroyDB(db)
+	}()
+	assert.Nil(t, err)
+
+	for i := 0; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(64))
+		assert.Nil(t, err)
+	}
+	err = db.Put(utils.GetTestKey(1), []byte("new value"))
+	assert.Nil(t, err)
+	err = db.Delete(utils.GetTestKey(2))
+	assert.Nil(t, err)
+
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	_ = wb.Put(utils.GetTestKey(3), []byte("batch value"))
+	_ = wb.Delete(utils.GetTestKey(4))
+	err = wb.Commit()
+	assert.Nil(t, err)
+
+	//只查询索引的方法不能读取数据文件
+	_, err = db.Has(utils.GetTestKey(1))
+	assert.Equal(t, ErrNotSupported, err)
+	_, err = db.CountPrefix([]byte("key"))
+	assert.Equal(t, ErrNotSupported, err)
+	_, err = db.KeysOnlyIterator(DefaultIteratorOptions)
+	assert.Equal(t, ErrNotSupported, err)
+
+	check := func() {
+		val, err := db.Get(utils.GetTestKey(1))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("new value"), val)
+		val, err = db.Get(utils.GetTestKey(3))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("batch value"), val)
+		_, err = db.Get(utils.GetTestKey(2))
+		assert.Equal(t, ErrKeyNotFound, err)
+		_, err = db.Get(utils.GetTestKey(4))
+		assert.Equal(t, ErrKeyNotFound, err)
+
+		keys := db.ListKeys()
+		assert.Equal(t, 998, len(keys))
+		for i := 1; i < len(keys); i++ {
+			assert.True(t, bytes.Compare(keys[i-1], keys[i]) < 0)
+		}
+	}
+	check()
+
+	err = db.Merge()
+	assert.Nil(t, err)
+	check()
+
+	// 重启后从hint文件和数据文件中加载索引，不会保存checkpoint
+	err = db.Close()
+	assert.Nil(t, err)
+	_, err = os.Stat(filepath.Join(dir, data.IndexCheckpointFileName))
+	assert.True(t, os.IsNotExist(err))
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	check()
+}
+
+func TestDB_IndexOnlyQueries(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "JDawDB-index-only")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for _, key := range []string{"a-1", "a-2", "a-3", "b-1", "b-2", "c-1"} {
+		err := db.Put([]byte(key), utils.RandomValue(24))
+		assert.Nil(t, err)
+	}
+	err = db.Delete([]byte("a-2"))
+	assert.Nil(t, err)
+
+	// 清空数据文件，只查询索引的方法不受影响
+	err = os.Truncate(data.GetDataFileName(dir, 0), 0)
+	assert.Nil(t, err)
+	_, err = db.Get([]byte("a-1"))
+	assert.NotNil(t, err)
+
+	ok, err := db.Has([]byte("a-1"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	ok, err = db.Has([]byte("a-2"))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	_, err = db.Has(nil)
+	assert.Equal(t, ErrKeyIsEmpty, err)
+
+	count := func(prefix []byte) int {
+		n, err := db.CountPrefix(prefix)
+		assert.Nil(t, err)
+		return n
+	}
+	assert.Equal(t, 5, count(nil))
+	assert.Equal(t, 2, count([]byte("a-")))
+	assert.Equal(t, 0, count([]byte("d-")))
+
+	collect := func(options IteratorOptions) []string {
+		it, err := db.KeysOnlyIterator(options)
+		assert.Nil(t, err)
+		defer it.Close()
+		var keys []string
+		for it.Rewind(); it.Valid(); it.Next() {
+			keys = append(keys, string(it.Key()))
+		}
+		return keys
+	}
+	assert.Equal(t, []string{"a-1", "a-3", "b-1", "b-2", "c-1"}, collect(DefaultIteratorOptions))
+	assert.Equal(t, []string{"b-1", "b-2"}, collect(IteratorOptions{Prefix: []byte("b-")}))
+	assert.Equal(t, []string{"b-2", "b-1"}, collect(IteratorOptions{Prefix: []byte("b-"), Reverse: true}))
+}
@@ -0,0 +1,77 @@
+package JDawDB
+
+import (
+	"github.com/GrandeLai/JDawDB/index"
+)
+
+// loadDiskIndexMeta 读取磁盘索引已经持久化的位置，启动时只需要重放这个位置之后的数据
+// 位置对应的数据文件不存在或者被截断时清空磁盘索引，返回false
+func (db *DB) loadDiskIndexMeta() (uint32, int64, bool, error) {
+	diskIndex := db.indexer.(*index.DiskIndex)
+	meta := diskIndex.Meta()
+	if meta == nil {
+		return 0, 0, false, nil
+	}
+	dataFile := db.olderFiles[meta.Fid]
+	if db.activeFile != nil && db.activeFile.FileId == meta.Fid {
+		dataFile = db.activeFile
+	}
+	var valid bool
+	if dataFile != nil {
+		size, err := dataFile.IoManager.Size()
+		if err != nil {
+			return 0, 0, false, err
+		}
+		valid = size >= meta.Offset
+	}
+	if !valid {
+		db.logger.Warn("disk index does not match data files, fall back to full replay")
+		return 0, 0, false, diskIndex.Reset()
+	}
+	db.seqNo = meta.SeqNo
+	db.reclaimSize = meta.ReclaimSize
+	db.logger.Info("disk index loaded", "fileId", meta.Fid, "offset", meta.Offset)
+	return meta.Fid, meta.Offset, true, nil
+}
+
+// maybeFlushIndex 磁盘索引的内存表写满之后刷盘，需要持有互斥锁
+func (db *DB) maybeFlushIndex() {
+	diskIndex, ok := db.indexer.(*index.DiskIndex)
+	if !ok || !diskIndex.NeedFlush() {
+		return
+	}
+	if err := db.flushDiskIndex(diskIndex); err != nil {
+		db.logger.Warn("failed to flush disk index", "err", err)
+		return
+	}
+	if diskIndex.NeedCompact() {
+		db.bgWg.Add(1)
+		go db.compactDiskIndex(diskIndex)
+	}
+}
+
+// compactDiskIndex 在后台合并磁盘索引的run文件，不持有数据库的锁，关闭时等待合并结束
+func (db *DB) compactDiskIndex(diskIndex *index.DiskIndex) {
+	defer db.bgWg.Done()
+	if err := diskIndex.Compact(); err != nil {
+		db.logger.Warn("failed to compact disk index", "err", err)
+	}
+}
+
+// flushDiskIndex 先持久化活跃文件，再把内存表写入磁盘，并记录索引覆盖到的位置
+// 多次写入的索引更新合并到一次数据文件持久化中，失败时内存表中的数据还在，只影响下次启动的速度
+func (db *DB) flushDiskIndex(diskIndex *index.DiskIndex) error {
+	if db.activeFile == nil {
+		return nil
+	}
+	if err := db.syncFile(db.activeFile); err != nil {
+		return err
+	}
+	db.bytesWrite = 0
+	return diskIndex.Flush(index.DiskIndexMeta{
+		Fid:         db.activeFile.FileId,
+		Offset:      db.activeFile.WriteOff,
+		SeqNo:       db.seqNo,
+		ReclaimSize: db.reclaimSize,
+	})
+}
@@ -0,0 +1,82 @@
+package JDawDB
+
+import (
+	"github.com/GrandeLai/JDawDB/index"
+	"github.com/GrandeLai/JDawDB/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func TestDB_DiskIndex(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "JDawDB-disk-index")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.DataFileMergeRatio = 0
+	opts.IndexType = Disk
+	var replayed []uint32
+	opts.EventListener.OnRecoveryProgress = func(info RecoveryInfo) {
+		replayed = append(replayed, info.FileId)
+	}
+	db, err := Open(opts)
+	defer func() {
+		destroyDB(db)
+	}()
+	assert.Nil(t, err)
+
+	for i := 0; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+	for i := 0; i < 100; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	reclaimSize := db.reclaimSize
+
+	// 正常关闭时刷盘，重启后只需要加载活跃文件
+	err = db.Close()
+	assert.Nil(t, err)
+	replayed = nil
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, []uint32{db.activeFile.FileId}, replayed)
+	assert.Equal(t, 900, db.indexer.Size())
+	assert.Equal(t, reclaimSize, db.reclaimSize)
+	_, err = db.Get(utils.GetTestKey(10))
+	assert.Equal(t, ErrKeyNotFound, err)
+	_, err = db.Get(utils.GetTestKey(500))
+	assert.Nil(t, err)
+
+	// 刷盘之后的写入在没有正常关闭时从数据文件中重放
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	_ = wb.Put(utils.GetTestKey(2000), []byte("batch"))
+	_ = wb.Delete(utils.GetTestKey(500))
+	err = wb.Commit()
+	assert.Nil(t, err)
+	err = db.fileLock.Unlock()
+	assert.Nil(t, err)
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	val, err := db.Get(utils.GetTestKey(2000))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("batch"), val)
+	_, err = db.Get(utils.GetTestKey(500))
+	assert.Equal(t, ErrKeyNotFound, err)
+	keys := db.ListKeys()
+	assert.Equal(t, 900, len(keys))
+
+	// merge之后磁盘索引失效，重启时重新加载
+	err = db.Merge()
+	assert.Nil(t, err)
+	err = db.Close()
+	assert.Nil(t, err)
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 900, len(db.ListKeys()))
+	val, err = db.Get(utils.GetTestKey(2000))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("batch"), val)
+	assert.Nil(t, db.indexer.(*index.DiskIndex).Meta())
+}
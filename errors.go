@@ -13,4 +13,12 @@ var (
 	ErrDatabaseIsUsing       = errors.New("database is being used by another process")
 	ErrMergeRatioUnreached   = errors.New("the merge ratio do not reach the option")
 	ErrNoEnoughSpaceForMerge = errors.New("no enough disk space for merge")
+	ErrReplicationMismatch   = errors.New("replication position does not match the local data files")
+	ErrReplicationDirInUse   = errors.New("follower dir contains data files but no replication offset")
+	ErrReplicationClosed     = errors.New("replication is already closed")
+	ErrReadOnly              = errors.New("database is opened in read-only mode")
+	ErrBulkLoadDirNotEmpty   = errors.New("bulk load dir is not empty")
+	ErrBulkLoadNotSorted     = errors.New("bulk load keys must be added in strictly ascending order")
+	ErrBulkLoadFinished      = errors.New("bulk loader is finished")
+	ErrNotSupported          = errors.New("operation is not supported by the index type")
 )
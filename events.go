@@ -0,0 +1,120 @@
+package JDawDB
+
+import (
+	"golang.org/x/exp/slog"
+	"time"
+)
+
+// Logger 存储引擎使用的日志接口，args是交替出现的key和value
+type Logger interface {
+	Debug(msg string, args ...any)
+	Info(msg string, args ...any)
+	Warn(msg string, args ...any)
+	Error(msg string, args ...any)
+}
+
+// NewSlogLogger 使用slog输出日志，l为空时使用slog.Default()
+func NewSlogLogger(l *slog.Logger) Logger {
+	if l == nil {
+		l = slog.Default()
+	}
+	return l
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Debug(string, ...any) {}
+
+func (nopLogger) Info(string, ...any) {}
+
+func (nopLogger) Warn(string, ...any) {}
+
+func (nopLogger) Error(string, ...any) {}
+
+// FileRotatedInfo 活跃文件写满后切换到新文件
+type FileRotatedInfo struct {
+	OldFileId uint32
+	NewFileId uint32
+	OldSize   int64 //旧的活跃文件的大小
+}
+
+// MergeInfo merge的开始和结束
+type MergeInfo struct {
+	NonMergeFileId uint32        //小于这个ID的数据文件参与了merge
+	MergeFileNum   int           //参与merge的数据文件数量
+	Duration       time.Duration //merge耗时，只在结束时有值
+	Err            error         //merge失败的原因，只在结束时有值
+}
+
+// RecoveryInfo 启动时从数据文件加载索引的进度
+type RecoveryInfo struct {
+	FileId     uint32        //刚加载完的数据文件
+	FilesDone  int           //已经加载完的数据文件数量
+	FilesTotal int           //需要加载的数据文件数量
+	Elapsed    time.Duration //从开始加载到现在的耗时
+}
+
+// CorruptionInfo 读取到了损坏的数据
+type CorruptionInfo struct {
+	FileId uint32
+	Offset int64
+	Err    error
+}
+
+// EventListener 存储引擎的事件回调，为空的回调不会被调用
+// 部分回调在持有DB锁的情况下执行，不能在回调中调用DB的方法
+type EventListener struct {
+	OnFileRotated      func(info FileRotatedInfo)
+	OnMergeBegin       func(info MergeInfo)
+	OnMergeEnd         func(info MergeInfo)
+	OnRecoveryProgress func(info RecoveryInfo)
+	OnSyncError        func(err error)
+	OnCorruption       func(info CorruptionInfo)
+}
+
+func (db *DB) onFileRotated(info FileRotatedInfo) {
+	db.logger.Info("data file rotated", "oldFileId", info.OldFileId, "newFileId", info.NewFileId, "oldSize", info.OldSize)
+	if db.options.EventListener.OnFileRotated != nil {
+		db.options.EventListener.OnFileRotated(info)
+	}
+}
+
+func (db *DB) onMergeBegin(info MergeInfo) {
+	db.logger.Info("merge begin", "nonMergeFileId", info.NonMergeFileId, "mergeFileNum", info.MergeFileNum)
+	if db.options.EventListener.OnMergeBegin != nil {
+		db.options.EventListener.OnMergeBegin(info)
+	}
+}
+
+func (db *DB) onMergeEnd(info MergeInfo) {
+	if info.Err != nil {
+		db.logger.Error("merge failed", "nonMergeFileId", info.NonMergeFileId, "duration", info.Duration, "err", info.Err)
+	} else {
+		db.logger.Info("merge end", "nonMergeFileId", info.NonMergeFileId, "duration", info.Duration)
+	}
+	if db.options.EventListener.OnMergeEnd != nil {
+		db.options.EventListener.OnMergeEnd(info)
+	}
+}
+
+func (db *DB) onRecoveryProgress(info RecoveryInfo) {
+	db.logger.Debug("data file loaded", "fileId", info.FileId, "filesDone", info.FilesDone,
+		"filesTotal", info.FilesTotal, "elapsed", info.Elapsed)
+	if db.options.EventListener.OnRecoveryProgress != nil {
+		db.options.EventListener.OnRecoveryProgress(info)
+	}
+}
+
+func (db *DB) onSyncError(err error) {
+	db.logger.Error("failed to sync data file", "err", err)
+	if db.options.EventListener.OnSyncError != nil {
+		db.options.EventListener.OnSyncError(err)
+	}
+}
+
+func (db *DB) onCorruption(info CorruptionInfo) {
+	db.logger.Error("data file is corrupted", "fileId", info.FileId, "offset", info.Offset, "err", info.Err)
+	if db.options.EventListener.OnCorruption != nil {
+		db.options.EventListener.OnCorruption(info)
+	}
+}
@@ -0,0 +1,102 @@
+package JDawDB
+
+import (
+	"bytes"
+	"github.com/GrandeLai/JDawDB/data"
+	"github.com/GrandeLai/JDawDB/utils"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/exp/slog"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDB_EventListener(t *testing.T) {
+	var rotated []FileRotatedInfo
+	var mergeBegin, mergeEnd []MergeInfo
+	var recovery []RecoveryInfo
+	listener := EventListener{
+		OnFileRotated:      func(info FileRotatedInfo) { rotated = append(rotated, info) },
+		OnMergeBegin:       func(info MergeInfo) { mergeBegin = append(mergeBegin, info) },
+		OnMergeEnd:         func(info MergeInfo) { mergeEnd = append(mergeEnd, info) },
+		OnRecoveryProgress: func(info RecoveryInfo) { recovery = append(recovery, info) },
+	}
+
+	buf := new(bytes.Buffer)
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "JDawDB-events")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.DataFileMergeRatio = 0
+	opts.EventListener = listener
+	opts.Logger = NewSlogLogger(slog.New(slog.NewTextHandler(buf, nil)))
+	db, err := Open(opts)
+	defer func() {
+		destroyDB(db)
+	}()
+	assert.Nil(t, err)
+
+	for i := 0; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+	assert.True(t, len(rotated) > 0)
+	assert.Equal(t, rotated[0].OldFileId+1, rotated[0].NewFileId)
+	assert.True(t, rotated[0].OldSize > 0)
+	assert.Contains(t, buf.String(), "data file rotated")
+
+	err = db.Merge()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(mergeBegin))
+	assert.Equal(t, 1, len(mergeEnd))
+	assert.Equal(t, mergeBegin[0].NonMergeFileId, mergeEnd[0].NonMergeFileId)
+	assert.Nil(t, mergeEnd[0].Err)
+
+	// 重启后加载merge文件并上报加载进度
+	err = db.Close()
+	assert.Nil(t, err)
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.True(t, len(recovery) > 0)
+	last := recovery[len(recovery)-1]
+	assert.Equal(t, last.FilesTotal, last.FilesDone)
+	assert.Contains(t, buf.String(), "merge files loaded")
+}
+
+func TestDB_EventListenerCorruption(t *testing.T) {
+	var corruptions []CorruptionInfo
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "JDawDB-corruption")
+	defer os.RemoveAll(dir)
+	opts.DirPath = dir
+	opts.EventListener = EventListener{
+		OnCorruption: func(info CorruptionInfo) { corruptions = append(corruptions, info) },
+	}
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	for i := 0; i < 10; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(24))
+		assert.Nil(t, err)
+	}
+	err = db.Close()
+	assert.Nil(t, err)
+
+	// 修改最后一条记录的value
+	fileName := data.GetDataFileName(dir, 0)
+	content, err := os.ReadFile(fileName)
+	assert.Nil(t, err)
+	content[len(content)-1] ^= 0xff
+	err = os.WriteFile(fileName, content, 0644)
+	assert.Nil(t, err)
+	// 删除关闭时保存的索引，启动时需要重放数据文件
+	err = os.Remove(filepath.Join(dir, data.IndexCheckpointFileName))
+	assert.Nil(t, err)
+	err = os.Remove(data.GetHintFileName(dir, 0))
+	assert.Nil(t, err)
+
+	_, err = Open(opts)
+	assert.Equal(t, data.ErrInvalidCRC, err)
+	assert.Equal(t, 1, len(corruptions))
+	assert.Equal(t, uint32(0), corruptions[0].FileId)
+	assert.True(t, corruptions[0].Offset > 0)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/GrandeLai/JDawDB"
+	"os"
+	"os/signal"
+	"time"
+)
+
+// 在两个终端中分别启动主节点和从节点：
+//
+//	go run ./examples/replication -role primary -dir /tmp/JDawDB-primary -addr 127.0.0.1:7380
+//	go run ./examples/replication -role follower -dir /tmp/JDawDB-follower -addr 127.0.0.1:7380
+func main() {
+	role := flag.String("role", "primary", "primary or follower")
+	dir := flag.String("dir", "/tmp/JDawDB-repl", "data dir")
+	addr := flag.String("addr", "127.0.0.1:7380", "primary listen address")
+	flag.Parse()
+
+	opts := JDawDB.DefaultOptions
+	opts.DirPath = *dir
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt)
+
+	switch *role {
+	case "primary":
+		db, err := JDawDB.Open(opts)
+		if err != nil {
+			panic(err)
+		}
+		defer db.Close()
+		primary, err := db.StartPrimary(*addr, JDawDB.DefaultReplicationOptions)
+		if err != nil {
+			panic(err)
+		}
+		defer primary.Close()
+
+		//每秒写入一个递增的计数器
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			case <-time.After(time.Second):
+			}
+			if err := db.Put([]byte("counter"), []byte(fmt.Sprint(i))); err != nil {
+				panic(err)
+			}
+			fmt.Println("put counter =", i)
+		}
+	case "follower":
+		follower, err := JDawDB.OpenFollower(*addr, opts, JDawDB.DefaultReplicationOptions)
+		if err != nil {
+			panic(err)
+		}
+		defer follower.Close()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-time.After(time.Second):
+			}
+			val, err := follower.Get([]byte("counter"))
+			fileId, offset := follower.Position()
+			fmt.Printf("get counter = %s, err = %v, position = %d:%d\n", val, err, fileId, offset)
+		}
+	default:
+		fmt.Println("unknown role:", *role)
+	}
+}
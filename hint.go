@@ -0,0 +1,109 @@
+package JDawDB
+
+import (
+	"github.com/GrandeLai/JDawDB/data"
+	"io"
+	"os"
+)
+
+// addActiveHint 记录活跃文件中一条记录的hint，key中带有事务序列号
+func (db *DB) addActiveHint(key []byte, typ data.LogRecordType, pos *data.LogRecordPos) {
+	if !db.writeHints {
+		return
+	}
+	encRecord, _ := data.EncodeLogRecord(&data.LogRecord{
+		Key:   key,
+		Value: data.EncodeLogRecordPos(pos),
+		Type:  typ,
+	})
+	db.activeHints = append(db.activeHints, encRecord...)
+}
+
+// resetActiveHints 切换到新的活跃文件时清空hint
+func (db *DB) resetActiveHints() {
+	db.activeHints = db.activeHints[:0]
+	db.activeHintsFull = db.writeHints
+}
+
+// writeActiveHintFile 活跃文件切换之前为它写入hint文件，调用时持有db.mu
+// 写入和fsync在后台进行，不阻塞其他读写，需要等待写完时调用hintWg.Wait
+// 写入失败只会让下次启动时重新读取这个数据文件，所以只记录日志
+func (db *DB) writeActiveHintFile() {
+	if !db.writeHints || !db.activeHintsFull || db.activeFile == nil {
+		return
+	}
+	fileId, hints := db.activeFile.FileId, db.activeHints
+	//后台还在读取hints，之后的活跃文件使用新的数组
+	db.activeHints = nil
+
+	db.hintWg.Add(1)
+	go func() {
+		defer db.hintWg.Done()
+		if err := data.WriteDataHintFile(db.options.DirPath, fileId, hints); err != nil {
+			db.logger.Warn("failed to write hint file", "fileId", fileId, "err", err)
+		}
+	}()
+}
+
+// loadIndexFromActiveFile 加载活跃文件，只重放replayFrom之后的记录，返回活跃文件的写入位置
+// 关闭时为活跃文件写入的hint文件只覆盖了文件的前一部分，剩下的部分需要读取数据文件
+func (db *DB) loadIndexFromActiveFile(dataFile *data.DataFile, replayFrom int64) (int64, error) {
+	records, positions, end, err := db.readDataHintFile(dataFile)
+	if err != nil {
+		return 0, err
+	}
+	fileSize, err := dataFile.IoManager.Size()
+	if err != nil {
+		return 0, err
+	}
+	if end > fileSize {
+		records, end = nil, 0
+	}
+	for i, logRecord := range records {
+		if positions[i].Offset >= replayFrom {
+			db.replayLogRecord(logRecord.Key, logRecord.Type, positions[i], db.txnRecords)
+		}
+		db.addActiveHint(logRecord.Key, logRecord.Type, positions[i])
+	}
+	return db.loadIndexFromDataFile(dataFile, end, replayFrom, true)
+}
+
+// readDataHintFile 读取数据文件对应的hint文件，返回的记录从数据文件的开头连续覆盖到end
+// hint文件不存在或者损坏时返回空
+func (db *DB) readDataHintFile(dataFile *data.DataFile) ([]*data.LogRecord, []*data.LogRecordPos, int64, error) {
+	fileName := data.GetHintFileName(db.options.DirPath, dataFile.FileId)
+	if _, err := os.Stat(fileName); os.IsNotExist(err) {
+		return nil, nil, 0, nil
+	}
+	hintFile, err := data.OpenDataHintFile(dataFile.FileId, db.options.DirPath)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	defer func() {
+		_ = hintFile.Close()
+	}()
+
+	records := make([]*data.LogRecord, 0)
+	var positions []*data.LogRecordPos
+	var offset, end int64
+	for {
+		logRecord, size, err := hintFile.ReadLogRecord(offset)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			db.logger.Warn("hint file is corrupted", "fileId", dataFile.FileId, "err", err)
+			return nil, nil, 0, nil
+		}
+		pos := data.DecodeLogRecordPos(logRecord.Value)
+		if pos == nil || pos.Fid != dataFile.FileId || pos.Offset != end {
+			db.logger.Warn("hint file does not match data file", "fileId", dataFile.FileId)
+			return nil, nil, 0, nil
+		}
+		records = append(records, logRecord)
+		positions = append(positions, pos)
+		end = pos.Offset + int64(pos.Size)
+		offset += size
+	}
+	return records, positions, end, nil
+}
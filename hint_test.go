@@ -0,0 +1,88 @@
+package JDawDB
+
+import (
+	"github.com/GrandeLai/JDawDB/data"
+	"github.com/GrandeLai/JDawDB/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDB_DataHintFile(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "JDawDB-hint")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	db, err := Open(opts)
+	defer func() {
+		destroyDB(db)
+	}()
+	assert.Nil(t, err)
+
+	for i := 0; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+	for i := 0; i < 100; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	_ = wb.Put(utils.GetTestKey(2000), []byte("batch"))
+	_ = wb.Delete(utils.GetTestKey(999))
+	err = wb.Commit()
+	assert.Nil(t, err)
+
+	// 除了活跃文件，每个数据文件都有对应的hint文件
+	db.hintWg.Wait()
+	activeFileId := db.activeFile.FileId
+	assert.True(t, activeFileId > 1)
+	for fid := uint32(0); fid < activeFileId; fid++ {
+		_, err := os.Stat(data.GetHintFileName(dir, fid))
+		assert.Nil(t, err)
+	}
+	_, err = os.Stat(data.GetHintFileName(dir, activeFileId))
+	assert.True(t, os.IsNotExist(err))
+	err = db.Close()
+	assert.Nil(t, err)
+	// 不使用关闭时保存的checkpoint
+	checkpointFileName := filepath.Join(dir, data.IndexCheckpointFileName)
+	err = os.Remove(checkpointFileName)
+	assert.Nil(t, err)
+
+	// 有hint文件时启动不会读取旧数据文件中的value，破坏value之后仍然可以启动
+	fileName := data.GetDataFileName(dir, 0)
+	content, err := os.ReadFile(fileName)
+	assert.Nil(t, err)
+	content[len(content)-1] ^= 0xff
+	err = os.WriteFile(fileName, content, 0644)
+	assert.Nil(t, err)
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 900, len(db.ListKeys()))
+	val, err := db.Get(utils.GetTestKey(2000))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("batch"), val)
+	_, err = db.Get(utils.GetTestKey(999))
+	assert.Equal(t, ErrKeyNotFound, err)
+	err = db.Close()
+	assert.Nil(t, err)
+	err = os.Remove(checkpointFileName)
+	assert.Nil(t, err)
+	content[len(content)-1] ^= 0xff
+	err = os.WriteFile(fileName, content, 0644)
+	assert.Nil(t, err)
+
+	// 和数据文件对不上的hint文件会被忽略，重新读取数据文件
+	err = os.Truncate(data.GetHintFileName(dir, 1), 10)
+	assert.Nil(t, err)
+	err = os.Remove(data.GetHintFileName(dir, 0))
+	assert.Nil(t, err)
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 900, len(db.ListKeys()))
+	val, err = db.Get(utils.GetTestKey(500))
+	assert.Nil(t, err)
+	assert.NotNil(t, val)
+}
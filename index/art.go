@@ -58,6 +58,20 @@ func (art *AdaptiveRadixTree) Size() int {
 	return size
 }
 
+// CountPrefix 只遍历前缀对应的子树
+func (art *AdaptiveRadixTree) CountPrefix(prefix []byte) int {
+	art.lock.RLock()
+	defer art.lock.RUnlock()
+	var count int
+	art.tree.ForEachPrefix(prefix, func(node goart.Node) bool {
+		if node.Kind() == goart.Leaf {
+			count++
+		}
+		return true
+	})
+	return count
+}
+
 func (art *AdaptiveRadixTree) Iterator(reverse bool) Iterator {
 	art.lock.RLock()
 	defer art.lock.RUnlock()
@@ -138,3 +152,129 @@ func (ai *ARTIterator) Close() {
 	//清理临时数组
 	ai.values = nil
 }
+
+// LiveIterator 直接在树上遍历，每次在读锁下取出一批key，不复制整棵树
+// 正向遍历时沿用树的迭代器，树被修改之后从头跳过已经遍历过的key；反向遍历每一批都需要从头遍历到上一批的位置
+func (art *AdaptiveRadixTree) LiveIterator(reverse bool) Iterator {
+	return &artLiveIterator{art: art, reverse: reverse}
+}
+
+// artLiveIterator ART索引的实时迭代器，遍历期间的写入可能可见
+type artLiveIterator struct {
+	art       *AdaptiveRadixTree
+	reverse   bool
+	treeIt    goart.Iterator //正向遍历时树的迭代器，位置在当前这一批之后
+	values    []*Item        //当前这一批key
+	currIndex int
+	hasMore   bool //这一批之后树中是否还有key
+}
+
+// load 从from开始取出一批key，rewind为true时从头开始，after为true时跳过from本身
+func (ai *artLiveIterator) load(from []byte, rewind, after bool) {
+	ai.art.lock.RLock()
+	defer ai.art.lock.RUnlock()
+	ai.currIndex = 0
+	if ai.reverse {
+		ai.loadReverse(from, rewind, after)
+		return
+	}
+
+	//继续上一批时优先使用树的迭代器，树被修改过时需要重新定位
+	if after && ai.treeIt != nil && ai.nextBatch(nil) {
+		return
+	}
+	ai.treeIt = ai.art.tree.Iterator()
+	for ai.treeIt.HasNext() {
+		//新的迭代器在读锁下不会遇到并发修改
+		node, _ := ai.treeIt.Next()
+		cmp := bytes.Compare(node.Key(), from)
+		if rewind || cmp > 0 || (cmp == 0 && !after) {
+			ai.nextBatch(&Item{node.Key(), node.Value().(*data.LogRecordPos)})
+			return
+		}
+	}
+	ai.values, ai.hasMore = nil, false
+}
+
+// nextBatch 从树的迭代器中取出一批key，first不为nil时作为这一批的第一个key，树已经被修改时返回false
+func (ai *artLiveIterator) nextBatch(first *Item) bool {
+	values := make([]*Item, 0, liveIteratorBatch)
+	if first != nil {
+		values = append(values, first)
+	}
+	//树的迭代器每次调用HasNext都会向前移动，只能和Next成对调用，取满一批时认为后面还有key
+	ai.hasMore = true
+	for len(values) < liveIteratorBatch {
+		if !ai.treeIt.HasNext() {
+			ai.hasMore = false
+			break
+		}
+		node, err := ai.treeIt.Next()
+		if err != nil {
+			return false
+		}
+		values = append(values, &Item{node.Key(), node.Value().(*data.LogRecordPos)})
+	}
+	ai.values = values
+	return true
+}
+
+// loadReverse 从头遍历到from，保留其中最后一批key
+func (ai *artLiveIterator) loadReverse(from []byte, rewind, after bool) {
+	//环形缓冲区，n是遍历过的key数量
+	ring := make([]*Item, liveIteratorBatch)
+	var n int
+	ai.art.tree.ForEach(func(node goart.Node) bool {
+		if !rewind {
+			cmp := bytes.Compare(node.Key(), from)
+			if cmp > 0 || (cmp == 0 && after) {
+				return false
+			}
+		}
+		ring[n%liveIteratorBatch] = &Item{node.Key(), node.Value().(*data.LogRecordPos)}
+		n++
+		return true
+	})
+	count := n
+	if count > liveIteratorBatch {
+		count = liveIteratorBatch
+	}
+	values := make([]*Item, count)
+	for i := range values {
+		values[i] = ring[(n-1-i)%liveIteratorBatch]
+	}
+	ai.values = values
+	ai.hasMore = n > liveIteratorBatch
+}
+
+func (ai *artLiveIterator) Rewind() {
+	ai.load(nil, true, false)
+}
+
+func (ai *artLiveIterator) Seek(key []byte) {
+	ai.load(key, false, false)
+}
+
+func (ai *artLiveIterator) Next() {
+	ai.currIndex++
+	if ai.currIndex == len(ai.values) && ai.hasMore {
+		ai.load(ai.values[len(ai.values)-1].key, false, true)
+	}
+}
+
+func (ai *artLiveIterator) Valid() bool {
+	return ai.currIndex < len(ai.values)
+}
+
+func (ai *artLiveIterator) Key() []byte {
+	return ai.values[ai.currIndex].key
+}
+
+func (ai *artLiveIterator) Value() *data.LogRecordPos {
+	return ai.values[ai.currIndex].pos
+}
+
+func (ai *artLiveIterator) Close() {
+	ai.values = nil
+	ai.treeIt = nil
+}
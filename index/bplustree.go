@@ -1,6 +1,7 @@
 package index
 
 import (
+	"bytes"
 	"github.com/GrandeLai/JDawDB/data"
 	"go.etcd.io/bbolt"
 	"path/filepath"
@@ -94,6 +95,21 @@ func (bpt *BPlusTree) Size() int {
 	return size
 }
 
+// CountPrefix 在只读事务中用游标遍历前缀下的key
+func (bpt *BPlusTree) CountPrefix(prefix []byte) int {
+	var count int
+	if err := bpt.tree.View(func(tx *bbolt.Tx) error {
+		cursor := tx.Bucket(indexBucketName).Cursor()
+		for k, _ := cursor.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = cursor.Next() {
+			count++
+		}
+		return nil
+	}); err != nil {
+		panic("failed to count prefix in bplustree")
+	}
+	return count
+}
+
 func (bpt *BPlusTree) Iterator(reverse bool) Iterator {
 	return NewBPlusTreeIterator(bpt.tree, reverse)
 }
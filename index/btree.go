@@ -56,6 +56,21 @@ func (bt *BTree) Size() int {
 	return bt.tree.Len()
 }
 
+// CountPrefix 从前缀开始遍历，不需要像迭代器那样复制所有的key
+func (bt *BTree) CountPrefix(prefix []byte) int {
+	bt.lock.RLock()
+	defer bt.lock.RUnlock()
+	var count int
+	bt.tree.AscendGreaterOrEqual(&Item{key: prefix}, func(i btree.Item) bool {
+		if !bytes.HasPrefix(i.(*Item).key, prefix) {
+			return false
+		}
+		count++
+		return true
+	})
+	return count
+}
+
 func (bt *BTree) Iterator(reverse bool) Iterator {
 	if bt.tree == nil {
 		return nil
@@ -134,3 +149,84 @@ func (bit *BtreeIterator) Close() {
 	//清理临时数组
 	bit.values = nil
 }
+
+// liveIteratorBatch 直接在树上遍历时每次在读锁下取出的key数量
+const liveIteratorBatch = 256
+
+// LiveIterator 直接在树上遍历，每次在读锁下取出一批key，用完之后从最后一个key继续，不复制整棵树
+func (bt *BTree) LiveIterator(reverse bool) Iterator {
+	return &btreeLiveIterator{bt: bt, reverse: reverse}
+}
+
+// btreeLiveIterator Btree索引的实时迭代器，遍历期间的写入可能可见
+type btreeLiveIterator struct {
+	bt        *BTree
+	reverse   bool
+	values    []*Item //当前这一批key
+	currIndex int
+	hasMore   bool //这一批之后树中是否还有key
+}
+
+// load 从from开始取出一批key，rewind为true时从头开始，after为true时跳过from本身
+func (bit *btreeLiveIterator) load(from []byte, rewind, after bool) {
+	values := make([]*Item, 0, liveIteratorBatch)
+	bit.hasMore = false
+	collect := func(i btree.Item) bool {
+		item := i.(*Item)
+		if after && bytes.Equal(item.key, from) {
+			return true
+		}
+		if len(values) == liveIteratorBatch {
+			bit.hasMore = true
+			return false
+		}
+		values = append(values, item)
+		return true
+	}
+
+	bit.bt.lock.RLock()
+	switch {
+	case rewind && bit.reverse:
+		bit.bt.tree.Descend(collect)
+	case rewind:
+		bit.bt.tree.Ascend(collect)
+	case bit.reverse:
+		bit.bt.tree.DescendLessOrEqual(&Item{key: from}, collect)
+	default:
+		bit.bt.tree.AscendGreaterOrEqual(&Item{key: from}, collect)
+	}
+	bit.bt.lock.RUnlock()
+	bit.values = values
+	bit.currIndex = 0
+}
+
+func (bit *btreeLiveIterator) Rewind() {
+	bit.load(nil, true, false)
+}
+
+func (bit *btreeLiveIterator) Seek(key []byte) {
+	bit.load(key, false, false)
+}
+
+func (bit *btreeLiveIterator) Next() {
+	bit.currIndex++
+	if bit.currIndex == len(bit.values) && bit.hasMore {
+		bit.load(bit.values[len(bit.values)-1].key, false, true)
+	}
+}
+
+func (bit *btreeLiveIterator) Valid() bool {
+	return bit.currIndex < len(bit.values)
+}
+
+func (bit *btreeLiveIterator) Key() []byte {
+	return bit.values[bit.currIndex].key
+}
+
+func (bit *btreeLiveIterator) Value() *data.LogRecordPos {
+	return bit.values[bit.currIndex].pos
+}
+
+func (bit *btreeLiveIterator) Close() {
+	bit.values = nil
+}
@@ -0,0 +1,756 @@
+package index
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"github.com/GrandeLai/JDawDB/data"
+	"github.com/GrandeLai/JDawDB/fio"
+	"github.com/google/btree"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// DiskIndexDirName 磁盘索引在数据目录中使用的子目录
+	DiskIndexDirName = "disk-index"
+	// DefaultDiskIndexCacheSize 默认的数据块缓存大小
+	DefaultDiskIndexCacheSize = 16 * 1024 * 1024
+
+	diskManifestFileName = "MANIFEST"
+	diskManifestMagic    = "JDIM"
+	// diskMemTableSize 内存表中的数据超过这个大小之后需要写入run文件
+	diskMemTableSize = 4 * 1024 * 1024
+	// diskMergeRuns 大小相近的run文件达到这个数量之后合并成一个
+	diskMergeRuns = 4
+	// diskEntryOverhead 估算内存表中每条记录除了key之外占用的内存
+	diskEntryOverhead = 64
+)
+
+var errInvalidDiskManifest = errors.New("invalid disk index manifest")
+
+// DiskIndexMeta 磁盘索引中已经持久化的数据覆盖到的数据文件位置，以及当时数据库的状态
+// 启动时只需要从这个位置开始重放数据文件，不需要单独的预写日志
+type DiskIndexMeta struct {
+	Fid         uint32
+	Offset      int64
+	SeqNo       uint64
+	ReclaimSize int64
+}
+
+func (e *diskEntry) Less(bi btree.Item) bool {
+	return bytes.Compare(e.key, bi.(*diskEntry).key) < 0
+}
+
+// DiskIndex 不需要把所有key都放在内存中的磁盘索引，结构类似LSM树
+// 最近的写入保存在内存表中，由数据库在持久化数据文件之后调用Flush写入不可变的有序run文件
+// 大小相近的run文件由Compact在后台合并，内存中只保存每个run文件的数据块索引，数据块通过有容量上限的LRU缓存读取
+type DiskIndex struct {
+	lock        *sync.RWMutex
+	compactLock *sync.Mutex //保证同一时间只有一个合并在进行
+	closed      bool
+	dirPath     string
+	memTable    *btree.BTree
+	memSize     int
+	flushSize   int        //内存表超过这个大小时需要刷盘
+	runs        []*diskRun //按照从旧到新的顺序
+	nextRunId   uint32
+	size        int
+	meta        *DiskIndexMeta //为nil表示还没有持久化过
+	cache       *blockCache
+}
+
+// NewDiskIndex 打开数据目录中的磁盘索引，cacheSize是数据块缓存的字节数
+// MANIFEST文件损坏时清空磁盘索引，由数据库重放所有数据文件
+func NewDiskIndex(dirPath string, cacheSize int64) (*DiskIndex, error) {
+	if cacheSize <= 0 {
+		cacheSize = DefaultDiskIndexCacheSize
+	}
+	dirPath = filepath.Join(dirPath, DiskIndexDirName)
+	if err := os.MkdirAll(dirPath, os.ModePerm); err != nil {
+		return nil, err
+	}
+	di := &DiskIndex{
+		lock:        new(sync.RWMutex),
+		compactLock: new(sync.Mutex),
+		dirPath:     dirPath,
+		memTable:    btree.New(32),
+		flushSize:   diskMemTableSize,
+		cache:       newBlockCache(cacheSize),
+	}
+	runIds, err := di.readManifest()
+	if err != nil && err != errInvalidDiskManifest {
+		return nil, err
+	}
+	for _, id := range runIds {
+		run, err := openDiskRun(dirPath, id)
+		if err != nil {
+			if err != errInvalidDiskRun && !os.IsNotExist(err) {
+				return nil, err
+			}
+			//run文件丢失或者损坏时清空磁盘索引
+			runIds = nil
+			break
+		}
+		di.runs = append(di.runs, run)
+	}
+	if runIds == nil {
+		di.releaseRuns(di.runs)
+		di.runs, di.size, di.meta = nil, 0, nil
+		if err := os.RemoveAll(filepath.Join(dirPath, diskManifestFileName)); err != nil {
+			return nil, err
+		}
+	}
+	if err := di.removeUnusedFiles(); err != nil {
+		return nil, err
+	}
+	return di, nil
+}
+
+// readManifest 读取MANIFEST文件，返回正在使用的run文件
+// 文件格式：magic + 文件ID + 偏移量 + 事务序列号 + 无效数据量 + key数量 + 下一个run文件ID + run文件数量 + 多个run文件ID + crc
+func (di *DiskIndex) readManifest() ([]uint32, error) {
+	buf, err := os.ReadFile(filepath.Join(di.dirPath, diskManifestFileName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if len(buf) < len(diskManifestMagic)+crc32.Size || string(buf[:len(diskManifestMagic)]) != diskManifestMagic {
+		return nil, errInvalidDiskManifest
+	}
+	content := buf[:len(buf)-crc32.Size]
+	if crc32.ChecksumIEEE(content) != binary.BigEndian.Uint32(buf[len(content):]) {
+		return nil, errInvalidDiskManifest
+	}
+
+	r := bytes.NewReader(content[len(diskManifestMagic):])
+	fid, err1 := binary.ReadUvarint(r)
+	offset, err2 := binary.ReadVarint(r)
+	seqNo, err3 := binary.ReadUvarint(r)
+	reclaimSize, err4 := binary.ReadVarint(r)
+	size, err5 := binary.ReadUvarint(r)
+	nextRunId, err6 := binary.ReadUvarint(r)
+	runNum, err7 := binary.ReadUvarint(r)
+	if errors.Join(err1, err2, err3, err4, err5, err6, err7) != nil {
+		return nil, errInvalidDiskManifest
+	}
+	runIds := make([]uint32, 0, runNum)
+	for i := uint64(0); i < runNum; i++ {
+		id, err := binary.ReadUvarint(r)
+		if err != nil || uint32(id) >= uint32(nextRunId) {
+			return nil, errInvalidDiskManifest
+		}
+		runIds = append(runIds, uint32(id))
+	}
+	if r.Len() != 0 {
+		return nil, errInvalidDiskManifest
+	}
+	di.meta = &DiskIndexMeta{Fid: uint32(fid), Offset: offset, SeqNo: seqNo, ReclaimSize: reclaimSize}
+	di.size = int(size)
+	di.nextRunId = uint32(nextRunId)
+	return runIds, nil
+}
+
+// writeManifest 先写临时文件再重命名，替换MANIFEST文件
+func (di *DiskIndex) writeManifest(meta DiskIndexMeta, size int, runs []*diskRun) error {
+	buf := []byte(diskManifestMagic)
+	buf = binary.AppendUvarint(buf, uint64(meta.Fid))
+	buf = binary.AppendVarint(buf, meta.Offset)
+	buf = binary.AppendUvarint(buf, meta.SeqNo)
+	buf = binary.AppendVarint(buf, meta.ReclaimSize)
+	buf = binary.AppendUvarint(buf, uint64(size))
+	buf = binary.AppendUvarint(buf, uint64(di.nextRunId))
+	buf = binary.AppendUvarint(buf, uint64(len(runs)))
+	for _, run := range runs {
+		buf = binary.AppendUvarint(buf, uint64(run.id))
+	}
+	buf = binary.BigEndian.AppendUint32(buf, crc32.ChecksumIEEE(buf))
+
+	fileName := filepath.Join(di.dirPath, diskManifestFileName)
+	tmpFileName := fileName + ".tmp"
+	file, err := os.OpenFile(tmpFileName, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, fio.DataFilePerm)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = file.Close()
+		_ = os.Remove(tmpFileName)
+	}()
+	if _, err := file.Write(buf); err != nil {
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpFileName, fileName)
+}
+
+// removeUnusedFiles 删除MANIFEST中没有记录的run文件，它们是刷盘或者合并中途退出留下的
+func (di *DiskIndex) removeUnusedFiles() error {
+	used := make(map[uint32]bool, len(di.runs))
+	for _, run := range di.runs {
+		used[run.id] = true
+	}
+	entries, err := os.ReadDir(di.dirPath)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasSuffix(name, ".tmp") {
+			if err := os.Remove(filepath.Join(di.dirPath, name)); err != nil {
+				return err
+			}
+			continue
+		}
+		if !strings.HasSuffix(name, diskRunFileSuffix) {
+			continue
+		}
+		id, err := strconv.Atoi(strings.TrimSuffix(name, diskRunFileSuffix))
+		if err != nil || used[uint32(id)] {
+			continue
+		}
+		if uint32(id) >= di.nextRunId {
+			di.nextRunId = uint32(id) + 1
+		}
+		if err := os.Remove(filepath.Join(di.dirPath, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getLocked 依次从内存表和从新到旧的run文件中查找，需要持有锁
+func (di *DiskIndex) getLocked(key []byte) *data.LogRecordPos {
+	if item := di.memTable.Get(&diskEntry{key: key}); item != nil {
+		return item.(*diskEntry).pos
+	}
+	for i := len(di.runs) - 1; i >= 0; i-- {
+		if entry := di.runs[i].get(key, di.cache); entry != nil {
+			return entry.pos
+		}
+	}
+	return nil
+}
+
+func (di *DiskIndex) Put(key []byte, pos *data.LogRecordPos) *data.LogRecordPos {
+	di.lock.Lock()
+	defer di.lock.Unlock()
+	oldPos := di.getLocked(key)
+	di.putMemTable(&diskEntry{key: key, pos: pos})
+	if oldPos == nil {
+		di.size++
+	}
+	return oldPos
+}
+
+func (di *DiskIndex) Get(key []byte) *data.LogRecordPos {
+	di.lock.RLock()
+	defer di.lock.RUnlock()
+	return di.getLocked(key)
+}
+
+// Delete 在内存表中写入删除标记，覆盖run文件中的旧数据
+func (di *DiskIndex) Delete(key []byte) (*data.LogRecordPos, bool) {
+	di.lock.Lock()
+	defer di.lock.Unlock()
+	oldPos := di.getLocked(key)
+	if oldPos == nil {
+		return nil, false
+	}
+	di.putMemTable(&diskEntry{key: key})
+	di.size--
+	return oldPos, true
+}
+
+func (di *DiskIndex) putMemTable(entry *diskEntry) {
+	if di.memTable.ReplaceOrInsert(entry) == nil {
+		di.memSize += len(entry.key) + diskEntryOverhead
+	}
+}
+
+func (di *DiskIndex) Size() int {
+	di.lock.RLock()
+	defer di.lock.RUnlock()
+	return di.size
+}
+
+// Meta 返回上一次Flush时记录的数据文件位置，没有持久化过时返回nil
+func (di *DiskIndex) Meta() *DiskIndexMeta {
+	di.lock.RLock()
+	defer di.lock.RUnlock()
+	if di.meta == nil {
+		return nil
+	}
+	meta := *di.meta
+	return &meta
+}
+
+// NeedFlush 内存表是否已经写满
+func (di *DiskIndex) NeedFlush() bool {
+	di.lock.RLock()
+	defer di.lock.RUnlock()
+	return di.memSize >= di.flushSize
+}
+
+// Flush 把内存表写入新的run文件，并记录索引覆盖到的数据文件位置，只需要写入内存表中的数据
+// 调用方需要保证meta之前的数据文件已经持久化，并且之后没有写入索引
+func (di *DiskIndex) Flush(meta DiskIndexMeta) error {
+	di.lock.Lock()
+	defer di.lock.Unlock()
+
+	runs := di.runs
+	var created *diskRun
+	if di.memTable.Len() > 0 {
+		id := di.nextRunId
+		di.nextRunId++
+		run, err := di.writeRun(id, newMemSource(di.memTable), true)
+		if err != nil {
+			return err
+		}
+		if run != nil {
+			created = run
+			runs = append(runs[:len(runs):len(runs)], run)
+		}
+	}
+	if err := di.writeManifest(meta, di.size, runs); err != nil {
+		//写入失败时删除这次生成的run文件
+		if created != nil {
+			created.obsolete.Store(true)
+			created.unref()
+		}
+		return err
+	}
+
+	di.runs = runs
+	di.meta = &meta
+	di.memTable = btree.New(32)
+	di.memSize = 0
+	return nil
+}
+
+// NeedCompact 是否有一组大小相近的run文件需要合并
+func (di *DiskIndex) NeedCompact() bool {
+	di.lock.RLock()
+	defer di.lock.RUnlock()
+	return !di.closed && pickCompaction(di.runs) >= 0
+}
+
+// pickCompaction 从最新的run文件开始向前选择一组大小相近的run文件，返回这组的起始位置，数量不到diskMergeRuns时返回-1
+// 更旧的run文件不超过已选的总大小时才加入，每条记录被合并的次数是对数级别的
+func pickCompaction(runs []*diskRun) int {
+	if len(runs) < diskMergeRuns {
+		return -1
+	}
+	start := len(runs) - 1
+	total := runs[start].size
+	for start > 0 && runs[start-1].size <= total {
+		start--
+		total += runs[start].size
+	}
+	if len(runs)-start < diskMergeRuns {
+		return -1
+	}
+	return start
+}
+
+// Compact 合并大小相近的run文件，直到没有需要合并的run文件，已经有合并在进行时直接返回
+// 读取和写入新run文件时不持有索引的锁，只在替换run文件时短暂持有
+func (di *DiskIndex) Compact() error {
+	if !di.compactLock.TryLock() {
+		return nil
+	}
+	defer di.compactLock.Unlock()
+	for {
+		merged, err := di.compactOnce()
+		if err != nil || !merged {
+			return err
+		}
+	}
+}
+
+// compactOnce 合并一组run文件，没有需要合并的run文件时返回false
+func (di *DiskIndex) compactOnce() (bool, error) {
+	di.lock.Lock()
+	start := -1
+	if !di.closed {
+		start = pickCompaction(di.runs)
+	}
+	if start < 0 {
+		di.lock.Unlock()
+		return false, nil
+	}
+	group := make([]*diskRun, len(di.runs)-start)
+	copy(group, di.runs[start:])
+	for _, run := range group {
+		run.ref()
+	}
+	id := di.nextRunId
+	di.nextRunId++
+	di.lock.Unlock()
+	defer di.releaseRuns(group)
+
+	//包含最旧的run文件时已经没有更旧的数据，删除标记可以丢弃
+	sources := make([]diskSource, 0, len(group))
+	for i := len(group) - 1; i >= 0; i-- {
+		sources = append(sources, &runCursor{run: group[i], cache: di.cache})
+	}
+	run, err := di.writeRun(id, newMergeSource(sources), start > 0)
+	if err != nil {
+		return false, err
+	}
+
+	di.lock.Lock()
+	defer di.lock.Unlock()
+	discard := func() {
+		if run != nil {
+			run.obsolete.Store(true)
+			run.unref()
+		}
+	}
+	//合并期间Flush只会在末尾追加run文件，被Reset或者Close清空时放弃这次合并
+	if di.closed || di.meta == nil || len(di.runs) < start+len(group) {
+		discard()
+		return false, nil
+	}
+	for i, old := range group {
+		if di.runs[start+i] != old {
+			discard()
+			return false, nil
+		}
+	}
+	runs := make([]*diskRun, 0, len(di.runs)-len(group)+1)
+	runs = append(runs, di.runs[:start]...)
+	if run != nil {
+		runs = append(runs, run)
+	}
+	runs = append(runs, di.runs[start+len(group):]...)
+	if err := di.writeManifest(*di.meta, di.size, runs); err != nil {
+		discard()
+		return false, err
+	}
+	di.runs = runs
+	for _, old := range group {
+		old.obsolete.Store(true)
+	}
+	di.releaseRuns(group)
+	return true, nil
+}
+
+// writeRun 把source中的记录按照顺序写入新的run文件，没有记录时返回nil
+func (di *DiskIndex) writeRun(id uint32, source diskSource, keepDeleted bool) (*diskRun, error) {
+	rw, err := newDiskRunWriter(di.dirPath, id)
+	if err != nil {
+		return nil, err
+	}
+	for source.seek(nil, false); source.valid(); source.next(false) {
+		entry := source.entry()
+		if entry.pos == nil && !keepDeleted {
+			continue
+		}
+		if err := rw.add(entry); err != nil {
+			rw.abort()
+			return nil, err
+		}
+	}
+	written, err := rw.finish()
+	if err != nil || !written {
+		_ = os.Remove(rw.fileName)
+		return nil, err
+	}
+	return openDiskRun(di.dirPath, id)
+}
+
+func (di *DiskIndex) releaseRuns(runs []*diskRun) {
+	for _, run := range runs {
+		run.unref()
+	}
+}
+
+// Reset 删除所有持久化的数据，用于数据文件和索引对不上的情况
+func (di *DiskIndex) Reset() error {
+	di.lock.Lock()
+	defer di.lock.Unlock()
+	if err := os.RemoveAll(filepath.Join(di.dirPath, diskManifestFileName)); err != nil {
+		return err
+	}
+	for _, run := range di.runs {
+		run.obsolete.Store(true)
+	}
+	di.releaseRuns(di.runs)
+	di.runs, di.size, di.meta = nil, 0, nil
+	di.memTable = btree.New(32)
+	di.memSize = 0
+	return nil
+}
+
+// Iterator 合并内存表和所有run文件的迭代器，内存表在创建时复制，run文件在迭代器关闭之前不会被删除
+func (di *DiskIndex) Iterator(reverse bool) Iterator {
+	di.lock.RLock()
+	defer di.lock.RUnlock()
+	sources := make([]diskSource, 0, len(di.runs)+1)
+	sources = append(sources, newMemSource(di.memTable))
+	runs := make([]*diskRun, len(di.runs))
+	copy(runs, di.runs)
+	for i := len(runs) - 1; i >= 0; i-- {
+		runs[i].ref()
+		sources = append(sources, &runCursor{run: runs[i], cache: di.cache})
+	}
+	return &DiskIndexIterator{
+		source:  newMergeSource(sources),
+		reverse: reverse,
+		runs:    runs,
+	}
+}
+
+// Close 等待正在进行的合并结束之后关闭run文件，内存表中没有Flush的数据会丢失，下次启动时从数据文件中重放
+func (di *DiskIndex) Close() error {
+	di.compactLock.Lock()
+	defer di.compactLock.Unlock()
+	di.lock.Lock()
+	defer di.lock.Unlock()
+	di.releaseRuns(di.runs)
+	di.runs = nil
+	di.closed = true
+	return nil
+}
+
+// diskSource 有序的记录来源，可以双向移动
+type diskSource interface {
+	// seek 正向时定位到第一个大于等于key的记录，反向时定位到最后一个小于等于key的记录，key为nil时定位到起点
+	seek(key []byte, reverse bool)
+	next(reverse bool)
+	valid() bool
+	entry() *diskEntry
+}
+
+// memSource 内存表的快照
+type memSource struct {
+	entries []*diskEntry
+	idx     int
+}
+
+func newMemSource(tree *btree.BTree) *memSource {
+	entries := make([]*diskEntry, 0, tree.Len())
+	tree.Ascend(func(i btree.Item) bool {
+		entries = append(entries, i.(*diskEntry))
+		return true
+	})
+	return &memSource{entries: entries}
+}
+
+func (ms *memSource) seek(key []byte, reverse bool) {
+	switch {
+	case key == nil && reverse:
+		ms.idx = len(ms.entries) - 1
+	case key == nil:
+		ms.idx = 0
+	case reverse:
+		ms.idx = sort.Search(len(ms.entries), func(i int) bool {
+			return bytes.Compare(ms.entries[i].key, key) > 0
+		}) - 1
+	default:
+		ms.idx = sort.Search(len(ms.entries), func(i int) bool {
+			return bytes.Compare(ms.entries[i].key, key) >= 0
+		})
+	}
+}
+
+func (ms *memSource) next(reverse bool) {
+	if reverse {
+		ms.idx--
+	} else {
+		ms.idx++
+	}
+}
+
+func (ms *memSource) valid() bool {
+	return ms.idx >= 0 && ms.idx < len(ms.entries)
+}
+
+func (ms *memSource) entry() *diskEntry {
+	return ms.entries[ms.idx]
+}
+
+// runCursor 在run文件中移动的游标，每次只加载一个数据块
+type runCursor struct {
+	run     *diskRun
+	cache   *blockCache
+	block   int
+	entries []*diskEntry
+	idx     int
+}
+
+func (rc *runCursor) loadBlock(block int) {
+	rc.block = block
+	if block < 0 || block >= len(rc.run.blocks) {
+		rc.entries = nil
+		return
+	}
+	rc.entries = rc.run.readBlock(block, rc.cache)
+}
+
+func (rc *runCursor) seek(key []byte, reverse bool) {
+	switch {
+	case key == nil && reverse:
+		rc.loadBlock(len(rc.run.blocks) - 1)
+		rc.idx = len(rc.entries) - 1
+	case key == nil:
+		rc.loadBlock(0)
+		rc.idx = 0
+	case reverse:
+		rc.loadBlock(rc.run.findBlock(key))
+		rc.idx = sort.Search(len(rc.entries), func(i int) bool {
+			return bytes.Compare(rc.entries[i].key, key) > 0
+		}) - 1
+	default:
+		block := rc.run.findBlock(key)
+		if block < 0 {
+			block = 0
+		}
+		rc.loadBlock(block)
+		rc.idx = sort.Search(len(rc.entries), func(i int) bool {
+			return bytes.Compare(rc.entries[i].key, key) >= 0
+		})
+		if rc.entries != nil && rc.idx >= len(rc.entries) {
+			rc.loadBlock(block + 1)
+			rc.idx = 0
+		}
+	}
+}
+
+func (rc *runCursor) next(reverse bool) {
+	if reverse {
+		if rc.idx--; rc.idx < 0 {
+			rc.loadBlock(rc.block - 1)
+			rc.idx = len(rc.entries) - 1
+		}
+		return
+	}
+	if rc.idx++; rc.idx >= len(rc.entries) {
+		rc.loadBlock(rc.block + 1)
+		rc.idx = 0
+	}
+}
+
+func (rc *runCursor) valid() bool {
+	return rc.idx >= 0 && rc.idx < len(rc.entries)
+}
+
+func (rc *runCursor) entry() *diskEntry {
+	return rc.entries[rc.idx]
+}
+
+// mergeSource 合并多个按照从新到旧排列的来源，同一个key只返回最新的记录，包括删除标记
+type mergeSource struct {
+	sources []diskSource
+	reverse bool
+	current *diskEntry
+}
+
+func newMergeSource(sources []diskSource) *mergeSource {
+	return &mergeSource{sources: sources}
+}
+
+func (m *mergeSource) seek(key []byte, reverse bool) {
+	m.reverse = reverse
+	for _, source := range m.sources {
+		source.seek(key, reverse)
+	}
+	m.pick()
+}
+
+func (m *mergeSource) next(bool) {
+	m.pick()
+}
+
+// pick 选出所有来源中最小（反向时最大）的key，并让包含这个key的来源都前进一步
+func (m *mergeSource) pick() {
+	m.current = nil
+	for _, source := range m.sources {
+		if !source.valid() {
+			continue
+		}
+		if m.current == nil {
+			m.current = source.entry()
+			continue
+		}
+		cmp := bytes.Compare(source.entry().key, m.current.key)
+		if (!m.reverse && cmp < 0) || (m.reverse && cmp > 0) {
+			m.current = source.entry()
+		}
+	}
+	if m.current == nil {
+		return
+	}
+	for _, source := range m.sources {
+		if source.valid() && bytes.Equal(source.entry().key, m.current.key) {
+			source.next(m.reverse)
+		}
+	}
+}
+
+func (m *mergeSource) valid() bool {
+	return m.current != nil
+}
+
+func (m *mergeSource) entry() *diskEntry {
+	return m.current
+}
+
+// DiskIndexIterator 磁盘索引迭代器，跳过被删除的key
+type DiskIndexIterator struct {
+	source  *mergeSource
+	reverse bool
+	runs    []*diskRun
+}
+
+func (dit *DiskIndexIterator) Rewind() {
+	dit.source.seek(nil, dit.reverse)
+	dit.skipDeleted()
+}
+
+func (dit *DiskIndexIterator) Seek(key []byte) {
+	dit.source.seek(key, dit.reverse)
+	dit.skipDeleted()
+}
+
+func (dit *DiskIndexIterator) Next() {
+	dit.source.next(dit.reverse)
+	dit.skipDeleted()
+}
+
+func (dit *DiskIndexIterator) skipDeleted() {
+	for dit.source.valid() && dit.source.entry().pos == nil {
+		dit.source.next(dit.reverse)
+	}
+}
+
+func (dit *DiskIndexIterator) Valid() bool {
+	return dit.source.valid()
+}
+
+func (dit *DiskIndexIterator) Key() []byte {
+	return dit.source.entry().key
+}
+
+func (dit *DiskIndexIterator) Value() *data.LogRecordPos {
+	return dit.source.entry().pos
+}
+
+func (dit *DiskIndexIterator) Close() {
+	for _, run := range dit.runs {
+		run.unref()
+	}
+	dit.runs = nil
+}
@@ -0,0 +1,392 @@
+package index
+
+import (
+	"bufio"
+	"bytes"
+	"container/list"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/GrandeLai/JDawDB/data"
+	"github.com/GrandeLai/JDawDB/fio"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	diskRunFileSuffix = ".run"
+	// diskBlockSize run文件中每个数据块的目标大小，数据块是读取和缓存的单位
+	diskBlockSize = 4096
+	// run文件的结尾：索引区的偏移量 + 索引区的crc + magic
+	diskRunFooterSize = 8 + 4 + 4
+	diskRunMagic      = "JDRN"
+)
+
+var errInvalidDiskRun = errors.New("invalid disk index run file")
+
+// diskEntry 磁盘索引中的一条记录，pos为nil表示key已经被删除
+type diskEntry struct {
+	key []byte
+	pos *data.LogRecordPos
+}
+
+// blockHandle 数据块在run文件中的位置，以及数据块中的第一个key
+type blockHandle struct {
+	firstKey []byte
+	offset   int64
+	length   int64
+}
+
+// diskRun 一个不可变的有序run文件，内存中只保存每个数据块的第一个key
+// 迭代器会持有引用，最后一个引用释放之后才关闭文件，被合并掉的run文件在这时删除
+type diskRun struct {
+	id       uint32
+	fileName string
+	file     *os.File
+	blocks   []blockHandle
+	size     int64
+	refs     atomic.Int32
+	obsolete atomic.Bool
+}
+
+func getDiskRunFileName(dirPath string, id uint32) string {
+	return filepath.Join(dirPath, fmt.Sprintf("%09d", id)+diskRunFileSuffix)
+}
+
+// openDiskRun 打开run文件并读取数据块索引
+func openDiskRun(dirPath string, id uint32) (*diskRun, error) {
+	fileName := getDiskRunFileName(dirPath, id)
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	run := &diskRun{id: id, fileName: fileName, file: file}
+	if err := run.readBlockIndex(); err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	run.refs.Store(1)
+	return run, nil
+}
+
+func (run *diskRun) readBlockIndex() error {
+	stat, err := run.file.Stat()
+	if err != nil {
+		return err
+	}
+	if stat.Size() < diskRunFooterSize {
+		return errInvalidDiskRun
+	}
+	run.size = stat.Size()
+	footer := make([]byte, diskRunFooterSize)
+	if _, err := run.file.ReadAt(footer, stat.Size()-diskRunFooterSize); err != nil {
+		return err
+	}
+	if string(footer[12:]) != diskRunMagic {
+		return errInvalidDiskRun
+	}
+	indexOffset := int64(binary.BigEndian.Uint64(footer[:8]))
+	if indexOffset < 0 || indexOffset > stat.Size()-diskRunFooterSize {
+		return errInvalidDiskRun
+	}
+	buf := make([]byte, stat.Size()-diskRunFooterSize-indexOffset)
+	if _, err := run.file.ReadAt(buf, indexOffset); err != nil {
+		return err
+	}
+	if crc32.ChecksumIEEE(buf) != binary.BigEndian.Uint32(footer[8:12]) {
+		return errInvalidDiskRun
+	}
+
+	for len(buf) > 0 {
+		var handle blockHandle
+		keySize, n := binary.Uvarint(buf)
+		if n <= 0 || uint64(len(buf)-n) < keySize {
+			return errInvalidDiskRun
+		}
+		buf = buf[n:]
+		handle.firstKey, buf = buf[:keySize], buf[keySize:]
+		offset, n := binary.Uvarint(buf)
+		if n <= 0 {
+			return errInvalidDiskRun
+		}
+		buf = buf[n:]
+		length, n := binary.Uvarint(buf)
+		if n <= 0 {
+			return errInvalidDiskRun
+		}
+		buf = buf[n:]
+		handle.offset, handle.length = int64(offset), int64(length)
+		run.blocks = append(run.blocks, handle)
+	}
+	return nil
+}
+
+// findBlock 找到最后一个第一个key小于等于传入key的数据块，没有时返回-1
+func (run *diskRun) findBlock(key []byte) int {
+	return sort.Search(len(run.blocks), func(i int) bool {
+		return bytes.Compare(run.blocks[i].firstKey, key) > 0
+	}) - 1
+}
+
+// readBlock 读取数据块中的记录，优先从缓存中读取
+// 和b+树索引一样，读取失败时直接panic
+func (run *diskRun) readBlock(i int, cache *blockCache) []*diskEntry {
+	cacheKey := blockCacheKey{runId: run.id, block: i}
+	if entries := cache.get(cacheKey); entries != nil {
+		return entries
+	}
+	handle := run.blocks[i]
+	buf := make([]byte, handle.length)
+	if _, err := run.file.ReadAt(buf, handle.offset); err != nil {
+		panic(fmt.Sprintf("failed to read disk index block, %v", err))
+	}
+	entries, err := decodeDiskBlock(buf)
+	if err != nil {
+		panic(fmt.Sprintf("failed to decode disk index block, %v", err))
+	}
+	cache.add(cacheKey, entries, handle.length)
+	return entries
+}
+
+// get 查找key，没有找到时返回nil，找到被删除的key时返回pos为nil的记录
+func (run *diskRun) get(key []byte, cache *blockCache) *diskEntry {
+	i := run.findBlock(key)
+	if i < 0 {
+		return nil
+	}
+	entries := run.readBlock(i, cache)
+	idx := sort.Search(len(entries), func(j int) bool {
+		return bytes.Compare(entries[j].key, key) >= 0
+	})
+	if idx < len(entries) && bytes.Equal(entries[idx].key, key) {
+		return entries[idx]
+	}
+	return nil
+}
+
+func (run *diskRun) ref() {
+	run.refs.Add(1)
+}
+
+// unref 释放引用，没有引用时关闭文件，已经被合并掉的run文件同时删除
+func (run *diskRun) unref() {
+	if run.refs.Add(-1) > 0 {
+		return
+	}
+	_ = run.file.Close()
+	if run.obsolete.Load() {
+		_ = os.Remove(run.fileName)
+	}
+}
+
+// 数据块格式：多个(key长度 + key + 是否删除 + 位置) + crc
+func encodeDiskEntry(buf []byte, entry *diskEntry) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(entry.key)))
+	buf = append(buf, entry.key...)
+	if entry.pos == nil {
+		return append(buf, 1)
+	}
+	buf = append(buf, 0)
+	buf = binary.AppendUvarint(buf, uint64(entry.pos.Fid))
+	buf = binary.AppendVarint(buf, entry.pos.Offset)
+	return binary.AppendUvarint(buf, uint64(entry.pos.Size))
+}
+
+func decodeDiskBlock(buf []byte) ([]*diskEntry, error) {
+	if len(buf) < crc32.Size {
+		return nil, errInvalidDiskRun
+	}
+	content := buf[:len(buf)-crc32.Size]
+	if crc32.ChecksumIEEE(content) != binary.BigEndian.Uint32(buf[len(content):]) {
+		return nil, data.ErrInvalidCRC
+	}
+	var entries []*diskEntry
+	for len(content) > 0 {
+		keySize, n := binary.Uvarint(content)
+		if n <= 0 || uint64(len(content)-n) <= keySize {
+			return nil, errInvalidDiskRun
+		}
+		content = content[n:]
+		entry := &diskEntry{key: content[:keySize]}
+		deleted := content[keySize]
+		content = content[keySize+1:]
+		if deleted == 0 {
+			fid, n1 := binary.Uvarint(content)
+			if n1 <= 0 {
+				return nil, errInvalidDiskRun
+			}
+			offset, n2 := binary.Varint(content[n1:])
+			if n2 <= 0 {
+				return nil, errInvalidDiskRun
+			}
+			size, n3 := binary.Uvarint(content[n1+n2:])
+			if n3 <= 0 {
+				return nil, errInvalidDiskRun
+			}
+			content = content[n1+n2+n3:]
+			entry.pos = &data.LogRecordPos{Fid: uint32(fid), Offset: offset, Size: uint32(size)}
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// diskRunWriter 按照key的顺序写入run文件，先写临时文件，完成之后再重命名
+type diskRunWriter struct {
+	fileName string
+	file     *os.File
+	w        *bufio.Writer
+	block    []byte
+	firstKey []byte
+	blocks   []blockHandle
+	offset   int64
+}
+
+func newDiskRunWriter(dirPath string, id uint32) (*diskRunWriter, error) {
+	fileName := getDiskRunFileName(dirPath, id)
+	file, err := os.OpenFile(fileName+".tmp", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, fio.DataFilePerm)
+	if err != nil {
+		return nil, err
+	}
+	return &diskRunWriter{
+		fileName: fileName,
+		file:     file,
+		w:        bufio.NewWriter(file),
+	}, nil
+}
+
+func (rw *diskRunWriter) add(entry *diskEntry) error {
+	if len(rw.block) == 0 {
+		rw.firstKey = entry.key
+	}
+	rw.block = encodeDiskEntry(rw.block, entry)
+	if len(rw.block) >= diskBlockSize {
+		return rw.finishBlock()
+	}
+	return nil
+}
+
+func (rw *diskRunWriter) finishBlock() error {
+	if len(rw.block) == 0 {
+		return nil
+	}
+	rw.block = binary.BigEndian.AppendUint32(rw.block, crc32.ChecksumIEEE(rw.block))
+	if _, err := rw.w.Write(rw.block); err != nil {
+		return err
+	}
+	rw.blocks = append(rw.blocks, blockHandle{
+		firstKey: rw.firstKey,
+		offset:   rw.offset,
+		length:   int64(len(rw.block)),
+	})
+	rw.offset += int64(len(rw.block))
+	rw.block = rw.block[:0]
+	return nil
+}
+
+// abort 放弃写入，删除临时文件
+func (rw *diskRunWriter) abort() {
+	_ = rw.file.Close()
+	_ = os.Remove(rw.file.Name())
+}
+
+// finish 写入数据块索引并持久化，返回false表示没有写入任何记录，不会生成文件
+func (rw *diskRunWriter) finish() (bool, error) {
+	defer func() {
+		_ = rw.file.Close()
+		_ = os.Remove(rw.file.Name())
+	}()
+	if err := rw.finishBlock(); err != nil {
+		return false, err
+	}
+	if len(rw.blocks) == 0 {
+		return false, nil
+	}
+	var index []byte
+	for _, handle := range rw.blocks {
+		index = binary.AppendUvarint(index, uint64(len(handle.firstKey)))
+		index = append(index, handle.firstKey...)
+		index = binary.AppendUvarint(index, uint64(handle.offset))
+		index = binary.AppendUvarint(index, uint64(handle.length))
+	}
+	footer := binary.BigEndian.AppendUint64(nil, uint64(rw.offset))
+	footer = binary.BigEndian.AppendUint32(footer, crc32.ChecksumIEEE(index))
+	footer = append(footer, diskRunMagic...)
+	if _, err := rw.w.Write(index); err != nil {
+		return false, err
+	}
+	if _, err := rw.w.Write(footer); err != nil {
+		return false, err
+	}
+	if err := rw.w.Flush(); err != nil {
+		return false, err
+	}
+	if err := rw.file.Sync(); err != nil {
+		return false, err
+	}
+	if err := rw.file.Close(); err != nil {
+		return false, err
+	}
+	return true, os.Rename(rw.file.Name(), rw.fileName)
+}
+
+type blockCacheKey struct {
+	runId uint32
+	block int
+}
+
+type blockCacheItem struct {
+	key     blockCacheKey
+	entries []*diskEntry
+	size    int64
+}
+
+// blockCache 按照数据块大小限制总容量的LRU缓存
+type blockCache struct {
+	lock     *sync.Mutex
+	capacity int64
+	used     int64
+	ll       *list.List
+	items    map[blockCacheKey]*list.Element
+}
+
+func newBlockCache(capacity int64) *blockCache {
+	return &blockCache{
+		lock:     new(sync.Mutex),
+		capacity: capacity,
+		ll:       list.New(),
+		items:    make(map[blockCacheKey]*list.Element),
+	}
+}
+
+func (c *blockCache) get(key blockCacheKey) []*diskEntry {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	elem, ok := c.items[key]
+	if !ok {
+		return nil
+	}
+	c.ll.MoveToFront(elem)
+	return elem.Value.(*blockCacheItem).entries
+}
+
+func (c *blockCache) add(key blockCacheKey, entries []*diskEntry, size int64) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if _, ok := c.items[key]; ok || size > c.capacity {
+		return
+	}
+	c.items[key] = c.ll.PushFront(&blockCacheItem{key: key, entries: entries, size: size})
+	c.used += size
+	for c.used > c.capacity {
+		oldest := c.ll.Back()
+		item := oldest.Value.(*blockCacheItem)
+		c.ll.Remove(oldest)
+		delete(c.items, item.key)
+		c.used -= item.size
+	}
+}
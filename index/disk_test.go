@@ -0,0 +1,178 @@
+package index
+
+import (
+	"fmt"
+	"github.com/GrandeLai/JDawDB/data"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func diskTestKey(i int) []byte {
+	return []byte(fmt.Sprintf("disk-index-key-%09d", i))
+}
+
+func TestDiskIndex_PutGetDelete(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "disk-index-put")
+	defer os.RemoveAll(dir)
+	di, err := NewDiskIndex(dir, 0)
+	assert.Nil(t, err)
+	defer di.Close()
+
+	assert.Nil(t, di.Put([]byte("key-1"), &data.LogRecordPos{Fid: 1, Offset: 12}))
+	assert.Nil(t, di.Put([]byte("key-2"), &data.LogRecordPos{Fid: 1, Offset: 24}))
+	err = di.Flush(DiskIndexMeta{Fid: 1, Offset: 36})
+	assert.Nil(t, err)
+
+	// 内存表中的数据覆盖run文件中的数据
+	oldPos := di.Put([]byte("key-1"), &data.LogRecordPos{Fid: 2, Offset: 0})
+	assert.Equal(t, int64(12), oldPos.Offset)
+	assert.Equal(t, uint32(2), di.Get([]byte("key-1")).Fid)
+	pos, ok := di.Delete([]byte("key-2"))
+	assert.True(t, ok)
+	assert.Equal(t, int64(24), pos.Offset)
+	assert.Nil(t, di.Get([]byte("key-2")))
+	_, ok = di.Delete([]byte("key-2"))
+	assert.False(t, ok)
+	assert.Equal(t, 1, di.Size())
+
+	err = di.Flush(DiskIndexMeta{Fid: 2, Offset: 100, SeqNo: 3, ReclaimSize: 10})
+	assert.Nil(t, err)
+	assert.Nil(t, di.Get([]byte("key-2")))
+	assert.Equal(t, uint32(2), di.Get([]byte("key-1")).Fid)
+}
+
+func TestDiskIndex_Reopen(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "disk-index-reopen")
+	defer os.RemoveAll(dir)
+	di, err := NewDiskIndex(dir, 4096)
+	assert.Nil(t, err)
+
+	// 刷盘只写入内存表，大小相近的run文件由Compact合并
+	for round := 0; round < diskMergeRuns+2; round++ {
+		for i := round * 500; i < (round+1)*500; i++ {
+			di.Put(diskTestKey(i), &data.LogRecordPos{Fid: uint32(round), Offset: int64(i)})
+		}
+		for i := round * 500; i < round*500+100; i++ {
+			di.Delete(diskTestKey(i))
+		}
+		err = di.Flush(DiskIndexMeta{Fid: uint32(round), Offset: int64(round)})
+		assert.Nil(t, err)
+	}
+	assert.Equal(t, diskMergeRuns+2, len(di.runs))
+	assert.True(t, di.NeedCompact())
+	err = di.Compact()
+	assert.Nil(t, err)
+	assert.True(t, len(di.runs) < diskMergeRuns)
+	assert.False(t, di.NeedCompact())
+	// 没有刷盘的数据在重新打开之后丢失
+	di.Put(diskTestKey(100000), &data.LogRecordPos{Fid: 100})
+	err = di.Close()
+	assert.Nil(t, err)
+
+	di, err = NewDiskIndex(dir, 4096)
+	assert.Nil(t, err)
+	defer di.Close()
+	meta := di.Meta()
+	assert.Equal(t, uint32(diskMergeRuns+1), meta.Fid)
+	assert.Equal(t, (diskMergeRuns+2)*400, di.Size())
+	assert.Nil(t, di.Get(diskTestKey(100000)))
+	assert.Nil(t, di.Get(diskTestKey(50)))
+	assert.Equal(t, int64(1234), di.Get(diskTestKey(1234)).Offset)
+	assert.True(t, di.cache.used <= 4096)
+
+	entries, err := os.ReadDir(di.dirPath)
+	assert.Nil(t, err)
+	assert.Equal(t, len(di.runs)+1, len(entries))
+
+	err = di.Reset()
+	assert.Nil(t, err)
+	assert.Nil(t, di.Meta())
+	assert.Equal(t, 0, di.Size())
+	assert.Nil(t, di.Get(diskTestKey(1234)))
+}
+
+func TestDiskIndex_Iterator(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "disk-index-iterator")
+	defer os.RemoveAll(dir)
+	di, err := NewDiskIndex(dir, 0)
+	assert.Nil(t, err)
+	defer di.Close()
+
+	for i := 0; i < 1000; i += 2 {
+		di.Put(diskTestKey(i), &data.LogRecordPos{Offset: int64(i)})
+	}
+	err = di.Flush(DiskIndexMeta{})
+	assert.Nil(t, err)
+	for i := 1; i < 1000; i += 2 {
+		di.Put(diskTestKey(i), &data.LogRecordPos{Offset: int64(i)})
+	}
+	for i := 0; i < 1000; i += 10 {
+		di.Delete(diskTestKey(i))
+	}
+
+	iter := di.Iterator(false)
+	var count int
+	var last []byte
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		assert.True(t, last == nil || string(last) < string(iter.Key()))
+		last = iter.Key()
+		count++
+	}
+	assert.Equal(t, 900, count)
+
+	iter.Seek(diskTestKey(500))
+	assert.Equal(t, diskTestKey(501), iter.Key())
+	iter.Close()
+
+	// 迭代器打开期间合并的run文件不会被删除
+	reverseIter := di.Iterator(true)
+	for i := 0; i < diskMergeRuns+1; i++ {
+		di.Put(diskTestKey(2000+i), &data.LogRecordPos{})
+		err = di.Flush(DiskIndexMeta{})
+		assert.Nil(t, err)
+	}
+	err = di.Compact()
+	assert.Nil(t, err)
+	reverseIter.Seek(diskTestKey(500))
+	assert.Equal(t, diskTestKey(499), reverseIter.Key())
+	reverseIter.Next()
+	assert.Equal(t, diskTestKey(498), reverseIter.Key())
+	reverseIter.Rewind()
+	assert.Equal(t, diskTestKey(999), reverseIter.Key())
+	reverseIter.Close()
+}
+
+func TestDiskIndex_CompactConcurrent(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "disk-index-compact")
+	defer os.RemoveAll(dir)
+	di, err := NewDiskIndex(dir, 0)
+	assert.Nil(t, err)
+
+	// 合并期间继续写入和刷盘，新的run文件不会丢失
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 50; i++ {
+			assert.Nil(t, di.Compact())
+		}
+	}()
+	for round := 0; round < 40; round++ {
+		for i := round * 100; i < (round+1)*100; i++ {
+			di.Put(diskTestKey(i), &data.LogRecordPos{Offset: int64(i)})
+		}
+		di.Delete(diskTestKey(round * 100))
+		assert.Nil(t, di.Flush(DiskIndexMeta{Fid: uint32(round)}))
+	}
+	<-done
+	assert.Nil(t, di.Compact())
+	assert.False(t, di.NeedCompact())
+	assert.Nil(t, di.Close())
+
+	di, err = NewDiskIndex(dir, 0)
+	assert.Nil(t, err)
+	defer di.Close()
+	assert.Equal(t, 40*99, di.Size())
+	assert.Nil(t, di.Get(diskTestKey(100)))
+	assert.Equal(t, int64(3999), di.Get(diskTestKey(3999)).Offset)
+}
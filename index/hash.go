@@ -0,0 +1,198 @@
+package index
+
+import (
+	"bytes"
+	"github.com/GrandeLai/JDawDB/data"
+	"hash/maphash"
+	"sort"
+	"sync"
+)
+
+// KeyReader 根据位置从数据文件中读取记录的key，哈希索引用它来区分指纹相同的key
+type KeyReader func(pos *data.LogRecordPos) ([]byte, error)
+
+// hashSlot 内联保存的位置信息，避免为每个key单独分配LogRecordPos
+type hashSlot struct {
+	fid    uint32
+	size   uint32
+	offset int64
+}
+
+func newHashSlot(pos *data.LogRecordPos) hashSlot {
+	return hashSlot{fid: pos.Fid, size: pos.Size, offset: pos.Offset}
+}
+
+func (s hashSlot) pos() *data.LogRecordPos {
+	return &data.LogRecordPos{Fid: s.fid, Offset: s.offset, Size: s.size}
+}
+
+// HashIndex 只在内存中保存key的64位哈希指纹和位置，不保存key本身，适合key很长或者数量很多的场景
+// 指纹命中时会从数据文件中读回key进行确认，因此每次读写索引都会多一次磁盘读取
+// 不支持有序遍历，迭代器需要读回所有的key之后排序
+type HashIndex struct {
+	lock       *sync.RWMutex
+	hash       func(key []byte) uint64 //计算key的指纹
+	slots      map[uint64]hashSlot     //只有一个key的指纹
+	collisions map[uint64][]hashSlot   //多个key共用的指纹，和slots不会同时出现
+	size       int
+	keyReader  KeyReader
+}
+
+// NewHashIndex 初始化哈希索引，keyReader可以之后通过SetKeyReader设置
+func NewHashIndex(keyReader KeyReader) *HashIndex {
+	seed := maphash.MakeSeed()
+	return &HashIndex{
+		lock: new(sync.RWMutex),
+		hash: func(key []byte) uint64 {
+			return maphash.Bytes(seed, key)
+		},
+		slots:      make(map[uint64]hashSlot),
+		collisions: make(map[uint64][]hashSlot),
+		keyReader:  keyReader,
+	}
+}
+
+// SetKeyReader 设置读取key的方法，需要在使用索引之前调用
+func (h *HashIndex) SetKeyReader(keyReader KeyReader) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.keyReader = keyReader
+}
+
+// matchKey 读回slot对应的key并和传入的key比较，读取失败时返回错误
+func (h *HashIndex) matchKey(slot hashSlot, key []byte) (bool, error) {
+	storedKey, err := h.keyReader(slot.pos())
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(storedKey, key), nil
+}
+
+func (h *HashIndex) Put(key []byte, pos *data.LogRecordPos) *data.LogRecordPos {
+	fp := h.hash(key)
+	newSlot := newHashSlot(pos)
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if slot, ok := h.slots[fp]; ok {
+		//key读取失败时指纹几乎不可能是冲突的，按照同一个key处理
+		if match, err := h.matchKey(slot, key); match || err != nil {
+			h.slots[fp] = newSlot
+			return slot.pos()
+		}
+		delete(h.slots, fp)
+		h.collisions[fp] = []hashSlot{slot, newSlot}
+		h.size++
+		return nil
+	}
+	if bucket, ok := h.collisions[fp]; ok {
+		for i, slot := range bucket {
+			if match, _ := h.matchKey(slot, key); match {
+				bucket[i] = newSlot
+				return slot.pos()
+			}
+		}
+		h.collisions[fp] = append(bucket, newSlot)
+		h.size++
+		return nil
+	}
+	h.slots[fp] = newSlot
+	h.size++
+	return nil
+}
+
+func (h *HashIndex) Get(key []byte) *data.LogRecordPos {
+	fp := h.hash(key)
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+
+	if slot, ok := h.slots[fp]; ok {
+		if match, _ := h.matchKey(slot, key); match {
+			return slot.pos()
+		}
+		return nil
+	}
+	for _, slot := range h.collisions[fp] {
+		if match, _ := h.matchKey(slot, key); match {
+			return slot.pos()
+		}
+	}
+	return nil
+}
+
+func (h *HashIndex) Delete(key []byte) (*data.LogRecordPos, bool) {
+	fp := h.hash(key)
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if slot, ok := h.slots[fp]; ok {
+		if match, _ := h.matchKey(slot, key); !match {
+			return nil, false
+		}
+		delete(h.slots, fp)
+		h.size--
+		return slot.pos(), true
+	}
+	bucket := h.collisions[fp]
+	for i, slot := range bucket {
+		if match, _ := h.matchKey(slot, key); !match {
+			continue
+		}
+		bucket = append(bucket[:i], bucket[i+1:]...)
+		//只剩一个key时放回slots
+		if len(bucket) == 1 {
+			delete(h.collisions, fp)
+			h.slots[fp] = bucket[0]
+		} else {
+			h.collisions[fp] = bucket
+		}
+		h.size--
+		return slot.pos(), true
+	}
+	return nil, false
+}
+
+func (h *HashIndex) Size() int {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	return h.size
+}
+
+// Iterator 读回所有的key并排序，读取失败的key会被跳过
+func (h *HashIndex) Iterator(reverse bool) Iterator {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+
+	values := make([]*Item, 0, h.size)
+	addItem := func(slot hashSlot) {
+		pos := slot.pos()
+		key, err := h.keyReader(pos)
+		if err != nil {
+			return
+		}
+		values = append(values, &Item{key: key, pos: pos})
+	}
+	for _, slot := range h.slots {
+		addItem(slot)
+	}
+	for _, bucket := range h.collisions {
+		for _, slot := range bucket {
+			addItem(slot)
+		}
+	}
+	sort.Slice(values, func(i, j int) bool {
+		if reverse {
+			return bytes.Compare(values[i].key, values[j].key) > 0
+		}
+		return bytes.Compare(values[i].key, values[j].key) < 0
+	})
+	return &BtreeIterator{
+		currIndex: 0,
+		reverse:   reverse,
+		values:    values,
+	}
+}
+
+func (h *HashIndex) Close() error {
+	return nil
+}
@@ -0,0 +1,84 @@
+package index
+
+import (
+	"errors"
+	"github.com/GrandeLai/JDawDB/data"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// newTestHashIndex 用map模拟数据文件，所有key的指纹都相同，用于测试冲突的处理
+func newTestHashIndex() (*HashIndex, map[int64][]byte) {
+	records := make(map[int64][]byte)
+	h := NewHashIndex(func(pos *data.LogRecordPos) ([]byte, error) {
+		key, ok := records[pos.Offset]
+		if !ok {
+			return nil, errors.New("record not found")
+		}
+		return key, nil
+	})
+	h.hash = func(key []byte) uint64 {
+		return 1
+	}
+	return h, records
+}
+
+func TestHashIndex_PutGet(t *testing.T) {
+	h, records := newTestHashIndex()
+	records[0], records[10], records[20] = []byte("key-1"), []byte("key-2"), []byte("key-1")
+
+	assert.Nil(t, h.Put([]byte("key-1"), &data.LogRecordPos{Fid: 1, Offset: 0}))
+	assert.Nil(t, h.Put([]byte("key-2"), &data.LogRecordPos{Fid: 1, Offset: 10}))
+	assert.Equal(t, 2, h.Size())
+	assert.Equal(t, int64(0), h.Get([]byte("key-1")).Offset)
+	assert.Equal(t, int64(10), h.Get([]byte("key-2")).Offset)
+	assert.Nil(t, h.Get([]byte("not exist")))
+
+	oldPos := h.Put([]byte("key-1"), &data.LogRecordPos{Fid: 1, Offset: 20})
+	assert.Equal(t, int64(0), oldPos.Offset)
+	assert.Equal(t, int64(20), h.Get([]byte("key-1")).Offset)
+	assert.Equal(t, 2, h.Size())
+}
+
+func TestHashIndex_Delete(t *testing.T) {
+	h, records := newTestHashIndex()
+	records[0], records[10] = []byte("key-1"), []byte("key-2")
+	h.Put([]byte("key-1"), &data.LogRecordPos{Fid: 1, Offset: 0})
+	h.Put([]byte("key-2"), &data.LogRecordPos{Fid: 1, Offset: 10})
+
+	pos, ok := h.Delete([]byte("not exist"))
+	assert.Nil(t, pos)
+	assert.False(t, ok)
+
+	pos, ok = h.Delete([]byte("key-1"))
+	assert.True(t, ok)
+	assert.Equal(t, int64(0), pos.Offset)
+	assert.Nil(t, h.Get([]byte("key-1")))
+	assert.Equal(t, int64(10), h.Get([]byte("key-2")).Offset)
+	assert.Equal(t, 1, h.Size())
+	assert.Equal(t, 0, len(h.collisions))
+
+	_, ok = h.Delete([]byte("key-2"))
+	assert.True(t, ok)
+	assert.Equal(t, 0, h.Size())
+}
+
+func TestHashIndex_Iterator(t *testing.T) {
+	h, records := newTestHashIndex()
+	records[0], records[10], records[20] = []byte("ccde"), []byte("acee"), []byte("bbcd")
+	h.Put([]byte("ccde"), &data.LogRecordPos{Fid: 1, Offset: 0})
+	h.Put([]byte("acee"), &data.LogRecordPos{Fid: 1, Offset: 10})
+	h.Put([]byte("bbcd"), &data.LogRecordPos{Fid: 1, Offset: 20})
+
+	iter := h.Iterator(false)
+	var keys []string
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		keys = append(keys, string(iter.Key()))
+	}
+	assert.Equal(t, []string{"acee", "bbcd", "ccde"}, keys)
+
+	iter = h.Iterator(true)
+	iter.Seek([]byte("bz"))
+	assert.Equal(t, "bbcd", string(iter.Key()))
+	assert.Equal(t, int64(20), iter.Value().Offset)
+}
@@ -30,22 +30,74 @@ const (
 
 	// BPTree B+ 树索引
 	BPTree
+
+	// Hash 只保存key指纹的哈希索引
+	Hash
+
+	// SkipList 并发跳表索引
+	SkipList
+
+	// Disk 不需要把所有key放在内存中的磁盘索引
+	Disk
 )
 
-// NewIndexer 根据类型索引
-func NewIndexer(tp IndexType, dirPath string, sync bool) Indexer {
+// NewIndexer 根据类型索引，磁盘索引打开失败时返回错误
+func NewIndexer(tp IndexType, dirPath string, sync bool) (Indexer, error) {
 	switch tp {
 	case Btree:
-		return NewBtree()
+		return NewBtree(), nil
 	case ART:
-		return NewART()
+		return NewART(), nil
 	case BPTree:
-		return NewBPlusTree(dirPath, sync)
+		return NewBPlusTree(dirPath, sync), nil
+	case Disk:
+		return NewDiskIndex(dirPath, DefaultDiskIndexCacheSize)
+	case SkipList:
+		return NewSkipList(), nil
+	case Hash:
+		//读取key的方法由DB在打开之后设置
+		return NewHashIndex(nil), nil
 	default:
 		panic("unsupported index type")
 	}
 }
 
+// PrefixCounter 不需要通过迭代器就能统计前缀下key数量的索引
+type PrefixCounter interface {
+	CountPrefix(prefix []byte) int
+}
+
+// CountPrefix 统计索引中以prefix开头的key数量，索引没有实现PrefixCounter时通过迭代器统计
+func CountPrefix(indexer Indexer, prefix []byte) int {
+	if len(prefix) == 0 {
+		return indexer.Size()
+	}
+	if counter, ok := indexer.(PrefixCounter); ok {
+		return counter.CountPrefix(prefix)
+	}
+	iterator := indexer.Iterator(false)
+	defer iterator.Close()
+	var count int
+	for iterator.Seek(prefix); iterator.Valid() && bytes.HasPrefix(iterator.Key(), prefix); iterator.Next() {
+		count++
+	}
+	return count
+}
+
+// LiveIterable 可以直接在索引上遍历、不需要复制所有key的索引
+type LiveIterable interface {
+	// LiveIterator 返回直接在索引上遍历的迭代器，遍历期间的写入可能可见
+	LiveIterator(reverse bool) Iterator
+}
+
+// NewLiveIterator 返回直接在索引上遍历的迭代器，索引没有实现LiveIterable时使用Iterator
+func NewLiveIterator(indexer Indexer, reverse bool) Iterator {
+	if iterable, ok := indexer.(LiveIterable); ok {
+		return iterable.LiveIterator(reverse)
+	}
+	return indexer.Iterator(reverse)
+}
+
 // Item 实现btree库内的Item接口
 type Item struct {
 	key []byte
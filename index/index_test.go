@@ -0,0 +1,98 @@
+package index
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/GrandeLai/JDawDB/data"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func TestCountPrefix(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "count-prefix")
+	defer os.RemoveAll(dir)
+	indexers := map[string]Indexer{
+		"btree":    NewBtree(),
+		"art":      NewART(),
+		"bptree":   NewBPlusTree(dir, false),
+		"skiplist": NewSkipList(),
+	}
+	diskIndex, err := NewDiskIndex(dir, 0)
+	assert.Nil(t, err)
+	indexers["disk"] = diskIndex
+
+	for name, indexer := range indexers {
+		for _, key := range []string{"a", "ab", "abc", "abd", "b", "bcd"} {
+			indexer.Put([]byte(key), &data.LogRecordPos{Fid: 1, Offset: 12})
+		}
+		indexer.Delete([]byte("abd"))
+		assert.Equal(t, 5, CountPrefix(indexer, nil), name)
+		assert.Equal(t, 3, CountPrefix(indexer, []byte("a")), name)
+		assert.Equal(t, 2, CountPrefix(indexer, []byte("ab")), name)
+		assert.Equal(t, 1, CountPrefix(indexer, []byte("bc")), name)
+		assert.Equal(t, 0, CountPrefix(indexer, []byte("c")), name)
+		assert.Nil(t, indexer.Close(), name)
+	}
+}
+
+func TestNewLiveIterator(t *testing.T) {
+	indexers := map[string]Indexer{
+		"btree":    NewBtree(),
+		"art":      NewART(),
+		"skiplist": NewSkipList(),
+	}
+	key := func(i int) []byte {
+		return []byte(fmt.Sprintf("key-%04d", i))
+	}
+
+	for name, indexer := range indexers {
+		//数量超过一批，需要多次从树上取出
+		for i := 0; i < 1000; i += 2 {
+			indexer.Put(key(i), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+		}
+
+		var n int
+		it := NewLiveIterator(indexer, false)
+		for it.Rewind(); it.Valid(); it.Next() {
+			assert.Equal(t, key(n), it.Key(), name)
+			n += 2
+		}
+		it.Close()
+		assert.Equal(t, 1000, n, name)
+
+		n = 998
+		it = NewLiveIterator(indexer, true)
+		for it.Rewind(); it.Valid(); it.Next() {
+			assert.Equal(t, key(n), it.Key(), name)
+			n -= 2
+		}
+		it.Close()
+		assert.Equal(t, -2, n, name)
+
+		it = NewLiveIterator(indexer, false)
+		it.Seek(key(501))
+		assert.Equal(t, key(502), it.Key(), name)
+		it.Close()
+		it = NewLiveIterator(indexer, true)
+		it.Seek(key(501))
+		assert.Equal(t, key(500), it.Key(), name)
+		it.Close()
+
+		//遍历期间写入，已经存在的key不会重复或者遗漏
+		var last []byte
+		n = 0
+		it = NewLiveIterator(indexer, false)
+		for it.Rewind(); it.Valid(); it.Next() {
+			assert.True(t, bytes.Compare(last, it.Key()) < 0, name)
+			last = it.Key()
+			if bytes.Equal(key(n), it.Key()) {
+				indexer.Put(key(n+1), &data.LogRecordPos{Fid: 1, Offset: int64(n + 1)})
+				indexer.Delete(key(n + 2000))
+				n += 2
+			}
+		}
+		it.Close()
+		assert.Equal(t, 1000, n, name)
+	}
+}
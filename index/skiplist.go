@@ -0,0 +1,264 @@
+package index
+
+import (
+	"bytes"
+	"github.com/GrandeLai/JDawDB/data"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	skipListMaxLevel = 24
+	// skipListBranching 每一层的节点数大约是下一层的1/4
+	skipListBranching = 4
+)
+
+type skipListNode struct {
+	key   []byte
+	value atomic.Pointer[data.LogRecordPos] //为nil表示key已经被删除，节点已经从链表中摘除
+	next  []atomic.Pointer[skipListNode]
+}
+
+// ConcurrentSkipList 并发跳表索引，读取和迭代不加锁，写入之间通过互斥锁串行
+// 写入没有使用CAS链接节点：DB对索引的写入都在db.mu的写锁下进行，本身就是串行的，
+// 只需要保证不加锁的读取和迭代能看到完整的节点，writeLock只在直接使用索引时保证写入之间互斥，在DB中不会发生竞争
+// 删除时把节点从每一层摘除，被摘除节点的next不会再修改，停在这个节点上的迭代器仍然可以继续向后遍历
+type ConcurrentSkipList struct {
+	head      *skipListNode
+	writeLock *sync.Mutex
+	height    atomic.Int32 //当前使用的层数
+	size      atomic.Int64 //未删除的key数量
+}
+
+func NewSkipList() *ConcurrentSkipList {
+	sl := &ConcurrentSkipList{
+		head:      &skipListNode{next: make([]atomic.Pointer[skipListNode], skipListMaxLevel)},
+		writeLock: new(sync.Mutex),
+	}
+	sl.height.Store(1)
+	return sl
+}
+
+func randomLevel() int {
+	level := 1
+	for level < skipListMaxLevel && rand.Intn(skipListBranching) == 0 {
+		level++
+	}
+	return level
+}
+
+// findSplice 找到每一层中key应该插入的位置，prevs[i].key < key <= nexts[i].key
+func (sl *ConcurrentSkipList) findSplice(key []byte) (prevs, nexts [skipListMaxLevel]*skipListNode) {
+	prev := sl.head
+	for level := skipListMaxLevel - 1; level >= 0; level-- {
+		prev, nexts[level] = sl.findSpliceForLevel(key, prev, level)
+		prevs[level] = prev
+	}
+	return
+}
+
+// findSpliceForLevel 从before开始在某一层中向后查找key的插入位置
+func (sl *ConcurrentSkipList) findSpliceForLevel(key []byte, before *skipListNode, level int) (*skipListNode, *skipListNode) {
+	for {
+		next := before.next[level].Load()
+		if next == nil || bytes.Compare(next.key, key) >= 0 {
+			return before, next
+		}
+		before = next
+	}
+}
+
+func (sl *ConcurrentSkipList) Put(key []byte, pos *data.LogRecordPos) *data.LogRecordPos {
+	sl.writeLock.Lock()
+	defer sl.writeLock.Unlock()
+
+	prevs, nexts := sl.findSplice(key)
+	if node := nexts[0]; node != nil && bytes.Equal(node.key, key) {
+		return node.value.Swap(pos)
+	}
+
+	level := randomLevel()
+	node := &skipListNode{key: key, next: make([]atomic.Pointer[skipListNode], level)}
+	node.value.Store(pos)
+	if int32(level) > sl.height.Load() {
+		sl.height.Store(int32(level))
+	}
+	//从最底层开始插入，上层只用于加速查找，插入之前读取到的节点不完整也不影响正确性
+	for i := 0; i < level; i++ {
+		node.next[i].Store(nexts[i])
+		prevs[i].next[i].Store(node)
+	}
+	sl.size.Add(1)
+	return nil
+}
+
+// findGreaterOrEqual 找到第一个key大于等于传入key的节点
+func (sl *ConcurrentSkipList) findGreaterOrEqual(key []byte) *skipListNode {
+	x := sl.head
+	for level := int(sl.height.Load()) - 1; level >= 0; level-- {
+		x, _ = sl.findSpliceForLevel(key, x, level)
+	}
+	return x.next[0].Load()
+}
+
+// findLess 找到最后一个key小于传入key的节点，没有时返回nil
+func (sl *ConcurrentSkipList) findLess(key []byte) *skipListNode {
+	x := sl.head
+	for level := int(sl.height.Load()) - 1; level >= 0; level-- {
+		x, _ = sl.findSpliceForLevel(key, x, level)
+	}
+	if x == sl.head {
+		return nil
+	}
+	return x
+}
+
+// findLast 找到最后一个节点，没有时返回nil
+func (sl *ConcurrentSkipList) findLast() *skipListNode {
+	x := sl.head
+	for level := int(sl.height.Load()) - 1; level >= 0; level-- {
+		for next := x.next[level].Load(); next != nil; next = x.next[level].Load() {
+			x = next
+		}
+	}
+	if x == sl.head {
+		return nil
+	}
+	return x
+}
+
+func (sl *ConcurrentSkipList) Get(key []byte) *data.LogRecordPos {
+	node := sl.findGreaterOrEqual(key)
+	if node == nil || !bytes.Equal(node.key, key) {
+		return nil
+	}
+	return node.value.Load()
+}
+
+// Delete 把节点从每一层摘除，已经停在这个节点上的读取和迭代不受影响
+func (sl *ConcurrentSkipList) Delete(key []byte) (*data.LogRecordPos, bool) {
+	sl.writeLock.Lock()
+	defer sl.writeLock.Unlock()
+
+	prevs, nexts := sl.findSplice(key)
+	node := nexts[0]
+	if node == nil || !bytes.Equal(node.key, key) {
+		return nil, false
+	}
+	oldPos := node.value.Swap(nil)
+	//从最上层开始摘除，保证节点在下层被摘除之前仍然可以通过下层找到
+	for i := len(node.next) - 1; i >= 0; i-- {
+		if nexts[i] == node {
+			prevs[i].next[i].Store(node.next[i].Load())
+		}
+	}
+	sl.size.Add(-1)
+	return oldPos, true
+}
+
+func (sl *ConcurrentSkipList) Size() int {
+	return int(sl.size.Load())
+}
+
+// CountPrefix 从前缀开始沿着最底层遍历，跳过正在被删除的节点
+func (sl *ConcurrentSkipList) CountPrefix(prefix []byte) int {
+	var count int
+	for node := sl.findGreaterOrEqual(prefix); node != nil && bytes.HasPrefix(node.key, prefix); node = node.next[0].Load() {
+		if node.value.Load() != nil {
+			count++
+		}
+	}
+	return count
+}
+
+func (sl *ConcurrentSkipList) Iterator(reverse bool) Iterator {
+	return &SkipListIterator{list: sl, reverse: reverse}
+}
+
+// LiveIterator 跳表的迭代器本身就直接在跳表上遍历
+func (sl *ConcurrentSkipList) LiveIterator(reverse bool) Iterator {
+	return sl.Iterator(reverse)
+}
+
+func (sl *ConcurrentSkipList) Close() error {
+	return nil
+}
+
+// SkipListIterator 跳表索引迭代器，直接在跳表上遍历，不复制数据
+// 遍历过程中的写入可能被看到，也可能看不到
+type SkipListIterator struct {
+	list    *ConcurrentSkipList
+	reverse bool
+	node    *skipListNode
+	pos     *data.LogRecordPos //移动到当前节点时读取的位置
+}
+
+func (sit *SkipListIterator) Rewind() {
+	if sit.reverse {
+		sit.moveBackward(sit.list.findLast())
+	} else {
+		sit.moveForward(sit.list.head.next[0].Load())
+	}
+}
+
+func (sit *SkipListIterator) Seek(key []byte) {
+	node := sit.list.findGreaterOrEqual(key)
+	if !sit.reverse {
+		sit.moveForward(node)
+		return
+	}
+	if node == nil || !bytes.Equal(node.key, key) {
+		node = sit.list.findLess(key)
+	}
+	sit.moveBackward(node)
+}
+
+func (sit *SkipListIterator) Next() {
+	if sit.node == nil {
+		return
+	}
+	if sit.reverse {
+		sit.moveBackward(sit.list.findLess(sit.node.key))
+	} else {
+		sit.moveForward(sit.node.next[0].Load())
+	}
+}
+
+// moveForward 从node开始向后找到第一个没有被删除的节点
+func (sit *SkipListIterator) moveForward(node *skipListNode) {
+	for ; node != nil; node = node.next[0].Load() {
+		if pos := node.value.Load(); pos != nil {
+			sit.node, sit.pos = node, pos
+			return
+		}
+	}
+	sit.node, sit.pos = nil, nil
+}
+
+// moveBackward 从node开始向前找到第一个没有被删除的节点
+func (sit *SkipListIterator) moveBackward(node *skipListNode) {
+	for ; node != nil; node = sit.list.findLess(node.key) {
+		if pos := node.value.Load(); pos != nil {
+			sit.node, sit.pos = node, pos
+			return
+		}
+	}
+	sit.node, sit.pos = nil, nil
+}
+
+func (sit *SkipListIterator) Valid() bool {
+	return sit.node != nil
+}
+
+func (sit *SkipListIterator) Key() []byte {
+	return sit.node.key
+}
+
+func (sit *SkipListIterator) Value() *data.LogRecordPos {
+	return sit.pos
+}
+
+func (sit *SkipListIterator) Close() {
+	sit.node, sit.pos = nil, nil
+}
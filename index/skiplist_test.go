@@ -0,0 +1,157 @@
+package index
+
+import (
+	"fmt"
+	"github.com/GrandeLai/JDawDB/data"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+)
+
+func TestSkipList_Put(t *testing.T) {
+	sl := NewSkipList()
+	res1 := sl.Put([]byte("key-1"), &data.LogRecordPos{Fid: 1, Offset: 12})
+	assert.Nil(t, res1)
+	res2 := sl.Put([]byte("key-2"), &data.LogRecordPos{Fid: 1, Offset: 12})
+	assert.Nil(t, res2)
+
+	res3 := sl.Put([]byte("key-2"), &data.LogRecordPos{Fid: 99, Offset: 88})
+	assert.Equal(t, uint32(1), res3.Fid)
+	assert.Equal(t, int64(12), res3.Offset)
+	assert.Equal(t, 2, sl.Size())
+}
+
+func TestSkipList_Get(t *testing.T) {
+	sl := NewSkipList()
+	sl.Put([]byte("key-1"), &data.LogRecordPos{Fid: 1, Offset: 12})
+	pos := sl.Get([]byte("key-1"))
+	assert.Equal(t, int64(12), pos.Offset)
+
+	pos1 := sl.Get([]byte("not exist"))
+	assert.Nil(t, pos1)
+
+	sl.Put([]byte("key-1"), &data.LogRecordPos{Fid: 1123, Offset: 990})
+	pos2 := sl.Get([]byte("key-1"))
+	assert.Equal(t, int64(990), pos2.Offset)
+}
+
+func TestSkipList_Delete(t *testing.T) {
+	sl := NewSkipList()
+	res1, ok1 := sl.Delete([]byte("not exist"))
+	assert.Nil(t, res1)
+	assert.False(t, ok1)
+
+	sl.Put([]byte("key-1"), &data.LogRecordPos{Fid: 1, Offset: 12})
+	res2, ok2 := sl.Delete([]byte("key-1"))
+	assert.True(t, ok2)
+	assert.Equal(t, int64(12), res2.Offset)
+	assert.Nil(t, sl.Get([]byte("key-1")))
+	assert.Equal(t, 0, sl.Size())
+
+	res3, ok3 := sl.Delete([]byte("key-1"))
+	assert.Nil(t, res3)
+	assert.False(t, ok3)
+
+	// 删除之后再次写入会插入新的节点
+	res4 := sl.Put([]byte("key-1"), &data.LogRecordPos{Fid: 2, Offset: 20})
+	assert.Nil(t, res4)
+	assert.Equal(t, 1, sl.Size())
+}
+
+func TestSkipList_DeleteUnlink(t *testing.T) {
+	sl := NewSkipList()
+	for i := 0; i < 1000; i++ {
+		sl.Put([]byte(fmt.Sprintf("key-%09d", i)), &data.LogRecordPos{Offset: int64(i)})
+	}
+	iter := sl.Iterator(false)
+	iter.Seek([]byte(fmt.Sprintf("key-%09d", 500)))
+
+	// 删除的节点从每一层摘除，停在被删除节点上的迭代器可以继续遍历
+	for i := 0; i < 1000; i += 2 {
+		sl.Delete([]byte(fmt.Sprintf("key-%09d", i)))
+	}
+	for level := 0; level < skipListMaxLevel; level++ {
+		var count int
+		for node := sl.head.next[level].Load(); node != nil; node = node.next[level].Load() {
+			assert.NotNil(t, node.value.Load())
+			count++
+		}
+		if level == 0 {
+			assert.Equal(t, 500, count)
+		}
+	}
+	assert.Equal(t, 500, sl.Size())
+
+	var keys int
+	for iter.Next(); iter.Valid(); iter.Next() {
+		keys++
+	}
+	assert.Equal(t, 250, keys)
+}
+
+func TestSkipList_Iterator(t *testing.T) {
+	sl := NewSkipList()
+	for _, key := range []string{"ccde", "acee", "bbcd", "eeff", "dddd"} {
+		sl.Put([]byte(key), &data.LogRecordPos{Fid: 1, Offset: 12})
+	}
+	sl.Delete([]byte("dddd"))
+
+	collect := func(iter Iterator) []string {
+		var keys []string
+		for ; iter.Valid(); iter.Next() {
+			keys = append(keys, string(iter.Key()))
+		}
+		return keys
+	}
+
+	iter1 := sl.Iterator(false)
+	iter1.Rewind()
+	assert.Equal(t, []string{"acee", "bbcd", "ccde", "eeff"}, collect(iter1))
+
+	iter2 := sl.Iterator(true)
+	iter2.Rewind()
+	assert.Equal(t, []string{"eeff", "ccde", "bbcd", "acee"}, collect(iter2))
+
+	iter3 := sl.Iterator(false)
+	iter3.Seek([]byte("cc"))
+	assert.Equal(t, []string{"ccde", "eeff"}, collect(iter3))
+
+	iter4 := sl.Iterator(true)
+	iter4.Seek([]byte("dddd"))
+	assert.Equal(t, []string{"ccde", "bbcd", "acee"}, collect(iter4))
+	iter4.Seek([]byte("bbcd"))
+	assert.Equal(t, "bbcd", string(iter4.Key()))
+}
+
+func TestSkipList_Concurrent(t *testing.T) {
+	sl := NewSkipList()
+	wg := new(sync.WaitGroup)
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				key := []byte(fmt.Sprintf("key-%09d", j*8+i))
+				sl.Put(key, &data.LogRecordPos{Fid: uint32(i), Offset: int64(j)})
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			iter := sl.Iterator(false)
+			var last []byte
+			for iter.Rewind(); iter.Valid(); iter.Next() {
+				assert.True(t, last == nil || string(last) < string(iter.Key()))
+				last = iter.Key()
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 8000, sl.Size())
+
+	var count int
+	iter := sl.Iterator(false)
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		count++
+	}
+	assert.Equal(t, 8000, count)
+}
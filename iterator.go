@@ -2,6 +2,7 @@ package JDawDB
 
 import (
 	"bytes"
+	"context"
 	"github.com/GrandeLai/JDawDB/index"
 )
 
@@ -9,17 +10,31 @@ import (
 type Iterator struct {
 	indexIt index.Iterator
 	db      *DB
+	ctx     context.Context
 	Options IteratorOptions
 }
 
 // NewIterator 初始化用户迭代器
 func (db *DB) NewIterator(options IteratorOptions) *Iterator {
+	it, _ := db.NewIteratorCtx(context.Background(), options)
+	return it
+}
+
+// NewIteratorCtx 初始化用户迭代器，ctx结束后迭代器变为无效，Err返回结束的原因
+func (db *DB) NewIteratorCtx(ctx context.Context, options IteratorOptions) (*Iterator, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	db.mu.RLock()
 	it := db.indexer.Iterator(options.Reverse)
+	db.mu.RUnlock()
+	db.updateIteratorGauge(1)
 	return &Iterator{
 		indexIt: it,
 		db:      db,
+		ctx:     ctx,
 		Options: options,
-	}
+	}, nil
 }
 
 func (it *Iterator) Rewind() {
@@ -38,7 +53,12 @@ func (it *Iterator) Next() {
 }
 
 func (it *Iterator) Valid() bool {
-	return it.indexIt.Valid()
+	return it.ctx.Err() == nil && it.indexIt.Valid()
+}
+
+// Err 返回迭代器因为ctx结束而变为无效的原因
+func (it *Iterator) Err() error {
+	return it.ctx.Err()
 }
 
 func (it *Iterator) Key() []byte {
@@ -54,6 +74,7 @@ func (it *Iterator) Value() ([]byte, error) {
 
 func (it *Iterator) Close() {
 	it.indexIt.Close()
+	it.db.updateIteratorGauge(-1)
 }
 
 // 筛选过滤器
@@ -62,10 +83,90 @@ func (it *Iterator) skipToNext() {
 	if prefixLen == 0 {
 		return
 	}
-	for ; it.indexIt.Valid(); it.indexIt.Next() {
+	for ; it.ctx.Err() == nil && it.indexIt.Valid(); it.indexIt.Next() {
 		key := it.indexIt.Key()
 		if prefixLen <= len(key) && bytes.Compare(it.Options.Prefix, key[:prefixLen]) == 0 { //如果前缀部分相等
 			break
 		}
 	}
 }
+
+// KeysIterator 只遍历key的迭代器，不读取数据文件
+type KeysIterator struct {
+	indexIt index.Iterator
+	db      *DB
+	Options IteratorOptions
+}
+
+// KeysOnlyIterator 初始化只遍历key的迭代器，正向遍历时直接定位到前缀的位置
+// 迭代器直接在索引上遍历，不复制所有的key，遍历期间的写入可能可见
+// 哈希索引需要从数据文件中读回key，返回ErrNotSupported
+func (db *DB) KeysOnlyIterator(options IteratorOptions) (*KeysIterator, error) {
+	if db.options.IndexType == index.Hash {
+		return nil, ErrNotSupported
+	}
+	db.mu.RLock()
+	it := index.NewLiveIterator(db.indexer, options.Reverse)
+	db.mu.RUnlock()
+	db.updateIteratorGauge(1)
+	return &KeysIterator{
+		indexIt: it,
+		db:      db,
+		Options: options,
+	}, nil
+}
+
+func (kit *KeysIterator) Rewind() {
+	if len(kit.Options.Prefix) > 0 && !kit.Options.Reverse {
+		kit.indexIt.Seek(kit.Options.Prefix)
+		return
+	}
+	kit.indexIt.Rewind()
+	kit.skipToPrefix()
+}
+
+func (kit *KeysIterator) Seek(key []byte) {
+	kit.indexIt.Seek(key)
+	kit.skipToPrefix()
+}
+
+func (kit *KeysIterator) Next() {
+	kit.indexIt.Next()
+	kit.skipToPrefix()
+}
+
+// Valid 正向遍历时key超过前缀的范围之后不再有效
+func (kit *KeysIterator) Valid() bool {
+	if !kit.indexIt.Valid() {
+		return false
+	}
+	return len(kit.Options.Prefix) == 0 || bytes.HasPrefix(kit.indexIt.Key(), kit.Options.Prefix)
+}
+
+func (kit *KeysIterator) Key() []byte {
+	return kit.indexIt.Key()
+}
+
+func (kit *KeysIterator) Close() {
+	kit.indexIt.Close()
+	kit.db.updateIteratorGauge(-1)
+}
+
+// skipToPrefix 跳过还没有到达前缀范围的key
+func (kit *KeysIterator) skipToPrefix() {
+	prefix := kit.Options.Prefix
+	if len(prefix) == 0 {
+		return
+	}
+	for ; kit.indexIt.Valid(); kit.indexIt.Next() {
+		key := kit.indexIt.Key()
+		if bytes.HasPrefix(key, prefix) {
+			return
+		}
+		//已经越过了前缀的范围
+		cmp := bytes.Compare(key, prefix)
+		if (!kit.Options.Reverse && cmp > 0) || (kit.Options.Reverse && cmp < 0) {
+			return
+		}
+	}
+}
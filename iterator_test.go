@@ -1,6 +1,7 @@
 package JDawDB
 
 import (
+	"context"
 	"github.com/GrandeLai/JDawDB/utils"
 	"github.com/stretchr/testify/assert"
 	"os"
@@ -96,3 +97,35 @@ func TestDB_Iterator_Multi_Values(t *testing.T) {
 	}
 	iter3.Close()
 }
+
+func TestDB_NewIteratorCtx(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "JDawDB-iterator-ctx")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 10; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(10))
+		assert.Nil(t, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	iterator, err := db.NewIteratorCtx(ctx, DefaultIteratorOptions)
+	assert.Nil(t, err)
+	defer iterator.Close()
+	var count int
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		count++
+		if count == 3 {
+			cancel()
+		}
+	}
+	assert.Equal(t, 3, count)
+	assert.Equal(t, context.Canceled, iterator.Err())
+
+	_, err = db.NewIteratorCtx(ctx, DefaultIteratorOptions)
+	assert.Equal(t, context.Canceled, err)
+}
@@ -1,7 +1,10 @@
 package JDawDB
 
 import (
+	"context"
 	"github.com/GrandeLai/JDawDB/data"
+	"github.com/GrandeLai/JDawDB/index"
+	"github.com/GrandeLai/JDawDB/metrics"
 	"github.com/GrandeLai/JDawDB/utils"
 	"io"
 	"os"
@@ -9,6 +12,7 @@ import (
 	"path/filepath"
 	"sort"
 	"strconv"
+	"time"
 )
 
 const (
@@ -20,15 +24,34 @@ const (
 
 // Merge 清理无效数据，生成hint文件
 func (db *DB) Merge() error {
+	return db.MergeCtx(context.Background())
+}
+
+// MergeCtx 和Merge相同，每处理一条记录前都会检查ctx，ctx结束时放弃这次merge并返回ctx.Err()
+// 放弃的merge目录中没有merge完成的标识文件，下次启动时会被删除
+func (db *DB) MergeCtx(ctx context.Context) (err error) {
+	if db.options.ReadOnly {
+		return ErrReadOnly
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	if db.activeFile == nil {
 		return nil
 	}
 	db.mu.Lock()
 
 	if db.isMerging {
+		db.mu.Unlock()
 		return ErrMergeInProgress
 	}
 	db.isMerging = true
+	//所有返回路径上都已经释放了db.mu
+	defer func() {
+		db.mu.Lock()
+		db.isMerging = false
+		db.mu.Unlock()
+	}()
 
 	// 查看可以 merge 的数据量是否达到了阈值
 	totalSize, err := utils.DirSize(db.options.DirPath)
@@ -52,17 +75,16 @@ func (db *DB) Merge() error {
 		return ErrNoEnoughSpaceForMerge
 	}
 
-	defer func() {
-		db.isMerging = false
-	}()
+	defer db.observeSince(metrics.MergeDuration, time.Now())
 
 	//持久化当前活跃的数据文件
-	if err := db.activeFile.Sync(); err != nil {
+	if err := db.syncFile(db.activeFile); err != nil {
 		db.mu.Unlock()
 		return err
 	}
 	//将当前活跃的数据文件加入到旧的数据文件列表中
 	db.olderFiles[db.activeFile.FileId] = db.activeFile
+	db.writeActiveHintFile()
 	//打开一个新的数据文件
 	if err := db.setActiveFile(); err != nil {
 		db.mu.Unlock()
@@ -79,6 +101,15 @@ func (db *DB) Merge() error {
 	}
 	db.mu.Unlock()
 
+	mergeInfo := MergeInfo{NonMergeFileId: nonMergeFileId, MergeFileNum: len(mergeFiles)}
+	db.onMergeBegin(mergeInfo)
+	mergeStart := time.Now()
+	defer func() {
+		mergeInfo.Duration = time.Since(mergeStart)
+		mergeInfo.Err = err
+		db.onMergeEnd(mergeInfo)
+	}()
+
 	//从小到大排序mergeFiles后进行merge
 	sort.Slice(mergeFiles, func(i, j int) bool {
 		return mergeFiles[i].FileId < mergeFiles[j].FileId
@@ -99,21 +130,49 @@ func (db *DB) Merge() error {
 	mergeOptions := db.options
 	mergeOptions.DirPath = mergePath
 	mergeOptions.SyncWrites = false //因为merge不可能都成功，每次都sync可能会导致merge变慢
+	mergeOptions.Metrics = nil      //merge过程中的写入不计入指标
+	mergeOptions.Logger = nil
+	mergeOptions.EventListener = EventListener{}
+	mergeOptions.IndexCheckpointInterval = 0
 	mergeDB, err := Open(mergeOptions)
 	if err != nil {
 		return err
 	}
+	//放弃merge时也要关闭，释放merge目录的文件锁和打开的文件
+	defer func() {
+		if closeErr := mergeDB.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}()
+	//merge后的数据文件通过hint-index文件加载索引
+	mergeDB.writeHints = false
 
 	//打开一个hint文件，存储索引
 	hintFile, err := data.OpenHintFile(mergePath)
 	if err != nil {
 		return err
 	}
+	defer func() {
+		_ = hintFile.Close()
+	}()
 
 	//遍历处理每个旧的数据文件
+	var mergeFilesSize int64
 	for _, file := range mergeFiles {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		size, err := file.IoManager.Size()
+		if err != nil {
+			return err
+		}
+		mergeFilesSize += size
+
 		var offset int64
 		for {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
 			logRecord, size, err := file.ReadLogRecord(offset)
 			if err != nil {
 				if err == io.EOF {
@@ -122,7 +181,9 @@ func (db *DB) Merge() error {
 				return err
 			}
 			realKey, _ := ParseLogRecordKeyWithSeqNo(logRecord.Key)
+			db.mu.RLock()
 			logRecordPos := db.indexer.Get(realKey)
+			db.mu.RUnlock()
 			//和内存中的索引位置进行比较，如果有就重写
 			if logRecordPos != nil &&
 				file.FileId == logRecordPos.Fid &&
@@ -150,24 +211,41 @@ func (db *DB) Merge() error {
 	}
 
 	//写标识merge完成的文件
-	mergeFinishedFile, err := data.OpenMergeFinishedFile(mergePath)
+	if err := writeMergeFinishedFile(mergePath, nonMergeFileId); err != nil {
+		return err
+	}
+
+	//重写后的数据比原来的数据文件少的部分就是回收的空间
+	var mergedSize int64
+	if mergeDB.activeFile != nil {
+		mergedSize = mergeDB.activeFile.WriteOff
+	}
+	for _, file := range mergeDB.olderFiles {
+		mergedSize += file.WriteOff
+	}
+	if mergeFilesSize > mergedSize {
+		db.metrics.IncCounter(metrics.MergeReclaimedBytes, float64(mergeFilesSize-mergedSize))
+	}
+
+	return nil
+}
+
+// writeMergeFinishedFile 写标识merge完成的文件，小于nonMergeFileId的数据文件都已经merge完成
+func writeMergeFinishedFile(dirPath string, nonMergeFileId uint32) error {
+	mergeFinishedFile, err := data.OpenMergeFinishedFile(dirPath)
 	if err != nil {
 		return err
 	}
+	defer mergeFinishedFile.Close()
 	mergeFinishedRecord := &data.LogRecord{
 		Key:   []byte(mergeFinishedKey),
 		Value: []byte(strconv.Itoa(int(nonMergeFileId))),
 	}
-	//小于nonMergeFileId的数据文件都已经merge完成
 	encRecord, _ := data.EncodeLogRecord(mergeFinishedRecord)
 	if err := mergeFinishedFile.Write(encRecord); err != nil {
 		return err
 	}
-	if err := mergeFinishedFile.Sync(); err != nil {
-		return err
-	}
-
-	return nil
+	return mergeFinishedFile.Sync()
 }
 
 // 获取需要merge的文件的目录，比如当前文件夹是/tmp/JDawDB,就生成/tmp/JDawDB-merge
@@ -206,7 +284,8 @@ func (db *DB) loadMergeFiles() error {
 			continue
 		}
 		//遇到文件锁的目录直接跳过
-		if dirEntry.Name() == fileLockName {
+		if dirEntry.Name() == fileLockName || dirEntry.Name() == data.IndexCheckpointFileName ||
+			dirEntry.Name() == index.DiskIndexDirName {
 			continue
 		}
 		mergeFileNames = append(mergeFileNames, dirEntry.Name())
@@ -220,6 +299,13 @@ func (db *DB) loadMergeFiles() error {
 	if err != nil {
 		return err
 	}
+	//checkpoint和磁盘索引中的位置指向被merge的数据文件，已经失效
+	if err := os.RemoveAll(filepath.Join(db.options.DirPath, data.IndexCheckpointFileName)); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(filepath.Join(db.options.DirPath, index.DiskIndexDirName)); err != nil {
+		return err
+	}
 	//删除比nonMergeFileId小的数据文件
 	var fileId uint32 = 0
 	for ; fileId < nonMergeFileId; fileId++ {
@@ -229,6 +315,9 @@ func (db *DB) loadMergeFiles() error {
 				return err
 			}
 		}
+		if err := os.RemoveAll(data.GetHintFileName(db.options.DirPath, fileId)); err != nil {
+			return err
+		}
 	}
 	//将merge后的数据文件移动过来
 	for _, fileName := range mergeFileNames {
@@ -238,15 +327,34 @@ func (db *DB) loadMergeFiles() error {
 			return err
 		}
 	}
+	//保留merge完成的标识文件，主从复制用其中的nonMergeFileId判断从节点的位置是否还有效
+	srcPath := filepath.Join(mergePath, data.MergeFinishedFileName)
+	dstPath := filepath.Join(db.options.DirPath, data.MergeFinishedFileName)
+	if err := os.Rename(srcPath, dstPath); err != nil {
+		return err
+	}
+	db.logger.Info("merge files loaded", "nonMergeFileId", nonMergeFileId, "fileNum", len(mergeFileNames))
 	return nil
 }
 
+// loadMergeEpoch 读取数据目录中最近一次merge的nonMergeFileId，没有merge过时返回0
+func (db *DB) loadMergeEpoch() (uint32, error) {
+	fileName := filepath.Join(db.options.DirPath, data.MergeFinishedFileName)
+	if _, err := os.Stat(fileName); os.IsNotExist(err) {
+		return 0, nil
+	}
+	return db.getNonMergeFileId(db.options.DirPath)
+}
+
 // 读取最近一个没有参与merge的数据文件的id
 func (db *DB) getNonMergeFileId(dirPath string) (uint32, error) {
 	mergeFinishedFile, err := data.OpenMergeFinishedFile(dirPath)
 	if err != nil {
 		return 0, err
 	}
+	defer func() {
+		_ = mergeFinishedFile.Close()
+	}()
 	//因为只有一条数据所以offset为0
 	record, _, err := mergeFinishedFile.ReadLogRecord(0)
 	if err != nil {
@@ -1,6 +1,7 @@
 package JDawDB
 
 import (
+	"context"
 	"github.com/GrandeLai/JDawDB/utils"
 	"github.com/stretchr/testify/assert"
 	"os"
@@ -201,3 +202,51 @@ func TestDB_Merge5(t *testing.T) {
 		assert.NotNil(t, val)
 	}
 }
+
+// merge过程中ctx结束
+func TestDB_MergeCtx(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "JDawDB-merge-ctx")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.DataFileMergeRatio = 0
+	opts.EventListener.OnMergeBegin = func(MergeInfo) { cancel() }
+	db, err := Open(opts)
+	defer func() {
+		destroyDB(db)
+	}()
+	assert.Nil(t, err)
+
+	for i := 0; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+	err = db.MergeCtx(ctx)
+	assert.Equal(t, context.Canceled, err)
+	assert.False(t, db.isMerging)
+
+	// 放弃的merge已经关闭了merge目录中的实例，释放了文件锁
+	mergeOpts := opts
+	mergeOpts.DirPath = db.getMergePath()
+	mergeOpts.EventListener = EventListener{}
+	mergeDB, err := Open(mergeOpts)
+	assert.Nil(t, err)
+	err = mergeDB.Close()
+	assert.Nil(t, err)
+
+	// 放弃的merge不影响原来的数据
+	err = db.Close()
+	assert.Nil(t, err)
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 1000, len(db.ListKeys()))
+	_, err = os.Stat(db.getMergePath())
+	assert.True(t, os.IsNotExist(err))
+
+	// 没有达到阈值时也会重置merge状态
+	db.options.DataFileMergeRatio = 2
+	err = db.Merge()
+	assert.Equal(t, ErrMergeRatioUnreached, err)
+	assert.False(t, db.isMerging)
+}
@@ -0,0 +1,52 @@
+package metrics
+
+// Metrics 存储引擎的指标上报接口，可以对接到任意的监控系统
+type Metrics interface {
+	// IncCounter 计数器增加delta
+	IncCounter(name string, delta float64)
+	// SetGauge 设置瞬时值
+	SetGauge(name string, value float64)
+	// Observe 记录一次观测值，比如一次操作的耗时
+	Observe(name string, value float64)
+}
+
+// 存储引擎上报的指标名称
+const (
+	PutDuration         = "jdawdb_put_duration_seconds"
+	GetDuration         = "jdawdb_get_duration_seconds"
+	DeleteDuration      = "jdawdb_delete_duration_seconds"
+	BytesWritten        = "jdawdb_bytes_written_total"
+	FsyncTotal          = "jdawdb_fsync_total"
+	FsyncDuration       = "jdawdb_fsync_duration_seconds"
+	FileRotations       = "jdawdb_file_rotations_total"
+	MergeDuration       = "jdawdb_merge_duration_seconds"
+	MergeReclaimedBytes = "jdawdb_merge_reclaimed_bytes_total"
+	IndexKeys           = "jdawdb_index_keys"
+	OpenIterators       = "jdawdb_open_iterators"
+)
+
+// helps 指标的说明，用于文本格式输出
+var helps = map[string]string{
+	PutDuration:         "Latency of Put operations.",
+	GetDuration:         "Latency of Get operations.",
+	DeleteDuration:      "Latency of Delete operations.",
+	BytesWritten:        "Bytes appended to data files.",
+	FsyncTotal:          "Number of data file fsyncs.",
+	FsyncDuration:       "Latency of data file fsyncs.",
+	FileRotations:       "Number of times the active data file was rotated.",
+	MergeDuration:       "Duration of merge operations.",
+	MergeReclaimedBytes: "Bytes reclaimed by merge operations.",
+	IndexKeys:           "Number of keys in the in-memory index.",
+	OpenIterators:       "Number of iterators that are not closed yet.",
+}
+
+// Nop 不上报任何指标
+var Nop Metrics = nopMetrics{}
+
+type nopMetrics struct{}
+
+func (nopMetrics) IncCounter(string, float64) {}
+
+func (nopMetrics) SetGauge(string, float64) {}
+
+func (nopMetrics) Observe(string, float64) {}
@@ -0,0 +1,167 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// DefaultBuckets 默认的直方图分桶，单位是秒，从10us到10s
+var DefaultBuckets = []float64{
+	0.00001, 0.00005, 0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5, 10,
+}
+
+type histogram struct {
+	counts []uint64 //每个分桶的计数，不是累计值
+	count  uint64
+	sum    float64
+}
+
+// Registry 内置的指标存储，实现了Metrics接口，可以按Prometheus文本格式输出
+type Registry struct {
+	mu         *sync.Mutex
+	buckets    []float64
+	counters   map[string]float64
+	gauges     map[string]float64
+	histograms map[string]*histogram
+}
+
+// NewRegistry 初始化Registry，buckets为空时使用DefaultBuckets
+func NewRegistry(buckets []float64) *Registry {
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+	return &Registry{
+		mu:         new(sync.Mutex),
+		buckets:    buckets,
+		counters:   make(map[string]float64),
+		gauges:     make(map[string]float64),
+		histograms: make(map[string]*histogram),
+	}
+}
+
+func (r *Registry) IncCounter(name string, delta float64) {
+	r.mu.Lock()
+	r.counters[name] += delta
+	r.mu.Unlock()
+}
+
+func (r *Registry) SetGauge(name string, value float64) {
+	r.mu.Lock()
+	r.gauges[name] = value
+	r.mu.Unlock()
+}
+
+func (r *Registry) Observe(name string, value float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	h, ok := r.histograms[name]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(r.buckets))}
+		r.histograms[name] = h
+	}
+	idx := sort.SearchFloat64s(r.buckets, value)
+	if idx < len(r.buckets) {
+		h.counts[idx]++
+	}
+	h.count++
+	h.sum += value
+}
+
+// Counter 返回计数器的当前值
+func (r *Registry) Counter(name string) float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.counters[name]
+}
+
+// Gauge 返回瞬时值
+func (r *Registry) Gauge(name string) float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.gauges[name]
+}
+
+// HistogramCount 返回直方图的观测次数和总和
+func (r *Registry) HistogramCount(name string) (uint64, float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	h, ok := r.histograms[name]
+	if !ok {
+		return 0, 0
+	}
+	return h.count, h.sum
+}
+
+// WriteText 按Prometheus文本格式输出所有指标
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, name := range sortedKeys(r.counters) {
+		writeHeader(w, name, "counter")
+		if _, err := fmt.Fprintf(w, "%s %s\n", name, formatFloat(r.counters[name])); err != nil {
+			return err
+		}
+	}
+	for _, name := range sortedKeys(r.gauges) {
+		writeHeader(w, name, "gauge")
+		if _, err := fmt.Fprintf(w, "%s %s\n", name, formatFloat(r.gauges[name])); err != nil {
+			return err
+		}
+	}
+
+	names := make([]string, 0, len(r.histograms))
+	for name := range r.histograms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		h := r.histograms[name]
+		writeHeader(w, name, "histogram")
+		//分桶需要输出累计值
+		var cumulative uint64
+		for i, bound := range r.buckets {
+			cumulative += h.counts[i]
+			if _, err := fmt.Fprintf(w, "%s_bucket{le=\"%s\"} %d\n", name, formatFloat(bound), cumulative); err != nil {
+				return err
+			}
+		}
+		if _, err := fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n%s_sum %s\n%s_count %d\n",
+			name, h.count, name, formatFloat(h.sum), name, h.count); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Handler 返回输出指标的http处理器
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		_ = r.WriteText(w)
+	})
+}
+
+func writeHeader(w io.Writer, name, typ string) {
+	if help, ok := helps[name]; ok {
+		_, _ = fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	}
+	_, _ = fmt.Fprintf(w, "# TYPE %s %s\n", name, typ)
+}
+
+func sortedKeys(m map[string]float64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
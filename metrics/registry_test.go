@@ -0,0 +1,56 @@
+package metrics
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRegistry_Counter_Gauge(t *testing.T) {
+	r := NewRegistry(nil)
+	r.IncCounter(BytesWritten, 10)
+	r.IncCounter(BytesWritten, 5)
+	assert.Equal(t, float64(15), r.Counter(BytesWritten))
+
+	r.SetGauge(IndexKeys, 3)
+	r.SetGauge(IndexKeys, 2)
+	assert.Equal(t, float64(2), r.Gauge(IndexKeys))
+	assert.Equal(t, float64(0), r.Gauge(OpenIterators))
+}
+
+func TestRegistry_Observe(t *testing.T) {
+	r := NewRegistry([]float64{1, 2, 3})
+	r.Observe(PutDuration, 0.5)
+	r.Observe(PutDuration, 2)
+	r.Observe(PutDuration, 10)
+
+	count, sum := r.HistogramCount(PutDuration)
+	assert.Equal(t, uint64(3), count)
+	assert.Equal(t, 12.5, sum)
+
+	buf := new(bytes.Buffer)
+	err := r.WriteText(buf)
+	assert.Nil(t, err)
+	text := buf.String()
+	assert.True(t, strings.Contains(text, "# TYPE jdawdb_put_duration_seconds histogram"))
+	assert.True(t, strings.Contains(text, `jdawdb_put_duration_seconds_bucket{le="1"} 1`))
+	assert.True(t, strings.Contains(text, `jdawdb_put_duration_seconds_bucket{le="3"} 2`))
+	assert.True(t, strings.Contains(text, `jdawdb_put_duration_seconds_bucket{le="+Inf"} 3`))
+	assert.True(t, strings.Contains(text, "jdawdb_put_duration_seconds_count 3"))
+}
+
+func TestRegistry_Handler(t *testing.T) {
+	r := NewRegistry(nil)
+	r.IncCounter(FsyncTotal, 1)
+	r.SetGauge(IndexKeys, 100)
+
+	rec := httptest.NewRecorder()
+	r.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+	assert.True(t, strings.Contains(body, "# HELP jdawdb_fsync_total"))
+	assert.True(t, strings.Contains(body, "jdawdb_fsync_total 1"))
+	assert.True(t, strings.Contains(body, "# TYPE jdawdb_index_keys gauge"))
+	assert.True(t, strings.Contains(body, "jdawdb_index_keys 100"))
+}
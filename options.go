@@ -1,18 +1,28 @@
 package JDawDB
 
 import (
+	"github.com/GrandeLai/JDawDB/metrics"
 	"os"
+	"time"
 )
 
 // Options 定义打开文件的配置项
 type Options struct {
 	DirPath            string
-	DataFileSize       int64     //数据文件大小
-	SyncWrites         bool      //每次写完数据是否都需要安全的持久化
-	BytesPerSync       uint      //累计写到多少字节进行持久化
-	IndexType          IndexType //索引类型
-	MMapAtStart        bool      //是否在启动时使用 MMap 加载数据
-	DataFileMergeRatio float32   //需要merge的数据文件占总数据文件的比例阈值
+	DataFileSize       int64           //数据文件大小
+	SyncWrites         bool            //每次写完数据是否都需要安全的持久化
+	BytesPerSync       uint            //累计写到多少字节进行持久化
+	IndexType          IndexType       //索引类型
+	MMapAtStart        bool            //是否在启动时使用 MMap 加载数据
+	DataFileMergeRatio float32         //需要merge的数据文件占总数据文件的比例阈值
+	Metrics            metrics.Metrics //指标上报，为空时不上报
+	Logger             Logger          //日志输出，为空时不输出
+	EventListener      EventListener   //事件回调
+	ReadOnly           bool            //只读模式，不加文件锁，也不会写入任何文件
+	//定期把内存索引保存到checkpoint文件的间隔，为0时只在Close时保存，b+tree、哈希、跳表和磁盘索引不保存checkpoint
+	IndexCheckpointInterval time.Duration
+	RecoveryConcurrency     int   //启动时并行解析数据文件的协程数量，小于等于0时使用CPU核数
+	DiskIndexCacheSize      int64 //磁盘索引数据块缓存的字节数，小于等于0时使用默认值
 }
 
 type IndexType = int8
@@ -31,6 +41,16 @@ type WriteBatchOptions struct {
 	SyncWrites bool
 }
 
+// ReplicationOptions 主从复制配置项
+type ReplicationOptions struct {
+	// 主节点没有新数据时，轮询活跃文件的间隔
+	PollInterval time.Duration
+	// 每个数据帧最多携带的字节数
+	MaxFrameSize int64
+	// 从节点断开连接后重连的间隔
+	RetryInterval time.Duration
+}
+
 const (
 	// Btree BTree索引
 	Btree IndexType = iota + 1
@@ -40,6 +60,15 @@ const (
 
 	// BPTree B+ 树索引
 	BPTree
+
+	// Hash 只保存key指纹的哈希索引，内存占用更小，但不支持高效的有序遍历
+	Hash
+
+	// SkipList 并发跳表索引，读取和迭代不加锁，迭代器不需要复制索引
+	SkipList
+
+	// Disk 磁盘索引，内存中只保存最近的写入和数据块缓存，启动时只重放上次刷盘之后的数据
+	Disk
 )
 
 // DefaultOptions 默认配置
@@ -63,3 +92,9 @@ var DefaultWriteBatchOptions = WriteBatchOptions{
 	MaxBatchNum: 10000,
 	SyncWrites:  false,
 }
+
+var DefaultReplicationOptions = ReplicationOptions{
+	PollInterval:  100 * time.Millisecond,
+	MaxFrameSize:  4 * 1024 * 1024,
+	RetryInterval: time.Second,
+}
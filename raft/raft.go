@@ -0,0 +1,790 @@
+package raft
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+var (
+	ErrNotLeader       = errors.New("raft: node is not the leader")
+	ErrLeadershipLost  = errors.New("raft: leadership lost before the entry was committed")
+	ErrProposalTimeout = errors.New("raft: timed out waiting for the entry to be applied")
+	ErrNodeStopped     = errors.New("raft: node is stopped")
+	ErrUnreachable     = errors.New("raft: target node is unreachable")
+	ErrDirPathIsEmpty  = errors.New("raft: dir path is empty")
+)
+
+type State = byte
+
+const (
+	Follower State = iota
+	Candidate
+	Leader
+)
+
+// Config raft节点配置项
+type Config struct {
+	ID                string        //节点ID
+	DirPath           string        //保存任期、投票、日志和快照的目录
+	Peers             []string      //集群中所有节点的ID，包含自己
+	ElectionTimeout   time.Duration //选举超时，实际超时在[ElectionTimeout, 2*ElectionTimeout)之间随机
+	HeartbeatInterval time.Duration //leader发送心跳的间隔
+	SnapshotThreshold uint64        //已应用的日志超过多少条时进行快照并压缩日志
+	ApplyTimeout      time.Duration //提交的日志等待被应用的最长时间
+}
+
+// DefaultConfig 默认配置
+var DefaultConfig = Config{
+	ElectionTimeout:   300 * time.Millisecond,
+	HeartbeatInterval: 50 * time.Millisecond,
+	SnapshotThreshold: 10000,
+	ApplyTimeout:      5 * time.Second,
+}
+
+// StateMachine 状态机，已提交的日志会按顺序应用到状态机上
+type StateMachine interface {
+	// Apply 应用一条日志
+	Apply(data []byte) error
+	// Snapshot 把状态机当前状态的快照写入w
+	Snapshot(w io.Writer) error
+	// Restore 用从r中读取的快照恢复状态机
+	Restore(r io.Reader) error
+}
+
+// LogEntry raft日志
+type LogEntry struct {
+	Term  uint64
+	Index uint64
+	Data  []byte //为空表示leader上任时写入的空日志，不会应用到状态机
+}
+
+// proposal 等待日志被应用的提案
+type proposal struct {
+	term uint64
+	ch   chan error
+}
+
+// Node raft节点
+type Node struct {
+	config    Config
+	fsm       StateMachine
+	transport Transport
+	storage   *storage
+	mu        *sync.Mutex
+	applyCond *sync.Cond
+	rand      *rand.Rand
+
+	state       State
+	currentTerm uint64
+	votedFor    string
+	leaderId    string
+	log         []LogEntry //log[0]是快照中的最后一条日志，只用于记录Index和Term
+	commitIndex uint64
+	lastApplied uint64
+
+	pendingSnapshot  bool            //快照还没有应用到状态机，启动时或者从leader收到快照之后设置
+	sendingSnapshot  map[string]bool //正在发送快照的follower
+	nextIndex        map[string]uint64
+	matchIndex       map[string]uint64
+	proposals        map[uint64]*proposal
+	electionDeadline time.Time
+
+	stopCh  chan struct{}
+	stopped bool
+	wg      *sync.WaitGroup
+}
+
+// NewNode 初始化raft节点，从DirPath中恢复任期、投票、日志和快照，需要调用Start开始运行
+// 有快照时状态机会先用快照恢复，再重新应用之后提交的日志
+func NewNode(config Config, fsm StateMachine, transport Transport) (*Node, error) {
+	if config.DirPath == "" {
+		return nil, ErrDirPathIsEmpty
+	}
+	storage, state, err := openStorage(config.DirPath)
+	if err != nil {
+		return nil, err
+	}
+	n := &Node{
+		config:          config,
+		fsm:             fsm,
+		transport:       transport,
+		storage:         storage,
+		mu:              new(sync.Mutex),
+		rand:            rand.New(rand.NewSource(time.Now().UnixNano())),
+		state:           Follower,
+		currentTerm:     state.term,
+		votedFor:        state.votedFor,
+		log:             append([]LogEntry{{Index: state.snapshotIndex, Term: state.snapshotTerm}}, state.entries...),
+		commitIndex:     state.snapshotIndex,
+		pendingSnapshot: state.hasSnapshot,
+		sendingSnapshot: make(map[string]bool),
+		nextIndex:       make(map[string]uint64),
+		matchIndex:      make(map[string]uint64),
+		proposals:       make(map[uint64]*proposal),
+		stopCh:          make(chan struct{}),
+		wg:              new(sync.WaitGroup),
+	}
+	n.applyCond = sync.NewCond(n.mu)
+	return n, nil
+}
+
+// Start 启动选举定时器和状态机应用协程
+func (n *Node) Start() {
+	n.mu.Lock()
+	n.resetElectionDeadline()
+	n.mu.Unlock()
+
+	n.wg.Add(2)
+	go n.ticker()
+	go n.applier()
+}
+
+// Stop 停止节点
+func (n *Node) Stop() {
+	n.mu.Lock()
+	if n.stopped {
+		n.mu.Unlock()
+		return
+	}
+	n.stopped = true
+	close(n.stopCh)
+	n.applyCond.Broadcast()
+	n.mu.Unlock()
+	n.wg.Wait()
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	_ = n.storage.close()
+}
+
+// ID 返回节点ID
+func (n *Node) ID() string {
+	return n.config.ID
+}
+
+// IsLeader 当前节点是否是leader
+func (n *Node) IsLeader() bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.state == Leader
+}
+
+// Leader 返回当前节点已知的leader
+func (n *Node) Leader() string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.leaderId
+}
+
+// Term 返回当前任期
+func (n *Node) Term() uint64 {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.currentTerm
+}
+
+// AppliedIndex 返回已经应用到状态机的日志索引
+func (n *Node) AppliedIndex() uint64 {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.lastApplied
+}
+
+// SnapshotIndex 返回最近一次快照包含的最后一条日志索引
+func (n *Node) SnapshotIndex() uint64 {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.log[0].Index
+}
+
+// Propose 提交一条日志，等到日志被应用到leader的状态机后返回Apply的结果
+func (n *Node) Propose(data []byte) error {
+	n.mu.Lock()
+	if n.stopped {
+		n.mu.Unlock()
+		return ErrNodeStopped
+	}
+	if n.state != Leader {
+		n.mu.Unlock()
+		return ErrNotLeader
+	}
+	entry := LogEntry{Term: n.currentTerm, Index: n.lastIndex() + 1, Data: data}
+	n.appendLog(entry)
+	n.matchIndex[n.config.ID] = entry.Index
+	p := &proposal{term: entry.Term, ch: make(chan error, 1)}
+	n.proposals[entry.Index] = p
+	//单节点集群直接提交
+	n.advanceCommitIndex()
+	n.mu.Unlock()
+
+	n.broadcastAppendEntries()
+
+	select {
+	case err := <-p.ch:
+		return err
+	case <-n.stopCh:
+		return ErrNodeStopped
+	case <-time.After(n.config.ApplyTimeout):
+		n.mu.Lock()
+		delete(n.proposals, entry.Index)
+		n.mu.Unlock()
+		return ErrProposalTimeout
+	}
+}
+
+func (n *Node) ticker() {
+	defer n.wg.Done()
+	t := time.NewTicker(n.config.HeartbeatInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-n.stopCh:
+			return
+		case <-t.C:
+		}
+
+		n.mu.Lock()
+		state := n.state
+		electionTimeout := time.Now().After(n.electionDeadline)
+		n.mu.Unlock()
+
+		if state == Leader {
+			n.broadcastAppendEntries()
+		} else if electionTimeout {
+			n.startElection()
+		}
+	}
+}
+
+// applier 按顺序把已经提交的日志应用到状态机，所有对状态机的操作都在这个协程中完成
+func (n *Node) applier() {
+	defer n.wg.Done()
+	for {
+		n.mu.Lock()
+		for !n.stopped && !n.pendingSnapshot && n.lastApplied >= n.commitIndex {
+			n.applyCond.Wait()
+		}
+		if n.stopped {
+			n.mu.Unlock()
+			return
+		}
+
+		//优先应用快照，快照文件在下一次替换之前不会改变
+		if n.pendingSnapshot {
+			snapshotIndex := n.log[0].Index
+			n.pendingSnapshot = false
+			file, snapshot, err := n.storage.openSnapshot()
+			n.mu.Unlock()
+
+			if err == nil {
+				err = n.fsm.Restore(snapshot)
+				_ = file.Close()
+			}
+			if err != nil {
+				panic(fmt.Sprintf("raft: failed to restore snapshot, %v", err))
+			}
+
+			n.mu.Lock()
+			if snapshotIndex > n.lastApplied {
+				n.lastApplied = snapshotIndex
+			}
+			//被快照覆盖的提案无法确认是否是自己提交的
+			for index, p := range n.proposals {
+				if index <= snapshotIndex {
+					p.ch <- ErrLeadershipLost
+					delete(n.proposals, index)
+				}
+			}
+			n.mu.Unlock()
+			continue
+		}
+
+		entry := n.entryAt(n.lastApplied + 1)
+		n.mu.Unlock()
+
+		var err error
+		if entry.Data != nil {
+			err = n.fsm.Apply(entry.Data)
+		}
+
+		n.mu.Lock()
+		if n.lastApplied+1 == entry.Index {
+			n.lastApplied = entry.Index
+		}
+		if p, ok := n.proposals[entry.Index]; ok {
+			if p.term != entry.Term {
+				err = ErrLeadershipLost
+			}
+			p.ch <- err
+			delete(n.proposals, entry.Index)
+		}
+		needSnapshot := n.config.SnapshotThreshold > 0 && n.lastApplied-n.log[0].Index >= n.config.SnapshotThreshold
+		appliedIndex := n.lastApplied
+		n.mu.Unlock()
+
+		if needSnapshot {
+			n.takeSnapshot(appliedIndex)
+		}
+	}
+}
+
+// takeSnapshot 把快照写入磁盘，并丢弃快照已经包含的日志，写入快照时不持有锁
+func (n *Node) takeSnapshot(index uint64) {
+	n.mu.Lock()
+	if index <= n.log[0].Index || index > n.lastIndex() {
+		n.mu.Unlock()
+		return
+	}
+	term := n.termAt(index)
+	n.mu.Unlock()
+
+	if err := n.storage.writeSnapshot(index, term, n.fsm.Snapshot); err != nil {
+		n.storage.removeSnapshotTmp()
+		return
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	//写入期间收到了leader的快照
+	if index <= n.log[0].Index || index > n.lastIndex() || n.termAt(index) != term {
+		n.storage.removeSnapshotTmp()
+		return
+	}
+	n.compactLog(index, term)
+	n.mustPersist(n.storage.installSnapshot(false, n.log[1:]))
+}
+
+// compactLog 丢弃index及之前的日志
+func (n *Node) compactLog(index, term uint64) {
+	var entries []LogEntry
+	if index < n.lastIndex() {
+		entries = n.log[index-n.log[0].Index+1:]
+	}
+	newLog := make([]LogEntry, 0, len(entries)+1)
+	newLog = append(newLog, LogEntry{Index: index, Term: term})
+	n.log = append(newLog, entries...)
+}
+
+func (n *Node) startElection() {
+	n.mu.Lock()
+	n.state = Candidate
+	n.currentTerm++
+	n.votedFor = n.config.ID
+	n.leaderId = ""
+	n.resetElectionDeadline()
+	n.persistState()
+
+	term := n.currentTerm
+	args := &RequestVoteArgs{
+		Term:         term,
+		CandidateId:  n.config.ID,
+		LastLogIndex: n.lastIndex(),
+		LastLogTerm:  n.lastTerm(),
+	}
+	votes := 1
+	if votes > len(n.config.Peers)/2 {
+		n.becomeLeader()
+		n.mu.Unlock()
+		return
+	}
+	n.mu.Unlock()
+
+	for _, peer := range n.config.Peers {
+		if peer == n.config.ID {
+			continue
+		}
+		go func(peer string) {
+			reply, err := n.transport.RequestVote(peer, args)
+			if err != nil {
+				return
+			}
+
+			n.mu.Lock()
+			defer n.mu.Unlock()
+			if n.stopped {
+				return
+			}
+			if reply.Term > n.currentTerm {
+				n.becomeFollower(reply.Term)
+				return
+			}
+			if n.state != Candidate || n.currentTerm != term || !reply.VoteGranted {
+				return
+			}
+			votes++
+			if votes > len(n.config.Peers)/2 {
+				n.becomeLeader()
+				go n.broadcastAppendEntries()
+			}
+		}(peer)
+	}
+}
+
+// becomeLeader 成为leader，调用时需要持有锁
+func (n *Node) becomeLeader() {
+	n.state = Leader
+	n.leaderId = n.config.ID
+	//写入一条当前任期的空日志，让之前任期的日志尽快提交
+	n.appendLog(LogEntry{Term: n.currentTerm, Index: n.lastIndex() + 1})
+	for _, peer := range n.config.Peers {
+		n.nextIndex[peer] = n.lastIndex() + 1
+		n.matchIndex[peer] = 0
+	}
+	n.matchIndex[n.config.ID] = n.lastIndex()
+	n.advanceCommitIndex()
+}
+
+// becomeFollower 发现更大的任期时转为follower，调用时需要持有锁
+func (n *Node) becomeFollower(term uint64) {
+	n.state = Follower
+	if term > n.currentTerm {
+		n.currentTerm = term
+		n.votedFor = ""
+		n.persistState()
+	}
+}
+
+// persistState 持久化任期和投票，回复请求或者发起投票之前调用，调用时需要持有锁
+func (n *Node) persistState() {
+	n.mustPersist(n.storage.saveState(n.currentTerm, n.votedFor))
+}
+
+// appendLog 追加日志并持久化，调用时需要持有锁
+func (n *Node) appendLog(entries ...LogEntry) {
+	n.log = append(n.log, entries...)
+	n.mustPersist(n.storage.appendEntries(entries))
+}
+
+// mustPersist 持久化失败之后无法保证已经回复的投票和日志不会丢失，和快照恢复失败一样直接panic
+func (n *Node) mustPersist(err error) {
+	if err != nil {
+		panic(fmt.Sprintf("raft: failed to persist state, %v", err))
+	}
+}
+
+func (n *Node) broadcastAppendEntries() {
+	for _, peer := range n.config.Peers {
+		if peer == n.config.ID {
+			continue
+		}
+		go n.replicateTo(peer)
+	}
+}
+
+// replicateTo 向follower发送日志，日志已经被压缩时发送快照
+func (n *Node) replicateTo(peer string) {
+	n.mu.Lock()
+	if n.state != Leader || n.stopped {
+		n.mu.Unlock()
+		return
+	}
+	term := n.currentTerm
+	next := n.nextIndex[peer]
+	if next <= n.log[0].Index {
+		n.sendSnapshot(peer, term)
+		return
+	}
+
+	prevIndex := next - 1
+	args := &AppendEntriesArgs{
+		Term:         term,
+		LeaderId:     n.config.ID,
+		PrevLogIndex: prevIndex,
+		PrevLogTerm:  n.termAt(prevIndex),
+		LeaderCommit: n.commitIndex,
+	}
+	if next <= n.lastIndex() {
+		args.Entries = append([]LogEntry(nil), n.log[next-n.log[0].Index:]...)
+	}
+	n.mu.Unlock()
+
+	reply, err := n.transport.AppendEntries(peer, args)
+	if err != nil {
+		return
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if reply.Term > n.currentTerm {
+		n.becomeFollower(reply.Term)
+		return
+	}
+	if n.state != Leader || n.currentTerm != term {
+		return
+	}
+	if reply.Success {
+		match := prevIndex + uint64(len(args.Entries))
+		if match > n.matchIndex[peer] {
+			n.matchIndex[peer] = match
+			n.nextIndex[peer] = match + 1
+		}
+		n.advanceCommitIndex()
+		return
+	}
+	//根据follower返回的冲突位置回退
+	if reply.ConflictIndex > 0 && reply.ConflictIndex < n.nextIndex[peer] {
+		n.nextIndex[peer] = reply.ConflictIndex
+	} else if n.nextIndex[peer] > 1 {
+		n.nextIndex[peer]--
+	}
+}
+
+// sendSnapshot 分块发送快照文件，调用时需要持有锁，返回时会释放锁
+// 同一个follower同时只会有一个快照在发送，发送期间快照被替换时仍然读取打开的旧文件
+func (n *Node) sendSnapshot(peer string, term uint64) {
+	if n.sendingSnapshot[peer] {
+		n.mu.Unlock()
+		return
+	}
+	file, snapshot, err := n.storage.openSnapshot()
+	if err != nil {
+		n.mu.Unlock()
+		return
+	}
+	n.sendingSnapshot[peer] = true
+	lastIncludedIndex, lastIncludedTerm := n.log[0].Index, n.log[0].Term
+	n.mu.Unlock()
+
+	defer func() {
+		_ = file.Close()
+		n.mu.Lock()
+		delete(n.sendingSnapshot, peer)
+		n.mu.Unlock()
+	}()
+
+	buf := make([]byte, snapshotChunkSize)
+	var offset int64
+	for {
+		size, err := snapshot.ReadAt(buf, offset)
+		if err != nil && err != io.EOF {
+			return
+		}
+		args := &InstallSnapshotArgs{
+			Term:              term,
+			LeaderId:          n.config.ID,
+			LastIncludedIndex: lastIncludedIndex,
+			LastIncludedTerm:  lastIncludedTerm,
+			Offset:            uint64(offset),
+			Data:              buf[:size],
+			Done:              offset+int64(size) == snapshot.Size(),
+		}
+		reply, err := n.transport.InstallSnapshot(peer, args)
+		if err != nil {
+			return
+		}
+
+		n.mu.Lock()
+		if reply.Term > n.currentTerm {
+			n.becomeFollower(reply.Term)
+			n.mu.Unlock()
+			return
+		}
+		if n.state != Leader || n.currentTerm != term || !reply.Success {
+			n.mu.Unlock()
+			return
+		}
+		if args.Done {
+			if args.LastIncludedIndex > n.matchIndex[peer] {
+				n.matchIndex[peer] = args.LastIncludedIndex
+				n.nextIndex[peer] = args.LastIncludedIndex + 1
+			}
+			n.mu.Unlock()
+			return
+		}
+		n.mu.Unlock()
+		offset += int64(size)
+	}
+}
+
+// advanceCommitIndex 多数节点都已复制的当前任期日志可以提交，调用时需要持有锁
+func (n *Node) advanceCommitIndex() {
+	for index := n.lastIndex(); index > n.commitIndex && index > n.log[0].Index; index-- {
+		if n.termAt(index) != n.currentTerm {
+			break
+		}
+		var count int
+		for _, peer := range n.config.Peers {
+			if n.matchIndex[peer] >= index {
+				count++
+			}
+		}
+		if count > len(n.config.Peers)/2 {
+			n.commitIndex = index
+			n.applyCond.Broadcast()
+			break
+		}
+	}
+}
+
+// HandleRequestVote 处理投票请求
+func (n *Node) HandleRequestVote(args *RequestVoteArgs) *RequestVoteReply {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	//停止之后不再修改持久化的状态
+	if n.stopped {
+		return &RequestVoteReply{Term: n.currentTerm}
+	}
+	if args.Term > n.currentTerm {
+		n.becomeFollower(args.Term)
+	}
+	reply := &RequestVoteReply{Term: n.currentTerm}
+	if args.Term < n.currentTerm {
+		return reply
+	}
+
+	//候选人的日志至少和自己一样新才投票
+	upToDate := args.LastLogTerm > n.lastTerm() ||
+		(args.LastLogTerm == n.lastTerm() && args.LastLogIndex >= n.lastIndex())
+	if (n.votedFor == "" || n.votedFor == args.CandidateId) && upToDate {
+		if n.votedFor != args.CandidateId {
+			n.votedFor = args.CandidateId
+			n.persistState()
+		}
+		n.resetElectionDeadline()
+		reply.VoteGranted = true
+	}
+	return reply
+}
+
+// HandleAppendEntries 处理leader发送的日志和心跳
+func (n *Node) HandleAppendEntries(args *AppendEntriesArgs) *AppendEntriesReply {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	reply := &AppendEntriesReply{Term: n.currentTerm}
+	if args.Term < n.currentTerm || n.stopped {
+		return reply
+	}
+	n.becomeFollower(args.Term)
+	n.leaderId = args.LeaderId
+	n.resetElectionDeadline()
+	reply.Term = n.currentTerm
+
+	prevIndex, prevTerm, entries := args.PrevLogIndex, args.PrevLogTerm, args.Entries
+	//已经被快照包含的日志直接跳过
+	if prevIndex < n.log[0].Index {
+		skip := n.log[0].Index - prevIndex
+		if skip > uint64(len(entries)) {
+			reply.Success = true
+			return reply
+		}
+		entries = entries[skip:]
+		prevIndex, prevTerm = n.log[0].Index, n.log[0].Term
+	}
+
+	if prevIndex > n.lastIndex() {
+		reply.ConflictIndex = n.lastIndex() + 1
+		return reply
+	}
+	if term := n.termAt(prevIndex); term != prevTerm {
+		//回退到冲突任期的第一条日志
+		index := prevIndex
+		for index > n.log[0].Index+1 && n.termAt(index-1) == term {
+			index--
+		}
+		reply.ConflictIndex = index
+		return reply
+	}
+
+	for i, entry := range entries {
+		if entry.Index <= n.lastIndex() {
+			if n.termAt(entry.Index) == entry.Term {
+				continue
+			}
+			n.truncateLog(entry.Index)
+		}
+		n.appendLog(entries[i:]...)
+		break
+	}
+
+	lastNewIndex := prevIndex + uint64(len(entries))
+	if args.LeaderCommit > n.commitIndex {
+		n.commitIndex = args.LeaderCommit
+		if lastNewIndex < n.commitIndex {
+			n.commitIndex = lastNewIndex
+		}
+		n.applyCond.Broadcast()
+	}
+	reply.Success = true
+	return reply
+}
+
+// HandleInstallSnapshot 处理leader发送的快照分块，收到最后一块之后替换快照和日志
+func (n *Node) HandleInstallSnapshot(args *InstallSnapshotArgs) *InstallSnapshotReply {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	reply := &InstallSnapshotReply{Term: n.currentTerm}
+	if args.Term < n.currentTerm || n.stopped {
+		return reply
+	}
+	n.becomeFollower(args.Term)
+	n.leaderId = args.LeaderId
+	n.resetElectionDeadline()
+	reply.Term = n.currentTerm
+
+	//已经提交的日志会被正常应用，不需要这个快照
+	if args.LastIncludedIndex <= n.commitIndex {
+		n.storage.discardReceived()
+		reply.Success = true
+		return reply
+	}
+	accepted, err := n.storage.receiveSnapshot(args)
+	if err != nil || !accepted || !args.Done {
+		reply.Success = accepted
+		return reply
+	}
+
+	if args.LastIncludedIndex <= n.lastIndex() && n.termAt(args.LastIncludedIndex) == args.LastIncludedTerm {
+		n.compactLog(args.LastIncludedIndex, args.LastIncludedTerm)
+	} else {
+		n.truncateLog(n.log[0].Index + 1)
+		n.log = []LogEntry{{Index: args.LastIncludedIndex, Term: args.LastIncludedTerm}}
+	}
+	n.mustPersist(n.storage.installSnapshot(true, n.log[1:]))
+	n.pendingSnapshot = true
+	n.commitIndex = args.LastIncludedIndex
+	n.applyCond.Broadcast()
+	reply.Success = true
+	return reply
+}
+
+// truncateLog 删除index及之后的日志，等待这些日志的提案都会失败
+func (n *Node) truncateLog(index uint64) {
+	for i, p := range n.proposals {
+		if i >= index {
+			p.ch <- ErrLeadershipLost
+			delete(n.proposals, i)
+		}
+	}
+	if index <= n.lastIndex() {
+		n.log = n.log[:index-n.log[0].Index]
+		n.mustPersist(n.storage.truncate(len(n.log) - 1))
+	}
+}
+
+func (n *Node) resetElectionDeadline() {
+	timeout := n.config.ElectionTimeout + time.Duration(n.rand.Int63n(int64(n.config.ElectionTimeout)))
+	n.electionDeadline = time.Now().Add(timeout)
+}
+
+func (n *Node) lastIndex() uint64 {
+	return n.log[len(n.log)-1].Index
+}
+
+func (n *Node) lastTerm() uint64 {
+	return n.log[len(n.log)-1].Term
+}
+
+func (n *Node) termAt(index uint64) uint64 {
+	return n.log[index-n.log[0].Index].Term
+}
+
+func (n *Node) entryAt(index uint64) LogEntry {
+	return n.log[index-n.log[0].Index]
+}
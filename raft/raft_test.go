@@ -0,0 +1,246 @@
+package raft
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// kvStateMachine 测试使用的状态机，记录所有应用过的日志
+type kvStateMachine struct {
+	mu      sync.Mutex
+	applied []string
+}
+
+func (sm *kvStateMachine) Apply(data []byte) error {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	sm.applied = append(sm.applied, string(data))
+	return nil
+}
+
+func (sm *kvStateMachine) Snapshot(w io.Writer) error {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	_, err := w.Write([]byte(strings.Join(sm.applied, ",")))
+	return err
+}
+
+func (sm *kvStateMachine) Restore(r io.Reader) error {
+	snapshot, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	sm.applied = nil
+	if len(snapshot) > 0 {
+		sm.applied = strings.Split(string(snapshot), ",")
+	}
+	return nil
+}
+
+func (sm *kvStateMachine) values() []string {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	return append([]string(nil), sm.applied...)
+}
+
+func newTestCluster(n int, snapshotThreshold uint64) ([]*Node, []*kvStateMachine, *InmemTransport) {
+	transport := NewInmemTransport()
+	var peers []string
+	for i := 0; i < n; i++ {
+		peers = append(peers, fmt.Sprintf("node-%d", i))
+	}
+	var nodes []*Node
+	var sms []*kvStateMachine
+	for i := 0; i < n; i++ {
+		config := DefaultConfig
+		config.ID = peers[i]
+		config.Peers = peers
+		config.ElectionTimeout = 100 * time.Millisecond
+		config.HeartbeatInterval = 20 * time.Millisecond
+		config.SnapshotThreshold = snapshotThreshold
+		config.DirPath, _ = os.MkdirTemp("", "JDawDB-raft-"+peers[i])
+		sm := &kvStateMachine{}
+		node, err := NewNode(config, sm, transport)
+		if err != nil {
+			panic(err)
+		}
+		transport.Register(node)
+		nodes = append(nodes, node)
+		sms = append(sms, sm)
+	}
+	for _, node := range nodes {
+		node.Start()
+	}
+	return nodes, sms, transport
+}
+
+func stopCluster(nodes []*Node) {
+	for _, node := range nodes {
+		node.Stop()
+		_ = os.RemoveAll(node.config.DirPath)
+	}
+}
+
+func waitLeader(nodes []*Node, exclude string) *Node {
+	for i := 0; i < 200; i++ {
+		for _, node := range nodes {
+			if node.ID() != exclude && node.IsLeader() {
+				return node
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return nil
+}
+
+func waitApplied(sm *kvStateMachine, n int) bool {
+	for i := 0; i < 200; i++ {
+		if len(sm.values()) >= n {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return false
+}
+
+func TestNode_Election(t *testing.T) {
+	nodes, _, _ := newTestCluster(3, 0)
+	defer stopCluster(nodes)
+
+	leader := waitLeader(nodes, "")
+	assert.NotNil(t, leader)
+	for _, node := range nodes {
+		if node != leader {
+			assert.False(t, node.IsLeader())
+		}
+	}
+}
+
+func TestNode_Propose(t *testing.T) {
+	nodes, sms, _ := newTestCluster(3, 0)
+	defer stopCluster(nodes)
+
+	leader := waitLeader(nodes, "")
+	assert.NotNil(t, leader)
+	for i := 0; i < 10; i++ {
+		err := leader.Propose([]byte(fmt.Sprintf("v%d", i)))
+		assert.Nil(t, err)
+	}
+	for _, sm := range sms {
+		assert.True(t, waitApplied(sm, 10))
+		assert.Equal(t, "v0", sm.values()[0])
+		assert.Equal(t, "v9", sm.values()[9])
+	}
+
+	for _, node := range nodes {
+		if node != leader {
+			err := node.Propose([]byte("x"))
+			assert.Equal(t, ErrNotLeader, err)
+		}
+	}
+}
+
+func TestNode_LeaderFailover(t *testing.T) {
+	nodes, sms, transport := newTestCluster(3, 0)
+	defer stopCluster(nodes)
+
+	leader := waitLeader(nodes, "")
+	assert.NotNil(t, leader)
+	err := leader.Propose([]byte("before"))
+	assert.Nil(t, err)
+
+	// 旧leader断开之后，剩下的节点重新选出leader
+	transport.Disconnect(leader.ID())
+	newLeader := waitLeader(nodes, leader.ID())
+	assert.NotNil(t, newLeader)
+	err = newLeader.Propose([]byte("after"))
+	assert.Nil(t, err)
+
+	// 旧leader恢复后会追上新的日志
+	transport.Connect(leader.ID())
+	for i, node := range nodes {
+		if node == leader {
+			assert.True(t, waitApplied(sms[i], 2))
+			assert.Equal(t, []string{"before", "after"}, sms[i].values())
+		}
+	}
+}
+
+func TestNode_InstallSnapshot(t *testing.T) {
+	nodes, sms, transport := newTestCluster(3, 5)
+	defer stopCluster(nodes)
+
+	leader := waitLeader(nodes, "")
+	assert.NotNil(t, leader)
+
+	var lagging int
+	for i, node := range nodes {
+		if node != leader {
+			lagging = i
+			break
+		}
+	}
+	transport.Disconnect(nodes[lagging].ID())
+
+	for i := 0; i < 20; i++ {
+		err := leader.Propose([]byte(fmt.Sprintf("v%d", i)))
+		assert.Nil(t, err)
+	}
+	assert.True(t, leader.SnapshotIndex() > 0)
+
+	// 落后的节点只能通过快照追上
+	transport.Connect(nodes[lagging].ID())
+	assert.True(t, waitApplied(sms[lagging], 20))
+	assert.Equal(t, "v19", sms[lagging].values()[19])
+}
+
+func TestNode_Restart(t *testing.T) {
+	nodes, sms, transport := newTestCluster(3, 5)
+	defer stopCluster(nodes)
+
+	leader := waitLeader(nodes, "")
+	assert.NotNil(t, leader)
+	for i := 0; i < 8; i++ {
+		err := leader.Propose([]byte(fmt.Sprintf("v%d", i)))
+		assert.Nil(t, err)
+	}
+
+	// 重启之后从目录中恢复任期、日志和快照
+	var restarted int
+	for i, node := range nodes {
+		if node != leader {
+			restarted = i
+			break
+		}
+	}
+	assert.True(t, waitApplied(sms[restarted], 8))
+	old := nodes[restarted]
+	old.Stop()
+	term := old.Term()
+	sm := &kvStateMachine{}
+	node, err := NewNode(old.config, sm, transport)
+	assert.Nil(t, err)
+	assert.Equal(t, term, node.Term())
+	assert.True(t, node.lastIndex() >= 9)
+	assert.Equal(t, old.SnapshotIndex(), node.SnapshotIndex())
+	transport.Register(node)
+	nodes[restarted] = node
+	node.Start()
+
+	err = waitLeader(nodes, "").Propose([]byte("v8"))
+	assert.Nil(t, err)
+	assert.True(t, waitApplied(sm, 9))
+	assert.Equal(t, "v0", sm.values()[0])
+	assert.Equal(t, "v8", sm.values()[8])
+
+	_, err = NewNode(Config{ID: "node-x"}, sm, transport)
+	assert.Equal(t, ErrDirPathIsEmpty, err)
+}
@@ -0,0 +1,390 @@
+package raft
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+const (
+	stateFileName        = "raft-state"
+	logFileName          = "raft-log"
+	snapshotFileName     = "raft-snapshot"
+	snapshotTmpFileName  = "raft-snapshot.tmp"
+	snapshotRecvFileName = "raft-snapshot.recv"
+	// snapshotHeaderSize 快照文件开头保存快照包含的最后一条日志的Index和Term
+	snapshotHeaderSize = 16
+	// snapshotChunkSize leader每次发送的快照数据大小
+	snapshotChunkSize = 1024 * 1024
+	// logHeaderSize 日志文件中每条记录的头部：crc + 记录长度
+	logHeaderSize = 8
+	filePerm      = 0644
+)
+
+var errInvalidState = errors.New("raft: invalid state file")
+
+// storage 持久化任期、投票、日志和快照，所有写入都在fsync之后才返回，调用方需要持有节点的锁
+// 日志文件中只保存快照之后的日志，offsets[i]是Node.log[i+1]在文件中的位置
+type storage struct {
+	dirPath   string
+	logFile   *os.File
+	logSize   int64
+	offsets   []int64
+	recvFile  *os.File //正在接收的快照
+	recvIndex uint64
+	recvSize  int64
+}
+
+// persistedState 启动时从目录中读取的状态
+type persistedState struct {
+	term          uint64
+	votedFor      string
+	snapshotIndex uint64
+	snapshotTerm  uint64
+	hasSnapshot   bool
+	entries       []LogEntry
+}
+
+// openStorage 打开目录中的持久化文件，日志文件末尾没有写完整的记录会被截断
+func openStorage(dirPath string) (*storage, *persistedState, error) {
+	if err := os.MkdirAll(dirPath, os.ModePerm); err != nil {
+		return nil, nil, err
+	}
+	_ = os.Remove(filepath.Join(dirPath, snapshotTmpFileName))
+	_ = os.Remove(filepath.Join(dirPath, snapshotRecvFileName))
+
+	s := &storage{dirPath: dirPath}
+	state := &persistedState{}
+	if err := s.readState(state); err != nil {
+		return nil, nil, err
+	}
+	if err := s.readSnapshotMeta(state); err != nil {
+		return nil, nil, err
+	}
+	if err := s.openLog(state); err != nil {
+		return nil, nil, err
+	}
+	return s, state, nil
+}
+
+func (s *storage) readState(state *persistedState) error {
+	buf, err := os.ReadFile(filepath.Join(s.dirPath, stateFileName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if len(buf) < crc32.Size {
+		return errInvalidState
+	}
+	content := buf[:len(buf)-crc32.Size]
+	if crc32.ChecksumIEEE(content) != binary.BigEndian.Uint32(buf[len(content):]) {
+		return errInvalidState
+	}
+	term, n := binary.Uvarint(content)
+	if n <= 0 {
+		return errInvalidState
+	}
+	state.term = term
+	state.votedFor = string(content[n:])
+	return nil
+}
+
+func (s *storage) readSnapshotMeta(state *persistedState) error {
+	file, err := os.Open(filepath.Join(s.dirPath, snapshotFileName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer file.Close()
+	header := make([]byte, snapshotHeaderSize)
+	if _, err := io.ReadFull(file, header); err != nil {
+		return err
+	}
+	state.snapshotIndex = binary.BigEndian.Uint64(header[:8])
+	state.snapshotTerm = binary.BigEndian.Uint64(header[8:])
+	state.hasSnapshot = true
+	return nil
+}
+
+// openLog 读取日志文件中快照之后连续的日志
+func (s *storage) openLog(state *persistedState) error {
+	file, err := os.OpenFile(filepath.Join(s.dirPath, logFileName), os.O_CREATE|os.O_RDWR, filePerm)
+	if err != nil {
+		return err
+	}
+	s.logFile = file
+
+	rd := bufio.NewReader(file)
+	header := make([]byte, logHeaderSize)
+	lastIndex := state.snapshotIndex
+	var offset int64
+	for {
+		if _, err := io.ReadFull(rd, header); err != nil {
+			break
+		}
+		payload := make([]byte, binary.BigEndian.Uint32(header[4:]))
+		if _, err := io.ReadFull(rd, payload); err != nil {
+			break
+		}
+		if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(header[:4]) {
+			break
+		}
+		entry, ok := decodeLogEntry(payload)
+		if !ok {
+			break
+		}
+		//快照之后重写日志文件之前退出时，文件中还有被快照包含的日志
+		if entry.Index > state.snapshotIndex {
+			if entry.Index != lastIndex+1 {
+				break
+			}
+			state.entries = append(state.entries, entry)
+			s.offsets = append(s.offsets, offset)
+			lastIndex = entry.Index
+		}
+		offset += int64(logHeaderSize + len(payload))
+	}
+	if err := file.Truncate(offset); err != nil {
+		return err
+	}
+	s.logSize = offset
+	_, err = file.Seek(offset, io.SeekStart)
+	return err
+}
+
+// saveState 持久化任期和投票，先写临时文件再重命名
+func (s *storage) saveState(term uint64, votedFor string) error {
+	buf := binary.AppendUvarint(nil, term)
+	buf = append(buf, votedFor...)
+	buf = binary.BigEndian.AppendUint32(buf, crc32.ChecksumIEEE(buf))
+	return writeFileSync(filepath.Join(s.dirPath, stateFileName), func(w io.Writer) error {
+		_, err := w.Write(buf)
+		return err
+	})
+}
+
+// appendEntries 把日志追加到日志文件末尾
+func (s *storage) appendEntries(entries []LogEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	var buf []byte
+	offsets := make([]int64, 0, len(entries))
+	for _, entry := range entries {
+		offsets = append(offsets, s.logSize+int64(len(buf)))
+		buf = appendLogEntry(buf, entry)
+	}
+	if _, err := s.logFile.Write(buf); err != nil {
+		return err
+	}
+	if err := s.logFile.Sync(); err != nil {
+		return err
+	}
+	s.logSize += int64(len(buf))
+	s.offsets = append(s.offsets, offsets...)
+	return nil
+}
+
+// truncate 只保留日志文件中的前keep条日志
+func (s *storage) truncate(keep int) error {
+	if keep >= len(s.offsets) {
+		return nil
+	}
+	size := s.offsets[keep]
+	if err := s.logFile.Truncate(size); err != nil {
+		return err
+	}
+	if _, err := s.logFile.Seek(size, io.SeekStart); err != nil {
+		return err
+	}
+	if err := s.logFile.Sync(); err != nil {
+		return err
+	}
+	s.logSize = size
+	s.offsets = s.offsets[:keep]
+	return nil
+}
+
+// writeSnapshot 把状态机的快照写入临时文件，不需要持有节点的锁，之后调用installSnapshot替换正在使用的快照
+func (s *storage) writeSnapshot(index, term uint64, snapshot func(w io.Writer) error) error {
+	return writeSync(filepath.Join(s.dirPath, snapshotTmpFileName), func(w io.Writer) error {
+		if _, err := w.Write(snapshotHeader(index, term)); err != nil {
+			return err
+		}
+		return snapshot(w)
+	})
+}
+
+// receiveSnapshot 按顺序写入leader发送的快照数据，offset和已经收到的数据对不上时返回false
+func (s *storage) receiveSnapshot(args *InstallSnapshotArgs) (bool, error) {
+	if args.Offset == 0 {
+		s.discardReceived()
+		file, err := os.OpenFile(filepath.Join(s.dirPath, snapshotRecvFileName), os.O_CREATE|os.O_TRUNC|os.O_WRONLY, filePerm)
+		if err != nil {
+			return false, err
+		}
+		if _, err := file.Write(snapshotHeader(args.LastIncludedIndex, args.LastIncludedTerm)); err != nil {
+			_ = file.Close()
+			return false, err
+		}
+		s.recvFile, s.recvIndex, s.recvSize = file, args.LastIncludedIndex, 0
+	}
+	if s.recvFile == nil || s.recvIndex != args.LastIncludedIndex || uint64(s.recvSize) != args.Offset {
+		return false, nil
+	}
+	if _, err := s.recvFile.Write(args.Data); err != nil {
+		s.discardReceived()
+		return false, err
+	}
+	s.recvSize += int64(len(args.Data))
+	if !args.Done {
+		return true, nil
+	}
+	file := s.recvFile
+	s.recvFile = nil
+	err := file.Sync()
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err == nil, err
+}
+
+func (s *storage) discardReceived() {
+	if s.recvFile != nil {
+		_ = s.recvFile.Close()
+		s.recvFile = nil
+	}
+}
+
+// installSnapshot 用写好的快照替换正在使用的快照，并重写日志文件，只保留快照之后的日志
+func (s *storage) installSnapshot(received bool, entries []LogEntry) error {
+	tmpFileName := snapshotTmpFileName
+	if received {
+		tmpFileName = snapshotRecvFileName
+	}
+	if err := os.Rename(filepath.Join(s.dirPath, tmpFileName), filepath.Join(s.dirPath, snapshotFileName)); err != nil {
+		return err
+	}
+
+	var buf []byte
+	offsets := make([]int64, 0, len(entries))
+	for _, entry := range entries {
+		offsets = append(offsets, int64(len(buf)))
+		buf = appendLogEntry(buf, entry)
+	}
+	fileName := filepath.Join(s.dirPath, logFileName)
+	if err := writeFileSync(fileName, func(w io.Writer) error {
+		_, err := w.Write(buf)
+		return err
+	}); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(fileName, os.O_RDWR, filePerm)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Seek(int64(len(buf)), io.SeekStart); err != nil {
+		_ = file.Close()
+		return err
+	}
+	_ = s.logFile.Close()
+	s.logFile, s.logSize, s.offsets = file, int64(len(buf)), offsets
+	return nil
+}
+
+// removeSnapshotTmp 删除没有被使用的快照临时文件
+func (s *storage) removeSnapshotTmp() {
+	_ = os.Remove(filepath.Join(s.dirPath, snapshotTmpFileName))
+}
+
+// openSnapshot 打开正在使用的快照，返回快照数据的读取器，读取完之后需要关闭文件
+func (s *storage) openSnapshot() (*os.File, *io.SectionReader, error) {
+	file, err := os.Open(filepath.Join(s.dirPath, snapshotFileName))
+	if err != nil {
+		return nil, nil, err
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return nil, nil, err
+	}
+	return file, io.NewSectionReader(file, snapshotHeaderSize, stat.Size()-snapshotHeaderSize), nil
+}
+
+func (s *storage) close() error {
+	s.discardReceived()
+	return s.logFile.Close()
+}
+
+func snapshotHeader(index, term uint64) []byte {
+	header := binary.BigEndian.AppendUint64(nil, index)
+	return binary.BigEndian.AppendUint64(header, term)
+}
+
+// appendLogEntry 编码一条日志：crc + 长度 + Term + Index + 是否有数据 + 数据
+func appendLogEntry(buf []byte, entry LogEntry) []byte {
+	payload := binary.AppendUvarint(nil, entry.Term)
+	payload = binary.AppendUvarint(payload, entry.Index)
+	if entry.Data == nil {
+		payload = append(payload, 0)
+	} else {
+		payload = append(payload, 1)
+		payload = append(payload, entry.Data...)
+	}
+	buf = binary.BigEndian.AppendUint32(buf, crc32.ChecksumIEEE(payload))
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(payload)))
+	return append(buf, payload...)
+}
+
+func decodeLogEntry(payload []byte) (LogEntry, bool) {
+	var entry LogEntry
+	term, n := binary.Uvarint(payload)
+	if n <= 0 {
+		return entry, false
+	}
+	payload = payload[n:]
+	index, n := binary.Uvarint(payload)
+	if n <= 0 || len(payload) == n {
+		return entry, false
+	}
+	payload = payload[n:]
+	entry.Term, entry.Index = term, index
+	if payload[0] == 1 {
+		entry.Data = append([]byte{}, payload[1:]...)
+	}
+	return entry, true
+}
+
+// writeFileSync 先写临时文件并fsync，再重命名替换目标文件
+func writeFileSync(fileName string, write func(w io.Writer) error) error {
+	tmpFileName := fileName + ".tmp"
+	if err := writeSync(tmpFileName, write); err != nil {
+		return err
+	}
+	return os.Rename(tmpFileName, fileName)
+}
+
+// writeSync 写入文件并fsync
+func writeSync(fileName string, write func(w io.Writer) error) error {
+	file, err := os.OpenFile(fileName, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, filePerm)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	w := bufio.NewWriter(file)
+	if err := write(w); err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return file.Sync()
+}
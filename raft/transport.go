@@ -0,0 +1,125 @@
+package raft
+
+import "sync"
+
+// RequestVoteArgs 投票请求
+type RequestVoteArgs struct {
+	Term         uint64
+	CandidateId  string
+	LastLogIndex uint64
+	LastLogTerm  uint64
+}
+
+type RequestVoteReply struct {
+	Term        uint64
+	VoteGranted bool
+}
+
+// AppendEntriesArgs 日志复制请求，Entries为空时就是心跳
+type AppendEntriesArgs struct {
+	Term         uint64
+	LeaderId     string
+	PrevLogIndex uint64
+	PrevLogTerm  uint64
+	Entries      []LogEntry
+	LeaderCommit uint64
+}
+
+type AppendEntriesReply struct {
+	Term          uint64
+	Success       bool
+	ConflictIndex uint64 //日志不匹配时，leader下一次从这个位置开始发送
+}
+
+// InstallSnapshotArgs 快照安装请求，快照按照Offset分块发送，Done表示最后一块
+type InstallSnapshotArgs struct {
+	Term              uint64
+	LeaderId          string
+	LastIncludedIndex uint64
+	LastIncludedTerm  uint64
+	Offset            uint64
+	Data              []byte
+	Done              bool
+}
+
+type InstallSnapshotReply struct {
+	Term    uint64
+	Success bool //为false时leader停止发送，之后从头重新发送
+}
+
+// Transport 节点之间的通信接口，接收方收到请求后调用对应节点的Handle方法
+type Transport interface {
+	RequestVote(target string, args *RequestVoteArgs) (*RequestVoteReply, error)
+	AppendEntries(target string, args *AppendEntriesArgs) (*AppendEntriesReply, error)
+	InstallSnapshot(target string, args *InstallSnapshotArgs) (*InstallSnapshotReply, error)
+}
+
+// InmemTransport 进程内的通信实现，可以在一个进程中测试多个节点组成的集群
+type InmemTransport struct {
+	mu           *sync.RWMutex
+	nodes        map[string]*Node
+	disconnected map[string]bool
+}
+
+func NewInmemTransport() *InmemTransport {
+	return &InmemTransport{
+		mu:           new(sync.RWMutex),
+		nodes:        make(map[string]*Node),
+		disconnected: make(map[string]bool),
+	}
+}
+
+// Register 注册节点，之后其他节点才能访问它
+func (t *InmemTransport) Register(node *Node) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.nodes[node.ID()] = node
+}
+
+// Disconnect 断开节点的网络，模拟网络分区
+func (t *InmemTransport) Disconnect(id string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.disconnected[id] = true
+}
+
+// Connect 恢复节点的网络
+func (t *InmemTransport) Connect(id string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.disconnected, id)
+}
+
+func (t *InmemTransport) RequestVote(target string, args *RequestVoteArgs) (*RequestVoteReply, error) {
+	node, err := t.route(args.CandidateId, target)
+	if err != nil {
+		return nil, err
+	}
+	return node.HandleRequestVote(args), nil
+}
+
+func (t *InmemTransport) AppendEntries(target string, args *AppendEntriesArgs) (*AppendEntriesReply, error) {
+	node, err := t.route(args.LeaderId, target)
+	if err != nil {
+		return nil, err
+	}
+	return node.HandleAppendEntries(args), nil
+}
+
+func (t *InmemTransport) InstallSnapshot(target string, args *InstallSnapshotArgs) (*InstallSnapshotReply, error) {
+	node, err := t.route(args.LeaderId, target)
+	if err != nil {
+		return nil, err
+	}
+	return node.HandleInstallSnapshot(args), nil
+}
+
+func (t *InmemTransport) route(from, target string) (*Node, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	node, ok := t.nodes[target]
+	if !ok || t.disconnected[from] || t.disconnected[target] {
+		return nil, ErrUnreachable
+	}
+	return node, nil
+}
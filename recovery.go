@@ -0,0 +1,120 @@
+package JDawDB
+
+import (
+	"github.com/GrandeLai/JDawDB/data"
+	"io"
+	"runtime"
+	"time"
+)
+
+// recoveryFile 启动时需要加载的旧数据文件
+type recoveryFile struct {
+	dataFile   *data.DataFile
+	replayFrom int64 //这个位置之前的记录已经包含在checkpoint中
+	filesDone  int   //加载完这个文件之后已经处理过的文件数量，用于上报进度
+}
+
+// decodedFile 并行解析出来的数据文件中的记录，不包含value
+type decodedFile struct {
+	records       []*data.LogRecord
+	positions     []*data.LogRecordPos
+	err           error
+	corruptOffset int64 //err为ErrInvalidCRC时损坏记录的位置
+}
+
+// loadIndexFromOlderFiles 并行解析旧的数据文件，再按照文件和偏移量的顺序更新内存索引，保证后写入的数据生效
+func (db *DB) loadIndexFromOlderFiles(files []*recoveryFile, start time.Time) error {
+	if len(files) == 0 {
+		return nil
+	}
+	concurrency := db.options.RecoveryConcurrency
+	if concurrency <= 0 {
+		concurrency = runtime.NumCPU()
+	}
+
+	//每个文件的解析结果单独放在一个channel中，按顺序取出
+	results := make([]chan *decodedFile, len(files))
+	for i := range results {
+		results[i] = make(chan *decodedFile, 1)
+	}
+	//限制同时解析完但还没有应用的文件数量，避免占用太多内存
+	tokens := make(chan struct{}, concurrency)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for i, file := range files {
+			select {
+			case tokens <- struct{}{}:
+			case <-done:
+				return
+			}
+			go func(i int, file *recoveryFile) {
+				results[i] <- db.decodeDataFile(file.dataFile)
+			}(i, file)
+		}
+	}()
+
+	for i, file := range files {
+		decoded := <-results[i]
+		<-tokens
+		if decoded.err != nil {
+			if decoded.err == data.ErrInvalidCRC {
+				db.onCorruption(CorruptionInfo{FileId: file.dataFile.FileId, Offset: decoded.corruptOffset, Err: decoded.err})
+			}
+			return decoded.err
+		}
+		for j, logRecord := range decoded.records {
+			if decoded.positions[j].Offset >= file.replayFrom {
+				db.replayLogRecord(logRecord.Key, logRecord.Type, decoded.positions[j], db.txnRecords)
+			}
+		}
+		db.onRecoveryProgress(RecoveryInfo{
+			FileId:     file.dataFile.FileId,
+			FilesDone:  file.filesDone,
+			FilesTotal: len(db.fileIds),
+			Elapsed:    time.Since(start),
+		})
+	}
+	return nil
+}
+
+// decodeDataFile 解析数据文件中所有记录的key和位置，优先从hint文件中读取
+// 可能在多个协程中同时执行，不能修改DB的状态
+func (db *DB) decodeDataFile(dataFile *data.DataFile) *decodedFile {
+	records, positions, end, err := db.readDataHintFile(dataFile)
+	if err != nil {
+		return &decodedFile{err: err}
+	}
+	fileSize, err := dataFile.IoManager.Size()
+	if err != nil {
+		return &decodedFile{err: err}
+	}
+	if records != nil {
+		if end == fileSize {
+			return &decodedFile{records: records, positions: positions}
+		}
+		db.logger.Warn("hint file does not match data file", "fileId", dataFile.FileId)
+	}
+
+	//没有可用的hint文件，读取数据文件
+	decoded := &decodedFile{}
+	var offset int64
+	for {
+		logRecord, size, err := dataFile.ReadLogRecord(offset)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return &decodedFile{err: err, corruptOffset: offset}
+		}
+		logRecord.Value = nil
+		decoded.records = append(decoded.records, logRecord)
+		decoded.positions = append(decoded.positions, &data.LogRecordPos{
+			Fid:    dataFile.FileId,
+			Offset: offset,
+			Size:   uint32(size),
+		})
+		offset += size
+	}
+	return decoded
+}
@@ -0,0 +1,91 @@
+package JDawDB
+
+import (
+	"github.com/GrandeLai/JDawDB/data"
+	"github.com/GrandeLai/JDawDB/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDB_ParallelRecovery(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "JDawDB-recovery")
+	opts.DirPath = dir
+	opts.DataFileSize = 4 * 1024
+	db, err := Open(opts)
+	defer func() {
+		destroyDB(db)
+	}()
+	assert.Nil(t, err)
+
+	// 同一个key在不同的数据文件中多次写入，最后写入的生效
+	for round := 0; round < 5; round++ {
+		for i := 0; i < 200; i++ {
+			err := db.Put(utils.GetTestKey(i), []byte{byte(round)})
+			assert.Nil(t, err)
+		}
+		wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+		for i := 200; i < 300; i++ {
+			_ = wb.Put(utils.GetTestKey(i), []byte{byte(round)})
+		}
+		err = wb.Commit()
+		assert.Nil(t, err)
+	}
+	for i := 0; i < 50; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	// 没有提交标识的事务数据不能生效，跨越了多个数据文件
+	db.mu.Lock()
+	for i := 0; i < 300; i++ {
+		_, err := db.appendLogRecord(&data.LogRecord{
+			Key:   LogRecordKeyWithSeqNo(utils.GetTestKey(i), 1000),
+			Value: []byte("uncommitted"),
+			Type:  data.LogRecordNormal,
+		})
+		assert.Nil(t, err)
+	}
+	db.mu.Unlock()
+	for i := 300; i < 400; i++ {
+		err := db.Put(utils.GetTestKey(i), []byte("last"))
+		assert.Nil(t, err)
+	}
+	assert.True(t, db.activeFile.FileId > 10)
+	err = db.Close()
+	assert.Nil(t, err)
+
+	check := func(db *DB) {
+		assert.Equal(t, 350, len(db.ListKeys()))
+		for i := 0; i < 50; i++ {
+			_, err := db.Get(utils.GetTestKey(i))
+			assert.Equal(t, ErrKeyNotFound, err)
+		}
+		for i := 50; i < 300; i++ {
+			val, err := db.Get(utils.GetTestKey(i))
+			assert.Nil(t, err)
+			assert.Equal(t, []byte{4}, val)
+		}
+		val, err := db.Get(utils.GetTestKey(399))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("last"), val)
+	}
+
+	// 去掉checkpoint和一部分hint文件，分别用并行和串行的方式从数据文件恢复
+	for _, concurrency := range []int{8, 1} {
+		err = os.Remove(filepath.Join(dir, data.IndexCheckpointFileName))
+		assert.Nil(t, err)
+		for fid := uint32(0); fid < 10; fid += 2 {
+			_ = os.Remove(data.GetHintFileName(dir, fid))
+		}
+		opts.RecoveryConcurrency = concurrency
+		db, err = Open(opts)
+		assert.Nil(t, err)
+		check(db)
+		err = db.Close()
+		assert.Nil(t, err)
+	}
+	db, err = Open(opts)
+	assert.Nil(t, err)
+}
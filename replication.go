@@ -0,0 +1,637 @@
+package JDawDB
+
+import (
+	"encoding/binary"
+	"github.com/GrandeLai/JDawDB/data"
+	"github.com/GrandeLai/JDawDB/fio"
+	"github.com/GrandeLai/JDawDB/index"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 复制协议：
+// 从节点建立连接后先发送 fileId(4字节) + offset(8字节) + epoch(4字节)，表示已经应用到的位置；
+// 主节点随后不断发送数据帧：type(1字节) + fileId(4字节) + offset(8字节) + length(4字节) + payload，
+// payload 是数据文件中从 offset 开始的若干条完整的 LogRecord 原始字节；
+// epoch 是主节点最近一次merge的nonMergeFileId，merge会重写数据文件，epoch不一致时位置已经失效，
+// 主节点发送一个resync帧（fileId字段为新的epoch），从节点清空数据后从头开始同步
+
+const (
+	replOffsetKey        = "repl-offset"
+	replEpochKey         = "repl-epoch"
+	replRequestSize      = 4 + 8 + 4
+	replFrameHeaderSize  = 1 + 4 + 8 + 4
+	replFrameRecords     = byte(1)
+	replFrameResync      = byte(2)
+	replOffsetTmpSuffix  = ".tmp"
+	replMaxFramePayload  = 1 << 30
+	replAcceptRetryDelay = 10 * time.Millisecond
+)
+
+// Primary 主节点，将数据文件中新追加的记录推送给从节点
+type Primary struct {
+	db       *DB
+	opts     ReplicationOptions
+	listener net.Listener
+	mu       *sync.Mutex
+	conns    map[net.Conn]struct{} //当前连接的从节点
+	closeCh  chan struct{}
+	wg       *sync.WaitGroup
+}
+
+// StartPrimary 在addr上监听从节点的连接，并开始推送数据
+func (db *DB) StartPrimary(addr string, opts ReplicationOptions) (*Primary, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	p := &Primary{
+		db:       db,
+		opts:     opts.withDefaults(),
+		listener: listener,
+		mu:       new(sync.Mutex),
+		conns:    make(map[net.Conn]struct{}),
+		closeCh:  make(chan struct{}),
+		wg:       new(sync.WaitGroup),
+	}
+	p.wg.Add(1)
+	go p.serve()
+	return p, nil
+}
+
+// Addr 返回主节点实际监听的地址
+func (p *Primary) Addr() net.Addr {
+	return p.listener.Addr()
+}
+
+// Close 停止监听并断开所有从节点，重复关闭时返回ErrReplicationClosed
+func (p *Primary) Close() error {
+	p.mu.Lock()
+	select {
+	case <-p.closeCh:
+		p.mu.Unlock()
+		return ErrReplicationClosed
+	default:
+	}
+	close(p.closeCh)
+	p.mu.Unlock()
+	err := p.listener.Close()
+	p.mu.Lock()
+	for conn := range p.conns {
+		_ = conn.Close()
+	}
+	p.mu.Unlock()
+	p.wg.Wait()
+	return err
+}
+
+func (p *Primary) serve() {
+	defer p.wg.Done()
+	for {
+		conn, err := p.listener.Accept()
+		if err != nil {
+			select {
+			case <-p.closeCh:
+				return
+			case <-time.After(replAcceptRetryDelay):
+				continue
+			}
+		}
+		p.mu.Lock()
+		p.conns[conn] = struct{}{}
+		p.mu.Unlock()
+
+		p.wg.Add(1)
+		go p.handleConn(conn)
+	}
+}
+
+// handleConn 从从节点请求的位置开始，不断推送数据
+func (p *Primary) handleConn(conn net.Conn) {
+	defer p.wg.Done()
+	defer func() {
+		p.mu.Lock()
+		delete(p.conns, conn)
+		p.mu.Unlock()
+		_ = conn.Close()
+	}()
+
+	//读取从节点已经应用到的位置
+	req := make([]byte, replRequestSize)
+	if _, err := io.ReadFull(conn, req); err != nil {
+		return
+	}
+	fileId := binary.BigEndian.Uint32(req[:4])
+	offset := int64(binary.BigEndian.Uint64(req[4:12]))
+	epoch := binary.BigEndian.Uint32(req[12:])
+
+	//merge之后从节点的位置对应的已经不是同样的数据了，通知从节点重新同步
+	if epoch != p.db.mergeEpoch {
+		_ = writeReplFrame(conn, replFrameResync, p.db.mergeEpoch, 0, nil)
+		return
+	}
+
+	for {
+		select {
+		case <-p.closeCh:
+			return
+		default:
+		}
+
+		dataFile, limit, nextFileId, nextOffset := p.db.replicationSource(fileId, offset)
+		fileId, offset = nextFileId, nextOffset
+		if dataFile == nil || offset >= limit {
+			//没有新的数据，等待下一次轮询
+			select {
+			case <-p.closeCh:
+				return
+			case <-time.After(p.opts.PollInterval):
+				continue
+			}
+		}
+
+		payload, err := readRecordsRaw(dataFile, offset, limit, p.opts.MaxFrameSize)
+		if err != nil {
+			return
+		}
+		if err := writeReplFrame(conn, replFrameRecords, fileId, offset, payload); err != nil {
+			return
+		}
+		offset += int64(len(payload))
+	}
+}
+
+// replicationSource 找到从节点位置对应的数据文件以及当前可以读取的上限
+// 如果该文件已经读完并且有更新的文件，则跳到下一个文件的开头
+func (db *DB) replicationSource(fileId uint32, offset int64) (*data.DataFile, int64, uint32, int64) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if db.activeFile == nil {
+		return nil, 0, fileId, offset
+	}
+	for {
+		if fileId == db.activeFile.FileId {
+			return db.activeFile, db.activeFile.WriteOff, fileId, offset
+		}
+		if dataFile := db.olderFiles[fileId]; dataFile != nil {
+			size, err := dataFile.IoManager.Size()
+			if err != nil {
+				return nil, 0, fileId, offset
+			}
+			if offset < size {
+				return dataFile, size, fileId, offset
+			}
+		}
+		//当前文件已经读完或者不存在，找到下一个更大的文件ID
+		nextFileId := db.activeFile.FileId
+		for fid := range db.olderFiles {
+			if fid > fileId && fid < nextFileId {
+				nextFileId = fid
+			}
+		}
+		if nextFileId <= fileId {
+			return nil, 0, fileId, offset
+		}
+		fileId, offset = nextFileId, 0
+	}
+}
+
+// readRecordsRaw 读取[offset, limit)之间若干条完整记录的原始字节，至少包含一条记录
+func readRecordsRaw(dataFile *data.DataFile, offset, limit, maxSize int64) ([]byte, error) {
+	var buf []byte
+	for offset < limit {
+		logRecord, size, err := dataFile.ReadLogRecord(offset)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+		if len(buf) > 0 && int64(len(buf))+size > maxSize {
+			break
+		}
+		//编码是确定的，重新编码即可得到和文件中一致的字节
+		encRecord, _ := data.EncodeLogRecord(logRecord)
+		buf = append(buf, encRecord...)
+		offset += size
+	}
+	return buf, nil
+}
+
+func writeReplFrame(w io.Writer, frameType byte, fileId uint32, offset int64, payload []byte) error {
+	header := make([]byte, replFrameHeaderSize)
+	header[0] = frameType
+	binary.BigEndian.PutUint32(header[1:5], fileId)
+	binary.BigEndian.PutUint64(header[5:13], uint64(offset))
+	binary.BigEndian.PutUint32(header[13:], uint32(len(payload)))
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	_, err := w.Write(payload)
+	return err
+}
+
+// Follower 从节点，接收主节点推送的记录并写入自己的数据目录，只提供读服务
+type Follower struct {
+	db                 *DB
+	opts               ReplicationOptions
+	primaryAddr        string
+	mu                 *sync.Mutex
+	conn               net.Conn
+	fileId             uint32                                  //已经应用到的文件ID
+	offset             int64                                   //已经应用到的偏移量
+	epoch              uint32                                  //复制位置对应的主节点merge epoch
+	transactionRecords map[uint64][]*data.TransactionLogRecord //还没有读到完成标识的事务数据
+	closeCh            chan struct{}
+	wg                 *sync.WaitGroup
+}
+
+// OpenFollower 打开从节点的数据目录，并开始从主节点同步数据
+func OpenFollower(primaryAddr string, options Options, replOpts ReplicationOptions) (*Follower, error) {
+	//把数据目录恢复到上一次持久化的复制位置
+	fileId, offset, epoch, err := prepareFollowerDir(options.DirPath)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := Open(options)
+	if err != nil {
+		return nil, err
+	}
+	f := &Follower{
+		db:                 db,
+		opts:               replOpts.withDefaults(),
+		primaryAddr:        primaryAddr,
+		mu:                 new(sync.Mutex),
+		fileId:             fileId,
+		offset:             offset,
+		epoch:              epoch,
+		transactionRecords: make(map[uint64][]*data.TransactionLogRecord),
+		closeCh:            make(chan struct{}),
+		wg:                 new(sync.WaitGroup),
+	}
+	f.wg.Add(1)
+	go f.run()
+	return f, nil
+}
+
+// Get 从从节点读取数据
+func (f *Follower) Get(key []byte) ([]byte, error) {
+	return f.db.Get(key)
+}
+
+// Position 返回从节点已经应用到的位置
+func (f *Follower) Position() (fileId uint32, offset int64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.fileId, f.offset
+}
+
+// Close 断开和主节点的连接并关闭数据库，重复关闭时返回ErrReplicationClosed
+func (f *Follower) Close() error {
+	f.mu.Lock()
+	select {
+	case <-f.closeCh:
+		f.mu.Unlock()
+		return ErrReplicationClosed
+	default:
+	}
+	close(f.closeCh)
+	if f.conn != nil {
+		_ = f.conn.Close()
+	}
+	f.mu.Unlock()
+	f.wg.Wait()
+	return f.db.Close()
+}
+
+func (f *Follower) run() {
+	defer f.wg.Done()
+	for {
+		conn, err := net.DialTimeout("tcp", f.primaryAddr, f.opts.RetryInterval)
+		if err == nil {
+			//Close可能发生在建立连接的过程中，这时不能再开始同步
+			f.mu.Lock()
+			select {
+			case <-f.closeCh:
+				f.mu.Unlock()
+				_ = conn.Close()
+				return
+			default:
+			}
+			f.conn = conn
+			f.mu.Unlock()
+
+			_ = f.replicate(conn)
+			_ = conn.Close()
+		}
+
+		//断开之后等待一段时间重连，从已经应用的位置继续同步
+		select {
+		case <-f.closeCh:
+			return
+		case <-time.After(f.opts.RetryInterval):
+		}
+	}
+}
+
+func (f *Follower) replicate(conn net.Conn) error {
+	f.mu.Lock()
+	req := make([]byte, replRequestSize)
+	binary.BigEndian.PutUint32(req[:4], f.fileId)
+	binary.BigEndian.PutUint64(req[4:12], uint64(f.offset))
+	binary.BigEndian.PutUint32(req[12:], f.epoch)
+	f.mu.Unlock()
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+
+	header := make([]byte, replFrameHeaderSize)
+	for {
+		if _, err := io.ReadFull(conn, header); err != nil {
+			return err
+		}
+		fileId := binary.BigEndian.Uint32(header[1:5])
+		if header[0] == replFrameResync {
+			return f.resync(fileId)
+		}
+		if header[0] != replFrameRecords {
+			return ErrReplicationMismatch
+		}
+		offset := int64(binary.BigEndian.Uint64(header[5:13]))
+		length := binary.BigEndian.Uint32(header[13:])
+		if length > replMaxFramePayload {
+			return ErrReplicationMismatch
+		}
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(conn, payload); err != nil {
+			return err
+		}
+		if err := f.apply(fileId, offset, payload); err != nil {
+			return err
+		}
+	}
+}
+
+// apply 应用主节点发送过来的一帧数据
+func (f *Follower) apply(fileId uint32, offset int64, payload []byte) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.db.applyReplicatedRecords(fileId, offset, payload, f.transactionRecords); err != nil {
+		return err
+	}
+	f.fileId, f.offset = fileId, offset+int64(len(payload))
+
+	//只有在没有未完成事务的位置才持久化，重启后从这里继续不会丢失事务中的数据
+	if len(f.transactionRecords) == 0 {
+		return saveReplOffset(f.db.options.DirPath, f.fileId, f.offset, f.epoch)
+	}
+	return nil
+}
+
+// resync 主节点merge过，清空本地数据，重连之后从头开始同步
+func (f *Follower) resync(epoch uint32) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	//先持久化新的位置，清空数据的过程中崩溃，重启时也会删除所有数据文件
+	if err := saveReplOffset(f.db.options.DirPath, 0, 0, epoch); err != nil {
+		return err
+	}
+	if err := f.db.resetReplicatedData(); err != nil {
+		return err
+	}
+	f.fileId, f.offset, f.epoch = 0, 0, epoch
+	f.transactionRecords = make(map[uint64][]*data.TransactionLogRecord)
+	f.db.logger.Info("replication resync", "epoch", epoch)
+	return nil
+}
+
+// resetReplicatedData 关闭并删除所有的数据文件和索引，只保留文件锁和复制位置
+func (db *DB) resetReplicatedData() error {
+	db.checkpointMu.Lock()
+	defer db.checkpointMu.Unlock()
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	//后台写入的hint文件对应的是要删除的数据文件
+	db.hintWg.Wait()
+
+	if db.activeFile != nil {
+		if err := db.activeFile.Close(); err != nil {
+			return err
+		}
+	}
+	for _, dataFile := range db.olderFiles {
+		if err := dataFile.Close(); err != nil {
+			return err
+		}
+	}
+	if err := db.indexer.Close(); err != nil {
+		return err
+	}
+
+	dirEntries, err := os.ReadDir(db.options.DirPath)
+	if err != nil {
+		return err
+	}
+	for _, entry := range dirEntries {
+		if entry.Name() == fileLockName || strings.HasPrefix(entry.Name(), data.ReplOffsetFileName) {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(db.options.DirPath, entry.Name())); err != nil {
+			return err
+		}
+	}
+
+	db.activeFile = nil
+	db.olderFiles = make(map[uint32]*data.DataFile)
+	db.fileIds = nil
+	db.bytesWrite = 0
+	db.reclaimSize = 0
+	db.resetActiveHints()
+	db.indexer, err = db.newIndexer()
+	return err
+}
+
+// applyReplicatedRecords 将主节点的记录原样写入对应的数据文件，并更新内存索引
+func (db *DB) applyReplicatedRecords(fileId uint32, offset int64, payload []byte,
+	transactionRecords map[uint64][]*data.TransactionLogRecord) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	//主节点切换到了新的数据文件，从节点也同步切换
+	if db.activeFile == nil || db.activeFile.FileId != fileId {
+		if db.activeFile != nil {
+			if fileId < db.activeFile.FileId {
+				return ErrReplicationMismatch
+			}
+			if err := db.syncFile(db.activeFile); err != nil {
+				return err
+			}
+			db.olderFiles[db.activeFile.FileId] = db.activeFile
+			db.writeActiveHintFile()
+		}
+		dataFile, err := data.OpenDataFile(fileId, db.options.DirPath, fio.StandardFIO)
+		if err != nil {
+			return err
+		}
+		db.activeFile = dataFile
+		db.resetActiveHints()
+	}
+	if db.activeFile.WriteOff != offset {
+		return ErrReplicationMismatch
+	}
+
+	if err := db.activeFile.Write(payload); err != nil {
+		return err
+	}
+	if err := db.syncFile(db.activeFile); err != nil {
+		return err
+	}
+
+	//解析写入的记录，更新内存索引
+	end := offset + int64(len(payload))
+	for offset < end {
+		logRecord, size, err := db.activeFile.ReadLogRecord(offset)
+		if err != nil {
+			return err
+		}
+		logRecordPos := &data.LogRecordPos{
+			Fid:    fileId,
+			Offset: offset,
+			Size:   uint32(size),
+		}
+		db.replayLogRecord(logRecord.Key, logRecord.Type, logRecordPos, transactionRecords)
+		db.addActiveHint(logRecord.Key, logRecord.Type, logRecordPos)
+		offset += size
+	}
+	//没有未完成的事务时磁盘索引才能刷盘，否则重放时会丢失事务中已经写入的记录
+	if len(transactionRecords) == 0 {
+		db.maybeFlushIndex()
+	}
+	return nil
+}
+
+// prepareFollowerDir 读取持久化的复制位置，删除这个位置之后的数据，返回位置信息
+func prepareFollowerDir(dirPath string) (uint32, int64, uint32, error) {
+	if err := os.MkdirAll(dirPath, os.ModePerm); err != nil {
+		return 0, 0, 0, err
+	}
+	dirEntries, err := os.ReadDir(dirPath)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	var fileIds []int
+	for _, entry := range dirEntries {
+		if strings.HasSuffix(entry.Name(), data.DataFileNameSuffix) {
+			fileId, err := strconv.Atoi(strings.Split(entry.Name(), ".")[0])
+			if err != nil {
+				return 0, 0, 0, ErrDataFileCorrupted
+			}
+			fileIds = append(fileIds, fileId)
+		}
+	}
+	sort.Ints(fileIds)
+
+	fileName := filepath.Join(dirPath, data.ReplOffsetFileName)
+	if _, err := os.Stat(fileName); os.IsNotExist(err) {
+		//全新的从节点目录，不能包含其他数据
+		if len(fileIds) > 0 {
+			return 0, 0, 0, ErrReplicationDirInUse
+		}
+		return 0, 0, 0, saveReplOffset(dirPath, 0, 0, 0)
+	}
+
+	replOffsetFile, err := data.OpenReplOffsetFile(dirPath)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	defer func() {
+		_ = replOffsetFile.Close()
+	}()
+	record, size, err := replOffsetFile.ReadLogRecord(0)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	pos := data.DecodeLogRecordPos(record.Value)
+	//旧版本的位置文件中没有epoch，说明当时主节点还没有merge过
+	var epoch uint32
+	epochRecord, _, err := replOffsetFile.ReadLogRecord(size)
+	if err != nil && err != io.EOF {
+		return 0, 0, 0, err
+	}
+	if err == nil {
+		epoch = uint32(data.DecodeLogRecordPos(epochRecord.Value).Fid)
+	}
+
+	//持久化位置之后的数据可能属于未完成的事务，全部丢弃后重新同步
+	//checkpoint和磁盘索引中可能包含被丢弃的数据，也需要删除
+	if err := os.RemoveAll(filepath.Join(dirPath, index.DiskIndexDirName)); err != nil {
+		return 0, 0, 0, err
+	}
+	if err := os.RemoveAll(filepath.Join(dirPath, data.IndexCheckpointFileName)); err != nil {
+		return 0, 0, 0, err
+	}
+	for _, fid := range fileIds {
+		dataFileName := data.GetDataFileName(dirPath, uint32(fid))
+		//被删除或者截断的数据文件对应的hint文件也不再有效
+		if uint32(fid) >= pos.Fid {
+			if err := os.RemoveAll(data.GetHintFileName(dirPath, uint32(fid))); err != nil {
+				return 0, 0, 0, err
+			}
+		}
+		if uint32(fid) > pos.Fid {
+			if err := os.Remove(dataFileName); err != nil {
+				return 0, 0, 0, err
+			}
+		} else if uint32(fid) == pos.Fid {
+			if err := os.Truncate(dataFileName, pos.Offset); err != nil {
+				return 0, 0, 0, err
+			}
+		}
+	}
+	return pos.Fid, pos.Offset, epoch, nil
+}
+
+// saveReplOffset 先写临时文件再重命名，保证复制位置文件总是完整的
+func saveReplOffset(dirPath string, fileId uint32, offset int64, epoch uint32) error {
+	record := &data.LogRecord{
+		Key:   []byte(replOffsetKey),
+		Value: data.EncodeLogRecordPos(&data.LogRecordPos{Fid: fileId, Offset: offset}),
+	}
+	encRecord, _ := data.EncodeLogRecord(record)
+	epochRecord := &data.LogRecord{
+		Key:   []byte(replEpochKey),
+		Value: data.EncodeLogRecordPos(&data.LogRecordPos{Fid: epoch}),
+	}
+	encEpochRecord, _ := data.EncodeLogRecord(epochRecord)
+	encRecord = append(encRecord, encEpochRecord...)
+	fileName := filepath.Join(dirPath, data.ReplOffsetFileName)
+	if err := os.WriteFile(fileName+replOffsetTmpSuffix, encRecord, fio.DataFilePerm); err != nil {
+		return err
+	}
+	return os.Rename(fileName+replOffsetTmpSuffix, fileName)
+}
+
+// withDefaults 非法的配置项使用默认值，间隔为0时主节点轮询和从节点重连都会空转
+func (opts ReplicationOptions) withDefaults() ReplicationOptions {
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = DefaultReplicationOptions.PollInterval
+	}
+	if opts.MaxFrameSize <= 0 {
+		opts.MaxFrameSize = DefaultReplicationOptions.MaxFrameSize
+	}
+	if opts.RetryInterval <= 0 {
+		opts.RetryInterval = DefaultReplicationOptions.RetryInterval
+	}
+	return opts
+}
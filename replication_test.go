@@ -0,0 +1,186 @@
+package JDawDB
+
+import (
+	"github.com/GrandeLai/JDawDB/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+	"time"
+)
+
+// 等待从节点读取到指定的值
+func waitFollowerValue(f *Follower, key []byte, expected []byte) bool {
+	for i := 0; i < 100; i++ {
+		val, err := f.Get(key)
+		if expected == nil && err == ErrKeyNotFound {
+			return true
+		}
+		if err == nil && string(val) == string(expected) {
+			return true
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return false
+}
+
+func TestFollower_Replicate(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "JDawDB-repl-primary")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	replOpts := DefaultReplicationOptions
+	replOpts.PollInterval = 10 * time.Millisecond
+	replOpts.RetryInterval = 50 * time.Millisecond
+	primary, err := db.StartPrimary("127.0.0.1:0", replOpts)
+	assert.Nil(t, err)
+	defer primary.Close()
+
+	// 写入足够多的数据，让主节点发生文件切换
+	for i := 0; i < 500; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+	lastVal := []byte("last-value")
+	err = db.Put(utils.GetTestKey(499), lastVal)
+	assert.Nil(t, err)
+
+	followerOpts := DefaultOptions
+	followerDir, _ := os.MkdirTemp("", "JDawDB-repl-follower")
+	followerOpts.DirPath = followerDir
+	defer func() {
+		_ = os.RemoveAll(followerDir)
+	}()
+	follower, err := OpenFollower(primary.Addr().String(), followerOpts, replOpts)
+	assert.Nil(t, err)
+	assert.True(t, waitFollowerValue(follower, utils.GetTestKey(499), lastVal))
+
+	val, err := db.Get(utils.GetTestKey(10))
+	assert.Nil(t, err)
+	assert.True(t, waitFollowerValue(follower, utils.GetTestKey(10), val))
+
+	// 断开之后主节点继续写入，包括事务数据和删除
+	err = follower.Close()
+	assert.Nil(t, err)
+	err = follower.Close()
+	assert.Equal(t, ErrReplicationClosed, err)
+
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	_ = wb.Put(utils.GetTestKey(1000), []byte("txn-value"))
+	_ = wb.Delete(utils.GetTestKey(1))
+	err = wb.Commit()
+	assert.Nil(t, err)
+	err = db.Delete(utils.GetTestKey(2))
+	assert.Nil(t, err)
+
+	// 重新打开从节点，从持久化的位置继续同步
+	follower2, err := OpenFollower(primary.Addr().String(), followerOpts, replOpts)
+	assert.Nil(t, err)
+	defer follower2.Close()
+	assert.True(t, waitFollowerValue(follower2, utils.GetTestKey(1000), []byte("txn-value")))
+	assert.True(t, waitFollowerValue(follower2, utils.GetTestKey(1), nil))
+	assert.True(t, waitFollowerValue(follower2, utils.GetTestKey(2), nil))
+	val, err = follower2.Get(utils.GetTestKey(499))
+	assert.Nil(t, err)
+	assert.Equal(t, lastVal, val)
+
+	fileId, offset := follower2.Position()
+	assert.Equal(t, db.activeFile.FileId, fileId)
+	assert.Equal(t, db.activeFile.WriteOff, offset)
+}
+
+func TestOpenFollower_DirInUse(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "JDawDB-repl-in-use")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	err = db.Put(utils.GetTestKey(1), utils.RandomValue(10))
+	assert.Nil(t, err)
+	err = db.Close()
+	assert.Nil(t, err)
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	_, err = OpenFollower("127.0.0.1:0", opts, DefaultReplicationOptions)
+	assert.Equal(t, ErrReplicationDirInUse, err)
+}
+
+func TestFollower_ResyncAfterMerge(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "JDawDB-repl-merge-primary")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.DataFileMergeRatio = 0
+	db, err := Open(opts)
+	assert.Nil(t, err)
+
+	// 间隔为0时使用默认值，不会空转
+	replOpts := ReplicationOptions{RetryInterval: 50 * time.Millisecond}
+	primary, err := db.StartPrimary("127.0.0.1:0", replOpts)
+	assert.Nil(t, err)
+	assert.Equal(t, DefaultReplicationOptions.PollInterval, primary.opts.PollInterval)
+
+	for i := 0; i < 500; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+	followerOpts := DefaultOptions
+	followerDir, _ := os.MkdirTemp("", "JDawDB-repl-merge-follower")
+	followerOpts.DirPath = followerDir
+	defer func() {
+		_ = os.RemoveAll(followerDir)
+	}()
+	follower, err := OpenFollower(primary.Addr().String(), followerOpts, replOpts)
+	assert.Nil(t, err)
+	val, err := db.Get(utils.GetTestKey(499))
+	assert.Nil(t, err)
+	assert.True(t, waitFollowerValue(follower, utils.GetTestKey(499), val))
+	err = follower.Close()
+	assert.Nil(t, err)
+	err = primary.Close()
+	assert.Nil(t, err)
+	err = primary.Close()
+	assert.Equal(t, ErrReplicationClosed, err)
+
+	// 主节点删除一部分数据后merge，重启之后数据文件被重写
+	for i := 0; i < 250; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	err = db.Merge()
+	assert.Nil(t, err)
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	defer destroyDB(db2)
+	assert.NotEqual(t, uint32(0), db2.mergeEpoch)
+	err = db2.Put(utils.GetTestKey(1000), []byte("after-merge"))
+	assert.Nil(t, err)
+
+	primary2, err := db2.StartPrimary("127.0.0.1:0", replOpts)
+	assert.Nil(t, err)
+	defer primary2.Close()
+
+	// 从节点发现主节点merge过，清空数据后重新同步
+	follower2, err := OpenFollower(primary2.Addr().String(), followerOpts, replOpts)
+	assert.Nil(t, err)
+	defer follower2.Close()
+	assert.True(t, waitFollowerValue(follower2, utils.GetTestKey(1000), []byte("after-merge")))
+	assert.True(t, waitFollowerValue(follower2, utils.GetTestKey(1), nil))
+	val, err = db2.Get(utils.GetTestKey(499))
+	assert.Nil(t, err)
+	got, err := follower2.Get(utils.GetTestKey(499))
+	assert.Nil(t, err)
+	assert.Equal(t, val, got)
+	assert.Equal(t, db2.mergeEpoch, follower2.epoch)
+
+	fileId, offset := follower2.Position()
+	assert.Equal(t, db2.activeFile.FileId, fileId)
+	assert.Equal(t, db2.activeFile.WriteOff, offset)
+}
@@ -0,0 +1,515 @@
+package JDawDB
+
+import (
+	"bytes"
+	"errors"
+	"github.com/GrandeLai/JDawDB/index"
+	"hash/crc32"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+const (
+	// 每个分片在哈希环上的虚拟节点数量
+	shardVirtualNodes = 128
+	// 迁移时每次从分片中读取的key数量
+	rebalanceBatchSize = 1000
+)
+
+var (
+	ErrNoShards        = errors.New("sharded db needs at least one dir")
+	ErrShardedDBClosed = errors.New("sharded db is closed")
+)
+
+// ShardedDB 将key按照一致性哈希分布到多个数据目录中，每个目录是一个独立的DB实例
+type ShardedDB struct {
+	mu          *sync.RWMutex
+	options     Options
+	dirs        []string
+	shards      []*DB
+	ring        *hashRing
+	closed      bool
+	rebalanceMu *sync.Mutex   //同一时间只有一个迁移在进行
+	moveMu      *sync.RWMutex //迁移期间，正在迁移的范围内的读写和单个key的迁移互斥
+	rebalancing bool          //是否正在迁移
+	prevRing    *hashRing     //AddShard之前的哈希环，为nil时key可能在任意一个分片中
+}
+
+// OpenSharded 打开多个数据目录，哈希环由目录路径决定，重新打开时需要传入相同的目录路径
+func OpenSharded(options Options, dirs []string) (*ShardedDB, error) {
+	if len(dirs) == 0 {
+		return nil, ErrNoShards
+	}
+	sd := &ShardedDB{
+		mu:          new(sync.RWMutex),
+		options:     options,
+		ring:        newHashRing(),
+		rebalanceMu: new(sync.Mutex),
+		moveMu:      new(sync.RWMutex),
+	}
+	for _, dir := range dirs {
+		if err := sd.openShard(dir); err != nil {
+			_ = sd.Close()
+			return nil, err
+		}
+	}
+	return sd, nil
+}
+
+func (sd *ShardedDB) openShard(dir string) error {
+	opts := sd.options
+	opts.DirPath = dir
+	db, err := Open(opts)
+	if err != nil {
+		return err
+	}
+	sd.dirs = append(sd.dirs, dir)
+	sd.shards = append(sd.shards, db)
+	sd.ring.add(dir, len(sd.shards)-1)
+	return nil
+}
+
+// Put 写入数据到key所在的分片
+// 迁移期间还会删除旧分片中的数据，避免迁移时用旧值覆盖
+func (sd *ShardedDB) Put(key []byte, value []byte) error {
+	sd.mu.RLock()
+	defer sd.mu.RUnlock()
+	owner := sd.ring.get(key)
+	sources := sd.sourcesOf(key, owner)
+	if len(sources) == 0 {
+		return sd.shards[owner].Put(key, value)
+	}
+
+	sd.moveMu.Lock()
+	defer sd.moveMu.Unlock()
+	if err := sd.shards[owner].Put(key, value); err != nil {
+		return err
+	}
+	return sd.deleteFrom(key, sources)
+}
+
+// Get 从key所在的分片读取数据，迁移期间没有找到时再从旧分片中读取
+func (sd *ShardedDB) Get(key []byte) ([]byte, error) {
+	sd.mu.RLock()
+	defer sd.mu.RUnlock()
+	owner := sd.ring.get(key)
+	sources := sd.sourcesOf(key, owner)
+	if len(sources) == 0 {
+		return sd.shards[owner].Get(key)
+	}
+
+	sd.moveMu.RLock()
+	defer sd.moveMu.RUnlock()
+	for _, shard := range append([]int{owner}, sources...) {
+		value, err := sd.shards[shard].Get(key)
+		if err != ErrKeyNotFound {
+			return value, err
+		}
+	}
+	return nil, ErrKeyNotFound
+}
+
+// Delete 从key所在的分片删除数据，迁移期间同时删除旧分片中的数据
+func (sd *ShardedDB) Delete(key []byte) error {
+	sd.mu.RLock()
+	defer sd.mu.RUnlock()
+	owner := sd.ring.get(key)
+	sources := sd.sourcesOf(key, owner)
+	if len(sources) == 0 {
+		return sd.shards[owner].Delete(key)
+	}
+
+	sd.moveMu.Lock()
+	defer sd.moveMu.Unlock()
+	return sd.deleteFrom(key, append([]int{owner}, sources...))
+}
+
+// sourcesOf 返回迁移期间key可能还留在的其他分片，没有在迁移或者key的归属没有变化时返回nil
+func (sd *ShardedDB) sourcesOf(key []byte, owner int) []int {
+	if !sd.rebalancing {
+		return nil
+	}
+	if sd.prevRing != nil {
+		if prevOwner := sd.prevRing.get(key); prevOwner != owner {
+			return []int{prevOwner}
+		}
+		return nil
+	}
+	var sources []int
+	for i := range sd.shards {
+		if i != owner {
+			sources = append(sources, i)
+		}
+	}
+	return sources
+}
+
+func (sd *ShardedDB) deleteFrom(key []byte, shards []int) error {
+	for _, shard := range shards {
+		if err := sd.shards[shard].Delete(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ShardNum 返回分片数量
+func (sd *ShardedDB) ShardNum() int {
+	sd.mu.RLock()
+	defer sd.mu.RUnlock()
+	return len(sd.shards)
+}
+
+// Sync 持久化所有分片
+func (sd *ShardedDB) Sync() error {
+	sd.mu.RLock()
+	defer sd.mu.RUnlock()
+	for _, db := range sd.shards {
+		if err := db.Sync(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close 关闭所有分片
+func (sd *ShardedDB) Close() error {
+	sd.mu.Lock()
+	defer sd.mu.Unlock()
+	sd.closed = true
+	var errs []error
+	for _, db := range sd.shards {
+		if err := db.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Merge 并行地对每个分片进行merge，没有达到merge阈值的分片会被跳过
+func (sd *ShardedDB) Merge() error {
+	sd.mu.RLock()
+	defer sd.mu.RUnlock()
+
+	errs := make([]error, len(sd.shards))
+	wg := new(sync.WaitGroup)
+	for i, db := range sd.shards {
+		wg.Add(1)
+		go func(i int, db *DB) {
+			defer wg.Done()
+			if err := db.Merge(); err != nil && err != ErrMergeRatioUnreached {
+				errs[i] = err
+			}
+		}(i, db)
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// AddShard 增加一个分片，并把改为归属新分片的key迁移过去
+// key是按照key的顺序存放的，不能按照哈希范围读取，需要遍历失去了范围的分片中所有的key，
+// 虚拟节点较多时基本上就是原来所有的分片；遍历时分批读取key，不会一次把所有的key读到内存中
+// 迁移时不会阻塞读写，只有归属发生变化的key的读写需要和单个key的迁移互斥
+func (sd *ShardedDB) AddShard(dir string) error {
+	sd.rebalanceMu.Lock()
+	defer sd.rebalanceMu.Unlock()
+
+	sd.mu.Lock()
+	if sd.closed {
+		sd.mu.Unlock()
+		return ErrShardedDBClosed
+	}
+	prevRing := sd.ring.clone()
+	if err := sd.openShard(dir); err != nil {
+		sd.mu.Unlock()
+		return err
+	}
+	sd.rebalancing, sd.prevRing = true, prevRing
+	sd.mu.Unlock()
+
+	return sd.rebalance(sd.ring.changedShards(prevRing))
+}
+
+// Rebalance 把不属于当前分片的key迁移到正确的分片
+// AddShard中途崩溃后，用完整的目录列表重新打开并调用Rebalance即可继续迁移
+// 这时不知道哪些范围改变了归属，需要检查所有分片，迁移期间没有找到的key会再到其他分片中读取
+func (sd *ShardedDB) Rebalance() error {
+	sd.rebalanceMu.Lock()
+	defer sd.rebalanceMu.Unlock()
+
+	sd.mu.Lock()
+	if sd.closed {
+		sd.mu.Unlock()
+		return ErrShardedDBClosed
+	}
+	sd.rebalancing, sd.prevRing = true, nil
+	sources := make(map[int]bool, len(sd.shards))
+	for i := range sd.shards {
+		sources[i] = true
+	}
+	sd.mu.Unlock()
+
+	return sd.rebalance(sources)
+}
+
+// rebalance 分批遍历sources中的分片，把归属其他分片的key逐个迁移过去
+func (sd *ShardedDB) rebalance(sources map[int]bool) error {
+	defer func() {
+		sd.mu.Lock()
+		sd.rebalancing, sd.prevRing = false, nil
+		sd.mu.Unlock()
+	}()
+
+	sd.mu.RLock()
+	shards, ring := sd.shards, sd.ring
+	sd.mu.RUnlock()
+	for i, db := range shards {
+		if !sources[i] {
+			continue
+		}
+		var after []byte
+		for {
+			keys, done, err := sd.nextKeys(db, after)
+			if err != nil {
+				return err
+			}
+			for _, key := range keys {
+				if owner := ring.get(key); owner != i {
+					if err := sd.moveKey(key, db, shards[owner]); err != nil {
+						return err
+					}
+				}
+			}
+			if done {
+				break
+			}
+			after = keys[len(keys)-1]
+		}
+	}
+	return nil
+}
+
+// nextKeys 读取分片中排在after之后的最多rebalanceBatchSize个key，after为nil时从头开始，done表示已经读完
+// 迭代器只在读锁下使用，关闭分片时不会和迁移互相等待
+// 哈希索引不能只遍历key，一次读出所有的key
+func (sd *ShardedDB) nextKeys(db *DB, after []byte) ([][]byte, bool, error) {
+	sd.mu.RLock()
+	defer sd.mu.RUnlock()
+	if sd.closed {
+		return nil, false, ErrShardedDBClosed
+	}
+	if db.options.IndexType == index.Hash {
+		return db.ListKeys(), true, nil
+	}
+
+	it, err := db.KeysOnlyIterator(IteratorOptions{})
+	if err != nil {
+		return nil, false, err
+	}
+	defer it.Close()
+	if after == nil {
+		it.Rewind()
+	} else {
+		it.Seek(after)
+	}
+	var keys [][]byte
+	for ; it.Valid(); it.Next() {
+		if after != nil && bytes.Equal(it.Key(), after) {
+			continue
+		}
+		if len(keys) == rebalanceBatchSize {
+			return keys, false, nil
+		}
+		keys = append(keys, append([]byte(nil), it.Key()...))
+	}
+	return keys, true, nil
+}
+
+// moveKey 把一个key从source迁移到target
+func (sd *ShardedDB) moveKey(key []byte, source, target *DB) error {
+	sd.mu.RLock()
+	defer sd.mu.RUnlock()
+	if sd.closed {
+		return ErrShardedDBClosed
+	}
+	sd.moveMu.Lock()
+	defer sd.moveMu.Unlock()
+
+	//先写入目标分片再删除，目标分片中已经有这个key说明是迁移期间写入的新值或者中断的迁移留下的副本，以目标分片为准
+	value, err := source.Get(key)
+	if err == ErrKeyNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := target.Get(key); err == ErrKeyNotFound {
+		if err := target.Put(key, value); err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+	return source.Delete(key)
+}
+
+func (sd *ShardedDB) shardOf(key []byte) *DB {
+	return sd.shards[sd.ring.get(key)]
+}
+
+// NewIterator 初始化合并了所有分片的有序迭代器，迭代器关闭之前不能增加分片
+// 迁移期间同一个key可能同时在两个分片中，只会返回一次
+func (sd *ShardedDB) NewIterator(options IteratorOptions) *ShardedIterator {
+	sd.mu.RLock()
+	defer sd.mu.RUnlock()
+
+	iters := make([]*Iterator, len(sd.shards))
+	for i, db := range sd.shards {
+		iters[i] = db.NewIterator(options)
+	}
+	it := &ShardedIterator{
+		iters:   iters,
+		options: options,
+	}
+	it.pick()
+	return it
+}
+
+// ShardedIterator 多个分片的迭代器，每次取各个分片中最小（反向时最大）的key
+type ShardedIterator struct {
+	iters   []*Iterator
+	options IteratorOptions
+	current int //当前key所在的分片迭代器，-1表示遍历结束
+}
+
+func (it *ShardedIterator) Rewind() {
+	for _, iter := range it.iters {
+		iter.Rewind()
+	}
+	it.pick()
+}
+
+func (it *ShardedIterator) Seek(key []byte) {
+	for _, iter := range it.iters {
+		iter.Seek(key)
+	}
+	it.pick()
+}
+
+func (it *ShardedIterator) Next() {
+	if it.current < 0 {
+		return
+	}
+	key := it.iters[it.current].Key()
+	for _, iter := range it.iters {
+		if iter.Valid() && bytes.Equal(iter.Key(), key) {
+			iter.Next()
+		}
+	}
+	it.pick()
+}
+
+func (it *ShardedIterator) Valid() bool {
+	return it.current >= 0
+}
+
+func (it *ShardedIterator) Key() []byte {
+	return it.iters[it.current].Key()
+}
+
+func (it *ShardedIterator) Value() ([]byte, error) {
+	return it.iters[it.current].Value()
+}
+
+func (it *ShardedIterator) Close() {
+	for _, iter := range it.iters {
+		iter.Close()
+	}
+}
+
+// pick 找到各个分片迭代器当前位置中最小（反向时最大）的key
+func (it *ShardedIterator) pick() {
+	it.current = -1
+	for i, iter := range it.iters {
+		if !iter.Valid() {
+			continue
+		}
+		if it.current < 0 {
+			it.current = i
+			continue
+		}
+		cmp := bytes.Compare(iter.Key(), it.iters[it.current].Key())
+		if (!it.options.Reverse && cmp < 0) || (it.options.Reverse && cmp > 0) {
+			it.current = i
+		}
+	}
+}
+
+// hashRing 一致性哈希环
+type hashRing struct {
+	hashes []uint32       //排好序的虚拟节点哈希值
+	owners map[uint32]int //虚拟节点哈希值对应的分片下标
+}
+
+func newHashRing() *hashRing {
+	return &hashRing{
+		owners: make(map[uint32]int),
+	}
+}
+
+// add 把分片的虚拟节点加入到哈希环中
+func (r *hashRing) add(name string, shard int) {
+	for i := 0; i < shardVirtualNodes; i++ {
+		hash := crc32.ChecksumIEEE([]byte(name + "#" + strconv.Itoa(i)))
+		if _, ok := r.owners[hash]; ok {
+			continue
+		}
+		r.owners[hash] = shard
+		r.hashes = append(r.hashes, hash)
+	}
+	sort.Slice(r.hashes, func(i, j int) bool {
+		return r.hashes[i] < r.hashes[j]
+	})
+}
+
+func (r *hashRing) clone() *hashRing {
+	owners := make(map[uint32]int, len(r.owners))
+	for hash, shard := range r.owners {
+		owners[hash] = shard
+	}
+	return &hashRing{
+		hashes: append([]uint32(nil), r.hashes...),
+		owners: owners,
+	}
+}
+
+// get 顺时针找到第一个虚拟节点，返回对应的分片下标
+func (r *hashRing) get(key []byte) int {
+	return r.owner(crc32.ChecksumIEEE(key))
+}
+
+func (r *hashRing) owner(hash uint32) int {
+	idx := sort.Search(len(r.hashes), func(i int) bool {
+		return r.hashes[i] >= hash
+	})
+	if idx == len(r.hashes) {
+		idx = 0
+	}
+	return r.owners[r.hashes[idx]]
+}
+
+// changedShards 返回在prev中拥有、在当前哈希环中改变了归属的范围的分片
+// 当前哈希环只是在prev上增加了虚拟节点，每个虚拟节点结束的范围在prev中都属于同一个分片
+func (r *hashRing) changedShards(prev *hashRing) map[int]bool {
+	shards := make(map[int]bool)
+	if len(prev.hashes) == 0 {
+		return shards
+	}
+	for _, hash := range r.hashes {
+		if prevOwner := prev.owner(hash); prevOwner != r.owners[hash] {
+			shards[prevOwner] = true
+		}
+	}
+	return shards
+}
@@ -0,0 +1,233 @@
+package JDawDB
+
+import (
+	"bytes"
+	"github.com/GrandeLai/JDawDB/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"sync"
+	"testing"
+)
+
+func newShardDirs(n int) []string {
+	var dirs []string
+	for i := 0; i < n; i++ {
+		dir, _ := os.MkdirTemp("", "JDawDB-shard")
+		dirs = append(dirs, dir)
+	}
+	return dirs
+}
+
+func destroyShardedDB(sd *ShardedDB) {
+	if sd != nil {
+		_ = sd.Close()
+		for _, dir := range sd.dirs {
+			_ = os.RemoveAll(dir)
+		}
+	}
+}
+
+func TestOpenSharded(t *testing.T) {
+	_, err := OpenSharded(DefaultOptions, nil)
+	assert.Equal(t, ErrNoShards, err)
+
+	sd, err := OpenSharded(DefaultOptions, newShardDirs(3))
+	defer destroyShardedDB(sd)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, sd.ShardNum())
+}
+
+func TestShardedDB_PutGetDelete(t *testing.T) {
+	sd, err := OpenSharded(DefaultOptions, newShardDirs(3))
+	defer destroyShardedDB(sd)
+	assert.Nil(t, err)
+
+	for i := 0; i < 1000; i++ {
+		err := sd.Put(utils.GetTestKey(i), utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	for i := 0; i < 1000; i++ {
+		val, err := sd.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, utils.GetTestKey(i), val)
+	}
+	// 每个分片都应该分到数据
+	for _, db := range sd.shards {
+		assert.True(t, len(db.ListKeys()) > 0)
+	}
+
+	err = sd.Delete(utils.GetTestKey(10))
+	assert.Nil(t, err)
+	_, err = sd.Get(utils.GetTestKey(10))
+	assert.Equal(t, ErrKeyNotFound, err)
+}
+
+func TestShardedDB_Iterator(t *testing.T) {
+	sd, err := OpenSharded(DefaultOptions, newShardDirs(3))
+	defer destroyShardedDB(sd)
+	assert.Nil(t, err)
+
+	for i := 0; i < 100; i++ {
+		err := sd.Put(utils.GetTestKey(i), utils.RandomValue(10))
+		assert.Nil(t, err)
+	}
+	err = sd.Put([]byte("prefix-1"), []byte("a"))
+	assert.Nil(t, err)
+	err = sd.Put([]byte("prefix-2"), []byte("b"))
+	assert.Nil(t, err)
+
+	it := sd.NewIterator(DefaultIteratorOptions)
+	var keys [][]byte
+	for it.Rewind(); it.Valid(); it.Next() {
+		keys = append(keys, it.Key())
+	}
+	it.Close()
+	assert.Equal(t, 102, len(keys))
+	for i := 1; i < len(keys); i++ {
+		assert.True(t, bytes.Compare(keys[i-1], keys[i]) < 0)
+	}
+
+	// 反向遍历
+	opts := DefaultIteratorOptions
+	opts.Reverse = true
+	it2 := sd.NewIterator(opts)
+	it2.Rewind()
+	assert.Equal(t, []byte("prefix-2"), it2.Key())
+	it2.Seek(utils.GetTestKey(50))
+	assert.Equal(t, utils.GetTestKey(50), it2.Key())
+	it2.Close()
+
+	// 前缀遍历
+	opts = DefaultIteratorOptions
+	opts.Prefix = []byte("prefix")
+	it3 := sd.NewIterator(opts)
+	var count int
+	for it3.Rewind(); it3.Valid(); it3.Next() {
+		val, err := it3.Value()
+		assert.Nil(t, err)
+		assert.NotNil(t, val)
+		count++
+	}
+	it3.Close()
+	assert.Equal(t, 2, count)
+}
+
+func TestShardedDB_Merge(t *testing.T) {
+	opts := DefaultOptions
+	opts.DataFileMergeRatio = 0
+	sd, err := OpenSharded(opts, newShardDirs(2))
+	defer destroyShardedDB(sd)
+	assert.Nil(t, err)
+
+	for i := 0; i < 1000; i++ {
+		err := sd.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+	for i := 0; i < 500; i++ {
+		err := sd.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	err = sd.Merge()
+	assert.Nil(t, err)
+
+	val, err := sd.Get(utils.GetTestKey(600))
+	assert.Nil(t, err)
+	assert.NotNil(t, val)
+}
+
+func TestShardedDB_AddShard(t *testing.T) {
+	dirs := newShardDirs(4)
+	sd, err := OpenSharded(DefaultOptions, dirs[:3])
+	assert.Nil(t, err)
+
+	for i := 0; i < 1000; i++ {
+		err := sd.Put(utils.GetTestKey(i), utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	before := make([]int, 3)
+	for i, db := range sd.shards {
+		before[i] = len(db.ListKeys())
+	}
+
+	err = sd.AddShard(dirs[3])
+	assert.Nil(t, err)
+	assert.Equal(t, 4, sd.ShardNum())
+
+	// 新分片分到了数据，原来的分片只会迁出数据
+	assert.True(t, len(sd.shards[3].ListKeys()) > 0)
+	for i := 0; i < 3; i++ {
+		assert.True(t, len(sd.shards[i].ListKeys()) <= before[i])
+	}
+	for i, db := range sd.shards {
+		for _, key := range db.ListKeys() {
+			assert.Equal(t, i, sd.ring.get(key))
+		}
+	}
+
+	// 用新的目录列表重新打开
+	err = sd.Close()
+	assert.Nil(t, err)
+	sd2, err := OpenSharded(DefaultOptions, dirs)
+	defer destroyShardedDB(sd2)
+	assert.Nil(t, err)
+	for i := 0; i < 1000; i++ {
+		val, err := sd2.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, utils.GetTestKey(i), val)
+	}
+	err = sd2.Rebalance()
+	assert.Nil(t, err)
+}
+
+func TestShardedDB_AddShardConcurrent(t *testing.T) {
+	dirs := newShardDirs(4)
+	sd, err := OpenSharded(DefaultOptions, dirs[:3])
+	defer destroyShardedDB(sd)
+	assert.Nil(t, err)
+
+	for i := 0; i < 2000; i++ {
+		err := sd.Put(utils.GetTestKey(i), []byte("old"))
+		assert.Nil(t, err)
+	}
+
+	// 只有改变了归属的范围所在的分片需要迁移
+	ring := sd.ring.clone()
+	ring.add(dirs[3], 3)
+	changed := ring.changedShards(sd.ring)
+	assert.True(t, len(changed) > 0)
+	assert.False(t, changed[3])
+
+	// 迁移期间的读写不会被阻塞，也不会被迁移覆盖
+	wg := new(sync.WaitGroup)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 2000; i++ {
+			if i%2 == 0 {
+				assert.Nil(t, sd.Put(utils.GetTestKey(i), []byte("new")))
+			} else {
+				assert.Nil(t, sd.Delete(utils.GetTestKey(i)))
+			}
+			_, err := sd.Get(utils.GetTestKey(i + 1))
+			assert.True(t, err == nil || err == ErrKeyNotFound)
+		}
+	}()
+	err = sd.AddShard(dirs[3])
+	assert.Nil(t, err)
+	wg.Wait()
+
+	for i := 0; i < 2000; i++ {
+		val, err := sd.Get(utils.GetTestKey(i))
+		if i%2 == 0 {
+			assert.Nil(t, err)
+			assert.Equal(t, []byte("new"), val)
+		} else {
+			assert.Equal(t, ErrKeyNotFound, err)
+		}
+	}
+	for i, db := range sd.shards {
+		for _, key := range db.ListKeys() {
+			assert.Equal(t, i, sd.ring.get(key))
+		}
+	}
+}
@@ -1,34 +1,252 @@
 package main
 
 import (
+	"context"
+	"encoding/base64"
+	"errors"
 	"fmt"
 	"github.com/GrandeLai/JDawDB"
 	"github.com/GrandeLai/JDawDB/structure"
 	"github.com/GrandeLai/JDawDB/utils"
 	"github.com/tidwall/redcon"
+	"math"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
 func newWrongNumberOfArgsError(cmd string) error {
 	return fmt.Errorf("ERR wrong number of arguments for '%s' command", cmd)
 }
 
+func newInvalidExpireError(cmd string) error {
+	return fmt.Errorf("ERR invalid expire time in '%s' command", cmd)
+}
+
+var (
+	errSyntax           = errors.New("ERR syntax error")
+	errNotInteger       = errors.New("ERR value is not an integer or out of range")
+	errNotFloat         = errors.New("ERR value is not a valid float")
+	errCursor           = errors.New("ERR invalid cursor")
+	errTimeout          = errors.New("ERR timeout is not a float or out of range")
+	errNegativeTimeout  = errors.New("ERR timeout is negative")
+	errMinMaxNotFloat   = errors.New("ERR min or max is not a float")
+	errLexRange         = errors.New("ERR min or max not valid string range item")
+	errBitFieldOverflow = errors.New("ERR Invalid OVERFLOW type specified")
+)
+
+// arrayReply 将结果转换为数组回复，nil元素回复为Null而不是空字符串
+func arrayReply(values [][]byte) []interface{} {
+	res := make([]interface{}, len(values))
+	for i, value := range values {
+		if value != nil {
+			res[i] = value
+		}
+	}
+	return res
+}
+
+// bulkReply 单个值的回复，值为nil时回复Null
+func bulkReply(value []byte, err error) (interface{}, error) {
+	if err != nil || value == nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// parseRange 解析start和stop两个整数参数
+func parseRange(startArg, stopArg []byte) (int64, int64, error) {
+	start, err := strconv.ParseInt(string(startArg), 10, 64)
+	if err != nil {
+		return 0, 0, errNotInteger
+	}
+	stop, err := strconv.ParseInt(string(stopArg), 10, 64)
+	if err != nil {
+		return 0, 0, errNotInteger
+	}
+	return start, stop, nil
+}
+
+// scanReply 扫描命令的回复，第一个元素是下一次的游标，第二个元素是本次的结果
+func scanReply(cursor []byte, values [][]byte) []interface{} {
+	return []interface{}{encodeCursor(cursor), arrayReply(values)}
+}
+
+// encodeCursor 遍历完成时游标为"0"，否则为带前缀的base64编码，编码结果不会是"0"
+func encodeCursor(cursor []byte) string {
+	if cursor == nil {
+		return "0"
+	}
+	return "c" + base64.RawURLEncoding.EncodeToString(cursor)
+}
+
+// parseCursor 解析encodeCursor编码的游标，"0"表示从头开始
+func parseCursor(arg []byte) ([]byte, error) {
+	if string(arg) == "0" {
+		return nil, nil
+	}
+	if len(arg) == 0 || arg[0] != 'c' {
+		return nil, errCursor
+	}
+	cursor, err := base64.RawURLEncoding.DecodeString(string(arg[1:]))
+	if err != nil {
+		return nil, errCursor
+	}
+	return cursor, nil
+}
+
+// parseScanArgs 解析 cursor [MATCH pattern] [COUNT count]
+func parseScanArgs(args [][]byte) (cursor []byte, match string, count int, err error) {
+	if cursor, err = parseCursor(args[0]); err != nil {
+		return nil, "", 0, err
+	}
+	if match, count, err = parseScanOptions(args[1:]); err != nil {
+		return nil, "", 0, err
+	}
+	return cursor, match, count, nil
+}
+
+// parseScanOptions 解析 [MATCH pattern] [COUNT count]
+func parseScanOptions(args [][]byte) (match string, count int, err error) {
+	for i := 0; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			return "", 0, errSyntax
+		}
+		switch strings.ToLower(string(args[i])) {
+		case "match":
+			match = string(args[i+1])
+		case "count":
+			if count, err = strconv.Atoi(string(args[i+1])); err != nil {
+				return "", 0, errNotInteger
+			}
+			if count < 1 {
+				return "", 0, errSyntax
+			}
+		default:
+			return "", 0, errSyntax
+		}
+	}
+	return match, count, nil
+}
+
 // 命令对应的处理函数
 type cmdHandler func(cli *DBClient, args [][]byte) (interface{}, error)
 
 // 每个命令对应一个处理函数
 var supportedCommands = map[string]cmdHandler{
-	"set":   set,
-	"get":   get,
-	"hset":  hset,
-	"sadd":  sadd,
-	"lpush": lpush,
-	"zadd":  zadd,
+	"del":              del,
+	"exists":           exists,
+	"type":             typeCmd,
+	"expire":           expire,
+	"pexpire":          pexpire,
+	"expireat":         expireat,
+	"pexpireat":        pexpireat,
+	"ttl":              ttl,
+	"pttl":             pttl,
+	"persist":          persist,
+	"rename":           rename,
+	"randomkey":        randomkey,
+	"keys":             keys,
+	"scan":             scan,
+	"set":              set,
+	"get":              get,
+	"setnx":            setnx,
+	"getset":           getset,
+	"mset":             mset,
+	"mget":             mget,
+	"incr":             incr,
+	"decr":             decr,
+	"incrby":           incrby,
+	"decrby":           decrby,
+	"incrbyfloat":      incrbyfloat,
+	"append":           appendCmd,
+	"getrange":         getrange,
+	"setrange":         setrange,
+	"strlen":           strlen,
+	"hset":             hset,
+	"hget":             hget,
+	"hdel":             hdel,
+	"hexists":          hexists,
+	"hlen":             hlen,
+	"hgetall":          hgetall,
+	"hkeys":            hkeys,
+	"hvals":            hvals,
+	"hmset":            hmset,
+	"hmget":            hmget,
+	"hsetnx":           hsetnx,
+	"hincrby":          hincrby,
+	"hincrbyfloat":     hincrbyfloat,
+	"hscan":            hscan,
+	"sadd":             sadd,
+	"srem":             srem,
+	"sismember":        sismember,
+	"smembers":         smembers,
+	"scard":            scard,
+	"spop":             spop,
+	"srandmember":      srandmember,
+	"smove":            smove,
+	"sinter":           sinter,
+	"sunion":           sunion,
+	"sdiff":            sdiff,
+	"sinterstore":      sinterstore,
+	"sunionstore":      sunionstore,
+	"sdiffstore":       sdiffstore,
+	"sscan":            sscan,
+	"lpush":            lpush,
+	"rpush":            rpush,
+	"lpushx":           lpushx,
+	"rpushx":           rpushx,
+	"lpop":             lpop,
+	"rpop":             rpop,
+	"llen":             llen,
+	"lindex":           lindex,
+	"lrange":           lrange,
+	"lset":             lset,
+	"ltrim":            ltrim,
+	"linsert":          linsert,
+	"lrem":             lrem,
+	"lmove":            lmove,
+	"blpop":            blpop,
+	"brpop":            brpop,
+	"blmove":           blmove,
+	"zadd":             zadd,
+	"zscore":           zscore,
+	"zcard":            zcard,
+	"zrem":             zrem,
+	"zincrby":          zincrby,
+	"zrank":            zrank,
+	"zrevrank":         zrevrank,
+	"zrange":           zrange,
+	"zrevrange":        zrevrange,
+	"zrangebyscore":    zrangebyscore,
+	"zrevrangebyscore": zrevrangebyscore,
+	"zrangebylex":      zrangebylex,
+	"zcount":           zcount,
+	"zpopmin":          zpopmin,
+	"zpopmax":          zpopmax,
+	"zremrangebyscore": zremrangebyscore,
+	"setbit":           setbit,
+	"getbit":           getbit,
+	"bitcount":         bitcount,
+	"bitpos":           bitpos,
+	"bitop":            bitop,
+	"bitfield":         bitfield,
 }
 
 type DBClient struct {
 	db     *structure.DataStructure
 	server *DBServer
+	//连接分离之后才会设置，连接关闭时取消
+	ctx      context.Context
+	detached bool
+}
+
+// blockingCommands 会阻塞的命令，执行之前先把连接分离出来，这样客户端断开时可以停止等待
+var blockingCommands = map[string]struct{}{
+	"blpop":  {},
+	"brpop":  {},
+	"blmove": {},
 }
 
 func execClientCommand(conn redcon.Conn, cmd redcon.Command) {
@@ -42,6 +260,10 @@ func execClientCommand(conn redcon.Conn, cmd redcon.Command) {
 
 	//取出context中存放的信息
 	client, _ := conn.Context().(*DBClient)
+	if _, ok := blockingCommands[command]; ok && !client.detached {
+		client.detach(conn, cmd)
+		return
+	}
 
 	switch command {
 	case "quit":
@@ -62,38 +284,155 @@ func execClientCommand(conn redcon.Conn, cmd redcon.Command) {
 	}
 }
 
-func set(cli *DBClient, args [][]byte) (interface{}, error) {
-	if len(args) != 2 {
-		return nil, newWrongNumberOfArgsError("set")
+// detach 把连接从redcon的事件循环中分离出来，由一个goroutine一直读取命令，连接关闭时取消ctx
+// 阻塞的命令在ctx取消后停止等待，之后的写入不会把element弹给已经断开的连接
+func (cli *DBClient) detach(conn redcon.Conn, cmd redcon.Command) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cli.ctx = ctx
+	cli.detached = true
+	dconn := conn.Detach()
+
+	//命令的参数引用的是读缓冲区，继续读取之前需要先复制
+	var (
+		mu      sync.Mutex
+		cond    = sync.NewCond(&mu)
+		pending = []redcon.Command{copyCommand(cmd)}
+		eof     bool
+	)
+	go func() {
+		defer cancel()
+		for {
+			next, err := dconn.ReadCommand()
+			mu.Lock()
+			if err != nil {
+				eof = true
+			} else {
+				pending = append(pending, copyCommand(next))
+			}
+			cond.Signal()
+			mu.Unlock()
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	go func() {
+		for {
+			mu.Lock()
+			for len(pending) == 0 && !eof {
+				cond.Wait()
+			}
+			if len(pending) == 0 {
+				mu.Unlock()
+				break
+			}
+			next := pending[0]
+			pending = pending[1:]
+			mu.Unlock()
+
+			execClientCommand(dconn, next)
+			if dconn.Flush() != nil {
+				break
+			}
+		}
+		_ = dconn.Close()
+		cli.server.closeAll()
+	}()
+}
+
+func copyCommand(cmd redcon.Command) redcon.Command {
+	raw := append([]byte(nil), cmd.Raw...)
+	args := make([][]byte, len(cmd.Args))
+	for i, arg := range cmd.Args {
+		args[i] = append([]byte(nil), arg...)
 	}
+	return redcon.Command{Raw: raw, Args: args}
+}
 
-	key, value := args[0], args[1]
-	if err := cli.db.Set(key, 0, value); err != nil {
+func del(cli *DBClient, args [][]byte) (interface{}, error) {
+	if len(args) < 1 {
+		return nil, newWrongNumberOfArgsError("del")
+	}
+
+	var deleted = 0
+	for _, key := range args {
+		n, err := cli.db.Exists(key)
+		if err != nil {
+			return nil, err
+		}
+		if n == 0 {
+			continue
+		}
+		if err = cli.db.Del(key); err != nil {
+			return nil, err
+		}
+		deleted++
+	}
+	return redcon.SimpleInt(deleted), nil
+}
+
+func exists(cli *DBClient, args [][]byte) (interface{}, error) {
+	if len(args) < 1 {
+		return nil, newWrongNumberOfArgsError("exists")
+	}
+
+	res, err := cli.db.Exists(args...)
+	if err != nil {
 		return nil, err
 	}
-	return redcon.SimpleString("OK"), nil
+	return redcon.SimpleInt(res), nil
 }
 
-func get(cli *DBClient, args [][]byte) (interface{}, error) {
+func typeCmd(cli *DBClient, args [][]byte) (interface{}, error) {
 	if len(args) != 1 {
-		return nil, newWrongNumberOfArgsError("get")
+		return nil, newWrongNumberOfArgsError("type")
 	}
 
-	value, err := cli.db.Get(args[0])
+	dataType, err := cli.db.Type(args[0])
+	if err == JDawDB.ErrKeyNotFound {
+		return redcon.SimpleString("none"), nil
+	}
 	if err != nil {
 		return nil, err
 	}
-	return value, nil
+	return redcon.SimpleString(structure.TypeName(dataType)), nil
 }
 
-func hset(cli *DBClient, args [][]byte) (interface{}, error) {
-	if len(args) != 3 {
-		return nil, newWrongNumberOfArgsError("hset")
+func expire(cli *DBClient, args [][]byte) (interface{}, error) {
+	return expireCommand(cli, args, "expire", func(n int64) time.Time {
+		return time.Now().Add(time.Duration(n) * time.Second)
+	})
+}
+
+func pexpire(cli *DBClient, args [][]byte) (interface{}, error) {
+	return expireCommand(cli, args, "pexpire", func(n int64) time.Time {
+		return time.Now().Add(time.Duration(n) * time.Millisecond)
+	})
+}
+
+func expireat(cli *DBClient, args [][]byte) (interface{}, error) {
+	return expireCommand(cli, args, "expireat", func(n int64) time.Time {
+		return time.Unix(n, 0)
+	})
+}
+
+func pexpireat(cli *DBClient, args [][]byte) (interface{}, error) {
+	return expireCommand(cli, args, "pexpireat", time.UnixMilli)
+}
+
+// expireCommand key n，toTime将n转换为过期的时间点
+func expireCommand(cli *DBClient, args [][]byte, cmd string, toTime func(n int64) time.Time) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, newWrongNumberOfArgsError(cmd)
 	}
 
+	n, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return nil, errNotInteger
+	}
 	var ok = 0
-	key, field, value := args[0], args[1], args[2]
-	res, err := cli.db.HSet(key, field, value)
+	res, err := cli.db.ExpireAt(args[0], toTime(n))
 	if err != nil {
 		return nil, err
 	}
@@ -103,14 +442,38 @@ func hset(cli *DBClient, args [][]byte) (interface{}, error) {
 	return redcon.SimpleInt(ok), nil
 }
 
-func sadd(cli *DBClient, args [][]byte) (interface{}, error) {
-	if len(args) != 2 {
-		return nil, newWrongNumberOfArgsError("sadd")
+func ttl(cli *DBClient, args [][]byte) (interface{}, error) {
+	return ttlCommand(cli, args, "ttl", time.Second)
+}
+
+func pttl(cli *DBClient, args [][]byte) (interface{}, error) {
+	return ttlCommand(cli, args, "pttl", time.Millisecond)
+}
+
+// ttlCommand 按照unit回复剩余的存活时间，-2表示key不存在，-1表示没有过期时间
+func ttlCommand(cli *DBClient, args [][]byte, cmd string, unit time.Duration) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, newWrongNumberOfArgsError(cmd)
+	}
+
+	res, err := cli.db.TTL(args[0])
+	if err != nil {
+		return nil, err
+	}
+	if res == structure.KeyNotExist || res == structure.KeyNoExpire {
+		return redcon.SimpleInt(res), nil
+	}
+	//四舍五入
+	return redcon.SimpleInt((res + unit/2) / unit), nil
+}
+
+func persist(cli *DBClient, args [][]byte) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, newWrongNumberOfArgsError("persist")
 	}
 
 	var ok = 0
-	key, member := args[0], args[1]
-	res, err := cli.db.SAdd(key, member)
+	res, err := cli.db.Persist(args[0])
 	if err != nil {
 		return nil, err
 	}
@@ -120,27 +483,139 @@ func sadd(cli *DBClient, args [][]byte) (interface{}, error) {
 	return redcon.SimpleInt(ok), nil
 }
 
-func lpush(cli *DBClient, args [][]byte) (interface{}, error) {
+func rename(cli *DBClient, args [][]byte) (interface{}, error) {
 	if len(args) != 2 {
-		return nil, newWrongNumberOfArgsError("lpush")
+		return nil, newWrongNumberOfArgsError("rename")
+	}
+
+	if err := cli.db.Rename(args[0], args[1]); err != nil {
+		return nil, err
+	}
+	return redcon.SimpleString("OK"), nil
+}
+
+func randomkey(cli *DBClient, args [][]byte) (interface{}, error) {
+	if len(args) != 0 {
+		return nil, newWrongNumberOfArgsError("randomkey")
+	}
+
+	return bulkReply(cli.db.RandomKey())
+}
+
+func keys(cli *DBClient, args [][]byte) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, newWrongNumberOfArgsError("keys")
+	}
+
+	res, err := cli.db.Keys(string(args[0]))
+	if err != nil {
+		return nil, err
+	}
+	return arrayReply(res), nil
+}
+
+func scan(cli *DBClient, args [][]byte) (interface{}, error) {
+	if len(args) < 1 {
+		return nil, newWrongNumberOfArgsError("scan")
+	}
+
+	//先取出TYPE选项，剩下的和其它扫描命令相同
+	var typeName string
+	scanArgs := [][]byte{args[0]}
+	for i := 1; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			return nil, errSyntax
+		}
+		if strings.ToLower(string(args[i])) == "type" {
+			typeName = strings.ToLower(string(args[i+1]))
+			continue
+		}
+		scanArgs = append(scanArgs, args[i], args[i+1])
+	}
+	cursor, match, count, err := parseScanArgs(scanArgs)
+	if err != nil {
+		return nil, err
+	}
+	next, res, err := cli.db.Scan(cursor, match, count, typeName)
+	if err != nil {
+		return nil, err
+	}
+	return scanReply(next, res), nil
+}
+
+func set(cli *DBClient, args [][]byte) (interface{}, error) {
+	if len(args) < 2 {
+		return nil, newWrongNumberOfArgsError("set")
+	}
+
+	//解析 [NX|XX] [GET] [EX seconds|PX milliseconds|KEEPTTL]
+	var opts structure.SetOptions
+	var hasExpire bool
+	for i := 2; i < len(args); i++ {
+		switch option := strings.ToLower(string(args[i])); option {
+		case "nx":
+			opts.NX = true
+		case "xx":
+			opts.XX = true
+		case "get":
+			opts.Get = true
+		case "keepttl":
+			opts.KeepTTL = true
+		case "ex", "px":
+			if hasExpire || i+1 >= len(args) {
+				return nil, errSyntax
+			}
+			n, err := strconv.ParseInt(string(args[i+1]), 10, 64)
+			if err != nil {
+				return nil, errNotInteger
+			}
+			if n <= 0 {
+				return nil, newInvalidExpireError("set")
+			}
+			unit := time.Second
+			if option == "px" {
+				unit = time.Millisecond
+			}
+			opts.TTL = time.Duration(n) * unit
+			hasExpire = true
+			i++
+		default:
+			return nil, errSyntax
+		}
+	}
+	if (opts.NX && opts.XX) || (opts.KeepTTL && hasExpire) {
+		return nil, errSyntax
 	}
 
 	key, value := args[0], args[1]
-	res, err := cli.db.LPush(key, value)
+	old, ok, err := cli.db.SetWithOptions(key, value, opts)
 	if err != nil {
 		return nil, err
 	}
-	return redcon.SimpleInt(res), nil
+	if opts.Get {
+		return bulkReply(old, nil)
+	}
+	if !ok {
+		return nil, nil
+	}
+	return redcon.SimpleString("OK"), nil
 }
 
-func zadd(cli *DBClient, args [][]byte) (interface{}, error) {
-	if len(args) != 3 {
-		return nil, newWrongNumberOfArgsError("zadd")
+func get(cli *DBClient, args [][]byte) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, newWrongNumberOfArgsError("get")
+	}
+
+	return bulkReply(cli.db.Get(args[0]))
+}
+
+func setnx(cli *DBClient, args [][]byte) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, newWrongNumberOfArgsError("setnx")
 	}
 
 	var ok = 0
-	key, score, member := args[0], args[1], args[2]
-	res, err := cli.db.ZAdd(key, utils.FloatFromBytes(score), member)
+	res, err := cli.db.SetNX(args[0], args[1])
 	if err != nil {
 		return nil, err
 	}
@@ -149,3 +624,1493 @@ func zadd(cli *DBClient, args [][]byte) (interface{}, error) {
 	}
 	return redcon.SimpleInt(ok), nil
 }
+
+func getset(cli *DBClient, args [][]byte) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, newWrongNumberOfArgsError("getset")
+	}
+
+	return bulkReply(cli.db.GetSet(args[0], args[1]))
+}
+
+func mset(cli *DBClient, args [][]byte) (interface{}, error) {
+	if len(args) < 2 || len(args)%2 != 0 {
+		return nil, newWrongNumberOfArgsError("mset")
+	}
+
+	if err := cli.db.MSet(args...); err != nil {
+		return nil, err
+	}
+	return redcon.SimpleString("OK"), nil
+}
+
+func mget(cli *DBClient, args [][]byte) (interface{}, error) {
+	if len(args) < 1 {
+		return nil, newWrongNumberOfArgsError("mget")
+	}
+
+	res, err := cli.db.MGet(args...)
+	if err != nil {
+		return nil, err
+	}
+	return arrayReply(res), nil
+}
+
+func incr(cli *DBClient, args [][]byte) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, newWrongNumberOfArgsError("incr")
+	}
+
+	return intReply(cli.db.Incr(args[0]))
+}
+
+func decr(cli *DBClient, args [][]byte) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, newWrongNumberOfArgsError("decr")
+	}
+
+	return intReply(cli.db.Decr(args[0]))
+}
+
+func incrby(cli *DBClient, args [][]byte) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, newWrongNumberOfArgsError("incrby")
+	}
+
+	n, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return nil, errNotInteger
+	}
+	return intReply(cli.db.IncrBy(args[0], n))
+}
+
+func decrby(cli *DBClient, args [][]byte) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, newWrongNumberOfArgsError("decrby")
+	}
+
+	n, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return nil, errNotInteger
+	}
+	return intReply(cli.db.DecrBy(args[0], n))
+}
+
+// intReply 整数结果的回复
+func intReply(n int64, err error) (interface{}, error) {
+	if err != nil {
+		return nil, err
+	}
+	return redcon.SimpleInt(n), nil
+}
+
+func incrbyfloat(cli *DBClient, args [][]byte) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, newWrongNumberOfArgsError("incrbyfloat")
+	}
+
+	incr, err := parseFloat(args[1])
+	if err != nil {
+		return nil, err
+	}
+	res, err := cli.db.IncrByFloat(args[0], incr)
+	if err != nil {
+		return nil, err
+	}
+	return utils.Float64ToBytes(res), nil
+}
+
+func appendCmd(cli *DBClient, args [][]byte) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, newWrongNumberOfArgsError("append")
+	}
+
+	res, err := cli.db.Append(args[0], args[1])
+	if err != nil {
+		return nil, err
+	}
+	return redcon.SimpleInt(res), nil
+}
+
+func getrange(cli *DBClient, args [][]byte) (interface{}, error) {
+	if len(args) != 3 {
+		return nil, newWrongNumberOfArgsError("getrange")
+	}
+
+	start, end, err := parseRange(args[1], args[2])
+	if err != nil {
+		return nil, err
+	}
+	res, err := cli.db.GetRange(args[0], start, end)
+	if err != nil {
+		return nil, err
+	}
+	//不存在时回复空字符串
+	if res == nil {
+		res = []byte{}
+	}
+	return res, nil
+}
+
+func setrange(cli *DBClient, args [][]byte) (interface{}, error) {
+	if len(args) != 3 {
+		return nil, newWrongNumberOfArgsError("setrange")
+	}
+
+	offset, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return nil, errNotInteger
+	}
+	res, err := cli.db.SetRange(args[0], offset, args[2])
+	if err != nil {
+		return nil, err
+	}
+	return redcon.SimpleInt(res), nil
+}
+
+func strlen(cli *DBClient, args [][]byte) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, newWrongNumberOfArgsError("strlen")
+	}
+
+	res, err := cli.db.StrLen(args[0])
+	if err != nil {
+		return nil, err
+	}
+	return redcon.SimpleInt(res), nil
+}
+
+func hset(cli *DBClient, args [][]byte) (interface{}, error) {
+	if len(args) != 3 {
+		return nil, newWrongNumberOfArgsError("hset")
+	}
+
+	var ok = 0
+	key, field, value := args[0], args[1], args[2]
+	res, err := cli.db.HSet(key, field, value)
+	if err != nil {
+		return nil, err
+	}
+	if res {
+		ok = 1
+	}
+	return redcon.SimpleInt(ok), nil
+}
+
+func hget(cli *DBClient, args [][]byte) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, newWrongNumberOfArgsError("hget")
+	}
+
+	return bulkReply(cli.db.HGet(args[0], args[1]))
+}
+
+func hdel(cli *DBClient, args [][]byte) (interface{}, error) {
+	if len(args) < 2 {
+		return nil, newWrongNumberOfArgsError("hdel")
+	}
+
+	var deleted = 0
+	key := args[0]
+	for _, field := range args[1:] {
+		res, err := cli.db.HDel(key, field)
+		if err != nil {
+			return nil, err
+		}
+		if res {
+			deleted++
+		}
+	}
+	return redcon.SimpleInt(deleted), nil
+}
+
+func hexists(cli *DBClient, args [][]byte) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, newWrongNumberOfArgsError("hexists")
+	}
+
+	var ok = 0
+	res, err := cli.db.HExists(args[0], args[1])
+	if err != nil {
+		return nil, err
+	}
+	if res {
+		ok = 1
+	}
+	return redcon.SimpleInt(ok), nil
+}
+
+func hlen(cli *DBClient, args [][]byte) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, newWrongNumberOfArgsError("hlen")
+	}
+
+	res, err := cli.db.HLen(args[0])
+	if err != nil {
+		return nil, err
+	}
+	return redcon.SimpleInt(res), nil
+}
+
+func hgetall(cli *DBClient, args [][]byte) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, newWrongNumberOfArgsError("hgetall")
+	}
+
+	res, err := cli.db.HGetAll(args[0])
+	if err != nil {
+		return nil, err
+	}
+	return arrayReply(res), nil
+}
+
+func hkeys(cli *DBClient, args [][]byte) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, newWrongNumberOfArgsError("hkeys")
+	}
+
+	res, err := cli.db.HKeys(args[0])
+	if err != nil {
+		return nil, err
+	}
+	return arrayReply(res), nil
+}
+
+func hvals(cli *DBClient, args [][]byte) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, newWrongNumberOfArgsError("hvals")
+	}
+
+	res, err := cli.db.HVals(args[0])
+	if err != nil {
+		return nil, err
+	}
+	return arrayReply(res), nil
+}
+
+func hmset(cli *DBClient, args [][]byte) (interface{}, error) {
+	if len(args) < 3 || len(args)%2 != 1 {
+		return nil, newWrongNumberOfArgsError("hmset")
+	}
+
+	if _, err := cli.db.HMSet(args[0], args[1:]...); err != nil {
+		return nil, err
+	}
+	return redcon.SimpleString("OK"), nil
+}
+
+func hmget(cli *DBClient, args [][]byte) (interface{}, error) {
+	if len(args) < 2 {
+		return nil, newWrongNumberOfArgsError("hmget")
+	}
+
+	res, err := cli.db.HMGet(args[0], args[1:]...)
+	if err != nil {
+		return nil, err
+	}
+	return arrayReply(res), nil
+}
+
+func hsetnx(cli *DBClient, args [][]byte) (interface{}, error) {
+	if len(args) != 3 {
+		return nil, newWrongNumberOfArgsError("hsetnx")
+	}
+
+	var ok = 0
+	res, err := cli.db.HSetNX(args[0], args[1], args[2])
+	if err != nil {
+		return nil, err
+	}
+	if res {
+		ok = 1
+	}
+	return redcon.SimpleInt(ok), nil
+}
+
+func hincrby(cli *DBClient, args [][]byte) (interface{}, error) {
+	if len(args) != 3 {
+		return nil, newWrongNumberOfArgsError("hincrby")
+	}
+
+	incr, err := strconv.ParseInt(string(args[2]), 10, 64)
+	if err != nil {
+		return nil, errNotInteger
+	}
+	res, err := cli.db.HIncrBy(args[0], args[1], incr)
+	if err != nil {
+		return nil, err
+	}
+	return redcon.SimpleInt(res), nil
+}
+
+func hincrbyfloat(cli *DBClient, args [][]byte) (interface{}, error) {
+	if len(args) != 3 {
+		return nil, newWrongNumberOfArgsError("hincrbyfloat")
+	}
+
+	incr, err := strconv.ParseFloat(string(args[2]), 64)
+	if err != nil {
+		return nil, errNotFloat
+	}
+	res, err := cli.db.HIncrByFloat(args[0], args[1], incr)
+	if err != nil {
+		return nil, err
+	}
+	return utils.Float64ToBytes(res), nil
+}
+
+func hscan(cli *DBClient, args [][]byte) (interface{}, error) {
+	if len(args) < 2 {
+		return nil, newWrongNumberOfArgsError("hscan")
+	}
+
+	cursor, match, count, err := parseScanArgs(args[1:])
+	if err != nil {
+		return nil, err
+	}
+	next, res, err := cli.db.HScan(args[0], cursor, match, count)
+	if err != nil {
+		return nil, err
+	}
+	return scanReply(next, res), nil
+}
+
+func sadd(cli *DBClient, args [][]byte) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, newWrongNumberOfArgsError("sadd")
+	}
+
+	var ok = 0
+	key, member := args[0], args[1]
+	res, err := cli.db.SAdd(key, member)
+	if err != nil {
+		return nil, err
+	}
+	if res {
+		ok = 1
+	}
+	return redcon.SimpleInt(ok), nil
+}
+
+func srem(cli *DBClient, args [][]byte) (interface{}, error) {
+	if len(args) < 2 {
+		return nil, newWrongNumberOfArgsError("srem")
+	}
+
+	var removed = 0
+	key := args[0]
+	for _, member := range args[1:] {
+		res, err := cli.db.SRem(key, member)
+		if err != nil {
+			return nil, err
+		}
+		if res {
+			removed++
+		}
+	}
+	return redcon.SimpleInt(removed), nil
+}
+
+func sismember(cli *DBClient, args [][]byte) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, newWrongNumberOfArgsError("sismember")
+	}
+
+	var ok = 0
+	res, err := cli.db.SIsMember(args[0], args[1])
+	if err != nil {
+		return nil, err
+	}
+	if res {
+		ok = 1
+	}
+	return redcon.SimpleInt(ok), nil
+}
+
+func smembers(cli *DBClient, args [][]byte) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, newWrongNumberOfArgsError("smembers")
+	}
+
+	res, err := cli.db.SMembers(args[0])
+	if err != nil {
+		return nil, err
+	}
+	return arrayReply(res), nil
+}
+
+func scard(cli *DBClient, args [][]byte) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, newWrongNumberOfArgsError("scard")
+	}
+
+	res, err := cli.db.SCard(args[0])
+	if err != nil {
+		return nil, err
+	}
+	return redcon.SimpleInt(res), nil
+}
+
+func spop(cli *DBClient, args [][]byte) (interface{}, error) {
+	if len(args) != 1 && len(args) != 2 {
+		return nil, newWrongNumberOfArgsError("spop")
+	}
+
+	//没有count时回复单个member
+	if len(args) == 1 {
+		res, err := cli.db.SPop(args[0], 1)
+		if err != nil || len(res) == 0 {
+			return nil, err
+		}
+		return res[0], nil
+	}
+	count, err := strconv.ParseUint(string(args[1]), 10, 32)
+	if err != nil {
+		return nil, errNotInteger
+	}
+	res, err := cli.db.SPop(args[0], uint32(count))
+	if err != nil {
+		return nil, err
+	}
+	return arrayReply(res), nil
+}
+
+func srandmember(cli *DBClient, args [][]byte) (interface{}, error) {
+	if len(args) != 1 && len(args) != 2 {
+		return nil, newWrongNumberOfArgsError("srandmember")
+	}
+
+	if len(args) == 1 {
+		res, err := cli.db.SRandMember(args[0], 1)
+		if err != nil || len(res) == 0 {
+			return nil, err
+		}
+		return res[0], nil
+	}
+	count, err := strconv.Atoi(string(args[1]))
+	if err != nil {
+		return nil, errNotInteger
+	}
+	res, err := cli.db.SRandMember(args[0], count)
+	if err != nil {
+		return nil, err
+	}
+	return arrayReply(res), nil
+}
+
+func smove(cli *DBClient, args [][]byte) (interface{}, error) {
+	if len(args) != 3 {
+		return nil, newWrongNumberOfArgsError("smove")
+	}
+
+	var ok = 0
+	res, err := cli.db.SMove(args[0], args[1], args[2])
+	if err != nil {
+		return nil, err
+	}
+	if res {
+		ok = 1
+	}
+	return redcon.SimpleInt(ok), nil
+}
+
+func sinter(cli *DBClient, args [][]byte) (interface{}, error) {
+	if len(args) < 1 {
+		return nil, newWrongNumberOfArgsError("sinter")
+	}
+
+	res, err := cli.db.SInter(args...)
+	if err != nil {
+		return nil, err
+	}
+	return arrayReply(res), nil
+}
+
+func sunion(cli *DBClient, args [][]byte) (interface{}, error) {
+	if len(args) < 1 {
+		return nil, newWrongNumberOfArgsError("sunion")
+	}
+
+	res, err := cli.db.SUnion(args...)
+	if err != nil {
+		return nil, err
+	}
+	return arrayReply(res), nil
+}
+
+func sdiff(cli *DBClient, args [][]byte) (interface{}, error) {
+	if len(args) < 1 {
+		return nil, newWrongNumberOfArgsError("sdiff")
+	}
+
+	res, err := cli.db.SDiff(args...)
+	if err != nil {
+		return nil, err
+	}
+	return arrayReply(res), nil
+}
+
+func sinterstore(cli *DBClient, args [][]byte) (interface{}, error) {
+	if len(args) < 2 {
+		return nil, newWrongNumberOfArgsError("sinterstore")
+	}
+
+	res, err := cli.db.SInterStore(args[0], args[1:]...)
+	if err != nil {
+		return nil, err
+	}
+	return redcon.SimpleInt(res), nil
+}
+
+func sunionstore(cli *DBClient, args [][]byte) (interface{}, error) {
+	if len(args) < 2 {
+		return nil, newWrongNumberOfArgsError("sunionstore")
+	}
+
+	res, err := cli.db.SUnionStore(args[0], args[1:]...)
+	if err != nil {
+		return nil, err
+	}
+	return redcon.SimpleInt(res), nil
+}
+
+func sdiffstore(cli *DBClient, args [][]byte) (interface{}, error) {
+	if len(args) < 2 {
+		return nil, newWrongNumberOfArgsError("sdiffstore")
+	}
+
+	res, err := cli.db.SDiffStore(args[0], args[1:]...)
+	if err != nil {
+		return nil, err
+	}
+	return redcon.SimpleInt(res), nil
+}
+
+func sscan(cli *DBClient, args [][]byte) (interface{}, error) {
+	if len(args) < 2 {
+		return nil, newWrongNumberOfArgsError("sscan")
+	}
+
+	cursor, match, count, err := parseScanArgs(args[1:])
+	if err != nil {
+		return nil, err
+	}
+	next, res, err := cli.db.SScan(args[0], cursor, match, count)
+	if err != nil {
+		return nil, err
+	}
+	return scanReply(next, res), nil
+}
+
+func lpush(cli *DBClient, args [][]byte) (interface{}, error) {
+	return pushCommand(cli, args, "lpush", cli.db.LPush)
+}
+
+func rpush(cli *DBClient, args [][]byte) (interface{}, error) {
+	return pushCommand(cli, args, "rpush", cli.db.RPush)
+}
+
+func lpushx(cli *DBClient, args [][]byte) (interface{}, error) {
+	return pushCommand(cli, args, "lpushx", cli.db.LPushX)
+}
+
+func rpushx(cli *DBClient, args [][]byte) (interface{}, error) {
+	return pushCommand(cli, args, "rpushx", cli.db.RPushX)
+}
+
+// pushCommand 依次写入多个element，返回最后的长度
+func pushCommand(cli *DBClient, args [][]byte, cmd string, push func(key, element []byte) (uint32, error)) (interface{}, error) {
+	if len(args) < 2 {
+		return nil, newWrongNumberOfArgsError(cmd)
+	}
+
+	var res uint32
+	key := args[0]
+	for _, element := range args[1:] {
+		var err error
+		if res, err = push(key, element); err != nil {
+			return nil, err
+		}
+	}
+	return redcon.SimpleInt(res), nil
+}
+
+func lpop(cli *DBClient, args [][]byte) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, newWrongNumberOfArgsError("lpop")
+	}
+
+	return bulkReply(cli.db.LPop(args[0]))
+}
+
+func rpop(cli *DBClient, args [][]byte) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, newWrongNumberOfArgsError("rpop")
+	}
+
+	return bulkReply(cli.db.RPop(args[0]))
+}
+
+func llen(cli *DBClient, args [][]byte) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, newWrongNumberOfArgsError("llen")
+	}
+
+	res, err := cli.db.LLen(args[0])
+	if err != nil {
+		return nil, err
+	}
+	return redcon.SimpleInt(res), nil
+}
+
+func lindex(cli *DBClient, args [][]byte) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, newWrongNumberOfArgsError("lindex")
+	}
+
+	index, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return nil, errNotInteger
+	}
+	return bulkReply(cli.db.LIndex(args[0], index))
+}
+
+func lrange(cli *DBClient, args [][]byte) (interface{}, error) {
+	if len(args) != 3 {
+		return nil, newWrongNumberOfArgsError("lrange")
+	}
+
+	start, stop, err := parseRange(args[1], args[2])
+	if err != nil {
+		return nil, err
+	}
+	res, err := cli.db.LRange(args[0], start, stop)
+	if err != nil {
+		return nil, err
+	}
+	return arrayReply(res), nil
+}
+
+func lset(cli *DBClient, args [][]byte) (interface{}, error) {
+	if len(args) != 3 {
+		return nil, newWrongNumberOfArgsError("lset")
+	}
+
+	index, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return nil, errNotInteger
+	}
+	if err = cli.db.LSet(args[0], index, args[2]); err != nil {
+		return nil, err
+	}
+	return redcon.SimpleString("OK"), nil
+}
+
+func ltrim(cli *DBClient, args [][]byte) (interface{}, error) {
+	if len(args) != 3 {
+		return nil, newWrongNumberOfArgsError("ltrim")
+	}
+
+	start, stop, err := parseRange(args[1], args[2])
+	if err != nil {
+		return nil, err
+	}
+	if err = cli.db.LTrim(args[0], start, stop); err != nil {
+		return nil, err
+	}
+	return redcon.SimpleString("OK"), nil
+}
+
+func linsert(cli *DBClient, args [][]byte) (interface{}, error) {
+	if len(args) != 4 {
+		return nil, newWrongNumberOfArgsError("linsert")
+	}
+
+	var before bool
+	switch strings.ToLower(string(args[1])) {
+	case "before":
+		before = true
+	case "after":
+	default:
+		return nil, errSyntax
+	}
+	res, err := cli.db.LInsert(args[0], before, args[2], args[3])
+	if err != nil {
+		return nil, err
+	}
+	return redcon.SimpleInt(res), nil
+}
+
+func lrem(cli *DBClient, args [][]byte) (interface{}, error) {
+	if len(args) != 3 {
+		return nil, newWrongNumberOfArgsError("lrem")
+	}
+
+	count, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return nil, errNotInteger
+	}
+	res, err := cli.db.LRem(args[0], count, args[2])
+	if err != nil {
+		return nil, err
+	}
+	return redcon.SimpleInt(res), nil
+}
+
+func lmove(cli *DBClient, args [][]byte) (interface{}, error) {
+	if len(args) != 4 {
+		return nil, newWrongNumberOfArgsError("lmove")
+	}
+
+	srcLeft, err := parseListSide(args[2])
+	if err != nil {
+		return nil, err
+	}
+	dstLeft, err := parseListSide(args[3])
+	if err != nil {
+		return nil, err
+	}
+	return bulkReply(cli.db.LMove(args[0], args[1], srcLeft, dstLeft))
+}
+
+func blpop(cli *DBClient, args [][]byte) (interface{}, error) {
+	if len(args) < 2 {
+		return nil, newWrongNumberOfArgsError("blpop")
+	}
+
+	timeout, err := parseTimeout(args[len(args)-1])
+	if err != nil {
+		return nil, err
+	}
+	return blockingPopReply(cli.db.BLPopCtx(cli.ctx, timeout, args[:len(args)-1]...))
+}
+
+func brpop(cli *DBClient, args [][]byte) (interface{}, error) {
+	if len(args) < 2 {
+		return nil, newWrongNumberOfArgsError("brpop")
+	}
+
+	timeout, err := parseTimeout(args[len(args)-1])
+	if err != nil {
+		return nil, err
+	}
+	return blockingPopReply(cli.db.BRPopCtx(cli.ctx, timeout, args[:len(args)-1]...))
+}
+
+// blockingPopReply 回复key和element组成的数组，超时回复Null
+func blockingPopReply(key, element []byte, err error) (interface{}, error) {
+	if err != nil || element == nil {
+		return nil, err
+	}
+	return [][]byte{key, element}, nil
+}
+
+func blmove(cli *DBClient, args [][]byte) (interface{}, error) {
+	if len(args) != 5 {
+		return nil, newWrongNumberOfArgsError("blmove")
+	}
+
+	srcLeft, err := parseListSide(args[2])
+	if err != nil {
+		return nil, err
+	}
+	dstLeft, err := parseListSide(args[3])
+	if err != nil {
+		return nil, err
+	}
+	timeout, err := parseTimeout(args[4])
+	if err != nil {
+		return nil, err
+	}
+	return bulkReply(cli.db.BLMoveCtx(cli.ctx, args[0], args[1], srcLeft, dstLeft, timeout))
+}
+
+// parseTimeout 解析以秒为单位的超时时间，可以是小数，0表示一直阻塞
+func parseTimeout(arg []byte) (time.Duration, error) {
+	seconds, err := strconv.ParseFloat(string(arg), 64)
+	if err != nil || math.IsNaN(seconds) || math.IsInf(seconds, 0) {
+		return 0, errTimeout
+	}
+	if seconds < 0 {
+		return 0, errNegativeTimeout
+	}
+	//超出time.Duration范围时转换会溢出，截断为最大值
+	if seconds >= float64(math.MaxInt64)/float64(time.Second) {
+		return math.MaxInt64, nil
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+// parseListSide 解析LEFT或者RIGHT，LEFT时返回true
+func parseListSide(arg []byte) (bool, error) {
+	switch strings.ToLower(string(arg)) {
+	case "left":
+		return true, nil
+	case "right":
+		return false, nil
+	default:
+		return false, errSyntax
+	}
+}
+
+func zadd(cli *DBClient, args [][]byte) (interface{}, error) {
+	if len(args) < 3 || len(args)%2 != 1 {
+		return nil, newWrongNumberOfArgsError("zadd")
+	}
+
+	//先解析所有的score，避免只写入一部分
+	key := args[0]
+	scores := make([]float64, 0, len(args)/2)
+	for i := 1; i < len(args); i += 2 {
+		score, err := parseFloat(args[i])
+		if err != nil {
+			return nil, err
+		}
+		scores = append(scores, score)
+	}
+
+	var added = 0
+	for i, score := range scores {
+		res, err := cli.db.ZAdd(key, score, args[i*2+2])
+		if err != nil {
+			return nil, err
+		}
+		if res {
+			added++
+		}
+	}
+	return redcon.SimpleInt(added), nil
+}
+
+func zscore(cli *DBClient, args [][]byte) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, newWrongNumberOfArgsError("zscore")
+	}
+
+	size, err := cli.db.ZCard(args[0])
+	if err != nil || size == 0 {
+		return nil, err
+	}
+	score, err := cli.db.ZScore(args[0], args[1])
+	if err != nil {
+		return nil, err
+	}
+	return utils.Float64ToBytes(score), nil
+}
+
+func zcard(cli *DBClient, args [][]byte) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, newWrongNumberOfArgsError("zcard")
+	}
+
+	res, err := cli.db.ZCard(args[0])
+	if err != nil {
+		return nil, err
+	}
+	return redcon.SimpleInt(res), nil
+}
+
+func zrem(cli *DBClient, args [][]byte) (interface{}, error) {
+	if len(args) < 2 {
+		return nil, newWrongNumberOfArgsError("zrem")
+	}
+
+	var removed = 0
+	key := args[0]
+	for _, member := range args[1:] {
+		res, err := cli.db.ZRem(key, member)
+		if err != nil {
+			return nil, err
+		}
+		if res {
+			removed++
+		}
+	}
+	return redcon.SimpleInt(removed), nil
+}
+
+func zincrby(cli *DBClient, args [][]byte) (interface{}, error) {
+	if len(args) != 3 {
+		return nil, newWrongNumberOfArgsError("zincrby")
+	}
+
+	incr, err := parseFloat(args[1])
+	if err != nil {
+		return nil, err
+	}
+	res, err := cli.db.ZIncrBy(args[0], incr, args[2])
+	if err != nil {
+		return nil, err
+	}
+	return utils.Float64ToBytes(res), nil
+}
+
+func zrank(cli *DBClient, args [][]byte) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, newWrongNumberOfArgsError("zrank")
+	}
+
+	return rankReply(cli.db.ZRank(args[0], args[1]))
+}
+
+func zrevrank(cli *DBClient, args [][]byte) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, newWrongNumberOfArgsError("zrevrank")
+	}
+
+	return rankReply(cli.db.ZRevRank(args[0], args[1]))
+}
+
+// rankReply 排名为-1时回复Null
+func rankReply(rank int64, err error) (interface{}, error) {
+	if err != nil || rank < 0 {
+		return nil, err
+	}
+	return redcon.SimpleInt(rank), nil
+}
+
+func zrange(cli *DBClient, args [][]byte) (interface{}, error) {
+	return zrangeByRank(cli, args, "zrange", cli.db.ZRange)
+}
+
+func zrevrange(cli *DBClient, args [][]byte) (interface{}, error) {
+	return zrangeByRank(cli, args, "zrevrange", cli.db.ZRevRange)
+}
+
+// zrangeByRank key start stop [WITHSCORES]
+func zrangeByRank(cli *DBClient, args [][]byte, cmd string, rangeFunc func(key []byte, start, stop int64) ([]structure.ZMember, error)) (interface{}, error) {
+	if len(args) != 3 && len(args) != 4 {
+		return nil, newWrongNumberOfArgsError(cmd)
+	}
+
+	start, stop, err := parseRange(args[1], args[2])
+	if err != nil {
+		return nil, err
+	}
+	var withScores bool
+	if len(args) == 4 {
+		if strings.ToLower(string(args[3])) != "withscores" {
+			return nil, errSyntax
+		}
+		withScores = true
+	}
+	res, err := rangeFunc(args[0], start, stop)
+	if err != nil {
+		return nil, err
+	}
+	return zmembersReply(res, withScores), nil
+}
+
+func zrangebyscore(cli *DBClient, args [][]byte) (interface{}, error) {
+	if len(args) < 3 {
+		return nil, newWrongNumberOfArgsError("zrangebyscore")
+	}
+
+	return zrangeByScore(cli, args[0], args[1], args[2], args[3:], cli.db.ZRangeByScore)
+}
+
+func zrevrangebyscore(cli *DBClient, args [][]byte) (interface{}, error) {
+	if len(args) < 3 {
+		return nil, newWrongNumberOfArgsError("zrevrangebyscore")
+	}
+
+	//zrevrangebyscore的参数是先max后min
+	return zrangeByScore(cli, args[0], args[2], args[1], args[3:], cli.db.ZRevRangeByScore)
+}
+
+// zrangeByScore 解析min max [WITHSCORES] [LIMIT offset count]
+func zrangeByScore(cli *DBClient, key, minArg, maxArg []byte, options [][]byte,
+	rangeFunc func(key []byte, r structure.ScoreRange, offset, count int) ([]structure.ZMember, error)) (interface{}, error) {
+	r, err := parseScoreRange(minArg, maxArg)
+	if err != nil {
+		return nil, err
+	}
+	var withScores bool
+	var offset, count = 0, -1
+	for i := 0; i < len(options); i++ {
+		switch strings.ToLower(string(options[i])) {
+		case "withscores":
+			withScores = true
+		case "limit":
+			if offset, count, err = parseLimit(options[i+1:]); err != nil {
+				return nil, err
+			}
+			i += 2
+		default:
+			return nil, errSyntax
+		}
+	}
+	res, err := rangeFunc(key, r, offset, count)
+	if err != nil {
+		return nil, err
+	}
+	return zmembersReply(res, withScores), nil
+}
+
+func zrangebylex(cli *DBClient, args [][]byte) (interface{}, error) {
+	if len(args) != 3 && len(args) != 6 {
+		return nil, newWrongNumberOfArgsError("zrangebylex")
+	}
+
+	r, err := parseLexRange(args[1], args[2])
+	if err != nil {
+		return nil, err
+	}
+	var offset, count = 0, -1
+	if len(args) == 6 {
+		if strings.ToLower(string(args[3])) != "limit" {
+			return nil, errSyntax
+		}
+		if offset, count, err = parseLimit(args[4:]); err != nil {
+			return nil, err
+		}
+	}
+	res, err := cli.db.ZRangeByLex(args[0], r, offset, count)
+	if err != nil {
+		return nil, err
+	}
+	return arrayReply(res), nil
+}
+
+func zcount(cli *DBClient, args [][]byte) (interface{}, error) {
+	if len(args) != 3 {
+		return nil, newWrongNumberOfArgsError("zcount")
+	}
+
+	r, err := parseScoreRange(args[1], args[2])
+	if err != nil {
+		return nil, err
+	}
+	res, err := cli.db.ZCount(args[0], r)
+	if err != nil {
+		return nil, err
+	}
+	return redcon.SimpleInt(res), nil
+}
+
+func zpopmin(cli *DBClient, args [][]byte) (interface{}, error) {
+	return zpop(cli, args, "zpopmin", cli.db.ZPopMin)
+}
+
+func zpopmax(cli *DBClient, args [][]byte) (interface{}, error) {
+	return zpop(cli, args, "zpopmax", cli.db.ZPopMax)
+}
+
+// zpop key [count]
+func zpop(cli *DBClient, args [][]byte, cmd string, popFunc func(key []byte, count int) ([]structure.ZMember, error)) (interface{}, error) {
+	if len(args) != 1 && len(args) != 2 {
+		return nil, newWrongNumberOfArgsError(cmd)
+	}
+
+	var count = 1
+	if len(args) == 2 {
+		var err error
+		if count, err = strconv.Atoi(string(args[1])); err != nil {
+			return nil, errNotInteger
+		}
+	}
+	res, err := popFunc(args[0], count)
+	if err != nil {
+		return nil, err
+	}
+	return zmembersReply(res, true), nil
+}
+
+func zremrangebyscore(cli *DBClient, args [][]byte) (interface{}, error) {
+	if len(args) != 3 {
+		return nil, newWrongNumberOfArgsError("zremrangebyscore")
+	}
+
+	r, err := parseScoreRange(args[1], args[2])
+	if err != nil {
+		return nil, err
+	}
+	res, err := cli.db.ZRemRangeByScore(args[0], r)
+	if err != nil {
+		return nil, err
+	}
+	return redcon.SimpleInt(res), nil
+}
+
+// zmembersReply 回复member数组，withScores为true时每个member之后跟着score
+func zmembersReply(members []structure.ZMember, withScores bool) []interface{} {
+	res := make([]interface{}, 0, len(members)*2)
+	for _, m := range members {
+		res = append(res, m.Member)
+		if withScores {
+			res = append(res, utils.Float64ToBytes(m.Score))
+		}
+	}
+	return res
+}
+
+// parseFloat 解析浮点数参数，支持inf、+inf和-inf
+func parseFloat(arg []byte) (float64, error) {
+	f, err := strconv.ParseFloat(string(arg), 64)
+	if err != nil || math.IsNaN(f) {
+		return 0, errNotFloat
+	}
+	return f, nil
+}
+
+// parseScoreRange 解析score的范围，(表示不包含边界
+func parseScoreRange(minArg, maxArg []byte) (structure.ScoreRange, error) {
+	var r structure.ScoreRange
+	var err error
+	if len(minArg) > 0 && minArg[0] == '(' {
+		r.MinExclusive = true
+		minArg = minArg[1:]
+	}
+	if len(maxArg) > 0 && maxArg[0] == '(' {
+		r.MaxExclusive = true
+		maxArg = maxArg[1:]
+	}
+	if r.Min, err = parseFloat(minArg); err != nil {
+		return r, errMinMaxNotFloat
+	}
+	if r.Max, err = parseFloat(maxArg); err != nil {
+		return r, errMinMaxNotFloat
+	}
+	return r, nil
+}
+
+// parseLexRange 解析字典序的范围，-和+表示负无穷和正无穷，[表示包含边界，(表示不包含边界
+func parseLexRange(minArg, maxArg []byte) (structure.LexRange, error) {
+	var r structure.LexRange
+	var err error
+	if r.Min, r.MinExclusive, err = parseLexBound(minArg, '-'); err != nil {
+		return r, err
+	}
+	if r.Max, r.MaxExclusive, err = parseLexBound(maxArg, '+'); err != nil {
+		return r, err
+	}
+	//-作为上界或者+作为下界时范围为空
+	if len(minArg) == 1 && minArg[0] == '+' || len(maxArg) == 1 && maxArg[0] == '-' {
+		r.Min, r.Max, r.MinExclusive = []byte{}, []byte{}, true
+	}
+	return r, nil
+}
+
+func parseLexBound(arg []byte, inf byte) ([]byte, bool, error) {
+	if len(arg) == 0 {
+		return nil, false, errLexRange
+	}
+	switch arg[0] {
+	case '-', '+':
+		if len(arg) != 1 {
+			return nil, false, errLexRange
+		}
+		if arg[0] != inf {
+			return []byte{}, false, nil
+		}
+		return nil, false, nil
+	case '[':
+		return append([]byte{}, arg[1:]...), false, nil
+	case '(':
+		return append([]byte{}, arg[1:]...), true, nil
+	default:
+		return nil, false, errLexRange
+	}
+}
+
+// parseLimit 解析LIMIT之后的offset和count
+func parseLimit(args [][]byte) (int, int, error) {
+	if len(args) < 2 {
+		return 0, 0, errSyntax
+	}
+	offset, err := strconv.Atoi(string(args[0]))
+	if err != nil {
+		return 0, 0, errNotInteger
+	}
+	count, err := strconv.Atoi(string(args[1]))
+	if err != nil {
+		return 0, 0, errNotInteger
+	}
+	return offset, count, nil
+}
+
+func setbit(cli *DBClient, args [][]byte) (interface{}, error) {
+	if len(args) != 3 {
+		return nil, newWrongNumberOfArgsError("setbit")
+	}
+
+	offset, err := parseBitOffset(args[1])
+	if err != nil {
+		return nil, err
+	}
+	bit, err := parseBit(args[2])
+	if err != nil {
+		return nil, err
+	}
+	res, err := cli.db.SetBit(args[0], offset, bit)
+	if err != nil {
+		return nil, err
+	}
+	return redcon.SimpleInt(res), nil
+}
+
+func getbit(cli *DBClient, args [][]byte) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, newWrongNumberOfArgsError("getbit")
+	}
+
+	offset, err := parseBitOffset(args[1])
+	if err != nil {
+		return nil, err
+	}
+	res, err := cli.db.GetBit(args[0], offset)
+	if err != nil {
+		return nil, err
+	}
+	return redcon.SimpleInt(res), nil
+}
+
+// bitcount key [start end [BYTE|BIT]]
+func bitcount(cli *DBClient, args [][]byte) (interface{}, error) {
+	if len(args) < 1 {
+		return nil, newWrongNumberOfArgsError("bitcount")
+	}
+	if len(args) == 2 || len(args) > 4 {
+		return nil, errSyntax
+	}
+
+	r, err := parseBitRange(args[1:])
+	if err != nil {
+		return nil, err
+	}
+	return intReply(cli.db.BitCount(args[0], r))
+}
+
+// bitpos key bit [start [end [BYTE|BIT]]]
+func bitpos(cli *DBClient, args [][]byte) (interface{}, error) {
+	if len(args) < 2 {
+		return nil, newWrongNumberOfArgsError("bitpos")
+	}
+	if len(args) > 5 {
+		return nil, errSyntax
+	}
+
+	bit, err := parseBit(args[1])
+	if err != nil {
+		return nil, err
+	}
+	r, err := parseBitRange(args[2:])
+	if err != nil {
+		return nil, err
+	}
+	return intReply(cli.db.BitPos(args[0], bit, r))
+}
+
+// bitop AND|OR|XOR|NOT destkey key [key ...]
+func bitop(cli *DBClient, args [][]byte) (interface{}, error) {
+	if len(args) < 3 {
+		return nil, newWrongNumberOfArgsError("bitop")
+	}
+
+	var op structure.BitOperation
+	switch strings.ToLower(string(args[0])) {
+	case "and":
+		op = structure.BitAnd
+	case "or":
+		op = structure.BitOr
+	case "xor":
+		op = structure.BitXor
+	case "not":
+		op = structure.BitNot
+	default:
+		return nil, errSyntax
+	}
+	return intReply(cli.db.BitOp(op, args[1], args[2:]...))
+}
+
+// bitfield key [GET type offset] [SET type offset value] [INCRBY type offset increment] [OVERFLOW WRAP|SAT|FAIL]
+func bitfield(cli *DBClient, args [][]byte) (interface{}, error) {
+	if len(args) < 1 {
+		return nil, newWrongNumberOfArgsError("bitfield")
+	}
+
+	var ops []structure.BitFieldOp
+	overflow := structure.BitFieldWrap
+	for i := 1; i < len(args); {
+		sub := strings.ToLower(string(args[i]))
+		if sub == "overflow" {
+			if i+1 >= len(args) {
+				return nil, errSyntax
+			}
+			switch strings.ToLower(string(args[i+1])) {
+			case "wrap":
+				overflow = structure.BitFieldWrap
+			case "sat":
+				overflow = structure.BitFieldSat
+			case "fail":
+				overflow = structure.BitFieldFail
+			default:
+				return nil, errBitFieldOverflow
+			}
+			i += 2
+			continue
+		}
+
+		op := structure.BitFieldOp{Overflow: overflow}
+		n := 3
+		switch sub {
+		case "get":
+			op.Command = structure.BitFieldGet
+		case "set":
+			op.Command = structure.BitFieldSet
+			n = 4
+		case "incrby":
+			op.Command = structure.BitFieldIncrBy
+			n = 4
+		default:
+			return nil, errSyntax
+		}
+		if i+n > len(args) {
+			return nil, errSyntax
+		}
+		var err error
+		if op.Signed, op.Bits, err = parseBitFieldType(args[i+1]); err != nil {
+			return nil, err
+		}
+		if op.Offset, err = parseBitFieldOffset(args[i+2], op.Bits); err != nil {
+			return nil, err
+		}
+		if n == 4 {
+			if op.Value, err = strconv.ParseInt(string(args[i+3]), 10, 64); err != nil {
+				return nil, errNotInteger
+			}
+		}
+		ops = append(ops, op)
+		i += n
+	}
+
+	res, err := cli.db.BitField(args[0], ops)
+	if err != nil {
+		return nil, err
+	}
+	reply := make([]interface{}, len(res))
+	for i, v := range res {
+		if v != nil {
+			reply[i] = redcon.SimpleInt(*v)
+		}
+	}
+	return reply, nil
+}
+
+func parseBitOffset(arg []byte) (uint64, error) {
+	offset, err := strconv.ParseUint(string(arg), 10, 32)
+	if err != nil {
+		return 0, structure.ErrBitOffsetOutOfRange
+	}
+	return offset, nil
+}
+
+func parseBit(arg []byte) (byte, error) {
+	switch string(arg) {
+	case "0":
+		return 0, nil
+	case "1":
+		return 1, nil
+	default:
+		return 0, structure.ErrBitValue
+	}
+}
+
+// parseBitRange 解析[start [end [BYTE|BIT]]]，没有参数时返回nil
+func parseBitRange(args [][]byte) (*structure.BitRange, error) {
+	if len(args) == 0 {
+		return nil, nil
+	}
+	r := &structure.BitRange{}
+	var err error
+	if r.Start, err = strconv.ParseInt(string(args[0]), 10, 64); err != nil {
+		return nil, errNotInteger
+	}
+	if len(args) > 1 {
+		r.HasEnd = true
+		if r.End, err = strconv.ParseInt(string(args[1]), 10, 64); err != nil {
+			return nil, errNotInteger
+		}
+	}
+	if len(args) > 2 {
+		switch strings.ToLower(string(args[2])) {
+		case "byte":
+		case "bit":
+			r.Bit = true
+		default:
+			return nil, errSyntax
+		}
+	}
+	return r, nil
+}
+
+// parseBitFieldType 解析i8、u16这样的类型
+func parseBitFieldType(arg []byte) (bool, uint8, error) {
+	if len(arg) < 2 || (arg[0] != 'i' && arg[0] != 'u') {
+		return false, 0, structure.ErrBitFieldType
+	}
+	signed := arg[0] == 'i'
+	n, err := strconv.ParseUint(string(arg[1:]), 10, 8)
+	if err != nil || n == 0 || (signed && n > 64) || (!signed && n > 63) {
+		return false, 0, structure.ErrBitFieldType
+	}
+	return signed, uint8(n), nil
+}
+
+// parseBitFieldOffset 解析字段的偏移，#N表示第N个字段，即N*bits
+func parseBitFieldOffset(arg []byte, bits uint8) (uint64, error) {
+	multiply := len(arg) > 0 && arg[0] == '#'
+	if multiply {
+		arg = arg[1:]
+	}
+	offset, err := strconv.ParseUint(string(arg), 10, 64)
+	if err != nil {
+		return 0, structure.ErrBitOffsetOutOfRange
+	}
+	if multiply {
+		if offset > math.MaxUint32/uint64(bits) {
+			return 0, structure.ErrBitOffsetOutOfRange
+		}
+		offset *= uint64(bits)
+	}
+	return offset, nil
+}
+
+func pfadd(cli *DBClient, args [][]byte) (interface{}, error) {
+	if len(args) < 1 {
+		return nil, newWrongNumberOfArgsError("pfadd")
+	}
+
+	var ok = 0
+	res, err := cli.db.PFAdd(args[0], args[1:]...)
+	if err != nil {
+		return nil, err
+	}
+	if res {
+		ok = 1
+	}
+	return redcon.SimpleInt(ok), nil
+}
+
+func pfcount(cli *DBClient, args [][]byte) (interface{}, error) {
+	if len(args) < 1 {
+		return nil, newWrongNumberOfArgsError("pfcount")
+	}
+
+	res, err := cli.db.PFCount(args...)
+	if err != nil {
+		return nil, err
+	}
+	return redcon.SimpleInt(res), nil
+}
+
+func pfmerge(cli *DBClient, args [][]byte) (interface{}, error) {
+	if len(args) < 1 {
+		return nil, newWrongNumberOfArgsError("pfmerge")
+	}
+
+	if err := cli.db.PFMerge(args[0], args[1:]...); err != nil {
+		return nil, err
+	}
+	return redcon.SimpleString("OK"), nil
+}
@@ -6,10 +6,14 @@ import (
 	"github.com/tidwall/redcon"
 	"log"
 	"sync"
+	"time"
 )
 
 const addr = "127.0.0.1:6380"
 
+// gcInterval 后台回收废弃数据的间隔
+const gcInterval = time.Minute
+
 type DBServer struct {
 	dbs    map[int]*structure.DataStructure
 	server *redcon.Server
@@ -22,6 +26,13 @@ func main() {
 	if err != nil {
 		panic(err)
 	}
+	dataStructure.StartGC(gcInterval, func(reclaimed int, err error) {
+		if err != nil {
+			log.Println("failed to collect garbage:", err)
+		} else if reclaimed > 0 {
+			log.Printf("collected %d orphaned keys", reclaimed)
+		}
+	})
 
 	//初始化DBServer
 	dbServer := &DBServer{
@@ -53,6 +64,14 @@ func (srv *DBServer) accept(conn redcon.Conn) bool {
 }
 
 func (srv *DBServer) close(conn redcon.Conn, err error) {
+	//分离出去的连接由客户端自己读取命令，连接真正关闭时再调用closeAll
+	if cli, _ := conn.Context().(*DBClient); cli != nil && cli.detached {
+		return
+	}
+	srv.closeAll()
+}
+
+func (srv *DBServer) closeAll() {
 	for _, db := range srv.dbs {
 		_ = db.Close()
 	}
@@ -1,23 +1,689 @@
 package structure
 
-import "errors"
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/GrandeLai/JDawDB"
+	"hash/maphash"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+// defaultScanCount 扫描命令没有指定COUNT时每次遍历的数量
+const defaultScanCount = 10
+
+// keyLockShards key写锁的分片数量，不同的key可能落在同一个分片
+const keyLockShards = 256
+
+var keyLockSeed = maphash.MakeSeed()
+
+// lockKeys 锁住keys所在的分片，返回解锁函数
+// 多个key时按照分片序号加锁，同时锁多个key的操作之间不会死锁
+func (ds *DataStructure) lockKeys(keys ...[]byte) func() {
+	shards := make([]int, 0, len(keys))
+	for _, key := range keys {
+		shards = append(shards, int(maphash.Bytes(keyLockSeed, key)%keyLockShards))
+	}
+	sort.Ints(shards)
+	n := 0
+	for i, shard := range shards {
+		if i == 0 || shard != shards[n-1] {
+			shards[n] = shard
+			n++
+		}
+	}
+	shards = shards[:n]
+
+	for _, shard := range shards {
+		ds.keyLocks[shard].Lock()
+	}
+	return func() {
+		for i := len(shards) - 1; i >= 0; i-- {
+			ds.keyLocks[shards[i]].Unlock()
+		}
+	}
+}
 
 func (ds *DataStructure) Del(key []byte) error {
-	return ds.db.Delete(key)
+	unlock := ds.lockKeys(key)
+	defer unlock()
+	return ds.replaceKey(key, nil)
 }
 
 func (ds *DataStructure) Type(key []byte) (DataType, error) {
-	encValue, err := ds.db.Get(key)
+	encValue, err := ds.liveValue(key)
 	if err != nil {
 		return 0, err
 	}
-	if len(encValue) == 0 {
-		return 0, errors.New("value is null")
+	//集合类型的数据部分不是一个独立的key
+	if _, ok := parseMetadata(encValue); !ok {
+		subKey, err := ds.isSubKey(key, time.Now().UnixNano())
+		if err != nil {
+			return 0, err
+		}
+		if subKey {
+			return 0, JDawDB.ErrKeyNotFound
+		}
 	}
 	// 第一个字节就是类型
 	return encValue[0], nil
 }
 
 func (ds *DataStructure) Close() error {
+	//唤醒阻塞的等待者，避免它们一直阻塞
+	ds.closeOnce.Do(func() {
+		close(ds.closed)
+	})
+	ds.bgWg.Wait()
 	return ds.db.Close()
 }
+
+const (
+	// KeyNotExist TTL返回的key不存在
+	KeyNotExist time.Duration = -2
+	// KeyNoExpire TTL返回的key没有设置过期时间
+	KeyNoExpire time.Duration = -1
+)
+
+// typeNames TYPE和SCAN命令中使用的类型名称
+var typeNames = map[DataType]string{
+	String:      "string",
+	Hash:        "hash",
+	Set:         "set",
+	List:        "list",
+	ZSet:        "zset",
+	Bitmap:      "bitmap",
+	HyperLogLog: "hyperloglog",
+}
+
+// TypeName 返回类型的名称
+func TypeName(dataType DataType) string {
+	return typeNames[dataType]
+}
+
+// Exists 返回keys中存在的数量，重复的key会重复计数
+func (ds *DataStructure) Exists(keys ...[]byte) (int, error) {
+	var count int
+	for _, key := range keys {
+		_, err := ds.liveValue(key)
+		if err == JDawDB.ErrKeyNotFound {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+// Expire 设置key在ttl之后过期，ttl不大于0时直接删除key，key不存在时返回false
+func (ds *DataStructure) Expire(key []byte, ttl time.Duration) (bool, error) {
+	return ds.ExpireAt(key, time.Now().Add(ttl))
+}
+
+// ExpireAt 设置key在at时过期，at已经过去时直接删除key，key不存在时返回false
+func (ds *DataStructure) ExpireAt(key []byte, at time.Time) (bool, error) {
+	unlock := ds.lockKeys(key)
+	defer unlock()
+
+	encValue, err := ds.liveValue(key)
+	if err == JDawDB.ErrKeyNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if !at.After(time.Now()) {
+		return true, ds.replaceKey(key, nil)
+	}
+	return true, ds.db.Put(key, setExpire(encValue, at.UnixNano()))
+}
+
+// Persist 清除key的过期时间，key不存在或者没有过期时间时返回false
+func (ds *DataStructure) Persist(key []byte) (bool, error) {
+	unlock := ds.lockKeys(key)
+	defer unlock()
+
+	encValue, err := ds.liveValue(key)
+	if err == JDawDB.ErrKeyNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if expire, _ := binary.Varint(encValue[1:]); expire == 0 {
+		return false, nil
+	}
+	return true, ds.db.Put(key, setExpire(encValue, 0))
+}
+
+// TTL 返回key剩余的存活时间，key不存在时返回KeyNotExist，没有过期时间时返回KeyNoExpire
+func (ds *DataStructure) TTL(key []byte) (time.Duration, error) {
+	encValue, err := ds.liveValue(key)
+	if err == JDawDB.ErrKeyNotFound {
+		return KeyNotExist, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	expire, _ := binary.Varint(encValue[1:])
+	if expire == 0 {
+		return KeyNoExpire, nil
+	}
+	return time.Duration(expire - time.Now().UnixNano()), nil
+}
+
+// setExpire 修改value中的过期时间，所有类型的value和元数据都以type+expire开头
+func setExpire(encValue []byte, expire int64) []byte {
+	if !isMetadataType(encValue[0]) {
+		value, _ := decodeString(encValue)
+		return encodeValue(encValue[0], value, expire)
+	}
+	meta := decode(encValue)
+	meta.expire = expire
+	return meta.encode()
+}
+
+// Rename 将key重命名为newKey，newKey原来的数据会被覆盖，过期时间保持不变
+// 集合类型的数据部分中包含了key，需要全部复制到newKey下
+func (ds *DataStructure) Rename(key, newKey []byte) error {
+	unlock := ds.lockKeys(key, newKey)
+	defer unlock()
+
+	encValue, err := ds.liveValue(key)
+	if err == JDawDB.ErrKeyNotFound {
+		return ErrNoSuchKey
+	}
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(key, newKey) {
+		return nil
+	}
+
+	if !isMetadataType(encValue[0]) {
+		writebatch := ds.db.NewWriteBatch(JDawDB.DefaultWriteBatchOptions)
+		if err = ds.markGarbage(writebatch, newKey); err != nil {
+			return err
+		}
+		_ = writebatch.Put(newKey, encValue)
+		_ = writebatch.Delete(key)
+		return writebatch.Commit()
+	}
+
+	//数据部分的key为key+version+后缀，只需要替换前缀
+	meta := decode(encValue)
+	oldPrefix := internalKeyPrefix(key, meta.version)
+	meta.version = time.Now().UnixNano()
+	newPrefix := internalKeyPrefix(newKey, meta.version)
+
+	type entry struct {
+		suffix []byte
+		value  []byte
+	}
+	var entries []entry
+	err = ds.scanInternalKeys(oldPrefix, true, func(suffix, value []byte) bool {
+		entries = append(entries, entry{suffix: suffix, value: value})
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	writebatch := ds.newWriteBatch(len(entries)*2 + 3)
+	if err = ds.markGarbage(writebatch, newKey); err != nil {
+		return err
+	}
+	for _, e := range entries {
+		_ = writebatch.Put(append(append([]byte(nil), newPrefix...), e.suffix...), e.value)
+		_ = writebatch.Delete(append(append([]byte(nil), oldPrefix...), e.suffix...))
+	}
+	_ = writebatch.Put(newKey, meta.encode())
+	_ = writebatch.Delete(key)
+	return writebatch.Commit()
+}
+
+// Keys 返回所有匹配pattern的key
+func (ds *DataStructure) Keys(pattern string) ([][]byte, error) {
+	var res [][]byte
+	err := ds.scanKeys(nil, func(key, _ []byte) bool {
+		if globMatch([]byte(pattern), key) {
+			res = append(res, key)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// RandomKey 随机返回一个key，没有key时返回nil
+func (ds *DataStructure) RandomKey() ([]byte, error) {
+	//蓄水池抽样，只需要遍历一次
+	var res []byte
+	var n int
+	err := ds.scanKeys(nil, func(key, _ []byte) bool {
+		n++
+		if rand.Intn(n) == 0 {
+			res = key
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// Scan 从cursor之后最多检查count个key，返回其中匹配match并且类型为typeName的key，以及下一次调用的游标
+// match和typeName为空时不过滤，游标是最后一个检查过的key，cursor为nil时从头开始，遍历完成时返回nil
+func (ds *DataStructure) Scan(cursor []byte, match string, count int, typeName string) ([]byte, [][]byte, error) {
+	if count <= 0 {
+		count = defaultScanCount
+	}
+	var res [][]byte
+	var examined int
+	var last []byte
+	var hasMore bool
+	err := ds.scanKeys(cursor, func(key, encValue []byte) bool {
+		//游标本身已经在上一次检查过
+		if cursor != nil && bytes.Equal(key, cursor) {
+			return true
+		}
+		if examined == count {
+			hasMore = true
+			return false
+		}
+		examined++
+		last = key
+		if match != "" && !globMatch([]byte(match), key) {
+			return true
+		}
+		if typeName != "" && typeNames[encValue[0]] != typeName {
+			return true
+		}
+		res = append(res, key)
+		return true
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	if !hasMore {
+		return nil, res, nil
+	}
+	return last, res, nil
+}
+
+// scanKeys 从seek开始按顺序遍历所有存在的key，跳过集合类型的数据部分，seek为nil时从头开始
+func (ds *DataStructure) scanKeys(seek []byte, fn func(key, encValue []byte) bool) error {
+	return ds.walkKeys(seek, func(key, encValue []byte, expired bool) bool {
+		if expired {
+			return true
+		}
+		return fn(key, encValue)
+	})
+}
+
+// walkKeys 从seek开始按顺序遍历所有的key，包括已经过期的，跳过集合类型的数据部分、空的集合类型和回收队列
+// 集合类型的数据部分都以元数据的key+version为前缀，并且排在元数据之后，遍历时记录遇到的前缀，之后落在前缀范围中的key都是数据部分
+// 回收队列中的前缀没有对应的元数据，用另一个迭代器同步向后遍历
+func (ds *DataStructure) walkKeys(seek []byte, fn func(key, encValue []byte, expired bool) bool) error {
+	return ds.walk(seek, fn, nil)
+}
+
+// walk 和walkKeys相同，同时将不属于任何元数据和回收队列、也不是string等类型的key交给unknown（可以为nil）
+func (ds *DataStructure) walk(seek []byte, fn func(key, encValue []byte, expired bool) bool, unknown func(key []byte) bool) error {
+	prefixes, err := ds.prefixesBefore(seek)
+	if err != nil {
+		return err
+	}
+
+	queue := ds.newKeyIterator(false)
+	defer queue.Close()
+	queue.Seek(append(append([]byte(nil), gcKeyPrefix...), seek...))
+	it := ds.newKeyIterator(false)
+	defer it.Close()
+
+	now := time.Now().UnixNano()
+	for it.Seek(seek); it.Valid(); it.Next() {
+		key := it.Key()
+		if bytes.HasPrefix(key, gcKeyPrefix) {
+			continue
+		}
+		//回收队列中的前缀是有序的，到达之后加入
+		for ; queue.Valid() && bytes.HasPrefix(queue.Key(), gcKeyPrefix); queue.Next() {
+			prefix := queue.Key()[len(gcKeyPrefix):]
+			if bytes.Compare(prefix, key) > 0 {
+				break
+			}
+			prefixes = append(prefixes, append([]byte(nil), prefix...))
+		}
+
+		//移除已经遍历完的前缀，同时判断是否是数据部分
+		var internal bool
+		n := 0
+		for _, prefix := range prefixes {
+			if bytes.HasPrefix(key, prefix) {
+				internal = true
+			} else if bytes.Compare(key, prefix) > 0 {
+				continue
+			}
+			prefixes[n] = prefix
+			n++
+		}
+		prefixes = prefixes[:n]
+		if internal {
+			continue
+		}
+
+		key = append([]byte(nil), key...)
+		encValue, err := ds.db.Get(key)
+		if err == JDawDB.ErrKeyNotFound {
+			//遍历期间被删除
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		var expire int64
+		meta, ok := parseMetadata(encValue)
+		if !ok {
+			//旧版本留下的数据部分的value可能和string一样，需要先根据key的结构判断
+			if ok, err = ds.isSubKey(key, now); err != nil {
+				return err
+			}
+			if ok {
+				if unknown != nil && !unknown(key) {
+					break
+				}
+				continue
+			}
+		}
+		if meta != nil {
+			//过期的元数据的数据部分也需要跳过
+			prefixes = append(prefixes, internalKeyPrefix(key, meta.version))
+			if meta.size == 0 {
+				continue
+			}
+			expire = meta.expire
+		} else if len(encValue) > 1 && (encValue[0] == String || encValue[0] == HyperLogLog) {
+			var n int
+			if expire, n = binary.Varint(encValue[1:]); n <= 0 {
+				continue
+			}
+		} else {
+			if unknown != nil && !unknown(key) {
+				break
+			}
+			continue
+		}
+		if !fn(key, encValue, expire > 0 && expire <= now) {
+			break
+		}
+	}
+	return nil
+}
+
+// prefixesBefore 返回排在seek之前、范围可能覆盖seek之后的key的数据部分前缀
+// 这样的前缀对应的key一定是seek的前缀，只需要逐个检查seek的前缀
+func (ds *DataStructure) prefixesBefore(seek []byte) ([][]byte, error) {
+	var prefixes [][]byte
+	for i := 1; i <= len(seek); i++ {
+		encValue, err := ds.db.Get(seek[:i])
+		if err != nil && err != JDawDB.ErrKeyNotFound {
+			return nil, err
+		}
+		if meta, ok := parseMetadata(encValue); ok {
+			prefixes = append(prefixes, internalKeyPrefix(seek[:i], meta.version))
+		}
+		//回收队列中的前缀本身是seek的前缀时，不会被回收队列的迭代器遍历到
+		gcKey := append(append([]byte(nil), gcKeyPrefix...), seek[:i]...)
+		if _, err = ds.db.Get(gcKey); err == nil {
+			prefixes = append(prefixes, append([]byte(nil), seek[:i]...))
+		} else if err != JDawDB.ErrKeyNotFound {
+			return nil, err
+		}
+	}
+	return prefixes, nil
+}
+
+// keyIterator 只遍历key的迭代器，value通过db.Get读取
+type keyIterator interface {
+	Seek(key []byte)
+	Next()
+	Valid() bool
+	Key() []byte
+	Close()
+}
+
+// newKeyIterator 初始化直接在索引上遍历的迭代器，索引不支持时使用会复制所有key的迭代器
+func (ds *DataStructure) newKeyIterator(reverse bool) keyIterator {
+	options := JDawDB.IteratorOptions{Reverse: reverse}
+	if it, err := ds.db.KeysOnlyIterator(options); err == nil {
+		return it
+	}
+	return ds.db.NewIterator(options)
+}
+
+// liveValue 读取key对应的value，key不存在、已经过期或者是空的集合类型时返回ErrKeyNotFound
+// string和其它类型的元数据都以type+expire开头
+func (ds *DataStructure) liveValue(key []byte) ([]byte, error) {
+	encValue, err := ds.db.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(encValue) == 0 {
+		return nil, JDawDB.ErrKeyNotFound
+	}
+	expire, _ := binary.Varint(encValue[1:])
+	if expire > 0 && expire <= time.Now().UnixNano() {
+		return nil, JDawDB.ErrKeyNotFound
+	}
+	if isMetadataType(encValue[0]) && decode(encValue).size == 0 {
+		return nil, JDawDB.ErrKeyNotFound
+	}
+	return encValue, nil
+}
+
+// newWriteBatch 初始化WriteBatch，写入数量超过默认上限时放大上限
+func (ds *DataStructure) newWriteBatch(n int) *JDawDB.WriteBatch {
+	opts := JDawDB.DefaultWriteBatchOptions
+	if uint(n) > opts.MaxBatchNum {
+		opts.MaxBatchNum = uint(n)
+	}
+	return ds.db.NewWriteBatch(opts)
+}
+
+// scanInternalKeys 按顺序遍历前缀为prefix的数据部分，fn收到的是去掉前缀之后的部分，返回false时停止遍历
+func (ds *DataStructure) scanInternalKeys(prefix []byte, withValue bool, fn func(suffix, value []byte) bool) error {
+	return ds.scanInternalKeysFrom(prefix, prefix, false, withValue, fn)
+}
+
+// scanInternalKeysFrom 从seek开始遍历前缀为prefix的数据部分，reverse为true时从小于等于seek的第一个key开始向前遍历
+// 迭代器直接在索引上遍历，遍历期间被删除的key会被跳过
+func (ds *DataStructure) scanInternalKeysFrom(prefix, seek []byte, reverse, withValue bool, fn func(suffix, value []byte) bool) error {
+	//迭代器不设置Prefix，否则离开前缀之后Next会一直扫描到最后
+	it := ds.newKeyIterator(reverse)
+	defer it.Close()
+
+	//离开前缀之后就结束
+	for it.Seek(seek); it.Valid(); it.Next() {
+		key := it.Key()
+		if !bytes.HasPrefix(key, prefix) {
+			//反向遍历时seek可能在前缀范围之后，需要跳过两者之间的key
+			if reverse && bytes.Compare(key, prefix) > 0 {
+				continue
+			}
+			break
+		}
+		var value []byte
+		if withValue {
+			var err error
+			value, err = ds.db.Get(key)
+			if err == JDawDB.ErrKeyNotFound {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+		}
+		//复制一份，避免调用方修改索引中的key
+		suffix := append([]byte(nil), key[len(prefix):]...)
+		if !fn(suffix, value) {
+			break
+		}
+	}
+	return nil
+}
+
+// scanByCursor 从cursor之后开始最多遍历count个数据，返回下一次遍历的游标，遍历完成时返回nil
+// 游标是最后一个遍历过的数据去掉前缀之后的部分，cursor为nil时从头开始，遍历期间写入或者删除其它数据不会导致遗漏
+func (ds *DataStructure) scanByCursor(prefix, cursor []byte, count int, withValue bool, fn func(suffix, value []byte)) ([]byte, error) {
+	if count <= 0 {
+		count = defaultScanCount
+	}
+	seek := append(append([]byte(nil), prefix...), cursor...)
+	var examined int
+	var last []byte
+	var hasMore bool
+	var readErr error
+	err := ds.scanInternalKeysFrom(prefix, seek, false, false, func(suffix, _ []byte) bool {
+		//游标本身已经在上一次返回过
+		if cursor != nil && bytes.Equal(suffix, cursor) {
+			return true
+		}
+		if examined == count {
+			hasMore = true
+			return false
+		}
+		examined++
+		last = suffix
+		//只读取需要返回的部分的value
+		var value []byte
+		if withValue {
+			internalKey := append(append([]byte(nil), prefix...), suffix...)
+			if value, readErr = ds.db.Get(internalKey); readErr != nil {
+				return false
+			}
+		}
+		fn(suffix, value)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	if readErr != nil {
+		return nil, readErr
+	}
+	if !hasMore {
+		return nil, nil
+	}
+	return last, nil
+}
+
+// normalizeRange 将可以为负数的[start, stop]转换为[0, size)中的范围，范围为空时返回false
+func normalizeRange(start, stop, size int64) (int64, int64, bool) {
+	if start < 0 {
+		start += size
+	}
+	if stop < 0 {
+		stop += size
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= size {
+		stop = size - 1
+	}
+	if start > stop || start >= size {
+		return 0, 0, false
+	}
+	return start, stop, true
+}
+
+// globMatch 按照redis的规则匹配通配符，支持*、?、[abc]、[^a-z]以及\转义
+func globMatch(pattern, str []byte) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			//合并连续的*
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 1 {
+				return true
+			}
+			for i := 0; i <= len(str); i++ {
+				if globMatch(pattern[1:], str[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(str) == 0 {
+				return false
+			}
+			str = str[1:]
+		case '[':
+			if len(str) == 0 {
+				return false
+			}
+			var matched bool
+			if matched, pattern = matchClass(pattern[1:], str[0]); !matched {
+				return false
+			}
+			str = str[1:]
+			continue
+		default:
+			if pattern[0] == '\\' && len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+			if len(str) == 0 || pattern[0] != str[0] {
+				return false
+			}
+			str = str[1:]
+		}
+		pattern = pattern[1:]
+	}
+	return len(str) == 0
+}
+
+// matchClass 匹配[]中的字符集合，pattern从[之后开始，返回是否匹配以及]之后剩余的pattern
+func matchClass(pattern []byte, c byte) (bool, []byte) {
+	var not, matched bool
+	if len(pattern) > 0 && pattern[0] == '^' {
+		not = true
+		pattern = pattern[1:]
+	}
+	for len(pattern) > 0 && pattern[0] != ']' {
+		switch {
+		case pattern[0] == '\\' && len(pattern) > 1:
+			pattern = pattern[1:]
+			if pattern[0] == c {
+				matched = true
+			}
+		case len(pattern) > 2 && pattern[1] == '-' && pattern[2] != ']':
+			start, end := pattern[0], pattern[2]
+			if start > end {
+				start, end = end, start
+			}
+			if c >= start && c <= end {
+				matched = true
+			}
+			pattern = pattern[2:]
+		default:
+			if pattern[0] == c {
+				matched = true
+			}
+		}
+		pattern = pattern[1:]
+	}
+	//跳过]，没有]时把剩余部分都当作字符集合
+	if len(pattern) > 0 {
+		pattern = pattern[1:]
+	}
+	return matched != not, pattern
+}
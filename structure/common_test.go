@@ -0,0 +1,241 @@
+package structure
+
+import (
+	"fmt"
+	"github.com/GrandeLai/JDawDB"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestDataStructure_Expire(t *testing.T) {
+	opts := JDawDB.DefaultOptions
+	dir, _ := os.MkdirTemp("", "JDawDB-redis-expire")
+	opts.DirPath = dir
+	ds, err := NewDataStructure(opts)
+	assert.Nil(t, err)
+
+	ttl, err := ds.TTL([]byte("str"))
+	assert.Nil(t, err)
+	assert.Equal(t, KeyNotExist, ttl)
+	ok, err := ds.Expire([]byte("str"), time.Second)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	err = ds.Set([]byte("str"), 0, []byte("v"))
+	assert.Nil(t, err)
+	_, err = ds.HSet([]byte("hash"), []byte("f"), []byte("v"))
+	assert.Nil(t, err)
+	_, err = ds.RPush([]byte("list"), []byte("e"))
+	assert.Nil(t, err)
+
+	for _, key := range []string{"str", "hash", "list"} {
+		ttl, err = ds.TTL([]byte(key))
+		assert.Nil(t, err)
+		assert.Equal(t, KeyNoExpire, ttl)
+
+		ok, err = ds.Expire([]byte(key), time.Minute)
+		assert.Nil(t, err)
+		assert.True(t, ok)
+		ttl, err = ds.TTL([]byte(key))
+		assert.Nil(t, err)
+		assert.True(t, ttl > 0 && ttl <= time.Minute)
+
+		ok, err = ds.Persist([]byte(key))
+		assert.Nil(t, err)
+		assert.True(t, ok)
+		ok, err = ds.Persist([]byte(key))
+		assert.Nil(t, err)
+		assert.False(t, ok)
+	}
+	val, err := ds.Get([]byte("str"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v"), val)
+	val, err = ds.HGet([]byte("hash"), []byte("f"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v"), val)
+
+	// 过去的时间直接删除
+	ok, err = ds.ExpireAt([]byte("hash"), time.Now().Add(-time.Second))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	n, err := ds.Exists([]byte("str"), []byte("hash"), []byte("str"))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, n)
+
+	ok, err = ds.Expire([]byte("str"), time.Millisecond*10)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	time.Sleep(time.Millisecond * 20)
+	n, err = ds.Exists([]byte("str"))
+	assert.Nil(t, err)
+	assert.Equal(t, 0, n)
+}
+
+func TestDataStructure_Rename(t *testing.T) {
+	opts := JDawDB.DefaultOptions
+	dir, _ := os.MkdirTemp("", "JDawDB-redis-rename")
+	opts.DirPath = dir
+	ds, err := NewDataStructure(opts)
+	assert.Nil(t, err)
+
+	err = ds.Rename([]byte("missing"), []byte("new"))
+	assert.Equal(t, ErrNoSuchKey, err)
+
+	_, err = ds.HSet([]byte("hash"), []byte("f"), []byte("v"))
+	assert.Nil(t, err)
+	for _, e := range []string{"a", "b", "c"} {
+		_, err = ds.RPush([]byte("list"), []byte(e))
+		assert.Nil(t, err)
+	}
+	// 覆盖已经存在的key
+	err = ds.Set([]byte("list2"), 0, []byte("v"))
+	assert.Nil(t, err)
+
+	err = ds.Rename([]byte("hash"), []byte("hash2"))
+	assert.Nil(t, err)
+	err = ds.Rename([]byte("list"), []byte("list2"))
+	assert.Nil(t, err)
+
+	val, err := ds.HGet([]byte("hash"), []byte("f"))
+	assert.Nil(t, err)
+	assert.Nil(t, val)
+	val, err = ds.HGet([]byte("hash2"), []byte("f"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v"), val)
+
+	elements, err := ds.LRange([]byte("list2"), 0, -1)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("a"), []byte("b"), []byte("c")}, elements)
+	n, err := ds.Exists([]byte("hash"), []byte("list"))
+	assert.Nil(t, err)
+	assert.Equal(t, 0, n)
+}
+
+func TestDataStructure_Keys_Scan(t *testing.T) {
+	opts := JDawDB.DefaultOptions
+	dir, _ := os.MkdirTemp("", "JDawDB-redis-keys")
+	opts.DirPath = dir
+	ds, err := NewDataStructure(opts)
+	assert.Nil(t, err)
+
+	key, err := ds.RandomKey()
+	assert.Nil(t, err)
+	assert.Nil(t, key)
+
+	err = ds.Set([]byte("user:1"), 0, []byte("v"))
+	assert.Nil(t, err)
+	_, err = ds.HSet([]byte("user:2"), []byte("f"), []byte("v"))
+	assert.Nil(t, err)
+	_, err = ds.SAdd([]byte("user:3"), []byte("m"))
+	assert.Nil(t, err)
+	_, err = ds.RPush([]byte("order:1"), []byte("e"))
+	assert.Nil(t, err)
+	_, err = ds.ZAdd([]byte("order:2"), 1, []byte("m"))
+	assert.Nil(t, err)
+	err = ds.Set([]byte("expired"), time.Millisecond, []byte("v"))
+	assert.Nil(t, err)
+	time.Sleep(time.Millisecond * 5)
+
+	// 数据部分和过期的key不会被返回
+	keys, err := ds.Keys("*")
+	assert.Nil(t, err)
+	assert.Equal(t, 5, len(keys))
+	keys, err = ds.Keys("user:*")
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("user:1"), []byte("user:2"), []byte("user:3")}, keys)
+
+	key, err = ds.RandomKey()
+	assert.Nil(t, err)
+	assert.NotNil(t, key)
+
+	var all []string
+	var cursor []byte
+	for {
+		var res [][]byte
+		cursor, res, err = ds.Scan(cursor, "", 2, "")
+		assert.Nil(t, err)
+		for _, k := range res {
+			all = append(all, string(k))
+		}
+		if cursor == nil {
+			break
+		}
+	}
+	sort.Strings(all)
+	assert.Equal(t, []string{"order:1", "order:2", "user:1", "user:2", "user:3"}, all)
+
+	cursor, keys, err = ds.Scan(nil, "*", 100, "hash")
+	assert.Nil(t, err)
+	assert.Nil(t, cursor)
+	assert.Equal(t, [][]byte{[]byte("user:2")}, keys)
+
+	typ, err := ds.Type([]byte("order:2"))
+	assert.Nil(t, err)
+	assert.Equal(t, "zset", TypeName(typ))
+}
+
+func TestDataStructure_Scan_Resume(t *testing.T) {
+	opts := JDawDB.DefaultOptions
+	dir, _ := os.MkdirTemp("", "JDawDB-scan-resume")
+	opts.DirPath = dir
+	ds, err := NewDataStructure(opts)
+	assert.Nil(t, err)
+
+	for i := 0; i < 20; i++ {
+		for j := 0; j < 5; j++ {
+			_, err = ds.HSet([]byte(fmt.Sprintf("h:%02d", i)), []byte(fmt.Sprintf("f%d", j)), []byte("v"))
+			assert.Nil(t, err)
+		}
+	}
+
+	//游标落在集合类型上时跳过它的数据部分，删除已经返回的key不影响后面的遍历
+	var all []string
+	var cursor []byte
+	for {
+		var res [][]byte
+		cursor, res, err = ds.Scan(cursor, "", 3, "")
+		assert.Nil(t, err)
+		for _, k := range res {
+			all = append(all, string(k))
+			assert.Nil(t, ds.Del(k))
+		}
+		if cursor == nil {
+			break
+		}
+	}
+	assert.Equal(t, 20, len(all))
+	assert.Equal(t, "h:00", all[0])
+	assert.Equal(t, "h:19", all[19])
+}
+
+func TestGlobMatch(t *testing.T) {
+	cases := []struct {
+		pattern string
+		str     string
+		match   bool
+	}{
+		{"*", "", true},
+		{"*", "anything", true},
+		{"h?llo", "hello", true},
+		{"h?llo", "hllo", false},
+		{"h*llo", "heeeello", true},
+		{"h*llo", "hell", false},
+		{"h[ae]llo", "hallo", true},
+		{"h[ae]llo", "hillo", false},
+		{"h[^e]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-b]llo", "hbllo", true},
+		{"h[a-b]llo", "hcllo", false},
+		{"h\\*llo", "h*llo", true},
+		{"h\\*llo", "hello", false},
+		{"user:*:name", "user:1:name", true},
+		{"user:*:name", "user:1:age", false},
+		{"a/*", "a/b/c", true},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.match, globMatch([]byte(c.pattern), []byte(c.str)), c.pattern+" "+c.str)
+	}
+}
@@ -0,0 +1,319 @@
+package structure
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/GrandeLai/JDawDB"
+	"time"
+)
+
+// gcKeyPrefix 回收队列的key前缀，队列中每一项的key为gcKeyPrefix+被废弃的数据部分前缀(key+version)，value为所属的key
+var gcKeyPrefix = []byte("\x00jdaw-gc\x00")
+
+// gcBatchSize 回收时每个WriteBatch删除的key数量
+const gcBatchSize = 1000
+
+// garbage 一个等待回收的数据部分
+type garbage struct {
+	key     []byte
+	version int64
+	queued  bool //是否来自回收队列，否则来自过期的元数据
+}
+
+func (g *garbage) prefix() []byte {
+	return internalKeyPrefix(g.key, g.version)
+}
+
+// addGarbage 将meta对应版本的数据部分加入回收队列，writebatch为nil时直接写入
+func (ds *DataStructure) addGarbage(writebatch *JDawDB.WriteBatch, key []byte, meta *metadata) error {
+	gcKey := append(append([]byte(nil), gcKeyPrefix...), internalKeyPrefix(key, meta.version)...)
+	if writebatch != nil {
+		return writebatch.Put(gcKey, key)
+	}
+	return ds.db.Put(gcKey, key)
+}
+
+// putMetadata 在writebatch中写入元数据，替换了过期的旧版本时同时把旧版本加入回收队列
+func (ds *DataStructure) putMetadata(writebatch *JDawDB.WriteBatch, key []byte, meta *metadata) {
+	if meta.replaced != nil {
+		_ = ds.addGarbage(writebatch, key, meta.replaced)
+		meta.replaced = nil
+	}
+	_ = writebatch.Put(key, meta.encode())
+}
+
+// markGarbage key将要在writebatch中被删除或者覆盖，如果它是集合类型（包括已经过期的），将它的数据部分加入回收队列
+func (ds *DataStructure) markGarbage(writebatch *JDawDB.WriteBatch, key []byte) error {
+	encValue, err := ds.db.Get(key)
+	if err == JDawDB.ErrKeyNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if meta, ok := parseMetadata(encValue); ok {
+		return ds.addGarbage(writebatch, key, meta)
+	}
+	return nil
+}
+
+// replaceKey 用value覆盖key，value为nil时删除key，原来是集合类型时在同一个WriteBatch中记录回收队列
+func (ds *DataStructure) replaceKey(key, value []byte) error {
+	encValue, err := ds.db.Get(key)
+	if err != nil && err != JDawDB.ErrKeyNotFound {
+		return err
+	}
+	meta, ok := parseMetadata(encValue)
+	if !ok {
+		if value == nil {
+			return ds.db.Delete(key)
+		}
+		return ds.db.Put(key, value)
+	}
+
+	writebatch := ds.db.NewWriteBatch(JDawDB.DefaultWriteBatchOptions)
+	_ = ds.addGarbage(writebatch, key, meta)
+	if value == nil {
+		_ = writebatch.Delete(key)
+	} else {
+		_ = writebatch.Put(key, value)
+	}
+	return writebatch.Commit()
+}
+
+// CollectGarbage 删除已经废弃的数据部分，返回删除的key数量
+// 废弃的数据部分有三个来源：删除、覆盖集合类型时记录的回收队列，仍然存在但已经过期的元数据，
+// 以及版本和当前元数据不一致、也不在回收队列中的数据部分，例如过期之后被重新写入的集合类型留下的旧版本
+// 过期的元数据会和它的数据部分一起删除
+func (ds *DataStructure) CollectGarbage() (int, error) {
+	var targets []*garbage
+	err := ds.scanInternalKeys(gcKeyPrefix, true, func(suffix, value []byte) bool {
+		//suffix为key+version
+		if len(suffix) == len(value)+8 && bytes.HasPrefix(suffix, value) {
+			targets = append(targets, &garbage{
+				key:     value,
+				version: int64(binary.LittleEndian.Uint64(suffix[len(value):])),
+				queued:  true,
+			})
+		}
+		return true
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	//遍历时不属于任何元数据的key攒够一批就删除
+	var reclaimed int
+	var orphans []*orphan
+	var orphanErr error
+	collectOrphans := func() {
+		var n int
+		n, orphanErr = ds.collectOrphans(orphans)
+		reclaimed += n
+		orphans = orphans[:0]
+	}
+	now := time.Now().UnixNano()
+	err = ds.walk(nil, func(key, encValue []byte, expired bool) bool {
+		if meta, ok := parseMetadata(encValue); ok && expired {
+			targets = append(targets, &garbage{key: key, version: meta.version})
+		}
+		return true
+	}, func(key []byte) bool {
+		if o := findOrphan(key, now); o != nil {
+			orphans = append(orphans, o)
+		}
+		if len(orphans) == gcBatchSize {
+			collectOrphans()
+		}
+		return orphanErr == nil
+	})
+	if err != nil {
+		return reclaimed, err
+	}
+	if orphanErr == nil {
+		collectOrphans()
+	}
+	if orphanErr != nil {
+		return reclaimed, orphanErr
+	}
+
+	for _, g := range targets {
+		n, err := ds.collect(g)
+		reclaimed += n
+		if err != nil {
+			return reclaimed, err
+		}
+	}
+	return reclaimed, nil
+}
+
+// collect 删除一个废弃版本的数据部分，版本仍然在使用时只移除回收队列中的记录
+func (ds *DataStructure) collect(g *garbage) (int, error) {
+	//版本不再使用或者已经过期之后不会再被写入，删除数据部分时不需要加锁
+	inUse, expired, err := ds.versionState(g.key, g.version)
+	if err != nil {
+		return 0, err
+	}
+
+	var reclaimed int
+	prefix := g.prefix()
+	if !inUse || expired {
+		//每次从头遍历，删除之后剩下的key会排到前面
+		for {
+			var keys [][]byte
+			err = ds.scanInternalKeys(prefix, false, func(suffix, _ []byte) bool {
+				keys = append(keys, append(append([]byte(nil), prefix...), suffix...))
+				return len(keys) < gcBatchSize
+			})
+			if err != nil {
+				return reclaimed, err
+			}
+			if len(keys) == 0 {
+				break
+			}
+			writebatch := ds.newWriteBatch(len(keys))
+			for _, key := range keys {
+				_ = writebatch.Delete(key)
+			}
+			if err = writebatch.Commit(); err != nil {
+				return reclaimed, err
+			}
+			reclaimed += len(keys)
+		}
+	}
+
+	writebatch := ds.db.NewWriteBatch(JDawDB.DefaultWriteBatchOptions)
+	if g.queued {
+		_ = writebatch.Delete(append(append([]byte(nil), gcKeyPrefix...), prefix...))
+	}
+	if !g.queued || expired {
+		//删除过期的元数据时持有和写入相同的锁，避免删除期间重新写入的元数据被删除
+		unlock := ds.lockKeys(g.key)
+		defer unlock()
+		if inUse, expired, err = ds.versionState(g.key, g.version); err != nil {
+			return reclaimed, err
+		}
+		if inUse && expired {
+			_ = writebatch.Delete(g.key)
+		}
+	}
+	return reclaimed, writebatch.Commit()
+}
+
+// gcMinVersion 版本是创建时的UnixNano，早于这个时间的版本不会出现
+var gcMinVersion = time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC).UnixNano()
+
+// orphan 不属于任何元数据和回收队列的key，splits是它可能所属的key和版本
+type orphan struct {
+	key    []byte
+	splits []garbage
+}
+
+// findOrphan 找出key所有可能的key+version+后缀的拆分方式，version必须是一个合理的时间，没有时返回nil
+func findOrphan(key []byte, now int64) *orphan {
+	var splits []garbage
+	for i := 1; i+8 <= len(key); i++ {
+		version := int64(binary.LittleEndian.Uint64(key[i : i+8]))
+		if version >= gcMinVersion && version <= now {
+			splits = append(splits, garbage{key: key[:i], version: version})
+		}
+	}
+	if len(splits) == 0 {
+		return nil
+	}
+	return &orphan{key: append([]byte(nil), key...), splits: splits}
+}
+
+// isSubKey key是否符合集合类型数据部分的结构：key+version+后缀，并且拆分出的key现在是集合类型
+// 数据部分的value可以是任意内容，只能根据key的结构判断
+func (ds *DataStructure) isSubKey(key []byte, now int64) (bool, error) {
+	o := findOrphan(key, now)
+	if o == nil {
+		return false, nil
+	}
+	for _, split := range o.splits {
+		encValue, err := ds.db.Get(split.key)
+		if err == JDawDB.ErrKeyNotFound {
+			continue
+		}
+		if err != nil {
+			return false, err
+		}
+		if _, ok := parseMetadata(encValue); ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// collectOrphans 在所有可能所属的key的锁下确认版本都不是当前的版本，然后删除orphans
+func (ds *DataStructure) collectOrphans(orphans []*orphan) (int, error) {
+	if len(orphans) == 0 {
+		return 0, nil
+	}
+	var keys [][]byte
+	for _, o := range orphans {
+		for _, split := range o.splits {
+			keys = append(keys, split.key)
+		}
+	}
+	unlock := ds.lockKeys(keys...)
+	defer unlock()
+
+	writebatch := ds.newWriteBatch(len(orphans))
+	var reclaimed int
+	for _, o := range orphans {
+		var inUse bool
+		for _, split := range o.splits {
+			var err error
+			if inUse, _, err = ds.versionState(split.key, split.version); err != nil {
+				return 0, err
+			}
+			if inUse {
+				break
+			}
+		}
+		if !inUse {
+			_ = writebatch.Delete(o.key)
+			reclaimed++
+		}
+	}
+	return reclaimed, writebatch.Commit()
+}
+
+// versionState 返回key当前的元数据是否是version这个版本，以及是否已经过期
+func (ds *DataStructure) versionState(key []byte, version int64) (bool, bool, error) {
+	encValue, err := ds.db.Get(key)
+	if err == JDawDB.ErrKeyNotFound {
+		return false, false, nil
+	}
+	if err != nil {
+		return false, false, err
+	}
+	meta, ok := parseMetadata(encValue)
+	if !ok || meta.version != version {
+		return false, false, nil
+	}
+	return true, meta.expire > 0 && meta.expire <= time.Now().UnixNano(), nil
+}
+
+// StartGC 在后台每隔interval回收一次废弃的数据部分，直到关闭，每次回收之后调用report（可以为nil）
+func (ds *DataStructure) StartGC(interval time.Duration, report func(reclaimed int, err error)) {
+	ds.bgWg.Add(1)
+	go func() {
+		defer ds.bgWg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				reclaimed, err := ds.CollectGarbage()
+				if report != nil {
+					report(reclaimed, err)
+				}
+			case <-ds.closed:
+				return
+			}
+		}
+	}()
+}
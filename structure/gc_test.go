@@ -0,0 +1,203 @@
+package structure
+
+import (
+	"fmt"
+	"github.com/GrandeLai/JDawDB"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestDataStructure_CollectGarbage(t *testing.T) {
+	opts := JDawDB.DefaultOptions
+	dir, _ := os.MkdirTemp("", "JDawDB-redis-gc")
+	opts.DirPath = dir
+	ds, err := NewDataStructure(opts)
+	assert.Nil(t, err)
+
+	// 数量超过一个批次
+	for i := 0; i < gcBatchSize+10; i++ {
+		_, err = ds.HSet([]byte("deleted"), []byte(fmt.Sprintf("field-%d", i)), []byte("v"))
+		assert.Nil(t, err)
+	}
+	err = ds.Del([]byte("deleted"))
+	assert.Nil(t, err)
+
+	// 被string覆盖
+	_, err = ds.SAdd([]byte("overwritten"), []byte("m"))
+	assert.Nil(t, err)
+	err = ds.Set([]byte("overwritten"), 0, []byte("v"))
+	assert.Nil(t, err)
+
+	// 过期之后没有再写入
+	_, err = ds.RPush([]byte("expired"), []byte("a"))
+	assert.Nil(t, err)
+	_, err = ds.RPush([]byte("expired"), []byte("b"))
+	assert.Nil(t, err)
+	// 过期之后重新写入
+	_, err = ds.ZAdd([]byte("rewritten"), 1, []byte("old"))
+	assert.Nil(t, err)
+	_, err = ds.Expire([]byte("expired"), time.Millisecond)
+	assert.Nil(t, err)
+	_, err = ds.Expire([]byte("rewritten"), time.Millisecond)
+	assert.Nil(t, err)
+	time.Sleep(time.Millisecond * 5)
+	_, err = ds.ZAdd([]byte("rewritten"), 2, []byte("new"))
+	assert.Nil(t, err)
+
+	// 仍然存在的数据不会被回收
+	_, err = ds.HSet([]byte("live"), []byte("f"), []byte("v"))
+	assert.Nil(t, err)
+
+	keys, err := ds.Keys("*")
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(keys))
+
+	reclaimed, err := ds.CollectGarbage()
+	assert.Nil(t, err)
+	// hash的field、set的member、list的两个元素，zset的member部分和score部分
+	assert.Equal(t, gcBatchSize+10+1+2+2, reclaimed)
+
+	reclaimed, err = ds.CollectGarbage()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, reclaimed)
+
+	_, err = ds.db.Get([]byte("expired"))
+	assert.Equal(t, JDawDB.ErrKeyNotFound, err)
+	val, err := ds.HGet([]byte("live"), []byte("f"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v"), val)
+	members, err := ds.ZRange([]byte("rewritten"), 0, -1)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(members))
+	assert.Equal(t, []byte("new"), members[0].Member)
+
+	// 只剩下三个key的数据：live的元数据和field，overwritten，rewritten的元数据和两个部分，以及zset和list编码的标记
+	assert.Equal(t, 8, len(ds.db.ListKeys()))
+}
+
+func TestDataStructure_CollectGarbage_Orphans(t *testing.T) {
+	opts := JDawDB.DefaultOptions
+	dir, _ := os.MkdirTemp("", "JDawDB-redis-gc-orphans")
+	opts.DirPath = dir
+	ds, err := NewDataStructure(opts)
+	assert.Nil(t, err)
+
+	// 旧版本的Del只删除元数据，数据部分不在回收队列中，数量超过一个批次
+	for i := 0; i < gcBatchSize+10; i++ {
+		_, err = ds.SAdd([]byte("old-del"), []byte(fmt.Sprintf("member-%d", i)))
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, ds.db.Delete([]byte("old-del")))
+
+	// 过期之后被string和HyperLogLog覆盖
+	_, err = ds.HSet([]byte("counter"), []byte("f"), []byte("v"))
+	assert.Nil(t, err)
+	_, err = ds.RPush([]byte("hll"), []byte("a"))
+	assert.Nil(t, err)
+	_, err = ds.Expire([]byte("counter"), time.Millisecond)
+	assert.Nil(t, err)
+	_, err = ds.Expire([]byte("hll"), time.Millisecond)
+	assert.Nil(t, err)
+	time.Sleep(time.Millisecond * 5)
+	_, err = ds.Incr([]byte("counter"))
+	assert.Nil(t, err)
+	_, err = ds.PFAdd([]byte("hll"), []byte("x"))
+	assert.Nil(t, err)
+
+	// 仍然存在的数据不会被回收
+	_, err = ds.SAdd([]byte("old-del-live"), []byte("m"))
+	assert.Nil(t, err)
+
+	reclaimed, err := ds.CollectGarbage()
+	assert.Nil(t, err)
+	assert.Equal(t, gcBatchSize+10+1+1, reclaimed)
+	reclaimed, err = ds.CollectGarbage()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, reclaimed)
+
+	ok, err := ds.SIsMember([]byte("old-del-live"), []byte("m"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	val, err := ds.Get([]byte("counter"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("1"), val)
+	// counter、hll、old-del-live的元数据和member，以及zset和list编码的标记
+	assert.Equal(t, 6, len(ds.db.ListKeys()))
+}
+
+// 数据部分的value和string的编码一样时，仍然要按照key的结构识别出来
+func TestDataStructure_CollectGarbage_StringLikeSubKey(t *testing.T) {
+	opts := JDawDB.DefaultOptions
+	dir, _ := os.MkdirTemp("", "JDawDB-redis-gc-string-like")
+	opts.DirPath = dir
+	ds, err := NewDataStructure(opts)
+	assert.Nil(t, err)
+
+	// 过期之后重新写入，旧版本在写入时加入回收队列
+	_, err = ds.HSet([]byte("h"), []byte("f"), []byte("\x00\x00abc"))
+	assert.Nil(t, err)
+	_, err = ds.SetBit([]byte("bits"), 3, 1)
+	assert.Nil(t, err)
+	_, err = ds.Expire([]byte("h"), time.Millisecond)
+	assert.Nil(t, err)
+	_, err = ds.Expire([]byte("bits"), time.Millisecond)
+	assert.Nil(t, err)
+	time.Sleep(time.Millisecond * 5)
+	_, err = ds.HSet([]byte("h"), []byte("g"), []byte("v"))
+	assert.Nil(t, err)
+	_, err = ds.SetBit([]byte("bits"), 5, 1)
+	assert.Nil(t, err)
+
+	// 旧版本写入的、不在回收队列中的数据部分
+	meta, err := ds.findMetadata([]byte("h"), Hash)
+	assert.Nil(t, err)
+	oldField := (&HashInternalKey{key: []byte("h"), version: meta.version - 1, field: []byte("old")}).encode()
+	assert.Nil(t, ds.db.Put(oldField, []byte("\x00\x00abc")))
+
+	keys, err := ds.Keys("*")
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("bits"), []byte("h")}, keys)
+	_, err = ds.Type(oldField)
+	assert.Equal(t, JDawDB.ErrKeyNotFound, err)
+
+	// h和bits旧版本的field和分段，以及没有在回收队列中的field
+	reclaimed, err := ds.CollectGarbage()
+	assert.Nil(t, err)
+	assert.Equal(t, 3, reclaimed)
+	_, err = ds.db.Get(oldField)
+	assert.Equal(t, JDawDB.ErrKeyNotFound, err)
+
+	val, err := ds.HGet([]byte("h"), []byte("g"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v"), val)
+	_, err = ds.HGet([]byte("h"), []byte("f"))
+	assert.Equal(t, JDawDB.ErrKeyNotFound, err)
+	bit, err := ds.GetBit([]byte("bits"), 3)
+	assert.Nil(t, err)
+	assert.Equal(t, byte(0), bit)
+	assert.Nil(t, ds.Close())
+	_ = os.RemoveAll(dir)
+}
+
+func TestDataStructure_StartGC(t *testing.T) {
+	opts := JDawDB.DefaultOptions
+	dir, _ := os.MkdirTemp("", "JDawDB-redis-start-gc")
+	opts.DirPath = dir
+	ds, err := NewDataStructure(opts)
+	assert.Nil(t, err)
+
+	_, err = ds.HSet([]byte("hash"), []byte("f"), []byte("v"))
+	assert.Nil(t, err)
+	err = ds.Del([]byte("hash"))
+	assert.Nil(t, err)
+
+	reports := make(chan int, 10)
+	ds.StartGC(time.Millisecond*10, func(reclaimed int, err error) {
+		assert.Nil(t, err)
+		reports <- reclaimed
+	})
+	assert.Equal(t, 1, <-reports)
+	assert.Nil(t, ds.Close())
+}
@@ -8,17 +8,18 @@ import (
 
 const (
 	maxMetadataSize       = 1 + binary.MaxVarintLen64*2 + binary.MaxVarintLen32
-	extraListMetadataSize = binary.MaxVarintLen32 * 2
+	extraListMetadataSize = binary.MaxVarintLen64 * 2
 	initialListMark       = math.MaxUint64 / 2
 )
 
 type metadata struct {
-	dataType byte   //数据类型
-	expire   int64  //过期时间
-	version  int64  //版本，用于快速删除
-	size     uint32 //key下的数据数量
-	head     uint64 //list数据结构专有
-	tail     uint64 //list数据结构专有
+	dataType byte      //数据类型
+	expire   int64     //过期时间
+	version  int64     //版本，用于快速删除
+	size     uint32    //key下的数据数量
+	head     uint64    //list数据结构专有
+	tail     uint64    //list数据结构专有
+	replaced *metadata //findMetadata替换掉的已经过期的旧版本，写入元数据时加入回收队列
 }
 
 // 元数据编码为字节数组
@@ -72,6 +73,49 @@ func decode(buf []byte) *metadata {
 	}
 }
 
+// isMetadataType 类型是否使用元数据+数据部分存放，string和hyperloglog直接存放在value中
+func isMetadataType(dataType DataType) bool {
+	return dataType >= Hash && dataType <= Bitmap
+}
+
+// parseMetadata 判断buf是否是hash、set、list、zset或bitmap的元数据，decode遇到格式错误的数据会panic，需要先检查
+func parseMetadata(buf []byte) (*metadata, bool) {
+	if len(buf) == 0 || !isMetadataType(buf[0]) {
+		return nil, false
+	}
+	var index = 1
+	//expire、version和size
+	for i := 0; i < 3; i++ {
+		_, n := binary.Varint(buf[index:])
+		if n <= 0 {
+			return nil, false
+		}
+		index += n
+	}
+	//list的head和tail
+	if buf[0] == List {
+		for i := 0; i < 2; i++ {
+			_, n := binary.Uvarint(buf[index:])
+			if n <= 0 {
+				return nil, false
+			}
+			index += n
+		}
+	}
+	if index != len(buf) {
+		return nil, false
+	}
+	return decode(buf), true
+}
+
+// internalKeyPrefix 数据部分的key共有的前缀：key+version，用于遍历某个版本下的所有数据
+func internalKeyPrefix(key []byte, version int64) []byte {
+	buf := make([]byte, len(key)+8)
+	copy(buf, key)
+	binary.LittleEndian.PutUint64(buf[len(key):], uint64(version))
+	return buf
+}
+
 // HashInternalKey hash实际放入的key
 type HashInternalKey struct {
 	key     []byte
@@ -124,11 +168,16 @@ func (sk *SetInternalKey) encode() []byte {
 	return buf
 }
 
+// decodeSetMember 从去掉前缀之后的部分中取出member，最后4个字节是member的长度
+func decodeSetMember(suffix []byte) []byte {
+	return suffix[:len(suffix)-4]
+}
+
 // ListInternalKey list实际放入的key
 type ListInternalKey struct {
 	key     []byte
 	version int64  //固定编码存放，占8位
-	index   uint64 //占8位
+	index   uint64 //大端编码存放，占8位，保证数据部分按照index排序
 }
 
 func (lk *ListInternalKey) encode() []byte {
@@ -144,64 +193,83 @@ func (lk *ListInternalKey) encode() []byte {
 	index += 8
 
 	//index
-	binary.LittleEndian.PutUint32(buf[index:], uint32(lk.index))
+	binary.BigEndian.PutUint64(buf[index:], lk.index)
 
 	return buf
 }
 
+// zset的数据部分有两份，在version之后用一个字节区分
+const (
+	zsetMemberTag byte = iota //key+version+tag+member，value是score
+	zsetScoreTag              //key+version+tag+score+member，value为空，按照score排序
+)
+
 // ZSetInternalKey zset实际放入的key
 type ZSetInternalKey struct {
 	key     []byte
 	version int64 //固定编码存放，占8位
 	member  []byte
-	score   float64
+	score   float64 //编码为可以按字节比较的8个字节
 }
 
 // 因为zset的数据部分有两份，所以需要两个encode方法
 
 func (zk *ZSetInternalKey) encodeWithScore() []byte {
-	//需要先将score转换为一个字节数组
-	scoreBuf := utils.Float64ToBytes(zk.score)
-	buf := make([]byte, len(zk.key)+len(zk.member)+len(scoreBuf)+8+4) //4字节存储member长度
+	prefix := zsetPrefix(zk.key, zk.version, zsetScoreTag)
+	buf := make([]byte, len(prefix)+8+len(zk.member))
 
-	//key
+	//key+version+tag
 	var index = 0
-	copy(buf[index:index+len(zk.key)], zk.key)
-	index += len(zk.key)
+	copy(buf[index:index+len(prefix)], prefix)
+	index += len(prefix)
 
-	//version
-	binary.LittleEndian.PutUint64(buf[index:index+8], uint64(zk.version))
+	//score
+	copy(buf[index:index+8], utils.Float64ToOrderedBytes(zk.score))
 	index += 8
 
-	//score
-	copy(buf[index:index+len(scoreBuf)], scoreBuf)
-	index += len(scoreBuf)
+	//member，score是定长的，不需要再存储member的长度
+	copy(buf[index:], zk.member)
 
-	//member
-	copy(buf[index:index+len(zk.member)], zk.member)
-	index += len(zk.member)
+	return buf
+}
 
-	//member size
-	binary.LittleEndian.PutUint32(buf[index:], uint32(len(zk.member)))
+func (zk *ZSetInternalKey) encodeWithMember() []byte {
+	prefix := zsetPrefix(zk.key, zk.version, zsetMemberTag)
+	buf := make([]byte, len(prefix)+len(zk.member)) //不需要编码score
+
+	//key+version+tag
+	copy(buf, prefix)
+
+	//member
+	copy(buf[len(prefix):], zk.member)
 
 	return buf
 }
 
-func (zk *ZSetInternalKey) encodeWithMember() []byte {
-	buf := make([]byte, len(zk.key)+len(zk.member)+8+4) //不需要编码score
+// zsetPrefix 某一份数据部分共有的前缀：key+version+tag
+func zsetPrefix(key []byte, version int64, tag byte) []byte {
+	return append(internalKeyPrefix(key, version), tag)
+}
 
-	//key
-	var index = 0
-	copy(buf[index:index+len(zk.key)], zk.key)
-	index += len(zk.key)
+// decodeZSetScoreKey 从去掉zsetPrefix之后的部分中取出score和member
+func decodeZSetScoreKey(suffix []byte) (float64, []byte) {
+	return utils.FloatFromOrderedBytes(suffix[:8]), suffix[8:]
+}
 
-	//version
-	binary.LittleEndian.PutUint64(buf[index:index+8], uint64(zk.version))
-	index += 8
+// BitmapInternalKey bitmap实际放入的key，每个分段单独存放，value是分段的内容
+type BitmapInternalKey struct {
+	key     []byte
+	version int64  //固定编码存放，占8位
+	segment uint32 //分段的序号，大端编码，分段按照序号排序
+}
 
-	//member
-	copy(buf[index:index+len(zk.member)], zk.member)
-	index += len(zk.member)
+func (bk *BitmapInternalKey) encode() []byte {
+	buf := make([]byte, len(bk.key)+8+4)
+
+	//key+version
+	copy(buf, internalKeyPrefix(bk.key, bk.version))
 
+	//segment
+	binary.BigEndian.PutUint32(buf[len(bk.key)+8:], bk.segment)
 	return buf
 }
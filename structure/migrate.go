@@ -0,0 +1,220 @@
+package structure
+
+import (
+	"encoding/binary"
+	"github.com/GrandeLai/JDawDB"
+	"strconv"
+)
+
+// zsetEncodingKey 记录zset编码已经转换完成的key，存在时打开时不再检查旧编码
+var zsetEncodingKey = []byte("\x00jdaw-zset-encoding\x00")
+
+// zsetEncodingVersion 当前zset编码的版本，写入zsetEncodingKey
+const zsetEncodingVersion = 1
+
+// listEncodingKey 记录list编码已经转换完成的key，存在时打开时不再检查旧编码
+var listEncodingKey = []byte("\x00jdaw-list-encoding\x00")
+
+// listEncodingVersion 当前list编码的版本，写入listEncodingKey
+const listEncodingVersion = 1
+
+// keyMetadata 遍历时找到的元数据以及它的key
+type keyMetadata struct {
+	key  []byte
+	meta *metadata
+}
+
+// oldZSetEntry 旧编码中一对member部分和score部分
+type oldZSetEntry struct {
+	member    []byte
+	score     float64
+	memberKey []byte
+	scoreKey  []byte
+}
+
+// MigrateZSetEncoding 将旧编码的zset数据部分转换为当前的编码，返回转换的zset数量
+// 旧编码中score以文本存放，score部分无法按照score排序：
+// member部分为key+version+member+4个字节的0，value是score文本
+// score部分为key+version+score文本+member+member长度（4字节小端）
+// 只有找到的旧数据数量和元数据中的size一致时才会转换，因此重复执行是安全的，执行期间不能有其它写入
+func (ds *DataStructure) MigrateZSetEncoding() (int, error) {
+	//先找出所有的zset元数据，Fold持有读锁，不能在回调中读取数据
+	var candidates []keyMetadata
+	err := ds.db.Fold(func(key []byte, value []byte) bool {
+		if meta, ok := parseMetadata(value); ok && meta.dataType == ZSet && meta.size > 0 {
+			candidates = append(candidates, keyMetadata{key: append([]byte(nil), key...), meta: meta})
+		}
+		return true
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	var migrated int
+	for _, c := range candidates {
+		entries, err := ds.findOldZSetEntries(c.key, c.meta)
+		if err != nil {
+			return migrated, err
+		}
+		if len(entries) == 0 || len(entries) != int(c.meta.size) {
+			continue
+		}
+
+		writebatch := ds.newWriteBatch(len(entries) * 4)
+		//先删除再写入，新旧key相同时保留写入
+		for _, e := range entries {
+			_ = writebatch.Delete(e.memberKey)
+			_ = writebatch.Delete(e.scoreKey)
+		}
+		for _, e := range entries {
+			zsetPut(writebatch, c.key, c.meta, e.member, e.score, false, 0)
+		}
+		if err = writebatch.Commit(); err != nil {
+			return migrated, err
+		}
+		migrated++
+	}
+	return migrated, nil
+}
+
+// migrateOnce 没有完成标记markKey时执行migrate并写入标记，之后打开时直接跳过
+func (ds *DataStructure) migrateOnce(markKey []byte, version byte, migrate func() (int, error)) error {
+	_, err := ds.db.Get(markKey)
+	if err == nil {
+		return nil
+	}
+	if err != JDawDB.ErrKeyNotFound {
+		return err
+	}
+	if _, err = migrate(); err != nil {
+		return err
+	}
+	return ds.db.Put(markKey, []byte{version})
+}
+
+// MigrateListEncoding 将旧编码的list数据部分转换为当前的编码，返回转换的list数量
+// 旧编码中index只存放了低32位（4字节小端），后面4个字节为0，数据部分无法按照index排序
+// 只有当前编码的数据部分不完整、而旧编码的数据部分和[head, tail)完全对应时才会转换，因此重复执行是安全的，执行期间不能有其它写入
+func (ds *DataStructure) MigrateListEncoding() (int, error) {
+	//先找出所有的list元数据，Fold持有读锁，不能在回调中读取数据
+	var candidates []keyMetadata
+	err := ds.db.Fold(func(key []byte, value []byte) bool {
+		if meta, ok := parseMetadata(value); ok && meta.dataType == List && meta.size > 0 {
+			candidates = append(candidates, keyMetadata{key: append([]byte(nil), key...), meta: meta})
+		}
+		return true
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	var migrated int
+	for _, c := range candidates {
+		oldKeys, elements, err := ds.findOldListEntries(c.key, c.meta)
+		if err != nil {
+			return migrated, err
+		}
+		if len(oldKeys) == 0 {
+			continue
+		}
+
+		writebatch := ds.newWriteBatch(len(oldKeys) * 2)
+		//先删除再写入，新旧key相同时保留写入
+		for _, oldKey := range oldKeys {
+			_ = writebatch.Delete(oldKey)
+		}
+		lk := &ListInternalKey{key: c.key, version: c.meta.version}
+		for i, element := range elements {
+			lk.index = c.meta.head + uint64(i)
+			_ = writebatch.Put(lk.encode(), element)
+		}
+		if err = writebatch.Commit(); err != nil {
+			return migrated, err
+		}
+		migrated++
+	}
+	return migrated, nil
+}
+
+// findOldListEntries 找出list当前版本下[head, tail)中所有旧编码的key和element，当前编码的数据完整或者旧编码的数据不完整时返回nil
+func (ds *DataStructure) findOldListEntries(key []byte, meta *metadata) ([][]byte, [][]byte, error) {
+	lk := &ListInternalKey{key: key, version: meta.version}
+	complete := true
+	for i := meta.head; i < meta.tail && complete; i++ {
+		lk.index = i
+		if _, err := ds.db.Get(lk.encode()); err == JDawDB.ErrKeyNotFound {
+			complete = false
+		} else if err != nil {
+			return nil, nil, err
+		}
+	}
+	if complete {
+		return nil, nil, nil
+	}
+
+	prefix := internalKeyPrefix(key, meta.version)
+	oldKeys := make([][]byte, 0, meta.size)
+	elements := make([][]byte, 0, meta.size)
+	for i := meta.head; i < meta.tail; i++ {
+		oldKey := binary.LittleEndian.AppendUint32(append([]byte(nil), prefix...), uint32(i))
+		oldKey = append(oldKey, 0, 0, 0, 0)
+		element, err := ds.db.Get(oldKey)
+		if err == JDawDB.ErrKeyNotFound {
+			return nil, nil, nil
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		oldKeys = append(oldKeys, oldKey)
+		elements = append(elements, element)
+	}
+	return oldKeys, elements, nil
+}
+
+// findOldZSetEntries 找出key当前版本下所有旧编码的数据，score部分和member部分必须同时存在并且score一致
+func (ds *DataStructure) findOldZSetEntries(key []byte, meta *metadata) ([]oldZSetEntry, error) {
+	prefix := internalKeyPrefix(key, meta.version)
+	var candidates []oldZSetEntry
+	err := ds.scanInternalKeys(prefix, false, func(suffix, _ []byte) bool {
+		n := len(suffix)
+		if n < 4 {
+			return true
+		}
+		memberLen := int(binary.LittleEndian.Uint32(suffix[n-4:]))
+		if memberLen+4 >= n {
+			return true
+		}
+		scoreText := suffix[:n-4-memberLen]
+		score, err := strconv.ParseFloat(string(scoreText), 64)
+		if err != nil {
+			return true
+		}
+		member := suffix[n-4-memberLen : n-4]
+		memberKey := append(append(append([]byte(nil), prefix...), member...), 0, 0, 0, 0)
+		candidates = append(candidates, oldZSetEntry{
+			member:    member,
+			score:     score,
+			memberKey: memberKey,
+			scoreKey:  append(append([]byte(nil), prefix...), suffix...),
+		})
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	entries := candidates[:0]
+	for _, e := range candidates {
+		value, err := ds.db.Get(e.memberKey)
+		if err == JDawDB.ErrKeyNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if score, err := strconv.ParseFloat(string(value), 64); err == nil && score == e.score {
+			entries = append(entries, e)
+		}
+	}
+	return entries, nil
+}
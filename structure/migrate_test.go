@@ -0,0 +1,137 @@
+package structure
+
+import (
+	"encoding/binary"
+	"github.com/GrandeLai/JDawDB"
+	"github.com/GrandeLai/JDawDB/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestDataStructure_MigrateZSetEncoding(t *testing.T) {
+	opts := JDawDB.DefaultOptions
+	dir, _ := os.MkdirTemp("", "JDawDB-migrate-zset")
+	opts.DirPath = dir
+	ds, err := NewDataStructure(opts)
+	assert.Nil(t, err)
+
+	key := utils.GetTestKey(1)
+	prefix := putOldZSet(t, ds, key)
+	_, err = ds.ZAdd(utils.GetTestKey(2), 1, []byte("new"))
+	assert.Nil(t, err)
+
+	migrated, err := ds.MigrateZSetEncoding()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, migrated)
+	migrated, err = ds.MigrateZSetEncoding()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, migrated)
+
+	members, err := ds.ZRange(key, 0, -1)
+	assert.Nil(t, err)
+	assert.Equal(t, []ZMember{{[]byte("c"), -1}, {[]byte("b"), 9}, {[]byte("a"), 10}}, members)
+	score, err := ds.ZScore(key, []byte("a"))
+	assert.Nil(t, err)
+	assert.Equal(t, float64(10), score)
+	//旧的数据已经删除，只剩下新编码的两份数据
+	var internalKeys int
+	err = ds.scanInternalKeys(prefix, false, func(suffix, _ []byte) bool {
+		internalKeys++
+		return true
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 6, internalKeys)
+}
+
+func TestNewDataStructure_MigrateZSetEncoding(t *testing.T) {
+	opts := JDawDB.DefaultOptions
+	dir, _ := os.MkdirTemp("", "JDawDB-migrate-zset-open")
+	opts.DirPath = dir
+	ds, err := NewDataStructure(opts)
+	assert.Nil(t, err)
+
+	//模拟旧版本写入的数据，旧版本没有完成标记
+	key := utils.GetTestKey(1)
+	putOldZSet(t, ds, key)
+	assert.Nil(t, ds.db.Delete(zsetEncodingKey))
+	assert.Nil(t, ds.Close())
+
+	//重新打开时自动转换
+	ds, err = NewDataStructure(opts)
+	assert.Nil(t, err)
+	members, err := ds.ZRange(key, 0, -1)
+	assert.Nil(t, err)
+	assert.Equal(t, []ZMember{{[]byte("c"), -1}, {[]byte("b"), 9}, {[]byte("a"), 10}}, members)
+	_, err = ds.db.Get(zsetEncodingKey)
+	assert.Nil(t, err)
+	assert.Nil(t, ds.Close())
+}
+
+// putOldZSet 按照旧编码写入一个有三个member的zset，返回数据部分的前缀
+func putOldZSet(t *testing.T, ds *DataStructure, key []byte) []byte {
+	meta := &metadata{dataType: ZSet, version: time.Now().UnixNano(), size: 3}
+	assert.Nil(t, ds.db.Put(key, meta.encode()))
+	prefix := internalKeyPrefix(key, meta.version)
+	for member, score := range map[string]float64{"a": 10, "b": 9, "c": -1} {
+		scoreText := utils.Float64ToBytes(score)
+		memberKey := append(append(append([]byte(nil), prefix...), member...), 0, 0, 0, 0)
+		assert.Nil(t, ds.db.Put(memberKey, scoreText))
+		scoreKey := append(append(append([]byte(nil), prefix...), scoreText...), member...)
+		scoreKey = binary.LittleEndian.AppendUint32(scoreKey, uint32(len(member)))
+		assert.Nil(t, ds.db.Put(scoreKey, nil))
+	}
+	return prefix
+}
+
+func TestNewDataStructure_MigrateListEncoding(t *testing.T) {
+	opts := JDawDB.DefaultOptions
+	dir, _ := os.MkdirTemp("", "JDawDB-migrate-list")
+	opts.DirPath = dir
+	ds, err := NewDataStructure(opts)
+	assert.Nil(t, err)
+
+	//模拟旧版本写入的数据，旧版本没有完成标记
+	key := utils.GetTestKey(1)
+	putOldList(t, ds, key)
+	_, err = ds.RPush(utils.GetTestKey(2), []byte("new"))
+	assert.Nil(t, err)
+	assert.Nil(t, ds.db.Delete(listEncodingKey))
+	assert.Nil(t, ds.Close())
+
+	//重新打开时自动转换，当前编码的list不受影响
+	ds, err = NewDataStructure(opts)
+	assert.Nil(t, err)
+	elements, err := ds.LRange(key, 0, -1)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("z"), []byte("a"), []byte("b"), []byte("c")}, elements)
+	elements, err = ds.LRange(utils.GetTestKey(2), 0, -1)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("new")}, elements)
+	element, err := ds.LPop(key)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("z"), element)
+	_, err = ds.db.Get(listEncodingKey)
+	assert.Nil(t, err)
+
+	//重复执行不会再转换
+	migrated, err := ds.MigrateListEncoding()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, migrated)
+	assert.Nil(t, ds.Close())
+	_ = os.RemoveAll(dir)
+}
+
+// putOldList 按照旧编码写入RPUSH a b c、LPUSH z之后的list
+func putOldList(t *testing.T, ds *DataStructure, key []byte) {
+	meta := &metadata{dataType: List, version: time.Now().UnixNano(), size: 4,
+		head: initialListMark - 1, tail: initialListMark + 3}
+	assert.Nil(t, ds.db.Put(key, meta.encode()))
+	prefix := internalKeyPrefix(key, meta.version)
+	for i, element := range []string{"z", "a", "b", "c"} {
+		oldKey := binary.LittleEndian.AppendUint32(append([]byte(nil), prefix...), uint32(meta.head+uint64(i)))
+		oldKey = append(oldKey, 0, 0, 0, 0)
+		assert.Nil(t, ds.db.Put(oldKey, []byte(element)))
+	}
+}
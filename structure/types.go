@@ -1,16 +1,43 @@
 package structure
 
 import (
+	"bytes"
+	"context"
 	"encoding/binary"
 	"errors"
 	"github.com/GrandeLai/JDawDB"
 	"github.com/GrandeLai/JDawDB/utils"
+	"math"
+	"math/bits"
+	"math/rand"
+	"sort"
+	"strconv"
+	"sync"
 	"time"
 )
 
 type DataType = byte
 
-var ErrWrongTypeOperation = errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
+var (
+	ErrWrongTypeOperation  = errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
+	ErrWrongNumberOfPairs  = errors.New("ERR wrong number of arguments for field and value pairs")
+	ErrHashValueNotInteger = errors.New("ERR hash value is not an integer")
+	ErrHashValueNotFloat   = errors.New("ERR hash value is not a float")
+	ErrIncrOverflow        = errors.New("ERR increment or decrement would overflow")
+	ErrIncrNaNOrInf        = errors.New("ERR increment would produce NaN or Infinity")
+	ErrNoSuchKey           = errors.New("ERR no such key")
+	ErrIndexOutOfRange     = errors.New("ERR index out of range")
+	ErrClosed              = errors.New("ERR data structure is closed")
+	ErrValueNotInteger     = errors.New("ERR value is not an integer or out of range")
+	ErrValueNotFloat       = errors.New("ERR value is not a valid float")
+	ErrStringTooLong       = errors.New("ERR string exceeds maximum allowed size (proto-max-bulk-len)")
+	ErrOffsetOutOfRange    = errors.New("ERR offset is out of range")
+	ErrBitOffsetOutOfRange = errors.New("ERR bit offset is not an integer or out of range")
+	ErrBitValue            = errors.New("ERR bit is not an integer or out of range")
+	ErrBitOpNotArgs        = errors.New("ERR BITOP NOT must be called with a single source key.")
+	ErrBitFieldType        = errors.New("ERR Invalid bitfield type. Use something like i16 u8. Note that u64 is not supported but i64 is.")
+	ErrInvalidHyperLogLog  = errors.New("INVALIDOBJ Corrupted HLL object detected")
+)
 
 const (
 	String DataType = iota
@@ -18,11 +45,20 @@ const (
 	Set
 	List
 	ZSet
+	Bitmap
+	HyperLogLog
 )
 
 // DataStructure nosql的数据结构服务
 type DataStructure struct {
 	db *JDawDB.DB
+
+	keyLocks    [keyLockShards]sync.Mutex  //按key分片的写锁，同一个key的读后写操作串行执行
+	waitersLock sync.Mutex                 //保护waiters
+	waiters     map[string][]chan struct{} //阻塞在每个list上的等待者，写入时通知
+	closed      chan struct{}              //关闭时唤醒所有的等待者
+	closeOnce   sync.Once
+	bgWg        sync.WaitGroup //等待后台的回收任务退出
 }
 
 // NewDataStructure 初始化DataStructure
@@ -31,34 +67,54 @@ func NewDataStructure(opt JDawDB.Options) (*DataStructure, error) {
 	if err != nil {
 		panic(err)
 	}
-	return &DataStructure{
-		db: db,
-	}, nil
+	ds := &DataStructure{
+		db:      db,
+		waiters: make(map[string][]chan struct{}),
+		closed:  make(chan struct{}),
+	}
+	//在提供服务之前转换旧编码的zset和list
+	if !opt.ReadOnly {
+		if err = ds.migrateOnce(zsetEncodingKey, zsetEncodingVersion, ds.MigrateZSetEncoding); err != nil {
+			_ = db.Close()
+			return nil, err
+		}
+		if err = ds.migrateOnce(listEncodingKey, listEncodingVersion, ds.MigrateListEncoding); err != nil {
+			_ = db.Close()
+			return nil, err
+		}
+	}
+	return ds, nil
 }
 
 // -----------------String数据结构-----------------
 
+// maxStringSize string的最大长度，和redis相同为512MB
+const maxStringSize = 512 * 1024 * 1024
+
+// SetOptions SET命令的可选参数
+type SetOptions struct {
+	TTL     time.Duration //大于0时设置过期时间
+	NX      bool          //只有key不存在时才写入
+	XX      bool          //只有key存在时才写入
+	KeepTTL bool          //保留原来的过期时间
+	Get     bool          //返回旧的值，旧的值不是string时返回ErrWrongTypeOperation
+}
+
 func (ds *DataStructure) Set(key []byte, ttl time.Duration, value []byte) error {
 	if value == nil {
 		return nil
 	}
 
-	//编码value：type+expire+payload
-	buf := make([]byte, binary.MaxVarintLen64+1)
-	buf[0] = String
-	var index = 1
 	var expire int64 = 0
 	if ttl != 0 {
 		expire = time.Now().Add(ttl).UnixNano()
 	}
-	index += binary.PutVarint(buf[index:], expire)
 
-	encValue := make([]byte, index+len(value))
-	copy(encValue[:index], buf[:index])
-	copy(encValue[index:], value)
+	unlock := ds.lockKeys(key)
+	defer unlock()
 
-	//调用存储引擎接口写入
-	return ds.db.Put(key, encValue)
+	//调用存储引擎接口写入，覆盖集合类型时需要回收它的数据部分
+	return ds.replaceKey(key, encodeString(value, expire))
 }
 
 func (ds *DataStructure) Get(key []byte) ([]byte, error) {
@@ -67,24 +123,306 @@ func (ds *DataStructure) Get(key []byte) ([]byte, error) {
 		return nil, err
 	}
 
+	//先判断是否过期，过期的key不论是什么类型都当作不存在
+	if expire, _ := binary.Varint(encValue[1:]); expire > 0 && expire <= time.Now().UnixNano() {
+		return nil, nil
+	}
 	//解码
 	dataType := encValue[0]
 	if dataType != String {
 		return nil, ErrWrongTypeOperation
 	}
+	value, _ := decodeString(encValue)
+	return value, nil
+}
+
+// SetWithOptions 按照SET命令的选项写入，返回写入之前的string值（不存在时为nil）以及是否写入
+// 和redis相同，SET会覆盖其它类型的key
+func (ds *DataStructure) SetWithOptions(key, value []byte, opts SetOptions) ([]byte, bool, error) {
+	unlock := ds.lockKeys(key)
+	defer unlock()
+
+	encValue, err := ds.liveValue(key)
+	if err != nil && err != JDawDB.ErrKeyNotFound {
+		return nil, false, err
+	}
+	exist := err == nil
+
+	var old []byte
+	var expire int64
+	isString := exist && encValue[0] == String
+	if isString {
+		old, expire = decodeString(encValue)
+	}
+	if opts.Get && exist && !isString {
+		return nil, false, ErrWrongTypeOperation
+	}
+	if (opts.NX && exist) || (opts.XX && !exist) {
+		return old, false, nil
+	}
+
+	if !opts.KeepTTL {
+		expire = 0
+		if opts.TTL > 0 {
+			expire = time.Now().Add(opts.TTL).UnixNano()
+		}
+	}
+	if err = ds.replaceKey(key, encodeString(value, expire)); err != nil {
+		return nil, false, err
+	}
+	return old, true, nil
+}
+
+// SetNX 只有key不存在时才写入
+func (ds *DataStructure) SetNX(key, value []byte) (bool, error) {
+	_, ok, err := ds.SetWithOptions(key, value, SetOptions{NX: true})
+	return ok, err
+}
+
+// GetSet 写入新的值并返回旧的值，过期时间会被清除
+func (ds *DataStructure) GetSet(key, value []byte) ([]byte, error) {
+	old, _, err := ds.SetWithOptions(key, value, SetOptions{Get: true})
+	return old, err
+}
+
+// MSet 原子地写入多个key，参数按照key1,value1,key2,value2...排列
+func (ds *DataStructure) MSet(keyValues ...[]byte) error {
+	if len(keyValues) == 0 || len(keyValues)%2 != 0 {
+		return ErrWrongNumberOfPairs
+	}
+
+	keys := make([][]byte, 0, len(keyValues)/2)
+	for i := 0; i < len(keyValues); i += 2 {
+		keys = append(keys, keyValues[i])
+	}
+	unlock := ds.lockKeys(keys...)
+	defer unlock()
+
+	writebatch := ds.newWriteBatch(len(keyValues))
+	for i := 0; i < len(keyValues); i += 2 {
+		if err := ds.markGarbage(writebatch, keyValues[i]); err != nil {
+			return err
+		}
+		_ = writebatch.Put(keyValues[i], encodeString(keyValues[i+1], 0))
+	}
+	return writebatch.Commit()
+}
+
+// MGet 读取多个key，不存在或者不是string的key对应的值为nil
+func (ds *DataStructure) MGet(keys ...[]byte) ([][]byte, error) {
+	res := make([][]byte, len(keys))
+	for i, key := range keys {
+		value, _, _, err := ds.getString(key)
+		if err != nil && err != ErrWrongTypeOperation {
+			return nil, err
+		}
+		res[i] = value
+	}
+	return res, nil
+}
+
+// Incr 将整数值加1，返回新的值
+func (ds *DataStructure) Incr(key []byte) (int64, error) {
+	return ds.IncrBy(key, 1)
+}
+
+// Decr 将整数值减1，返回新的值
+func (ds *DataStructure) Decr(key []byte) (int64, error) {
+	return ds.IncrBy(key, -1)
+}
+
+// DecrBy 将整数值减去decr，返回新的值
+func (ds *DataStructure) DecrBy(key []byte, decr int64) (int64, error) {
+	if decr == math.MinInt64 {
+		return 0, ErrIncrOverflow
+	}
+	return ds.IncrBy(key, -decr)
+}
+
+// IncrBy 将整数值加上incr，key不存在时当作0处理，保留原来的过期时间
+func (ds *DataStructure) IncrBy(key []byte, incr int64) (int64, error) {
+	var res int64
+	err := ds.stringUpdate(key, func(value []byte, exist bool) ([]byte, error) {
+		var current int64
+		if exist {
+			var err error
+			if current, err = strconv.ParseInt(string(value), 10, 64); err != nil {
+				return nil, ErrValueNotInteger
+			}
+		}
+		if (incr > 0 && current > math.MaxInt64-incr) || (incr < 0 && current < math.MinInt64-incr) {
+			return nil, ErrIncrOverflow
+		}
+		res = current + incr
+		return strconv.AppendInt(nil, res, 10), nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return res, nil
+}
+
+// IncrByFloat 将数值加上浮点数incr，key不存在时当作0处理，保留原来的过期时间
+func (ds *DataStructure) IncrByFloat(key []byte, incr float64) (float64, error) {
+	var res float64
+	err := ds.stringUpdate(key, func(value []byte, exist bool) ([]byte, error) {
+		var current float64
+		if exist {
+			var err error
+			if current, err = strconv.ParseFloat(string(value), 64); err != nil {
+				return nil, ErrValueNotFloat
+			}
+		}
+		res = current + incr
+		if math.IsNaN(res) || math.IsInf(res, 0) {
+			return nil, ErrIncrNaNOrInf
+		}
+		return utils.Float64ToBytes(res), nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return res, nil
+}
+
+// Append 在值的末尾追加value，key不存在时相当于Set，返回追加之后的长度
+func (ds *DataStructure) Append(key, value []byte) (int, error) {
+	var length int
+	err := ds.stringUpdate(key, func(old []byte, _ bool) ([]byte, error) {
+		if len(old)+len(value) > maxStringSize {
+			return nil, ErrStringTooLong
+		}
+		newValue := make([]byte, len(old)+len(value))
+		copy(newValue, old)
+		copy(newValue[len(old):], value)
+		length = len(newValue)
+		return newValue, nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return length, nil
+}
+
+// SetRange 从offset开始覆盖写入value，长度不够时用0补齐，返回写入之后的长度
+func (ds *DataStructure) SetRange(key []byte, offset int64, value []byte) (int, error) {
+	if offset < 0 {
+		return 0, ErrOffsetOutOfRange
+	}
+	if offset+int64(len(value)) > maxStringSize {
+		return 0, ErrStringTooLong
+	}
+
+	var length int
+	err := ds.stringUpdate(key, func(old []byte, _ bool) ([]byte, error) {
+		//value为空时不修改，key不存在时也不创建
+		if len(value) == 0 {
+			length = len(old)
+			return nil, nil
+		}
+		size := len(old)
+		if end := int(offset) + len(value); end > size {
+			size = end
+		}
+		newValue := make([]byte, size)
+		copy(newValue, old)
+		copy(newValue[offset:], value)
+		length = len(newValue)
+		return newValue, nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return length, nil
+}
+
+// GetRange 返回[start, end]范围内的子串，负数表示从末尾开始计数
+func (ds *DataStructure) GetRange(key []byte, start, end int64) ([]byte, error) {
+	value, _, _, err := ds.getString(key)
+	if err != nil {
+		return nil, err
+	}
+	start, end, ok := normalizeRange(start, end, int64(len(value)))
+	if !ok {
+		return []byte{}, nil
+	}
+	return value[start : end+1], nil
+}
+
+// StrLen 返回值的长度，key不存在时返回0
+func (ds *DataStructure) StrLen(key []byte) (int, error) {
+	value, _, _, err := ds.getString(key)
+	if err != nil {
+		return 0, err
+	}
+	return len(value), nil
+}
+
+// getString 读取string的值和过期时间，key不存在或者已经过期时exist为false
+func (ds *DataStructure) getString(key []byte) ([]byte, int64, bool, error) {
+	encValue, err := ds.liveValue(key)
+	if err == JDawDB.ErrKeyNotFound {
+		return nil, 0, false, nil
+	}
+	if err != nil {
+		return nil, 0, false, err
+	}
+	if encValue[0] != String {
+		return nil, 0, false, ErrWrongTypeOperation
+	}
+	value, expire := decodeString(encValue)
+	return value, expire, true, nil
+}
+
+// stringUpdate 读取旧值，由update计算出新值之后写入，保留原来的过期时间，update返回nil时不写入
+func (ds *DataStructure) stringUpdate(key []byte, update func(value []byte, exist bool) ([]byte, error)) error {
+	unlock := ds.lockKeys(key)
+	defer unlock()
+
+	value, expire, exist, err := ds.getString(key)
+	if err != nil {
+		return err
+	}
+	newValue, err := update(value, exist)
+	if err != nil || newValue == nil {
+		return err
+	}
+	//key可能是已经过期的集合类型，覆盖时需要回收它的数据部分
+	return ds.replaceKey(key, encodeString(newValue, expire))
+}
+
+// encodeString 编码value：type+expire+payload
+func encodeString(value []byte, expire int64) []byte {
+	return encodeValue(String, value, expire)
+}
+
+// encodeValue 编码不使用元数据的类型：type+expire+payload
+func encodeValue(dataType DataType, value []byte, expire int64) []byte {
+	buf := make([]byte, binary.MaxVarintLen64+1)
+	buf[0] = dataType
+	var index = 1
+	index += binary.PutVarint(buf[index:], expire)
+
+	encValue := make([]byte, index+len(value))
+	copy(encValue[:index], buf[:index])
+	copy(encValue[index:], value)
+	return encValue
+}
+
+// decodeString 解码出payload和过期时间，也用于其它不使用元数据的类型
+func decodeString(encValue []byte) ([]byte, int64) {
 	var index = 1
 	expire, n := binary.Varint(encValue[index:])
 	index += n
-	//判断是否过期
-	if expire > 0 && expire <= time.Now().UnixNano() {
-		return nil, nil
-	}
-	return encValue[index:], nil
+	return encValue[index:], expire
 }
 
 // -----------------Hash数据结构-----------------
 
 func (ds *DataStructure) HSet(key, field, value []byte) (bool, error) {
+	unlock := ds.lockKeys(key)
+	defer unlock()
+
 	//先查找元数据
 	meta, err := ds.findMetadata(key, Hash)
 	if err != nil {
@@ -111,7 +449,7 @@ func (ds *DataStructure) HSet(key, field, value []byte) (bool, error) {
 	//不存在，说明需要增加size，更新元数据
 	if !exist {
 		meta.size++
-		_ = writebatch.Put(key, meta.encode())
+		ds.putMetadata(writebatch, key, meta)
 	}
 	//更新数据
 	_ = writebatch.Put(encKey, value)
@@ -141,6 +479,9 @@ func (ds *DataStructure) HGet(key, field []byte) ([]byte, error) {
 }
 
 func (ds *DataStructure) HDel(key, field []byte) (bool, error) {
+	unlock := ds.lockKeys(key)
+	defer unlock()
+
 	meta, err := ds.findMetadata(key, Hash)
 	if err != nil {
 		return false, err
@@ -166,7 +507,7 @@ func (ds *DataStructure) HDel(key, field []byte) (bool, error) {
 		writebatch := ds.db.NewWriteBatch(JDawDB.DefaultWriteBatchOptions)
 		meta.size--
 		//更新元数据
-		_ = writebatch.Put(key, meta.encode())
+		ds.putMetadata(writebatch, key, meta)
 		//
 		_ = writebatch.Delete(encKey)
 		if err = writebatch.Commit(); err != nil {
@@ -177,276 +518,2539 @@ func (ds *DataStructure) HDel(key, field []byte) (bool, error) {
 	return exist, nil
 }
 
-// -----------------Set数据结构-----------------
-
-func (ds *DataStructure) SAdd(key, member []byte) (bool, error) {
-	//查找元数据
-	meta, err := ds.findMetadata(key, Set)
+// HExists 判断field是否存在
+func (ds *DataStructure) HExists(key, field []byte) (bool, error) {
+	meta, err := ds.findMetadata(key, Hash)
 	if err != nil {
 		return false, err
 	}
+	if meta.size == 0 {
+		return false, nil
+	}
 
-	//构造一个数据部分的key
-	sk := &SetInternalKey{
+	hk := &HashInternalKey{
 		key:     key,
 		version: meta.version,
-		member:  member,
+		field:   field,
+	}
+	_, err = ds.db.Get(hk.encode())
+	if err != nil && err != JDawDB.ErrKeyNotFound {
+		return false, err
 	}
+	return err == nil, nil
+}
 
-	var ok bool
-	if _, err = ds.db.Get(sk.encode()); err == JDawDB.ErrKeyNotFound {
-		//不存在就更新
-		writebatch := ds.db.NewWriteBatch(JDawDB.DefaultWriteBatchOptions)
-		meta.size++
-		_ = writebatch.Put(key, meta.encode())
-		_ = writebatch.Put(sk.encode(), nil)
-		if err = writebatch.Commit(); err != nil {
-			return false, err
-		}
-		ok = true
+// HLen 返回field的数量
+func (ds *DataStructure) HLen(key []byte) (uint32, error) {
+	meta, err := ds.findMetadata(key, Hash)
+	if err != nil {
+		return 0, err
 	}
+	return meta.size, nil
+}
 
-	return ok, nil
+// HGetAll 返回所有的field和value，按照field1,value1,field2,value2...排列
+func (ds *DataStructure) HGetAll(key []byte) ([][]byte, error) {
+	return ds.hashEntries(key, true, true)
 }
 
-// SIsMember 判断传入的member是否是这个key的成员
-func (ds *DataStructure) SIsMember(key, member []byte) (bool, error) {
-	//查找元数据
-	meta, err := ds.findMetadata(key, Set)
+// HKeys 返回所有的field
+func (ds *DataStructure) HKeys(key []byte) ([][]byte, error) {
+	return ds.hashEntries(key, true, false)
+}
+
+// HVals 返回所有的value
+func (ds *DataStructure) HVals(key []byte) ([][]byte, error) {
+	return ds.hashEntries(key, false, true)
+}
+
+// hashEntries 遍历当前版本下所有的field，按照需要返回field和value
+func (ds *DataStructure) hashEntries(key []byte, withField, withValue bool) ([][]byte, error) {
+	meta, err := ds.findMetadata(key, Hash)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
-
 	if meta.size == 0 {
-		//说明这个key下没有数据的
-		return false, err
+		return nil, nil
 	}
 
-	//构造一个数据部分的key
-	sk := &SetInternalKey{
-		key:     key,
-		version: meta.version,
-		member:  member,
+	prefix := internalKeyPrefix(key, meta.version)
+	res := make([][]byte, 0, meta.size)
+	err = ds.scanInternalKeys(prefix, withValue, func(field, value []byte) bool {
+		if withField {
+			res = append(res, field)
+		}
+		if withValue {
+			res = append(res, value)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
 	}
+	return res, nil
+}
 
-	_, err = ds.db.Get(sk.encode())
-	if err != nil && err != JDawDB.ErrKeyNotFound {
-		return false, err
+// HMSet 一次写入多个field，参数按照field1,value1,field2,value2...排列，返回新增的field数量
+func (ds *DataStructure) HMSet(key []byte, fieldValues ...[]byte) (uint32, error) {
+	if len(fieldValues) == 0 || len(fieldValues)%2 != 0 {
+		return 0, ErrWrongNumberOfPairs
 	}
-	if err == JDawDB.ErrKeyNotFound {
-		return false, nil
+	unlock := ds.lockKeys(key)
+	defer unlock()
+
+	meta, err := ds.findMetadata(key, Hash)
+	if err != nil {
+		return 0, err
 	}
-	return true, nil
+
+	//field、元数据以及过期的旧版本加入回收队列的记录
+	writebatch := ds.newWriteBatch(len(fieldValues)/2 + 2)
+	var added uint32
+	seen := make(map[string]struct{}, len(fieldValues)/2)
+	for i := 0; i < len(fieldValues); i += 2 {
+		hk := &HashInternalKey{
+			key:     key,
+			version: meta.version,
+			field:   fieldValues[i],
+		}
+		encKey := hk.encode()
+		//同一个field在参数中出现多次时只计算一次
+		if _, ok := seen[string(encKey)]; !ok {
+			seen[string(encKey)] = struct{}{}
+			_, err = ds.db.Get(encKey)
+			if err != nil && err != JDawDB.ErrKeyNotFound {
+				return 0, err
+			}
+			if err == JDawDB.ErrKeyNotFound {
+				added++
+			}
+		}
+		if err = writebatch.Put(encKey, fieldValues[i+1]); err != nil {
+			return 0, err
+		}
+	}
+
+	meta.size += added
+	ds.putMetadata(writebatch, key, meta)
+	if err = writebatch.Commit(); err != nil {
+		return 0, err
+	}
+	return added, nil
 }
 
-func (ds *DataStructure) SRem(key, member []byte) (bool, error) {
-	//查找元数据
-	meta, err := ds.findMetadata(key, Set)
+// HMGet 一次读取多个field，不存在的field对应的值为nil
+func (ds *DataStructure) HMGet(key []byte, fields ...[]byte) ([][]byte, error) {
+	meta, err := ds.findMetadata(key, Hash)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
 
+	res := make([][]byte, len(fields))
 	if meta.size == 0 {
-		//说明这个key下没有数据的
+		return res, nil
+	}
+	for i, field := range fields {
+		hk := &HashInternalKey{
+			key:     key,
+			version: meta.version,
+			field:   field,
+		}
+		value, err := ds.db.Get(hk.encode())
+		if err != nil && err != JDawDB.ErrKeyNotFound {
+			return nil, err
+		}
+		res[i] = value
+	}
+	return res, nil
+}
+
+// HSetNX 只有field不存在时才写入
+func (ds *DataStructure) HSetNX(key, field, value []byte) (bool, error) {
+	unlock := ds.lockKeys(key)
+	defer unlock()
+
+	meta, err := ds.findMetadata(key, Hash)
+	if err != nil {
 		return false, err
 	}
 
-	//构造一个数据部分的key
-	sk := &SetInternalKey{
+	hk := &HashInternalKey{
 		key:     key,
 		version: meta.version,
-		member:  member,
+		field:   field,
 	}
-
-	if _, err = ds.db.Get(sk.encode()); err == JDawDB.ErrKeyNotFound {
+	encKey := hk.encode()
+	_, err = ds.db.Get(encKey)
+	if err == nil {
 		return false, nil
 	}
+	if err != JDawDB.ErrKeyNotFound {
+		return false, err
+	}
 
-	//不存在就更新
 	writebatch := ds.db.NewWriteBatch(JDawDB.DefaultWriteBatchOptions)
-	meta.size--
-	_ = writebatch.Put(key, meta.encode())
-	_ = writebatch.Delete(sk.encode())
+	meta.size++
+	ds.putMetadata(writebatch, key, meta)
+	_ = writebatch.Put(encKey, value)
 	if err = writebatch.Commit(); err != nil {
 		return false, err
 	}
-
 	return true, nil
 }
 
-// -----------------List数据结构-----------------
-
-func (ds *DataStructure) LPush(key, element []byte) (uint32, error) {
-	return ds.pushInner(key, element, true)
+// HIncrBy 将field的值加上增量，field不存在时当作0处理，值以十进制字符串存储
+func (ds *DataStructure) HIncrBy(key, field []byte, incr int64) (int64, error) {
+	var res int64
+	err := ds.hashUpdate(key, field, func(value []byte, exist bool) ([]byte, error) {
+		var current int64
+		if exist {
+			var err error
+			if current, err = strconv.ParseInt(string(value), 10, 64); err != nil {
+				return nil, ErrHashValueNotInteger
+			}
+		}
+		if (incr > 0 && current > math.MaxInt64-incr) || (incr < 0 && current < math.MinInt64-incr) {
+			return nil, ErrIncrOverflow
+		}
+		res = current + incr
+		return strconv.AppendInt(nil, res, 10), nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return res, nil
 }
 
-func (ds *DataStructure) RPush(key, element []byte) (uint32, error) {
-	return ds.pushInner(key, element, false)
+// HIncrByFloat 将field的值加上浮点数增量，field不存在时当作0处理
+func (ds *DataStructure) HIncrByFloat(key, field []byte, incr float64) (float64, error) {
+	var res float64
+	err := ds.hashUpdate(key, field, func(value []byte, exist bool) ([]byte, error) {
+		var current float64
+		if exist {
+			var err error
+			if current, err = strconv.ParseFloat(string(value), 64); err != nil {
+				return nil, ErrHashValueNotFloat
+			}
+		}
+		res = current + incr
+		if math.IsNaN(res) || math.IsInf(res, 0) {
+			return nil, ErrIncrNaNOrInf
+		}
+		return utils.Float64ToBytes(res), nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return res, nil
 }
 
-func (ds *DataStructure) LPop(key []byte) ([]byte, error) {
-	return ds.popInner(key, true)
+// hashUpdate 读取field的旧值，由update计算出新值之后和元数据一起写入，持有key的锁，读写之间不会有其它写入
+func (ds *DataStructure) hashUpdate(key, field []byte, update func(value []byte, exist bool) ([]byte, error)) error {
+	unlock := ds.lockKeys(key)
+	defer unlock()
+
+	meta, err := ds.findMetadata(key, Hash)
+	if err != nil {
+		return err
+	}
+
+	hk := &HashInternalKey{
+		key:     key,
+		version: meta.version,
+		field:   field,
+	}
+	encKey := hk.encode()
+	value, err := ds.db.Get(encKey)
+	if err != nil && err != JDawDB.ErrKeyNotFound {
+		return err
+	}
+	exist := err == nil
+
+	newValue, err := update(value, exist)
+	if err != nil {
+		return err
+	}
+
+	writebatch := ds.db.NewWriteBatch(JDawDB.DefaultWriteBatchOptions)
+	if !exist {
+		meta.size++
+		ds.putMetadata(writebatch, key, meta)
+	}
+	_ = writebatch.Put(encKey, newValue)
+	return writebatch.Commit()
+}
+
+// HScan 从cursor之后最多检查count个field，返回其中匹配match的field和value，以及下一次调用的游标
+// cursor为nil时从头开始，遍历完成时返回的游标为nil，遍历期间一直存在的field都会被返回
+func (ds *DataStructure) HScan(key, cursor []byte, match string, count int) ([]byte, [][]byte, error) {
+	meta, err := ds.findMetadata(key, Hash)
+	if err != nil {
+		return nil, nil, err
+	}
+	if meta.size == 0 {
+		return nil, nil, nil
+	}
+
+	prefix := internalKeyPrefix(key, meta.version)
+	var res [][]byte
+	next, err := ds.scanByCursor(prefix, cursor, count, true, func(field, value []byte) {
+		if match == "" || globMatch([]byte(match), field) {
+			res = append(res, field, value)
+		}
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return next, res, nil
+}
+
+// -----------------Set数据结构-----------------
+
+func (ds *DataStructure) SAdd(key, member []byte) (bool, error) {
+	unlock := ds.lockKeys(key)
+	defer unlock()
+
+	//查找元数据
+	meta, err := ds.findMetadata(key, Set)
+	if err != nil {
+		return false, err
+	}
+
+	//构造一个数据部分的key
+	sk := &SetInternalKey{
+		key:     key,
+		version: meta.version,
+		member:  member,
+	}
+
+	var ok bool
+	if _, err = ds.db.Get(sk.encode()); err == JDawDB.ErrKeyNotFound {
+		//不存在就更新
+		writebatch := ds.db.NewWriteBatch(JDawDB.DefaultWriteBatchOptions)
+		meta.size++
+		ds.putMetadata(writebatch, key, meta)
+		_ = writebatch.Put(sk.encode(), nil)
+		if err = writebatch.Commit(); err != nil {
+			return false, err
+		}
+		ok = true
+	}
+
+	return ok, nil
+}
+
+// SIsMember 判断传入的member是否是这个key的成员
+func (ds *DataStructure) SIsMember(key, member []byte) (bool, error) {
+	//查找元数据
+	meta, err := ds.findMetadata(key, Set)
+	if err != nil {
+		return false, err
+	}
+
+	if meta.size == 0 {
+		//说明这个key下没有数据的
+		return false, err
+	}
+
+	//构造一个数据部分的key
+	sk := &SetInternalKey{
+		key:     key,
+		version: meta.version,
+		member:  member,
+	}
+
+	_, err = ds.db.Get(sk.encode())
+	if err != nil && err != JDawDB.ErrKeyNotFound {
+		return false, err
+	}
+	if err == JDawDB.ErrKeyNotFound {
+		return false, nil
+	}
+	return true, nil
+}
+
+func (ds *DataStructure) SRem(key, member []byte) (bool, error) {
+	unlock := ds.lockKeys(key)
+	defer unlock()
+
+	//查找元数据
+	meta, err := ds.findMetadata(key, Set)
+	if err != nil {
+		return false, err
+	}
+
+	if meta.size == 0 {
+		//说明这个key下没有数据的
+		return false, err
+	}
+
+	//构造一个数据部分的key
+	sk := &SetInternalKey{
+		key:     key,
+		version: meta.version,
+		member:  member,
+	}
+
+	if _, err = ds.db.Get(sk.encode()); err == JDawDB.ErrKeyNotFound {
+		return false, nil
+	}
+
+	//不存在就更新
+	writebatch := ds.db.NewWriteBatch(JDawDB.DefaultWriteBatchOptions)
+	meta.size--
+	ds.putMetadata(writebatch, key, meta)
+	_ = writebatch.Delete(sk.encode())
+	if err = writebatch.Commit(); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// SCard 返回member的数量
+func (ds *DataStructure) SCard(key []byte) (uint32, error) {
+	meta, err := ds.findMetadata(key, Set)
+	if err != nil {
+		return 0, err
+	}
+	return meta.size, nil
+}
+
+// SMembers 返回所有的member
+func (ds *DataStructure) SMembers(key []byte) ([][]byte, error) {
+	members, _, err := ds.setMembers(key)
+	return members, err
+}
+
+// setMembers 遍历当前版本下所有的member，同时返回元数据
+func (ds *DataStructure) setMembers(key []byte) ([][]byte, *metadata, error) {
+	meta, err := ds.findMetadata(key, Set)
+	if err != nil {
+		return nil, nil, err
+	}
+	if meta.size == 0 {
+		return nil, meta, nil
+	}
+
+	members := make([][]byte, 0, meta.size)
+	err = ds.scanInternalKeys(internalKeyPrefix(key, meta.version), false, func(suffix, _ []byte) bool {
+		members = append(members, decodeSetMember(suffix))
+		return true
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return members, meta, nil
+}
+
+// SPop 随机移除并返回count个member
+func (ds *DataStructure) SPop(key []byte, count uint32) ([][]byte, error) {
+	unlock := ds.lockKeys(key)
+	defer unlock()
+
+	members, meta, err := ds.setMembers(key)
+	if err != nil || len(members) == 0 || count == 0 {
+		return nil, err
+	}
+
+	rand.Shuffle(len(members), func(i, j int) {
+		members[i], members[j] = members[j], members[i]
+	})
+	if int(count) < len(members) {
+		members = members[:count]
+	}
+
+	writebatch := ds.newWriteBatch(len(members) + 1)
+	meta.size -= uint32(len(members))
+	ds.putMetadata(writebatch, key, meta)
+	for _, member := range members {
+		sk := &SetInternalKey{
+			key:     key,
+			version: meta.version,
+			member:  member,
+		}
+		_ = writebatch.Delete(sk.encode())
+	}
+	if err = writebatch.Commit(); err != nil {
+		return nil, err
+	}
+	return members, nil
+}
+
+// SRandMember 随机返回member但不移除，count为正数时返回不重复的member，为负数时返回-count个可能重复的member
+func (ds *DataStructure) SRandMember(key []byte, count int) ([][]byte, error) {
+	members, _, err := ds.setMembers(key)
+	if err != nil || len(members) == 0 || count == 0 {
+		return nil, err
+	}
+
+	if count < 0 {
+		res := make([][]byte, -count)
+		for i := range res {
+			res[i] = members[rand.Intn(len(members))]
+		}
+		return res, nil
+	}
+	rand.Shuffle(len(members), func(i, j int) {
+		members[i], members[j] = members[j], members[i]
+	})
+	if count < len(members) {
+		members = members[:count]
+	}
+	return members, nil
+}
+
+// SMove 将member从source移动到destination，member不在source中时返回false
+func (ds *DataStructure) SMove(source, destination, member []byte) (bool, error) {
+	unlock := ds.lockKeys(source, destination)
+	defer unlock()
+
+	srcMeta, err := ds.findMetadata(source, Set)
+	if err != nil {
+		return false, err
+	}
+	dstMeta, err := ds.findMetadata(destination, Set)
+	if err != nil {
+		return false, err
+	}
+
+	srcKey := (&SetInternalKey{key: source, version: srcMeta.version, member: member}).encode()
+	if _, err = ds.db.Get(srcKey); err != nil {
+		if err == JDawDB.ErrKeyNotFound {
+			return false, nil
+		}
+		return false, err
+	}
+	if bytes.Equal(source, destination) {
+		return true, nil
+	}
+
+	dstKey := (&SetInternalKey{key: destination, version: dstMeta.version, member: member}).encode()
+	var dstExist = true
+	if _, err = ds.db.Get(dstKey); err == JDawDB.ErrKeyNotFound {
+		dstExist = false
+	} else if err != nil {
+		return false, err
+	}
+
+	writebatch := ds.db.NewWriteBatch(JDawDB.DefaultWriteBatchOptions)
+	srcMeta.size--
+	ds.putMetadata(writebatch, source, srcMeta)
+	_ = writebatch.Delete(srcKey)
+	if !dstExist {
+		dstMeta.size++
+		ds.putMetadata(writebatch, destination, dstMeta)
+		_ = writebatch.Put(dstKey, nil)
+	}
+	if err = writebatch.Commit(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// SInter 返回所有集合的交集
+func (ds *DataStructure) SInter(keys ...[]byte) ([][]byte, error) {
+	return ds.setAlgebra(setInter, keys)
+}
+
+// SUnion 返回所有集合的并集
+func (ds *DataStructure) SUnion(keys ...[]byte) ([][]byte, error) {
+	return ds.setAlgebra(setUnion, keys)
+}
+
+// SDiff 返回第一个集合中不在其它集合中的member
+func (ds *DataStructure) SDiff(keys ...[]byte) ([][]byte, error) {
+	return ds.setAlgebra(setDiff, keys)
+}
+
+// SInterStore 将交集保存到destination，返回结果的数量
+func (ds *DataStructure) SInterStore(destination []byte, keys ...[]byte) (uint32, error) {
+	return ds.setAlgebraStore(setInter, destination, keys)
+}
+
+// SUnionStore 将并集保存到destination，返回结果的数量
+func (ds *DataStructure) SUnionStore(destination []byte, keys ...[]byte) (uint32, error) {
+	return ds.setAlgebraStore(setUnion, destination, keys)
+}
+
+// SDiffStore 将差集保存到destination，返回结果的数量
+func (ds *DataStructure) SDiffStore(destination []byte, keys ...[]byte) (uint32, error) {
+	return ds.setAlgebraStore(setDiff, destination, keys)
+}
+
+type setOperation byte
+
+const (
+	setInter setOperation = iota
+	setUnion
+	setDiff
+)
+
+// setAlgebra 读取所有集合并计算结果，结果按照member排序
+func (ds *DataStructure) setAlgebra(op setOperation, keys [][]byte) ([][]byte, error) {
+	var res [][]byte
+	var inResult map[string]struct{}
+	for i, key := range keys {
+		members, _, err := ds.setMembers(key)
+		if err != nil {
+			return nil, err
+		}
+		if i == 0 {
+			res = members
+			inResult = make(map[string]struct{}, len(members))
+			for _, member := range members {
+				inResult[string(member)] = struct{}{}
+			}
+			continue
+		}
+
+		current := make(map[string]struct{}, len(members))
+		for _, member := range members {
+			current[string(member)] = struct{}{}
+		}
+		switch op {
+		case setInter:
+			res = filterMembers(res, current, true)
+		case setDiff:
+			res = filterMembers(res, current, false)
+		case setUnion:
+			for _, member := range members {
+				if _, ok := inResult[string(member)]; !ok {
+					inResult[string(member)] = struct{}{}
+					res = append(res, member)
+				}
+			}
+		}
+	}
+	if op == setUnion && len(keys) > 1 {
+		sort.Slice(res, func(i, j int) bool {
+			return bytes.Compare(res[i], res[j]) < 0
+		})
+	}
+	return res, nil
+}
+
+// filterMembers 保留在set中（keep为true）或者不在set中（keep为false）的member
+func filterMembers(members [][]byte, set map[string]struct{}, keep bool) [][]byte {
+	res := members[:0]
+	for _, member := range members {
+		if _, ok := set[string(member)]; ok == keep {
+			res = append(res, member)
+		}
+	}
+	return res
+}
+
+// setAlgebraStore 计算结果并用新的版本写入destination，原来的数据不再可见，结果为空时删除destination
+func (ds *DataStructure) setAlgebraStore(op setOperation, destination []byte, keys [][]byte) (uint32, error) {
+	//同时锁住源集合，保证读到的是同一时刻的结果
+	unlock := ds.lockKeys(append([][]byte{destination}, keys...)...)
+	defer unlock()
+
+	members, err := ds.setAlgebra(op, keys)
+	if err != nil {
+		return 0, err
+	}
+
+	writebatch := ds.newWriteBatch(len(members) + 2)
+	if err = ds.markGarbage(writebatch, destination); err != nil {
+		return 0, err
+	}
+	if len(members) == 0 {
+		_ = writebatch.Delete(destination)
+		return 0, writebatch.Commit()
+	}
+
+	meta := &metadata{
+		dataType: Set,
+		version:  time.Now().UnixNano(),
+		size:     uint32(len(members)),
+	}
+	ds.putMetadata(writebatch, destination, meta)
+	for _, member := range members {
+		sk := &SetInternalKey{
+			key:     destination,
+			version: meta.version,
+			member:  member,
+		}
+		_ = writebatch.Put(sk.encode(), nil)
+	}
+	if err = writebatch.Commit(); err != nil {
+		return 0, err
+	}
+	return meta.size, nil
+}
+
+// SScan 从cursor之后最多检查count个member，返回其中匹配match的member，以及下一次调用的游标，游标的含义和HScan相同
+func (ds *DataStructure) SScan(key, cursor []byte, match string, count int) ([]byte, [][]byte, error) {
+	meta, err := ds.findMetadata(key, Set)
+	if err != nil {
+		return nil, nil, err
+	}
+	if meta.size == 0 {
+		return nil, nil, nil
+	}
+
+	var res [][]byte
+	next, err := ds.scanByCursor(internalKeyPrefix(key, meta.version), cursor, count, false, func(suffix, _ []byte) {
+		member := decodeSetMember(suffix)
+		if match == "" || globMatch([]byte(match), member) {
+			res = append(res, member)
+		}
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return next, res, nil
+}
+
+// -----------------List数据结构-----------------
+
+// list的数据部分始终连续存放在[head, tail)中，第i个元素的index为head+i
+
+func (ds *DataStructure) LPush(key, element []byte) (uint32, error) {
+	return ds.pushInner(key, element, true)
+}
+
+func (ds *DataStructure) RPush(key, element []byte) (uint32, error) {
+	return ds.pushInner(key, element, false)
+}
+
+// LPushX 只有list存在时才从左边写入，返回list的长度，不存在时返回0
+func (ds *DataStructure) LPushX(key, element []byte) (uint32, error) {
+	return ds.pushExistInner(key, element, true)
+}
+
+// RPushX 只有list存在时才从右边写入，返回list的长度，不存在时返回0
+func (ds *DataStructure) RPushX(key, element []byte) (uint32, error) {
+	return ds.pushExistInner(key, element, false)
+}
+
+func (ds *DataStructure) LPop(key []byte) ([]byte, error) {
+	return ds.popInner(key, true)
+}
+
+func (ds *DataStructure) RPop(key []byte) ([]byte, error) {
+	return ds.popInner(key, false)
+}
+
+// LPush和RPush时处理head和tail
+func (ds *DataStructure) pushInner(key, element []byte, isLeft bool) (uint32, error) {
+	unlock := ds.lockKeys(key)
+	defer unlock()
+
+	//查找元数据
+	meta, err := ds.findMetadata(key, List)
+	if err != nil {
+		return 0, err
+	}
+	return ds.commitPush(key, meta, element, isLeft)
+}
+
+func (ds *DataStructure) pushExistInner(key, element []byte, isLeft bool) (uint32, error) {
+	unlock := ds.lockKeys(key)
+	defer unlock()
+
+	meta, err := ds.findMetadata(key, List)
+	if err != nil {
+		return 0, err
+	}
+	if meta.size == 0 {
+		return 0, nil
+	}
+	return ds.commitPush(key, meta, element, isLeft)
+}
+
+// commitPush 更新元数据和数据部分，并唤醒阻塞在这个list上的等待者
+func (ds *DataStructure) commitPush(key []byte, meta *metadata, element []byte, isLeft bool) (uint32, error) {
+	writebatch := ds.db.NewWriteBatch(JDawDB.DefaultWriteBatchOptions)
+	listPush(writebatch, key, meta, element, isLeft)
+	ds.putMetadata(writebatch, key, meta)
+	if err := writebatch.Commit(); err != nil {
+		return 0, err
+	}
+	ds.notifyWaiters(key)
+	return meta.size, nil
+}
+
+// LPop和RPop时处理head和tail
+func (ds *DataStructure) popInner(key []byte, isLeft bool) ([]byte, error) {
+	unlock := ds.lockKeys(key)
+	defer unlock()
+
+	//查找元数据
+	meta, err := ds.findMetadata(key, List)
+	if err != nil {
+		return nil, err
+	}
+	if meta.size == 0 {
+		return nil, nil
+	}
+
+	writebatch := ds.db.NewWriteBatch(JDawDB.DefaultWriteBatchOptions)
+	element, err := ds.listPop(writebatch, key, meta, isLeft)
+	if err != nil {
+		return nil, err
+	}
+	ds.putMetadata(writebatch, key, meta)
+	if err = writebatch.Commit(); err != nil {
+		return nil, err
+	}
+	return element, nil
+}
+
+// listPush 将写入element的操作放入writebatch，并更新meta中的head或tail
+func listPush(writebatch *JDawDB.WriteBatch, key []byte, meta *metadata, element []byte, isLeft bool) {
+	lk := &ListInternalKey{
+		key:     key,
+		version: meta.version,
+	}
+	if isLeft {
+		meta.head--
+		lk.index = meta.head
+	} else {
+		lk.index = meta.tail
+		meta.tail++
+	}
+	meta.size++
+	_ = writebatch.Put(lk.encode(), element)
+}
+
+// listPop 读取一端的element，将删除操作放入writebatch，并更新meta中的head或tail
+func (ds *DataStructure) listPop(writebatch *JDawDB.WriteBatch, key []byte, meta *metadata, isLeft bool) ([]byte, error) {
+	lk := &ListInternalKey{
+		key:     key,
+		version: meta.version,
+	}
+	if isLeft {
+		lk.index = meta.head
+	} else {
+		lk.index = meta.tail - 1
+	}
+
+	element, err := ds.db.Get(lk.encode())
+	if err != nil {
+		return nil, err
+	}
+
+	meta.size--
+	if isLeft {
+		meta.head++
+	} else {
+		meta.tail--
+	}
+	_ = writebatch.Delete(lk.encode())
+	return element, nil
+}
+
+// LLen 返回list的长度
+func (ds *DataStructure) LLen(key []byte) (uint32, error) {
+	meta, err := ds.findMetadata(key, List)
+	if err != nil {
+		return 0, err
+	}
+	return meta.size, nil
+}
+
+// LIndex 返回第index个element，负数表示从右边开始计数，超出范围时返回nil
+func (ds *DataStructure) LIndex(key []byte, index int64) ([]byte, error) {
+	meta, err := ds.findMetadata(key, List)
+	if err != nil {
+		return nil, err
+	}
+	if index < 0 {
+		index += int64(meta.size)
+	}
+	if index < 0 || index >= int64(meta.size) {
+		return nil, nil
+	}
+
+	lk := &ListInternalKey{
+		key:     key,
+		version: meta.version,
+		index:   meta.head + uint64(index),
+	}
+	return ds.db.Get(lk.encode())
+}
+
+// LRange 返回[start, stop]范围内的element，负数表示从右边开始计数
+func (ds *DataStructure) LRange(key []byte, start, stop int64) ([][]byte, error) {
+	meta, err := ds.findMetadata(key, List)
+	if err != nil {
+		return nil, err
+	}
+	start, stop, ok := normalizeRange(start, stop, int64(meta.size))
+	if !ok {
+		return nil, nil
+	}
+	return ds.listElements(key, meta, start, stop)
+}
+
+// listElements 按顺序读取第start到第stop个element
+func (ds *DataStructure) listElements(key []byte, meta *metadata, start, stop int64) ([][]byte, error) {
+	res := make([][]byte, 0, stop-start+1)
+	for i := start; i <= stop; i++ {
+		lk := &ListInternalKey{
+			key:     key,
+			version: meta.version,
+			index:   meta.head + uint64(i),
+		}
+		element, err := ds.db.Get(lk.encode())
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, element)
+	}
+	return res, nil
+}
+
+// LSet 修改第index个element
+func (ds *DataStructure) LSet(key []byte, index int64, element []byte) error {
+	unlock := ds.lockKeys(key)
+	defer unlock()
+
+	meta, err := ds.findMetadata(key, List)
+	if err != nil {
+		return err
+	}
+	if meta.size == 0 {
+		return ErrNoSuchKey
+	}
+	if index < 0 {
+		index += int64(meta.size)
+	}
+	if index < 0 || index >= int64(meta.size) {
+		return ErrIndexOutOfRange
+	}
+
+	lk := &ListInternalKey{
+		key:     key,
+		version: meta.version,
+		index:   meta.head + uint64(index),
+	}
+	return ds.db.Put(lk.encode(), element)
+}
+
+// LTrim 只保留[start, stop]范围内的element
+func (ds *DataStructure) LTrim(key []byte, start, stop int64) error {
+	unlock := ds.lockKeys(key)
+	defer unlock()
+
+	meta, err := ds.findMetadata(key, List)
+	if err != nil {
+		return err
+	}
+	if meta.size == 0 {
+		return nil
+	}
+	start, stop, ok := normalizeRange(start, stop, int64(meta.size))
+	if !ok {
+		//范围为空时清空list
+		start, stop = int64(meta.size), int64(meta.size)-1
+	}
+
+	writebatch := ds.newWriteBatch(int(meta.size) - int(stop-start+1) + 1)
+	for i := meta.head; i < meta.tail; i++ {
+		if i >= meta.head+uint64(start) && i <= meta.head+uint64(stop) {
+			continue
+		}
+		lk := &ListInternalKey{
+			key:     key,
+			version: meta.version,
+			index:   i,
+		}
+		_ = writebatch.Delete(lk.encode())
+	}
+	meta.tail = meta.head + uint64(stop) + 1
+	meta.head += uint64(start)
+	meta.size = uint32(meta.tail - meta.head)
+	ds.putMetadata(writebatch, key, meta)
+	return writebatch.Commit()
+}
+
+// LInsert 在第一个等于pivot的element之前或者之后插入element，返回list的长度
+// pivot不存在时返回-1，list不存在时返回0
+func (ds *DataStructure) LInsert(key []byte, before bool, pivot, element []byte) (int64, error) {
+	unlock := ds.lockKeys(key)
+	defer unlock()
+
+	meta, err := ds.findMetadata(key, List)
+	if err != nil {
+		return 0, err
+	}
+	if meta.size == 0 {
+		return 0, nil
+	}
+	elements, err := ds.listElements(key, meta, 0, int64(meta.size)-1)
+	if err != nil {
+		return 0, err
+	}
+
+	var pos = -1
+	for i, e := range elements {
+		if bytes.Equal(e, pivot) {
+			pos = i
+			break
+		}
+	}
+	if pos == -1 {
+		return -1, nil
+	}
+	if !before {
+		pos++
+	}
+
+	//移动插入位置左右两边中较少的一边，空出位置之后写入element
+	writebatch := ds.newWriteBatch(len(elements) + 2)
+	lk := &ListInternalKey{
+		key:     key,
+		version: meta.version,
+	}
+	if pos < len(elements)-pos {
+		meta.head--
+		for i := 0; i < pos; i++ {
+			lk.index = meta.head + uint64(i)
+			_ = writebatch.Put(lk.encode(), elements[i])
+		}
+	} else {
+		for i := len(elements) - 1; i >= pos; i-- {
+			lk.index = meta.head + uint64(i) + 1
+			_ = writebatch.Put(lk.encode(), elements[i])
+		}
+		meta.tail++
+	}
+	lk.index = meta.head + uint64(pos)
+	_ = writebatch.Put(lk.encode(), element)
+	meta.size++
+	ds.putMetadata(writebatch, key, meta)
+	if err = writebatch.Commit(); err != nil {
+		return 0, err
+	}
+	return int64(meta.size), nil
+}
+
+// LRem 删除count个等于element的元素，count为正数时从左边开始，为负数时从右边开始，为0时删除全部，返回删除的数量
+func (ds *DataStructure) LRem(key []byte, count int64, element []byte) (uint32, error) {
+	unlock := ds.lockKeys(key)
+	defer unlock()
+
+	meta, err := ds.findMetadata(key, List)
+	if err != nil {
+		return 0, err
+	}
+	if meta.size == 0 {
+		return 0, nil
+	}
+	elements, err := ds.listElements(key, meta, 0, int64(meta.size)-1)
+	if err != nil {
+		return 0, err
+	}
+
+	//标记需要删除的元素
+	removed := make([]bool, len(elements))
+	var n int64
+	limit := count
+	if limit < 0 {
+		limit = -limit
+	}
+	for i := range elements {
+		j := i
+		if count < 0 {
+			j = len(elements) - 1 - i
+		}
+		if limit != 0 && n == limit {
+			break
+		}
+		if bytes.Equal(elements[j], element) {
+			removed[j] = true
+			n++
+		}
+	}
+	if n == 0 {
+		return 0, nil
+	}
+
+	//剩下的元素从head开始重新连续存放
+	writebatch := ds.newWriteBatch(len(elements) + 1)
+	lk := &ListInternalKey{
+		key:     key,
+		version: meta.version,
+	}
+	var index = meta.head
+	for i, e := range elements {
+		if removed[i] {
+			continue
+		}
+		lk.index = index
+		_ = writebatch.Put(lk.encode(), e)
+		index++
+	}
+	for ; index < meta.tail; index++ {
+		lk.index = index
+		_ = writebatch.Delete(lk.encode())
+	}
+	meta.size -= uint32(n)
+	meta.tail = meta.head + uint64(meta.size)
+	ds.putMetadata(writebatch, key, meta)
+	if err = writebatch.Commit(); err != nil {
+		return 0, err
+	}
+	return uint32(n), nil
+}
+
+// LMove 从source的一端取出element并写入destination的一端，source为空时返回nil
+func (ds *DataStructure) LMove(source, destination []byte, srcLeft, dstLeft bool) ([]byte, error) {
+	unlock := ds.lockKeys(source, destination)
+	defer unlock()
+
+	srcMeta, err := ds.findMetadata(source, List)
+	if err != nil {
+		return nil, err
+	}
+	dstMeta := srcMeta
+	if !bytes.Equal(source, destination) {
+		if dstMeta, err = ds.findMetadata(destination, List); err != nil {
+			return nil, err
+		}
+	}
+	if srcMeta.size == 0 {
+		return nil, nil
+	}
+
+	writebatch := ds.db.NewWriteBatch(JDawDB.DefaultWriteBatchOptions)
+	element, err := ds.listPop(writebatch, source, srcMeta, srcLeft)
+	if err != nil {
+		return nil, err
+	}
+	listPush(writebatch, destination, dstMeta, element, dstLeft)
+	ds.putMetadata(writebatch, source, srcMeta)
+	ds.putMetadata(writebatch, destination, dstMeta)
+	if err = writebatch.Commit(); err != nil {
+		return nil, err
+	}
+	ds.notifyWaiters(destination)
+	return element, nil
+}
+
+// BLPop 依次从keys中第一个非空的list左边弹出element，都为空时阻塞到有写入或者超时，timeout为0时一直阻塞
+// 超时返回的key和element都为nil
+func (ds *DataStructure) BLPop(timeout time.Duration, keys ...[]byte) ([]byte, []byte, error) {
+	return ds.BLPopCtx(context.Background(), timeout, keys...)
+}
+
+// BLPopCtx 和BLPop相同，ctx结束时停止等待并返回ctx.Err()，例如客户端的连接已经断开
+func (ds *DataStructure) BLPopCtx(ctx context.Context, timeout time.Duration, keys ...[]byte) ([]byte, []byte, error) {
+	return ds.blockingPop(ctx, keys, timeout, ds.LPop)
+}
+
+// BRPop 和BLPop相同，从右边弹出
+func (ds *DataStructure) BRPop(timeout time.Duration, keys ...[]byte) ([]byte, []byte, error) {
+	return ds.BRPopCtx(context.Background(), timeout, keys...)
+}
+
+// BRPopCtx 和BLPopCtx相同，从右边弹出
+func (ds *DataStructure) BRPopCtx(ctx context.Context, timeout time.Duration, keys ...[]byte) ([]byte, []byte, error) {
+	return ds.blockingPop(ctx, keys, timeout, ds.RPop)
+}
+
+// BLMove LMove的阻塞版本，source为空时阻塞到有写入或者超时，超时返回nil
+func (ds *DataStructure) BLMove(source, destination []byte, srcLeft, dstLeft bool, timeout time.Duration) ([]byte, error) {
+	return ds.BLMoveCtx(context.Background(), source, destination, srcLeft, dstLeft, timeout)
+}
+
+// BLMoveCtx 和BLMove相同，ctx结束时停止等待并返回ctx.Err()
+func (ds *DataStructure) BLMoveCtx(ctx context.Context, source, destination []byte, srcLeft, dstLeft bool, timeout time.Duration) ([]byte, error) {
+	_, element, err := ds.blockingPop(ctx, [][]byte{source}, timeout, func(key []byte) ([]byte, error) {
+		return ds.LMove(key, destination, srcLeft, dstLeft)
+	})
+	return element, err
+}
+
+// blockingPop 先注册等待者再尝试弹出，保证在两者之间发生的写入不会被错过
+// 一次写入会唤醒这个list上所有的等待者，没有取到element的等待者继续等待
+// 每次弹出之前检查ctx，已经不再等待的调用方不会取走element
+func (ds *DataStructure) blockingPop(ctx context.Context, keys [][]byte, timeout time.Duration, pop func(key []byte) ([]byte, error)) ([]byte, []byte, error) {
+	ch := ds.addWaiter(keys)
+	defer ds.removeWaiter(keys, ch)
+
+	var deadline <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+	for {
+		for _, key := range keys {
+			if err := ctx.Err(); err != nil {
+				return nil, nil, err
+			}
+			element, err := pop(key)
+			if err != nil {
+				return nil, nil, err
+			}
+			if element != nil {
+				return key, element, nil
+			}
+		}
+		select {
+		case <-ch:
+		case <-deadline:
+			return nil, nil, nil
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		case <-ds.closed:
+			return nil, nil, ErrClosed
+		}
+	}
+}
+
+// addWaiter 在每个key上注册同一个等待者，缓冲为1，多次唤醒只保留一次
+func (ds *DataStructure) addWaiter(keys [][]byte) chan struct{} {
+	ch := make(chan struct{}, 1)
+	ds.waitersLock.Lock()
+	defer ds.waitersLock.Unlock()
+	for _, key := range keys {
+		ds.waiters[string(key)] = append(ds.waiters[string(key)], ch)
+	}
+	return ch
+}
+
+func (ds *DataStructure) removeWaiter(keys [][]byte, ch chan struct{}) {
+	ds.waitersLock.Lock()
+	defer ds.waitersLock.Unlock()
+	for _, key := range keys {
+		waiters := ds.waiters[string(key)]
+		for i, waiter := range waiters {
+			if waiter == ch {
+				waiters = append(waiters[:i], waiters[i+1:]...)
+				break
+			}
+		}
+		if len(waiters) == 0 {
+			delete(ds.waiters, string(key))
+		} else {
+			ds.waiters[string(key)] = waiters
+		}
+	}
+}
+
+// notifyWaiters 唤醒阻塞在key上的所有等待者
+func (ds *DataStructure) notifyWaiters(key []byte) {
+	ds.waitersLock.Lock()
+	defer ds.waitersLock.Unlock()
+	for _, ch := range ds.waiters[string(key)] {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// -----------------ZSet数据结构-----------------
+
+// ZMember zset中的member和对应的score
+type ZMember struct {
+	Member []byte
+	Score  float64
+}
+
+// ScoreRange score的范围，Min和Max可以是正负无穷
+type ScoreRange struct {
+	Min          float64
+	Max          float64
+	MinExclusive bool
+	MaxExclusive bool
+}
+
+func (r ScoreRange) aboveMin(score float64) bool {
+	if r.MinExclusive {
+		return score > r.Min
+	}
+	return score >= r.Min
+}
+
+func (r ScoreRange) belowMax(score float64) bool {
+	if r.MaxExclusive {
+		return score < r.Max
+	}
+	return score <= r.Max
+}
+
+// LexRange member的字典序范围，Min为nil表示负无穷，Max为nil表示正无穷
+type LexRange struct {
+	Min          []byte
+	Max          []byte
+	MinExclusive bool
+	MaxExclusive bool
+}
+
+func (r LexRange) contains(member []byte) bool {
+	if r.Min != nil {
+		if c := bytes.Compare(member, r.Min); c < 0 || (c == 0 && r.MinExclusive) {
+			return false
+		}
+	}
+	if r.Max != nil {
+		if c := bytes.Compare(member, r.Max); c > 0 || (c == 0 && r.MaxExclusive) {
+			return false
+		}
+	}
+	return true
+}
+
+func (ds *DataStructure) ZAdd(key []byte, score float64, member []byte) (bool, error) {
+	unlock := ds.lockKeys(key)
+	defer unlock()
+
+	//查找元数据
+	meta, err := ds.findMetadata(key, ZSet)
+	if err != nil {
+		return false, err
+	}
+
+	//查看是否已经存在
+	oldScore, exist, err := ds.zsetScore(key, meta, member)
+	if err != nil {
+		return false, err
+	}
+	if exist && score == oldScore {
+		return false, nil
+	}
+
+	//需要更新元数据
+	writebatch := ds.db.NewWriteBatch(JDawDB.DefaultWriteBatchOptions)
+	if !exist {
+		meta.size++
+		ds.putMetadata(writebatch, key, meta)
+	}
+	zsetPut(writebatch, key, meta, member, score, exist, oldScore)
+	if err = writebatch.Commit(); err != nil {
+		return false, err
+	}
+
+	return !exist, nil
+}
+
+func (ds *DataStructure) ZScore(key []byte, member []byte) (float64, error) {
+	meta, err := ds.findMetadata(key, ZSet)
+	if err != nil {
+		return -1, err
+	}
+	if meta.size == 0 {
+		return -1, nil
+	}
+
+	// 构造数据部分的key
+	zk := &ZSetInternalKey{
+		key:     key,
+		version: meta.version,
+		member:  member,
+	}
+
+	value, err := ds.db.Get(zk.encodeWithMember())
+	if err != nil {
+		return -1, err
+	}
+
+	return utils.FloatFromBytes(value), nil
+}
+
+// ZCard 返回member的数量
+func (ds *DataStructure) ZCard(key []byte) (uint32, error) {
+	meta, err := ds.findMetadata(key, ZSet)
+	if err != nil {
+		return 0, err
+	}
+	return meta.size, nil
+}
+
+// ZIncrBy 将member的score加上增量，member不存在时当作0处理，返回新的score
+func (ds *DataStructure) ZIncrBy(key []byte, incr float64, member []byte) (float64, error) {
+	unlock := ds.lockKeys(key)
+	defer unlock()
+
+	meta, err := ds.findMetadata(key, ZSet)
+	if err != nil {
+		return 0, err
+	}
+	oldScore, exist, err := ds.zsetScore(key, meta, member)
+	if err != nil {
+		return 0, err
+	}
+	score := oldScore + incr
+	if math.IsNaN(score) {
+		return 0, ErrIncrNaNOrInf
+	}
+
+	writebatch := ds.db.NewWriteBatch(JDawDB.DefaultWriteBatchOptions)
+	if !exist {
+		meta.size++
+		ds.putMetadata(writebatch, key, meta)
+	}
+	zsetPut(writebatch, key, meta, member, score, exist, oldScore)
+	if err = writebatch.Commit(); err != nil {
+		return 0, err
+	}
+	return score, nil
+}
+
+// ZRem 删除member
+func (ds *DataStructure) ZRem(key []byte, member []byte) (bool, error) {
+	unlock := ds.lockKeys(key)
+	defer unlock()
+
+	meta, err := ds.findMetadata(key, ZSet)
+	if err != nil {
+		return false, err
+	}
+	if meta.size == 0 {
+		return false, nil
+	}
+	score, exist, err := ds.zsetScore(key, meta, member)
+	if err != nil || !exist {
+		return false, err
+	}
+
+	writebatch := ds.db.NewWriteBatch(JDawDB.DefaultWriteBatchOptions)
+	meta.size--
+	ds.putMetadata(writebatch, key, meta)
+	zsetDelete(writebatch, key, meta, member, score)
+	if err = writebatch.Commit(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// ZRank 返回member按照score从小到大的排名，从0开始，member不存在时返回-1
+func (ds *DataStructure) ZRank(key []byte, member []byte) (int64, error) {
+	return ds.zsetRank(key, member, false)
+}
+
+// ZRevRank 返回member按照score从大到小的排名，从0开始，member不存在时返回-1
+func (ds *DataStructure) ZRevRank(key []byte, member []byte) (int64, error) {
+	return ds.zsetRank(key, member, true)
+}
+
+func (ds *DataStructure) zsetRank(key []byte, member []byte, reverse bool) (int64, error) {
+	meta, err := ds.findMetadata(key, ZSet)
+	if err != nil {
+		return -1, err
+	}
+	if meta.size == 0 {
+		return -1, nil
+	}
+	score, exist, err := ds.zsetScore(key, meta, member)
+	if err != nil || !exist {
+		return -1, err
+	}
+
+	//统计排在member之前的数量
+	var rank int64
+	target := (&ZSetInternalKey{key: key, version: meta.version, member: member, score: score}).encodeWithScore()
+	prefix := zsetPrefix(key, meta.version, zsetScoreTag)
+	err = ds.scanInternalKeys(prefix, false, func(suffix, _ []byte) bool {
+		if bytes.Equal(target[len(prefix):], suffix) {
+			return false
+		}
+		rank++
+		return true
+	})
+	if err != nil {
+		return -1, err
+	}
+	if reverse {
+		rank = int64(meta.size) - 1 - rank
+	}
+	return rank, nil
+}
+
+// ZRange 返回按照score从小到大排名在[start, stop]中的member，负数表示从最后开始计数
+func (ds *DataStructure) ZRange(key []byte, start, stop int64) ([]ZMember, error) {
+	return ds.zsetRangeByRank(key, start, stop, false)
+}
+
+// ZRevRange 返回按照score从大到小排名在[start, stop]中的member，负数表示从最后开始计数
+func (ds *DataStructure) ZRevRange(key []byte, start, stop int64) ([]ZMember, error) {
+	return ds.zsetRangeByRank(key, start, stop, true)
+}
+
+func (ds *DataStructure) zsetRangeByRank(key []byte, start, stop int64, reverse bool) ([]ZMember, error) {
+	meta, err := ds.findMetadata(key, ZSet)
+	if err != nil {
+		return nil, err
+	}
+	start, stop, ok := normalizeRange(start, stop, int64(meta.size))
+	if !ok {
+		return nil, nil
+	}
+	return ds.zsetMembers(key, meta, reverse, int(start), int(stop-start+1), nil)
+}
+
+// ZRangeByScore 返回score在范围内的member，按照score从小到大排列，跳过offset个之后最多返回count个，count为负数时返回全部
+func (ds *DataStructure) ZRangeByScore(key []byte, r ScoreRange, offset, count int) ([]ZMember, error) {
+	return ds.zsetRangeByScore(key, r, false, offset, count)
+}
+
+// ZRevRangeByScore 和ZRangeByScore相同，按照score从大到小排列
+func (ds *DataStructure) ZRevRangeByScore(key []byte, r ScoreRange, offset, count int) ([]ZMember, error) {
+	return ds.zsetRangeByScore(key, r, true, offset, count)
+}
+
+func (ds *DataStructure) zsetRangeByScore(key []byte, r ScoreRange, reverse bool, offset, count int) ([]ZMember, error) {
+	meta, err := ds.findMetadata(key, ZSet)
+	if err != nil {
+		return nil, err
+	}
+	if meta.size == 0 {
+		return nil, nil
+	}
+	return ds.zsetMembers(key, meta, reverse, offset, count, &r)
+}
+
+// ZRangeByLex 返回member在字典序范围内的元素，只有所有member的score相同时结果才有意义
+func (ds *DataStructure) ZRangeByLex(key []byte, r LexRange, offset, count int) ([][]byte, error) {
+	meta, err := ds.findMetadata(key, ZSet)
+	if err != nil {
+		return nil, err
+	}
+	if meta.size == 0 || offset < 0 || count == 0 {
+		return nil, nil
+	}
+
+	var res [][]byte
+	prefix := zsetPrefix(key, meta.version, zsetScoreTag)
+	err = ds.scanInternalKeys(prefix, false, func(suffix, _ []byte) bool {
+		_, member := decodeZSetScoreKey(suffix)
+		if !r.contains(member) {
+			return true
+		}
+		if offset > 0 {
+			offset--
+			return true
+		}
+		res = append(res, member)
+		return count < 0 || len(res) < count
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// ZCount 返回score在范围内的member数量
+func (ds *DataStructure) ZCount(key []byte, r ScoreRange) (uint32, error) {
+	members, err := ds.zsetRangeByScore(key, r, false, 0, -1)
+	if err != nil {
+		return 0, err
+	}
+	return uint32(len(members)), nil
+}
+
+// ZPopMin 移除并返回score最小的count个member
+func (ds *DataStructure) ZPopMin(key []byte, count int) ([]ZMember, error) {
+	return ds.zsetPop(key, count, false)
+}
+
+// ZPopMax 移除并返回score最大的count个member
+func (ds *DataStructure) ZPopMax(key []byte, count int) ([]ZMember, error) {
+	return ds.zsetPop(key, count, true)
+}
+
+func (ds *DataStructure) zsetPop(key []byte, count int, reverse bool) ([]ZMember, error) {
+	unlock := ds.lockKeys(key)
+	defer unlock()
+
+	meta, err := ds.findMetadata(key, ZSet)
+	if err != nil {
+		return nil, err
+	}
+	if meta.size == 0 || count <= 0 {
+		return nil, nil
+	}
+	members, err := ds.zsetMembers(key, meta, reverse, 0, count, nil)
+	if err != nil {
+		return nil, err
+	}
+	return members, ds.zsetDeleteMembers(key, meta, members)
+}
+
+// ZRemRangeByScore 删除score在范围内的member，返回删除的数量
+func (ds *DataStructure) ZRemRangeByScore(key []byte, r ScoreRange) (uint32, error) {
+	unlock := ds.lockKeys(key)
+	defer unlock()
+
+	meta, err := ds.findMetadata(key, ZSet)
+	if err != nil {
+		return 0, err
+	}
+	if meta.size == 0 {
+		return 0, nil
+	}
+	members, err := ds.zsetMembers(key, meta, false, 0, -1, &r)
+	if err != nil {
+		return 0, err
+	}
+	if err = ds.zsetDeleteMembers(key, meta, members); err != nil {
+		return 0, err
+	}
+	return uint32(len(members)), nil
+}
+
+// zsetScore 读取member的score，member不存在时返回false
+func (ds *DataStructure) zsetScore(key []byte, meta *metadata, member []byte) (float64, bool, error) {
+	zk := &ZSetInternalKey{
+		key:     key,
+		version: meta.version,
+		member:  member,
+	}
+	value, err := ds.db.Get(zk.encodeWithMember())
+	if err == JDawDB.ErrKeyNotFound {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return utils.FloatFromBytes(value), true, nil
+}
+
+// zsetMembers 按照score的顺序遍历，r不为nil时只遍历score在范围内的部分，跳过offset个之后最多返回count个，count为负数时返回全部
+func (ds *DataStructure) zsetMembers(key []byte, meta *metadata, reverse bool, offset, count int, r *ScoreRange) ([]ZMember, error) {
+	if offset < 0 || count == 0 {
+		return nil, nil
+	}
+	prefix := zsetPrefix(key, meta.version, zsetScoreTag)
+
+	//正向从最小的score开始，反向从最大的score之后开始
+	seek := prefix
+	if r != nil {
+		if reverse {
+			seek = append(append([]byte(nil), prefix...), utils.Float64ToOrderedBytes(r.Max)...)
+			incrementBytes(seek[len(prefix):])
+		} else {
+			seek = append(append([]byte(nil), prefix...), utils.Float64ToOrderedBytes(r.Min)...)
+		}
+	} else if reverse {
+		seek = zsetPrefix(key, meta.version, zsetScoreTag+1)
+	}
+
+	var res []ZMember
+	err := ds.scanInternalKeysFrom(prefix, seek, reverse, false, func(suffix, _ []byte) bool {
+		score, member := decodeZSetScoreKey(suffix)
+		if r != nil {
+			//先跳过起点一侧不满足的部分，离开终点一侧之后结束
+			if reverse && !r.belowMax(score) || !reverse && !r.aboveMin(score) {
+				return true
+			}
+			if reverse && !r.aboveMin(score) || !reverse && !r.belowMax(score) {
+				return false
+			}
+		}
+		if offset > 0 {
+			offset--
+			return true
+		}
+		res = append(res, ZMember{Member: member, Score: score})
+		return count < 0 || len(res) < count
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// zsetDeleteMembers 在一个WriteBatch中删除members并更新元数据
+func (ds *DataStructure) zsetDeleteMembers(key []byte, meta *metadata, members []ZMember) error {
+	if len(members) == 0 {
+		return nil
+	}
+	writebatch := ds.newWriteBatch(len(members)*2 + 1)
+	for _, m := range members {
+		zsetDelete(writebatch, key, meta, m.Member, m.Score)
+	}
+	meta.size -= uint32(len(members))
+	ds.putMetadata(writebatch, key, meta)
+	return writebatch.Commit()
+}
+
+// zsetPut 将写入member和score的操作放入writebatch，exist为true时同时删除旧的score部分
+func zsetPut(writebatch *JDawDB.WriteBatch, key []byte, meta *metadata, member []byte, score float64, exist bool, oldScore float64) {
+	if exist {
+		oldKey := &ZSetInternalKey{
+			key:     key,
+			version: meta.version,
+			member:  member,
+			score:   oldScore,
+		}
+		_ = writebatch.Delete(oldKey.encodeWithScore())
+	}
+	zk := &ZSetInternalKey{
+		key:     key,
+		version: meta.version,
+		member:  member,
+		score:   score,
+	}
+	_ = writebatch.Put(zk.encodeWithMember(), utils.Float64ToBytes(score))
+	_ = writebatch.Put(zk.encodeWithScore(), nil)
+}
+
+// zsetDelete 将删除member的两份数据部分的操作放入writebatch
+func zsetDelete(writebatch *JDawDB.WriteBatch, key []byte, meta *metadata, member []byte, score float64) {
+	zk := &ZSetInternalKey{
+		key:     key,
+		version: meta.version,
+		member:  member,
+		score:   score,
+	}
+	_ = writebatch.Delete(zk.encodeWithMember())
+	_ = writebatch.Delete(zk.encodeWithScore())
+}
+
+// incrementBytes 将buf当作大端无符号整数加1，溢出时保持全为0xff
+func incrementBytes(buf []byte) {
+	for i := len(buf) - 1; i >= 0; i-- {
+		if buf[i] != 0xff {
+			buf[i]++
+			return
+		}
+		buf[i] = 0
+	}
+	for i := range buf {
+		buf[i] = 0xff
+	}
+}
+
+// -----------------Bitmap数据结构-----------------
+
+const (
+	// bitmapSegmentSize bitmap每个分段的字节数，修改一个位只需要重写所在的分段
+	bitmapSegmentSize = 1024
+	// maxBitOffset 位偏移的最大值，和redis相同，bitmap最大为512MB
+	maxBitOffset = 1<<32 - 1
+)
+
+// BitRange BITCOUNT和BITPOS的范围，Start和End可以为负数，表示从末尾开始计算
+type BitRange struct {
+	Start  int64
+	End    int64
+	HasEnd bool //为false时范围一直到最后
+	Bit    bool //Start和End的单位是位，否则是字节
+}
+
+// BitOperation BITOP的运算
+type BitOperation byte
+
+const (
+	BitAnd BitOperation = iota
+	BitOr
+	BitXor
+	BitNot
+)
+
+// BitFieldCommand BITFIELD的子命令
+type BitFieldCommand byte
+
+const (
+	BitFieldGet BitFieldCommand = iota
+	BitFieldSet
+	BitFieldIncrBy
+)
+
+// BitFieldOverflow BITFIELD的SET和INCRBY溢出时的处理方式
+type BitFieldOverflow byte
+
+const (
+	BitFieldWrap BitFieldOverflow = iota //回绕
+	BitFieldSat                          //取最大值或最小值
+	BitFieldFail                         //不做修改，结果为nil
+)
+
+// BitFieldOp BITFIELD中的一个操作
+type BitFieldOp struct {
+	Command  BitFieldCommand
+	Signed   bool
+	Bits     uint8  //有符号最多64位，无符号最多63位
+	Offset   uint64 //字段第一个位的偏移
+	Value    int64  //SET的值或者INCRBY的增量
+	Overflow BitFieldOverflow
+}
+
+// SetBit 设置offset位置的位，返回原来的值，元数据中的size为bitmap的字节数
+func (ds *DataStructure) SetBit(key []byte, offset uint64, value byte) (byte, error) {
+	if offset > maxBitOffset {
+		return 0, ErrBitOffsetOutOfRange
+	}
+	if value > 1 {
+		return 0, ErrBitValue
+	}
+
+	unlock := ds.lockKeys(key)
+	defer unlock()
+
+	meta, err := ds.findMetadata(key, Bitmap)
+	if err != nil {
+		return 0, err
+	}
+	editor := ds.newBitmapEditor(key, meta)
+	old, err := editor.getBit(offset)
+	if err != nil {
+		return 0, err
+	}
+	if err = editor.setBit(offset, value); err != nil {
+		return 0, err
+	}
+	return old, editor.commit()
+}
+
+// GetBit 返回offset位置的位，超出bitmap的部分为0
+func (ds *DataStructure) GetBit(key []byte, offset uint64) (byte, error) {
+	if offset > maxBitOffset {
+		return 0, ErrBitOffsetOutOfRange
+	}
+
+	meta, err := ds.findMetadata(key, Bitmap)
+	if err != nil {
+		return 0, err
+	}
+	return ds.newBitmapEditor(key, meta).getBit(offset)
+}
+
+// BitCount 返回范围内值为1的位的数量，r为nil时统计整个bitmap
+func (ds *DataStructure) BitCount(key []byte, r *BitRange) (int64, error) {
+	meta, err := ds.findMetadata(key, Bitmap)
+	if err != nil {
+		return 0, err
+	}
+	startBit, endBit, ok := bitRange(r, meta.size)
+	if !ok {
+		return 0, nil
+	}
+
+	var count int64
+	err = ds.bitmapSegments(key, meta, startBit/8, endBit/8, func(start uint64, data []byte) bool {
+		for i, b := range data {
+			index := start + uint64(i)
+			if index < startBit/8 || index > endBit/8 {
+				continue
+			}
+			count += int64(bits.OnesCount8(b & bitRangeMask(index, startBit, endBit)))
+		}
+		return true
+	})
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// BitPos 返回范围内第一个值为bit的位的偏移，没有找到时返回-1
+// 和redis相同，key不存在时查找0返回0；查找0并且没有指定End时，如果范围内全部为1，返回bitmap之后的第一个位
+func (ds *DataStructure) BitPos(key []byte, bit byte, r *BitRange) (int64, error) {
+	if bit > 1 {
+		return 0, ErrBitValue
+	}
+
+	meta, err := ds.findMetadata(key, Bitmap)
+	if err != nil {
+		return 0, err
+	}
+	if meta.size == 0 {
+		if bit == 0 {
+			return 0, nil
+		}
+		return -1, nil
+	}
+	startBit, endBit, ok := bitRange(r, meta.size)
+	if !ok {
+		return -1, nil
+	}
+
+	var pos int64 = -1
+	//下一个还没有检查的字节，不存在的分段全部为0
+	next := startBit / 8
+	firstZero := func() int64 {
+		if next*8 < startBit {
+			return int64(startBit)
+		}
+		return int64(next * 8)
+	}
+	err = ds.bitmapSegments(key, meta, startBit/8, endBit/8, func(start uint64, data []byte) bool {
+		if bit == 0 && start > next {
+			pos = firstZero()
+			return false
+		}
+		if next < start {
+			next = start
+		}
+		for ; next < start+uint64(len(data)) && next <= endBit/8; next++ {
+			if p := findBit(data[next-start], next, bit, startBit, endBit); p >= 0 {
+				pos = p
+				return false
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return 0, err
+	}
+	if pos < 0 && bit == 0 {
+		if next <= endBit/8 {
+			pos = firstZero()
+		} else if r == nil || !r.HasEnd {
+			pos = int64(meta.size) * 8
+		}
+	}
+	return pos, nil
 }
 
-func (ds *DataStructure) RPop(key []byte) ([]byte, error) {
-	return ds.popInner(key, false)
+// BitOp 对keys执行位运算并将结果写入destination，返回结果的字节数
+// 较短的bitmap和不存在的key按照0补齐，结果为空时删除destination
+func (ds *DataStructure) BitOp(op BitOperation, destination []byte, keys ...[]byte) (int64, error) {
+	if op == BitNot && len(keys) != 1 {
+		return 0, ErrBitOpNotArgs
+	}
+
+	unlock := ds.lockKeys(append([][]byte{destination}, keys...)...)
+	defer unlock()
+
+	sources := make([][]byte, len(keys))
+	var size int
+	for i, key := range keys {
+		meta, err := ds.findMetadata(key, Bitmap)
+		if err != nil {
+			return 0, err
+		}
+		if sources[i], err = ds.bitmapBytes(key, meta); err != nil {
+			return 0, err
+		}
+		if len(sources[i]) > size {
+			size = len(sources[i])
+		}
+	}
+
+	res := make([]byte, size)
+	for i := range res {
+		var b byte
+		for j, source := range sources {
+			var v byte
+			if i < len(source) {
+				v = source[i]
+			}
+			switch {
+			case j == 0:
+				b = v
+			case op == BitAnd:
+				b &= v
+			case op == BitOr:
+				b |= v
+			case op == BitXor:
+				b ^= v
+			}
+		}
+		if op == BitNot {
+			b = ^b
+		}
+		res[i] = b
+	}
+
+	writebatch := ds.newWriteBatch(size/bitmapSegmentSize + 3)
+	if err := ds.markGarbage(writebatch, destination); err != nil {
+		return 0, err
+	}
+	if size == 0 {
+		_ = writebatch.Delete(destination)
+		return 0, writebatch.Commit()
+	}
+	meta := &metadata{
+		dataType: Bitmap,
+		version:  time.Now().UnixNano(),
+		size:     uint32(size),
+	}
+	ds.putMetadata(writebatch, destination, meta)
+	for start := 0; start < size; start += bitmapSegmentSize {
+		segment := make([]byte, bitmapSegmentSize)
+		copy(segment, res[start:])
+		if isZeroSegment(segment) {
+			continue
+		}
+		bk := &BitmapInternalKey{
+			key:     destination,
+			version: meta.version,
+			segment: uint32(start / bitmapSegmentSize),
+		}
+		_ = writebatch.Put(bk.encode(), segment)
+	}
+	return int64(size), writebatch.Commit()
 }
 
-// LPush和RPush时处理head和tail
-func (ds *DataStructure) pushInner(key, element []byte, isLeft bool) (uint32, error) {
-	//查找元数据
-	meta, err := ds.findMetadata(key, List)
+// BitField 依次执行ops，返回每个操作的结果：GET和INCRBY为操作之后的值，SET为原来的值，FAIL策略下溢出时为nil
+func (ds *DataStructure) BitField(key []byte, ops []BitFieldOp) ([]*int64, error) {
+	readOnly := true
+	for _, op := range ops {
+		if op.Bits == 0 || (op.Signed && op.Bits > 64) || (!op.Signed && op.Bits > 63) {
+			return nil, ErrBitFieldType
+		}
+		if op.Offset > maxBitOffset-uint64(op.Bits)+1 {
+			return nil, ErrBitOffsetOutOfRange
+		}
+		if op.Command != BitFieldGet {
+			readOnly = false
+		}
+	}
+	if !readOnly {
+		unlock := ds.lockKeys(key)
+		defer unlock()
+	}
+
+	meta, err := ds.findMetadata(key, Bitmap)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
+	editor := ds.newBitmapEditor(key, meta)
+	res := make([]*int64, 0, len(ops))
+	for i := range ops {
+		op := &ops[i]
+		old, err := editor.getField(op)
+		if err != nil {
+			return nil, err
+		}
+		if op.Command == BitFieldGet {
+			res = append(res, &old)
+			continue
+		}
 
-	//构造数据部分的key
-	lk := &ListInternalKey{
-		key:     key,
-		version: meta.version,
+		var value int64
+		var ok bool
+		if op.Command == BitFieldSet {
+			value, ok = bitFieldIncr(op, 0, op.Value)
+		} else {
+			value, ok = bitFieldIncr(op, old, op.Value)
+		}
+		if !ok {
+			res = append(res, nil)
+			continue
+		}
+		if err = editor.setField(op, value); err != nil {
+			return nil, err
+		}
+		if op.Command == BitFieldSet {
+			res = append(res, &old)
+		} else {
+			res = append(res, &value)
+		}
 	}
-	if isLeft {
-		lk.index = meta.head - 1
+	if !readOnly {
+		if err = editor.commit(); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// bitFieldIncr 计算old+incr并按照op的类型和溢出策略处理，FAIL策略下溢出时返回false
+func bitFieldIncr(op *BitFieldOp, old, incr int64) (int64, bool) {
+	var min, max int64
+	if op.Signed {
+		min = -1 << (op.Bits - 1)
+		max = 1<<(op.Bits-1) - 1
 	} else {
-		lk.index = meta.tail
+		max = 1<<op.Bits - 1
 	}
 
-	//更新元数据和数据部分
-	writebatch := ds.db.NewWriteBatch(JDawDB.DefaultWriteBatchOptions)
-	meta.size++
-	if isLeft {
-		meta.head--
+	overflow := incr > 0 && old > max-incr
+	var underflow bool
+	if op.Signed {
+		underflow = incr < 0 && old < min-incr
 	} else {
-		meta.tail++
+		//old不小于0，相加不会溢出int64
+		underflow = incr < 0 && old+incr < 0
 	}
-	_ = writebatch.Put(key, meta.encode())
-	_ = writebatch.Put(lk.encode(), element)
-	if err = writebatch.Commit(); err != nil {
+	if !overflow && !underflow {
+		return old + incr, true
+	}
+
+	switch op.Overflow {
+	case BitFieldFail:
+		return 0, false
+	case BitFieldSat:
+		if overflow {
+			return max, true
+		}
+		return min, true
+	default:
+		//只保留低Bits位，有符号时再扩展符号位
+		v := uint64(old) + uint64(incr)
+		if op.Bits < 64 {
+			v &= 1<<op.Bits - 1
+			if op.Signed && v>>(op.Bits-1)&1 == 1 {
+				v |= math.MaxUint64 << op.Bits
+			}
+		}
+		return int64(v), true
+	}
+}
+
+// bitmapEditor 缓存读取过的分段，修改之后一起写入
+type bitmapEditor struct {
+	ds       *DataStructure
+	key      []byte
+	meta     *metadata
+	segments map[uint32][]byte
+	dirty    map[uint32]bool //修改过的分段
+	grown    bool            //size变大了，需要写入元数据
+}
+
+func (ds *DataStructure) newBitmapEditor(key []byte, meta *metadata) *bitmapEditor {
+	return &bitmapEditor{
+		ds:       ds,
+		key:      key,
+		meta:     meta,
+		segments: make(map[uint32][]byte),
+		dirty:    make(map[uint32]bool),
+	}
+}
+
+// segment 返回分段的内容，不存在的分段全部为0
+func (be *bitmapEditor) segment(seg uint32) ([]byte, error) {
+	if buf, ok := be.segments[seg]; ok {
+		return buf, nil
+	}
+	buf := make([]byte, bitmapSegmentSize)
+	//bitmap不会缩短，size之后的分段一定不存在
+	if uint64(seg)*bitmapSegmentSize < uint64(be.meta.size) {
+		bk := &BitmapInternalKey{
+			key:     be.key,
+			version: be.meta.version,
+			segment: seg,
+		}
+		value, err := be.ds.db.Get(bk.encode())
+		if err != nil && err != JDawDB.ErrKeyNotFound {
+			return nil, err
+		}
+		copy(buf, value)
+	}
+	be.segments[seg] = buf
+	return buf, nil
+}
+
+func (be *bitmapEditor) getBit(offset uint64) (byte, error) {
+	if offset/8 >= uint64(be.meta.size) {
+		return 0, nil
+	}
+	buf, err := be.segment(uint32(offset / 8 / bitmapSegmentSize))
+	if err != nil {
 		return 0, err
 	}
-	return meta.size, nil
+	return buf[offset/8%bitmapSegmentSize] >> (7 - offset%8) & 1, nil
 }
 
-// LPop和RPop时处理head和tail
-func (ds *DataStructure) popInner(key []byte, isLeft bool) ([]byte, error) {
-	//查找元数据
-	meta, err := ds.findMetadata(key, List)
+// setBit 修改offset位置的位，超出bitmap时将bitmap扩展到包含这个位
+func (be *bitmapEditor) setBit(offset uint64, value byte) error {
+	seg := uint32(offset / 8 / bitmapSegmentSize)
+	buf, err := be.segment(seg)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	if meta.size == 0 {
-		return nil, nil
+	index := offset / 8 % bitmapSegmentSize
+	mask := byte(1) << (7 - offset%8)
+	old := buf[index] & mask
+	if value == 1 {
+		buf[index] |= mask
+	} else {
+		buf[index] &^= mask
+	}
+	if buf[index]&mask != old {
+		be.dirty[seg] = true
 	}
+	if size := uint32(offset/8 + 1); size > be.meta.size {
+		be.meta.size = size
+		be.grown = true
+	}
+	return nil
+}
 
-	//构造数据部分的key
-	lk := &ListInternalKey{
+// getField 读取BITFIELD的字段，高位在前，有符号时扩展符号位
+func (be *bitmapEditor) getField(op *BitFieldOp) (int64, error) {
+	var v uint64
+	for i := uint64(0); i < uint64(op.Bits); i++ {
+		b, err := be.getBit(op.Offset + i)
+		if err != nil {
+			return 0, err
+		}
+		v = v<<1 | uint64(b)
+	}
+	if op.Signed && op.Bits < 64 && v>>(op.Bits-1)&1 == 1 {
+		v |= math.MaxUint64 << op.Bits
+	}
+	return int64(v), nil
+}
+
+// setField 将value的低Bits位写入BITFIELD的字段
+func (be *bitmapEditor) setField(op *BitFieldOp, value int64) error {
+	v := uint64(value)
+	for i := uint64(0); i < uint64(op.Bits); i++ {
+		if err := be.setBit(op.Offset+i, byte(v>>(uint64(op.Bits)-1-i)&1)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// commit 写入修改过的分段和元数据，全部为0的分段直接删除
+func (be *bitmapEditor) commit() error {
+	if len(be.dirty) == 0 && !be.grown {
+		return nil
+	}
+	writebatch := be.ds.newWriteBatch(len(be.dirty) + 2)
+	if be.grown {
+		be.ds.putMetadata(writebatch, be.key, be.meta)
+	}
+	for seg := range be.dirty {
+		bk := &BitmapInternalKey{
+			key:     be.key,
+			version: be.meta.version,
+			segment: seg,
+		}
+		if isZeroSegment(be.segments[seg]) {
+			_ = writebatch.Delete(bk.encode())
+		} else {
+			_ = writebatch.Put(bk.encode(), be.segments[seg])
+		}
+	}
+	return writebatch.Commit()
+}
+
+// bitmapSegments 按顺序遍历包含[fromByte, toByte]的分段中存在的部分，start为分段第一个字节的偏移
+func (ds *DataStructure) bitmapSegments(key []byte, meta *metadata, fromByte, toByte uint64, fn func(start uint64, data []byte) bool) error {
+	if meta.size == 0 {
+		return nil
+	}
+	seek := &BitmapInternalKey{
 		key:     key,
 		version: meta.version,
+		segment: uint32(fromByte / bitmapSegmentSize),
 	}
-	if isLeft {
-		lk.index = meta.head
-	} else {
-		lk.index = meta.tail - 1
-	}
+	lastSegment := uint32(toByte / bitmapSegmentSize)
+	return ds.scanInternalKeysFrom(internalKeyPrefix(key, meta.version), seek.encode(), false, true, func(suffix, value []byte) bool {
+		seg := binary.BigEndian.Uint32(suffix)
+		if seg > lastSegment {
+			return false
+		}
+		return fn(uint64(seg)*bitmapSegmentSize, value)
+	})
+}
 
-	element, err := ds.db.Get(lk.encode())
+// bitmapBytes 读取整个bitmap
+func (ds *DataStructure) bitmapBytes(key []byte, meta *metadata) ([]byte, error) {
+	res := make([]byte, meta.size)
+	err := ds.bitmapSegments(key, meta, 0, uint64(meta.size), func(start uint64, data []byte) bool {
+		copy(res[start:], data)
+		return true
+	})
 	if err != nil {
 		return nil, err
 	}
+	return res, nil
+}
 
-	meta.size--
-	if isLeft {
-		meta.head++
-	} else {
-		meta.tail--
+// bitRange 将r转换为位的范围[startBit, endBit]，size为bitmap的字节数，范围为空时返回false
+func bitRange(r *BitRange, size uint32) (uint64, uint64, bool) {
+	if size == 0 {
+		return 0, 0, false
 	}
-	if err = ds.db.Put(key, meta.encode()); err != nil {
-		return nil, err
+	if r == nil {
+		return 0, uint64(size)*8 - 1, true
 	}
+	end := r.End
+	if !r.HasEnd {
+		end = -1
+	}
+	if r.Bit {
+		start, end, ok := normalizeRange(r.Start, end, int64(size)*8)
+		return uint64(start), uint64(end), ok
+	}
+	start, end, ok := normalizeRange(r.Start, end, int64(size))
+	return uint64(start) * 8, uint64(end)*8 + 7, ok
+}
 
-	return element, err
+// bitRangeMask 第index个字节中落在[startBit, endBit]中的位
+func bitRangeMask(index, startBit, endBit uint64) byte {
+	mask := byte(0xff)
+	if index*8 < startBit {
+		mask &= 0xff >> (startBit - index*8)
+	}
+	if index*8+7 > endBit {
+		mask &= 0xff << (index*8 + 7 - endBit)
+	}
+	return mask
 }
 
-// -----------------ZSet数据结构-----------------
+// findBit 返回第index个字节b中第一个值为bit并且落在[startBit, endBit]中的位的偏移，没有时返回-1
+func findBit(b byte, index uint64, bit byte, startBit, endBit uint64) int64 {
+	if bit == 0 {
+		b = ^b
+	}
+	b &= bitRangeMask(index, startBit, endBit)
+	if b == 0 {
+		return -1
+	}
+	return int64(index*8) + int64(bits.LeadingZeros8(b))
+}
 
-func (ds *DataStructure) ZAdd(key []byte, score float64, member []byte) (bool, error) {
-	//查找元数据
-	meta, err := ds.findMetadata(key, ZSet)
+func isZeroSegment(segment []byte) bool {
+	for _, b := range segment {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// -----------------HyperLogLog数据结构-----------------
+
+const (
+	hllP         = 14        //用hash的低14位选择寄存器
+	hllQ         = 64 - hllP //剩下的50位用于计算末尾0的数量
+	hllRegisters = 1 << hllP //寄存器数量，标准误差为1.04/sqrt(16384)=0.81%
+	hllBits      = 6         //dense编码中每个寄存器占用的位数
+	hllDenseSize = hllRegisters * hllBits / 8
+	// hllSparseMaxBytes sparse编码超过这个大小时转换为dense编码，和redis的hll-sparse-max-bytes默认值相同
+	hllSparseMaxBytes = 3000
+	// hllSeed 和redis相同的hash种子
+	hllSeed     = 0xadc83b19
+	hllAlphaInf = 0.721347520444481703680
+)
+
+// HyperLogLog的value为type+expire+编码+寄存器
+const (
+	hllSparse byte = iota //非0的寄存器按照序号排序，每个占3个字节：序号（2字节大端）+值
+	hllDense              //16384个6位的寄存器，从低位开始紧密排列
+)
+
+// hyperLogLog 解码之后的寄存器
+type hyperLogLog struct {
+	registers [hllRegisters]uint8
+	dense     bool //转换为dense编码之后不再转换回sparse
+}
+
+// PFAdd 将elements加入HyperLogLog，有寄存器被修改或者key被创建时返回true
+func (ds *DataStructure) PFAdd(key []byte, elements ...[]byte) (bool, error) {
+	unlock := ds.lockKeys(key)
+	defer unlock()
+
+	hll, expire, exist, err := ds.getHyperLogLog(key)
 	if err != nil {
 		return false, err
 	}
+	changed := !exist
+	for _, element := range elements {
+		if hll.add(element) {
+			changed = true
+		}
+	}
+	if !changed {
+		return false, nil
+	}
+	//key可能是已经过期的集合类型，覆盖时需要回收它的数据部分
+	return true, ds.replaceKey(key, encodeValue(HyperLogLog, hll.encode(), expire))
+}
 
-	//构造数据部分的key
-	zk := &ZSetInternalKey{
-		key:     key,
-		version: meta.version,
-		score:   score,
-		member:  member,
+// PFCount 返回keys合并之后的基数估计值，不存在的key当作空集
+func (ds *DataStructure) PFCount(keys ...[]byte) (uint64, error) {
+	merged := &hyperLogLog{}
+	for _, key := range keys {
+		hll, _, _, err := ds.getHyperLogLog(key)
+		if err != nil {
+			return 0, err
+		}
+		merged.merge(hll)
 	}
+	return merged.count(), nil
+}
 
-	//查看是否已经存在
-	var exist = true
-	value, err := ds.db.Get(zk.encodeWithMember())
-	if err != nil && err != JDawDB.ErrKeyNotFound {
-		return false, err
+// PFMerge 将destination和keys合并之后写入destination，destination的过期时间保持不变，结果使用dense编码
+func (ds *DataStructure) PFMerge(destination []byte, keys ...[]byte) error {
+	unlock := ds.lockKeys(append([][]byte{destination}, keys...)...)
+	defer unlock()
+
+	merged, expire, _, err := ds.getHyperLogLog(destination)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		hll, _, _, err := ds.getHyperLogLog(key)
+		if err != nil {
+			return err
+		}
+		merged.merge(hll)
 	}
+	merged.dense = true
+	return ds.replaceKey(destination, encodeValue(HyperLogLog, merged.encode(), expire))
+}
+
+// getHyperLogLog 读取并解码HyperLogLog，key不存在或者已经过期时返回空的HyperLogLog并且exist为false
+func (ds *DataStructure) getHyperLogLog(key []byte) (*hyperLogLog, int64, bool, error) {
+	encValue, err := ds.liveValue(key)
 	if err == JDawDB.ErrKeyNotFound {
-		exist = false
+		return &hyperLogLog{}, 0, false, nil
 	}
-	if exist {
-		if score == utils.FloatFromBytes(value) {
-			return false, nil
-		}
+	if err != nil {
+		return nil, 0, false, err
+	}
+	if encValue[0] != HyperLogLog {
+		return nil, 0, false, ErrWrongTypeOperation
 	}
+	payload, expire := decodeString(encValue)
+	hll, ok := decodeHyperLogLog(payload)
+	if !ok {
+		return nil, 0, false, ErrInvalidHyperLogLog
+	}
+	return hll, expire, true, nil
+}
 
-	//需要更新元数据
-	writebatch := ds.db.NewWriteBatch(JDawDB.DefaultWriteBatchOptions)
-	if !exist {
-		meta.size++
-		_ = writebatch.Put(key, meta.encode())
+// add 加入一个元素，寄存器被修改时返回true
+func (hll *hyperLogLog) add(element []byte) bool {
+	hash := utils.MurmurHash64A(element, hllSeed)
+	index := hash & (hllRegisters - 1)
+	//最高位补1，末尾0的数量不会超过hllQ
+	hash = hash>>hllP | 1<<hllQ
+	count := uint8(bits.TrailingZeros64(hash)) + 1
+	if count <= hll.registers[index] {
+		return false
 	}
-	if exist {
-		//需要删除旧的key
-		oldKey := &ZSetInternalKey{
-			key:     key,
-			version: meta.version,
-			member:  member,
-			score:   utils.FloatFromBytes(value),
+	hll.registers[index] = count
+	return true
+}
+
+// merge 每个寄存器取两者中的较大值
+func (hll *hyperLogLog) merge(other *hyperLogLog) {
+	for i, v := range other.registers {
+		if v > hll.registers[i] {
+			hll.registers[i] = v
 		}
-		_ = writebatch.Delete(oldKey.encodeWithScore())
 	}
-	//更新数据部分
-	_ = writebatch.Put(zk.encodeWithMember(), utils.Float64ToBytes(score))
-	_ = writebatch.Put(zk.encodeWithScore(), nil)
-	if err = writebatch.Commit(); err != nil {
-		return false, err
+	hll.dense = hll.dense || other.dense
+}
+
+// count 使用Otmar Ertl的改进估计算法计算基数，和redis相同，在整个范围内都不需要偏差修正
+func (hll *hyperLogLog) count() uint64 {
+	var histogram [hllQ + 2]int
+	for _, v := range hll.registers {
+		histogram[v]++
 	}
 
-	return !exist, nil
+	m := float64(hllRegisters)
+	z := m * hllTau((m-float64(histogram[hllQ+1]))/m)
+	for j := hllQ; j >= 1; j-- {
+		z += float64(histogram[j])
+		z *= 0.5
+	}
+	z += m * hllSigma(float64(histogram[0])/m)
+	return uint64(math.Round(hllAlphaInf * m * m / z))
 }
 
-func (ds *DataStructure) ZScore(key []byte, member []byte) (float64, error) {
-	meta, err := ds.findMetadata(key, ZSet)
-	if err != nil {
-		return -1, err
+func hllSigma(x float64) float64 {
+	if x == 1 {
+		return math.Inf(1)
 	}
-	if meta.size == 0 {
-		return -1, nil
+	y, z := 1.0, x
+	for {
+		x *= x
+		zPrime := z
+		z += x * y
+		y += y
+		if zPrime == z {
+			return z
+		}
 	}
+}
 
-	// 构造数据部分的key
-	zk := &ZSetInternalKey{
-		key:     key,
-		version: meta.version,
-		member:  member,
+func hllTau(x float64) float64 {
+	if x == 0 || x == 1 {
+		return 0
+	}
+	y, z := 1.0, 1-x
+	for {
+		x = math.Sqrt(x)
+		zPrime := z
+		y *= 0.5
+		z -= math.Pow(1-x, 2) * y
+		if zPrime == z {
+			return z / 3
+		}
 	}
+}
 
-	value, err := ds.db.Get(zk.encodeWithMember())
-	if err != nil {
-		return -1, err
+// encode 编码寄存器，sparse编码超过hllSparseMaxBytes时转换为dense编码
+func (hll *hyperLogLog) encode() []byte {
+	if !hll.dense {
+		buf := make([]byte, 1, hllSparseMaxBytes+1)
+		buf[0] = hllSparse
+		for i, v := range hll.registers {
+			if v == 0 {
+				continue
+			}
+			if len(buf)+3 > hllSparseMaxBytes+1 {
+				hll.dense = true
+				break
+			}
+			buf = append(buf, byte(i>>8), byte(i), v)
+		}
+		if !hll.dense {
+			return buf
+		}
 	}
 
-	return utils.FloatFromBytes(value), nil
+	buf := make([]byte, 1+hllDenseSize)
+	buf[0] = hllDense
+	registers := buf[1:]
+	for i, v := range hll.registers {
+		pos := i * hllBits
+		registers[pos/8] |= v << (pos % 8)
+		if pos%8 > 8-hllBits {
+			registers[pos/8+1] |= v >> (8 - pos%8)
+		}
+	}
+	return buf
+}
+
+// decodeHyperLogLog 解码寄存器，格式错误时返回false
+func decodeHyperLogLog(buf []byte) (*hyperLogLog, bool) {
+	if len(buf) == 0 {
+		return nil, false
+	}
+	hll := &hyperLogLog{}
+	switch buf[0] {
+	case hllSparse:
+		entries := buf[1:]
+		if len(entries)%3 != 0 {
+			return nil, false
+		}
+		for i := 0; i < len(entries); i += 3 {
+			index := int(entries[i])<<8 | int(entries[i+1])
+			if index >= hllRegisters || entries[i+2] > hllQ+1 {
+				return nil, false
+			}
+			hll.registers[index] = entries[i+2]
+		}
+	case hllDense:
+		registers := buf[1:]
+		if len(registers) != hllDenseSize {
+			return nil, false
+		}
+		hll.dense = true
+		for i := range hll.registers {
+			pos := i * hllBits
+			v := registers[pos/8] >> (pos % 8)
+			if pos%8 > 8-hllBits {
+				v |= registers[pos/8+1] << (8 - pos%8)
+			}
+			hll.registers[i] = v & (1<<hllBits - 1)
+		}
+	default:
+		return nil, false
+	}
+	return hll, true
 }
 
 func (ds *DataStructure) findMetadata(key []byte, dataType DataType) (*metadata, error) {
@@ -457,17 +3061,20 @@ func (ds *DataStructure) findMetadata(key []byte, dataType DataType) (*metadata,
 
 	var meta *metadata
 	var exist = true //除了本身不存在，如果过期了也是不存在，需要标识
+	var replaced *metadata
 	if err == JDawDB.ErrKeyNotFound {
 		exist = false
+	} else if expire, _ := binary.Varint(metaBuf[1:]); expire > 0 && expire <= time.Now().UnixNano() {
+		//string和其它类型都以type+expire开头，过期的key不论是什么类型都当作不存在
+		//过期之后会使用新的版本，集合类型旧版本的数据部分在写入新的元数据时加入回收队列
+		exist = false
+		replaced, _ = parseMetadata(metaBuf)
 	} else {
 		meta = decode(metaBuf)
 		//判断数据类型
 		if meta.dataType != dataType {
 			return nil, ErrWrongTypeOperation
 		}
-		if meta.expire > 0 && meta.expire <= time.Now().UnixNano() {
-			exist = false
-		}
 	}
 
 	if !exist {
@@ -477,6 +3084,7 @@ func (ds *DataStructure) findMetadata(key []byte, dataType DataType) (*metadata,
 			expire:   0,
 			version:  time.Now().UnixNano(),
 			size:     0,
+			replaced: replaced,
 		}
 		if dataType == List {
 			meta.head = initialListMark
@@ -1,10 +1,16 @@
 package structure
 
 import (
+	"bytes"
+	"context"
+	"fmt"
 	"github.com/GrandeLai/JDawDB"
 	"github.com/GrandeLai/JDawDB/utils"
 	"github.com/stretchr/testify/assert"
+	"math"
 	"os"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -59,6 +65,221 @@ func TestDataStructure_Del_Type(t *testing.T) {
 	assert.Equal(t, JDawDB.ErrKeyNotFound, err)
 }
 
+// 过期的key不论原来是什么类型，都可以作为其它类型重新写入
+func TestDataStructure_WriteAfterExpire(t *testing.T) {
+	opts := JDawDB.DefaultOptions
+	dir, _ := os.MkdirTemp("", "JDawDB-redis-write-after-expire")
+	opts.DirPath = dir
+	ds, err := NewDataStructure(opts)
+	assert.Nil(t, err)
+
+	err = ds.Set([]byte("str"), time.Millisecond, []byte("v"))
+	assert.Nil(t, err)
+	_, err = ds.SAdd([]byte("set"), []byte("m"))
+	assert.Nil(t, err)
+	_, err = ds.Expire([]byte("set"), time.Millisecond)
+	assert.Nil(t, err)
+	time.Sleep(time.Millisecond * 5)
+
+	_, err = ds.HSet([]byte("str"), []byte("f"), []byte("v"))
+	assert.Nil(t, err)
+	_, err = ds.RPush([]byte("set"), []byte("a"))
+	assert.Nil(t, err)
+	typ, err := ds.Type([]byte("str"))
+	assert.Nil(t, err)
+	assert.Equal(t, Hash, typ)
+	typ, err = ds.Type([]byte("set"))
+	assert.Nil(t, err)
+	assert.Equal(t, List, typ)
+
+	// 过期的set的member在写入list时加入了回收队列
+	reclaimed, err := ds.CollectGarbage()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, reclaimed)
+
+	_, err = ds.HSet([]byte("hash"), []byte("f"), []byte("v"))
+	assert.Nil(t, err)
+	_, err = ds.Expire([]byte("hash"), time.Millisecond)
+	assert.Nil(t, err)
+	time.Sleep(time.Millisecond * 5)
+	val, err := ds.Get([]byte("hash"))
+	assert.Nil(t, err)
+	assert.Nil(t, val)
+	assert.Nil(t, ds.Close())
+	_ = os.RemoveAll(dir)
+}
+
+func TestDataStructure_SetWithOptions(t *testing.T) {
+	opts := JDawDB.DefaultOptions
+	dir, _ := os.MkdirTemp("", "JDawDB-set-options")
+	opts.DirPath = dir
+	ds, err := NewDataStructure(opts)
+	assert.Nil(t, err)
+
+	old, ok, err := ds.SetWithOptions(utils.GetTestKey(1), []byte("v1"), SetOptions{XX: true})
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	assert.Nil(t, old)
+
+	_, ok, err = ds.SetWithOptions(utils.GetTestKey(1), []byte("v1"), SetOptions{NX: true, TTL: time.Hour})
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	_, ok, err = ds.SetWithOptions(utils.GetTestKey(1), []byte("v2"), SetOptions{NX: true})
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	//KEEPTTL保留过期时间
+	old, ok, err = ds.SetWithOptions(utils.GetTestKey(1), []byte("v2"), SetOptions{XX: true, KeepTTL: true, Get: true})
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, []byte("v1"), old)
+	_, expire, _, err := ds.getString(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.True(t, expire > 0)
+
+	old, err = ds.GetSet(utils.GetTestKey(1), []byte("v3"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v2"), old)
+	_, expire, _, err = ds.getString(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), expire)
+
+	//过期的key当作不存在
+	err = ds.Set(utils.GetTestKey(2), time.Millisecond, []byte("expired"))
+	assert.Nil(t, err)
+	time.Sleep(time.Millisecond * 5)
+	ok, err = ds.SetNX(utils.GetTestKey(2), []byte("v"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	//SET覆盖其它类型，GET选项遇到其它类型时报错
+	_, err = ds.HSet(utils.GetTestKey(3), []byte("field"), []byte("value"))
+	assert.Nil(t, err)
+	ok, err = ds.SetNX(utils.GetTestKey(3), []byte("v"))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	_, _, err = ds.SetWithOptions(utils.GetTestKey(3), []byte("v"), SetOptions{Get: true})
+	assert.Equal(t, ErrWrongTypeOperation, err)
+	_, ok, err = ds.SetWithOptions(utils.GetTestKey(3), []byte("v"), SetOptions{})
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	val, err := ds.Get(utils.GetTestKey(3))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v"), val)
+
+	err = ds.MSet(utils.GetTestKey(4), []byte("a"), utils.GetTestKey(5), []byte("b"))
+	assert.Nil(t, err)
+	values, err := ds.MGet(utils.GetTestKey(4), utils.GetTestKey(6), utils.GetTestKey(5))
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("a"), nil, []byte("b")}, values)
+	assert.Equal(t, ErrWrongNumberOfPairs, ds.MSet(utils.GetTestKey(4)))
+}
+
+func TestDataStructure_Incr(t *testing.T) {
+	opts := JDawDB.DefaultOptions
+	dir, _ := os.MkdirTemp("", "JDawDB-incr")
+	opts.DirPath = dir
+	ds, err := NewDataStructure(opts)
+	assert.Nil(t, err)
+
+	res, err := ds.Incr(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), res)
+	res, err = ds.IncrBy(utils.GetTestKey(1), 10)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(11), res)
+	res, err = ds.DecrBy(utils.GetTestKey(1), 20)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(-9), res)
+	res, err = ds.Decr(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(-10), res)
+	_, err = ds.IncrBy(utils.GetTestKey(1), math.MinInt64)
+	assert.Equal(t, ErrIncrOverflow, err)
+
+	f, err := ds.IncrByFloat(utils.GetTestKey(1), 0.5)
+	assert.Nil(t, err)
+	assert.Equal(t, -9.5, f)
+	_, err = ds.Incr(utils.GetTestKey(1))
+	assert.Equal(t, ErrValueNotInteger, err)
+
+	//并发的INCR不会丢失更新
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				_, err := ds.Incr(utils.GetTestKey(2))
+				assert.Nil(t, err)
+			}
+		}()
+	}
+	wg.Wait()
+	val, err := ds.Get(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("100"), val)
+
+	_, err = ds.HSet(utils.GetTestKey(3), []byte("field"), []byte("1"))
+	assert.Nil(t, err)
+	_, err = ds.Incr(utils.GetTestKey(3))
+	assert.Equal(t, ErrWrongTypeOperation, err)
+}
+
+func TestDataStructure_Append(t *testing.T) {
+	opts := JDawDB.DefaultOptions
+	dir, _ := os.MkdirTemp("", "JDawDB-append")
+	opts.DirPath = dir
+	ds, err := NewDataStructure(opts)
+	assert.Nil(t, err)
+
+	n, err := ds.Append(utils.GetTestKey(1), []byte("Hello"))
+	assert.Nil(t, err)
+	assert.Equal(t, 5, n)
+	n, err = ds.Append(utils.GetTestKey(1), []byte(" World"))
+	assert.Nil(t, err)
+	assert.Equal(t, 11, n)
+
+	n, err = ds.StrLen(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, 11, n)
+	n, err = ds.StrLen(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.Equal(t, 0, n)
+
+	sub, err := ds.GetRange(utils.GetTestKey(1), 0, 4)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("Hello"), sub)
+	sub, err = ds.GetRange(utils.GetTestKey(1), -5, -1)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("World"), sub)
+	sub, err = ds.GetRange(utils.GetTestKey(1), 20, 30)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{}, sub)
+
+	n, err = ds.SetRange(utils.GetTestKey(1), 6, []byte("Redis"))
+	assert.Nil(t, err)
+	assert.Equal(t, 11, n)
+	val, err := ds.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("Hello Redis"), val)
+
+	n, err = ds.SetRange(utils.GetTestKey(2), 3, []byte("abc"))
+	assert.Nil(t, err)
+	assert.Equal(t, 6, n)
+	val, err = ds.Get(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{0, 0, 0, 'a', 'b', 'c'}, val)
+
+	n, err = ds.SetRange(utils.GetTestKey(3), 3, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, n)
+	_, err = ds.Get(utils.GetTestKey(3))
+	assert.Equal(t, JDawDB.ErrKeyNotFound, err)
+	_, err = ds.SetRange(utils.GetTestKey(3), maxStringSize, []byte("a"))
+	assert.Equal(t, ErrStringTooLong, err)
+}
+
 func TestDataStructure_HGet(t *testing.T) {
 	opts := JDawDB.DefaultOptions
 	dir, _ := os.MkdirTemp("", "JDawDB-hget")
@@ -122,6 +343,201 @@ func TestDataStructure_HDel(t *testing.T) {
 	assert.True(t, del2)
 }
 
+func TestDataStructure_HGetAll(t *testing.T) {
+	opts := JDawDB.DefaultOptions
+	dir, _ := os.MkdirTemp("", "JDawDB-hgetall")
+	opts.DirPath = dir
+	ds, err := NewDataStructure(opts)
+	assert.Nil(t, err)
+
+	all, err := ds.HGetAll(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(all))
+
+	added, err := ds.HMSet(utils.GetTestKey(1), []byte("f2"), []byte("v2"), []byte("f1"), []byte("v1"), []byte("f2"), []byte("v2-new"))
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(2), added)
+	_, err = ds.HMSet(utils.GetTestKey(1), []byte("f3"))
+	assert.Equal(t, ErrWrongNumberOfPairs, err)
+
+	// field数量超过默认的批次上限
+	var fieldValues [][]byte
+	for i := 0; i < int(JDawDB.DefaultWriteBatchOptions.MaxBatchNum); i++ {
+		fieldValues = append(fieldValues, []byte(fmt.Sprintf("many-%d", i)), []byte("v"))
+	}
+	added, err = ds.HMSet(utils.GetTestKey(2), fieldValues...)
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(JDawDB.DefaultWriteBatchOptions.MaxBatchNum), added)
+
+	ok, err := ds.HSetNX(utils.GetTestKey(1), []byte("f1"), []byte("v1-new"))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	ok, err = ds.HSetNX(utils.GetTestKey(1), []byte("f3"), []byte("v3"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	size, err := ds.HLen(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(3), size)
+
+	all, err = ds.HGetAll(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("f1"), []byte("v1"), []byte("f2"), []byte("v2-new"), []byte("f3"), []byte("v3")}, all)
+
+	keys, err := ds.HKeys(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("f1"), []byte("f2"), []byte("f3")}, keys)
+
+	vals, err := ds.HVals(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("v1"), []byte("v2-new"), []byte("v3")}, vals)
+
+	values, err := ds.HMGet(utils.GetTestKey(1), []byte("f1"), []byte("not-exist"), []byte("f3"))
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("v1"), nil, []byte("v3")}, values)
+
+	exist, err := ds.HExists(utils.GetTestKey(1), []byte("f2"))
+	assert.Nil(t, err)
+	assert.True(t, exist)
+	exist, err = ds.HExists(utils.GetTestKey(1), []byte("not-exist"))
+	assert.Nil(t, err)
+	assert.False(t, exist)
+
+	//key作为前缀的其它hash不会被遍历到
+	_, err = ds.HSet(append(utils.GetTestKey(1), 'x'), []byte("f4"), []byte("v4"))
+	assert.Nil(t, err)
+	keys, err = ds.HKeys(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(keys))
+
+	err = ds.Set(utils.GetTestKey(2), 0, []byte("string"))
+	assert.Nil(t, err)
+	_, err = ds.HGetAll(utils.GetTestKey(2))
+	assert.Equal(t, ErrWrongTypeOperation, err)
+}
+
+func TestDataStructure_HIncrBy(t *testing.T) {
+	opts := JDawDB.DefaultOptions
+	dir, _ := os.MkdirTemp("", "JDawDB-hincrby")
+	opts.DirPath = dir
+	ds, err := NewDataStructure(opts)
+	assert.Nil(t, err)
+
+	res, err := ds.HIncrBy(utils.GetTestKey(1), []byte("counter"), 5)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(5), res)
+	res, err = ds.HIncrBy(utils.GetTestKey(1), []byte("counter"), -8)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(-3), res)
+
+	val, err := ds.HGet(utils.GetTestKey(1), []byte("counter"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("-3"), val)
+
+	_, err = ds.HIncrBy(utils.GetTestKey(1), []byte("counter"), math.MinInt64)
+	assert.Equal(t, ErrIncrOverflow, err)
+
+	_, err = ds.HSet(utils.GetTestKey(1), []byte("name"), []byte("abc"))
+	assert.Nil(t, err)
+	_, err = ds.HIncrBy(utils.GetTestKey(1), []byte("name"), 1)
+	assert.Equal(t, ErrHashValueNotInteger, err)
+	_, err = ds.HIncrByFloat(utils.GetTestKey(1), []byte("name"), 1)
+	assert.Equal(t, ErrHashValueNotFloat, err)
+
+	f, err := ds.HIncrByFloat(utils.GetTestKey(1), []byte("counter"), 0.5)
+	assert.Nil(t, err)
+	assert.Equal(t, -2.5, f)
+	_, err = ds.HIncrByFloat(utils.GetTestKey(1), []byte("counter"), math.Inf(1))
+	assert.Equal(t, ErrIncrNaNOrInf, err)
+
+	size, err := ds.HLen(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(2), size)
+}
+
+func TestDataStructure_HIncrBy_Concurrent(t *testing.T) {
+	opts := JDawDB.DefaultOptions
+	dir, _ := os.MkdirTemp("", "JDawDB-hincrby-concurrent")
+	opts.DirPath = dir
+	ds, err := NewDataStructure(opts)
+	assert.Nil(t, err)
+
+	// 并发的读后写不会丢失更新，新增的field也不会丢失size
+	wg := new(sync.WaitGroup)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				_, err := ds.HIncrBy([]byte("hash"), []byte("counter"), 1)
+				assert.Nil(t, err)
+				_, err = ds.HSet([]byte("hash"), []byte(fmt.Sprintf("field-%d-%d", i, j)), []byte("v"))
+				assert.Nil(t, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	val, err := ds.HGet([]byte("hash"), []byte("counter"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("1600"), val)
+	size, err := ds.HLen([]byte("hash"))
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(1601), size)
+}
+
+func TestDataStructure_HScan(t *testing.T) {
+	opts := JDawDB.DefaultOptions
+	dir, _ := os.MkdirTemp("", "JDawDB-hscan")
+	opts.DirPath = dir
+	ds, err := NewDataStructure(opts)
+	assert.Nil(t, err)
+
+	for i := 0; i < 25; i++ {
+		_, err = ds.HSet(utils.GetTestKey(1), []byte(fmt.Sprintf("field-%02d", i)), []byte(fmt.Sprintf("value-%02d", i)))
+		assert.Nil(t, err)
+	}
+
+	var cursor []byte
+	var fields [][]byte
+	for rounds := 0; ; rounds++ {
+		next, res, err := ds.HScan(utils.GetTestKey(1), cursor, "", 10)
+		assert.Nil(t, err)
+		assert.True(t, len(res) <= 20)
+		fields = append(fields, res...)
+		if next == nil {
+			assert.Equal(t, 2, rounds)
+			break
+		}
+		cursor = next
+	}
+	assert.Equal(t, 50, len(fields))
+	assert.Equal(t, []byte("field-00"), fields[0])
+	assert.Equal(t, []byte("value-00"), fields[1])
+
+	next, res, err := ds.HScan(utils.GetTestKey(1), nil, "field-1?", 100)
+	assert.Nil(t, err)
+	assert.Nil(t, next)
+	assert.Equal(t, 20, len(res))
+
+	next, res, err = ds.HScan(utils.GetTestKey(2), nil, "", 10)
+	assert.Nil(t, err)
+	assert.Nil(t, next)
+	assert.Equal(t, 0, len(res))
+
+	//遍历期间删除已经返回的field，不会遗漏剩下的field
+	next, res, err = ds.HScan(utils.GetTestKey(1), nil, "", 10)
+	assert.Nil(t, err)
+	for i := 0; i < len(res); i += 2 {
+		_, err = ds.HDel(utils.GetTestKey(1), res[i])
+		assert.Nil(t, err)
+	}
+	_, res, err = ds.HScan(utils.GetTestKey(1), next, "", 100)
+	assert.Nil(t, err)
+	assert.Equal(t, 30, len(res))
+	assert.Equal(t, []byte("field-10"), res[0])
+}
+
 func TestDataStructure_SIsMember(t *testing.T) {
 	opts := JDawDB.DefaultOptions
 	dir, _ := os.MkdirTemp("", "JDawDB-sismember")
@@ -182,6 +598,187 @@ func TestDataStructure_SRem(t *testing.T) {
 	assert.False(t, ok)
 }
 
+func TestDataStructure_SMembers(t *testing.T) {
+	opts := JDawDB.DefaultOptions
+	dir, _ := os.MkdirTemp("", "JDawDB-smembers")
+	opts.DirPath = dir
+	ds, err := NewDataStructure(opts)
+	assert.Nil(t, err)
+
+	for _, member := range []string{"c", "a", "b"} {
+		_, err = ds.SAdd(utils.GetTestKey(1), []byte(member))
+		assert.Nil(t, err)
+	}
+
+	members, err := ds.SMembers(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("a"), []byte("b"), []byte("c")}, members)
+	size, err := ds.SCard(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(3), size)
+
+	random, err := ds.SRandMember(utils.GetTestKey(1), 5)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(random))
+	random, err = ds.SRandMember(utils.GetTestKey(1), -5)
+	assert.Nil(t, err)
+	assert.Equal(t, 5, len(random))
+
+	popped, err := ds.SPop(utils.GetTestKey(1), 2)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(popped))
+	for _, member := range popped {
+		ok, err := ds.SIsMember(utils.GetTestKey(1), member)
+		assert.Nil(t, err)
+		assert.False(t, ok)
+	}
+	size, err = ds.SCard(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(1), size)
+
+	//移动剩下的member
+	members, err = ds.SMembers(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(members))
+	ok, err := ds.SMove(utils.GetTestKey(1), utils.GetTestKey(2), members[0])
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	ok, err = ds.SMove(utils.GetTestKey(1), utils.GetTestKey(2), members[0])
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	ok, err = ds.SIsMember(utils.GetTestKey(2), members[0])
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	size, err = ds.SCard(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(0), size)
+}
+
+func TestDataStructure_SInter(t *testing.T) {
+	opts := JDawDB.DefaultOptions
+	dir, _ := os.MkdirTemp("", "JDawDB-sinter")
+	opts.DirPath = dir
+	ds, err := NewDataStructure(opts)
+	assert.Nil(t, err)
+
+	for _, member := range []string{"a", "b", "c", "d"} {
+		_, err = ds.SAdd(utils.GetTestKey(1), []byte(member))
+		assert.Nil(t, err)
+	}
+	for _, member := range []string{"c", "d", "e"} {
+		_, err = ds.SAdd(utils.GetTestKey(2), []byte(member))
+		assert.Nil(t, err)
+	}
+
+	inter, err := ds.SInter(utils.GetTestKey(1), utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("c"), []byte("d")}, inter)
+
+	union, err := ds.SUnion(utils.GetTestKey(2), utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("a"), []byte("b"), []byte("c"), []byte("d"), []byte("e")}, union)
+
+	diff, err := ds.SDiff(utils.GetTestKey(1), utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("a"), []byte("b")}, diff)
+
+	inter, err = ds.SInter(utils.GetTestKey(1), utils.GetTestKey(3))
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(inter))
+
+	//store会覆盖destination原来的数据
+	_, err = ds.SAdd(utils.GetTestKey(3), []byte("old"))
+	assert.Nil(t, err)
+	size, err := ds.SDiffStore(utils.GetTestKey(3), utils.GetTestKey(1), utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(2), size)
+	members, err := ds.SMembers(utils.GetTestKey(3))
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("a"), []byte("b")}, members)
+
+	size, err = ds.SUnionStore(utils.GetTestKey(4), utils.GetTestKey(1), utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(5), size)
+	size, err = ds.SCard(utils.GetTestKey(4))
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(5), size)
+
+	//结果为空时删除destination
+	size, err = ds.SInterStore(utils.GetTestKey(4), utils.GetTestKey(3), utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(0), size)
+	_, err = ds.Type(utils.GetTestKey(4))
+	assert.Equal(t, JDawDB.ErrKeyNotFound, err)
+
+	err = ds.Set(utils.GetTestKey(5), 0, []byte("string"))
+	assert.Nil(t, err)
+	_, err = ds.SUnion(utils.GetTestKey(1), utils.GetTestKey(5))
+	assert.Equal(t, ErrWrongTypeOperation, err)
+}
+
+func TestDataStructure_SMove_Concurrent(t *testing.T) {
+	opts := JDawDB.DefaultOptions
+	dir, _ := os.MkdirTemp("", "JDawDB-smove-concurrent")
+	opts.DirPath = dir
+	ds, err := NewDataStructure(opts)
+	assert.Nil(t, err)
+
+	for i := 0; i < 100; i++ {
+		_, err = ds.SAdd([]byte("src"), []byte(fmt.Sprintf("member-%d", i)))
+		assert.Nil(t, err)
+	}
+
+	// 并发移动同一批member，每个member只会被移动一次，size不会出错
+	var moved int32
+	wg := new(sync.WaitGroup)
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				ok, err := ds.SMove([]byte("src"), []byte("dst"), []byte(fmt.Sprintf("member-%d", j)))
+				assert.Nil(t, err)
+				if ok {
+					atomic.AddInt32(&moved, 1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(100), moved)
+	size, err := ds.SCard([]byte("src"))
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(0), size)
+	size, err = ds.SCard([]byte("dst"))
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(100), size)
+}
+
+func TestDataStructure_SScan(t *testing.T) {
+	opts := JDawDB.DefaultOptions
+	dir, _ := os.MkdirTemp("", "JDawDB-sscan")
+	opts.DirPath = dir
+	ds, err := NewDataStructure(opts)
+	assert.Nil(t, err)
+
+	for i := 0; i < 15; i++ {
+		_, err = ds.SAdd(utils.GetTestKey(1), []byte(fmt.Sprintf("member-%02d", i)))
+		assert.Nil(t, err)
+	}
+
+	next, res, err := ds.SScan(utils.GetTestKey(1), nil, "", 10)
+	assert.Nil(t, err)
+	assert.NotNil(t, next)
+	assert.Equal(t, 10, len(res))
+	assert.Equal(t, []byte("member-00"), res[0])
+
+	next, res, err = ds.SScan(utils.GetTestKey(1), next, "*1?", 10)
+	assert.Nil(t, err)
+	assert.Nil(t, next)
+	assert.Equal(t, [][]byte{[]byte("member-10"), []byte("member-11"), []byte("member-12"), []byte("member-13"), []byte("member-14")}, res)
+}
+
 func TestDataStructure_LPop(t *testing.T) {
 	opts := JDawDB.DefaultOptions
 	dir, _ := os.MkdirTemp("", "JDawDB-lpop")
@@ -238,16 +835,313 @@ func TestDataStructure_RPop(t *testing.T) {
 	assert.NotNil(t, val)
 }
 
-func TestDataStructure_ZScore(t *testing.T) {
+func TestDataStructure_LRange(t *testing.T) {
 	opts := JDawDB.DefaultOptions
-	dir, _ := os.MkdirTemp("", "JDawDB-zset")
+	dir, _ := os.MkdirTemp("", "JDawDB-lrange")
 	opts.DirPath = dir
 	ds, err := NewDataStructure(opts)
 	assert.Nil(t, err)
 
-	ok, err := ds.ZAdd(utils.GetTestKey(1), 113, []byte("val-1"))
+	//超过uint32范围的index也能正确编码和排序
+	lk1 := &ListInternalKey{key: utils.GetTestKey(1), index: initialListMark}
+	lk2 := &ListInternalKey{key: utils.GetTestKey(1), index: initialListMark + 1}
+	assert.True(t, bytes.Compare(lk1.encode(), lk2.encode()) < 0)
+
+	for i := 0; i < 5; i++ {
+		_, err = ds.RPush(utils.GetTestKey(1), []byte(fmt.Sprintf("r%d", i)))
+		assert.Nil(t, err)
+		_, err = ds.LPush(utils.GetTestKey(1), []byte(fmt.Sprintf("l%d", i)))
+		assert.Nil(t, err)
+	}
+
+	size, err := ds.LLen(utils.GetTestKey(1))
 	assert.Nil(t, err)
-	assert.True(t, ok)
+	assert.Equal(t, uint32(10), size)
+
+	elements, err := ds.LRange(utils.GetTestKey(1), 0, -1)
+	assert.Nil(t, err)
+	assert.Equal(t, 10, len(elements))
+	assert.Equal(t, []byte("l4"), elements[0])
+	assert.Equal(t, []byte("r4"), elements[9])
+
+	elements, err = ds.LRange(utils.GetTestKey(1), 3, 5)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("l1"), []byte("l0"), []byte("r0")}, elements)
+	elements, err = ds.LRange(utils.GetTestKey(1), 8, 100)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("r3"), []byte("r4")}, elements)
+	elements, err = ds.LRange(utils.GetTestKey(1), 5, 3)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(elements))
+
+	element, err := ds.LIndex(utils.GetTestKey(1), -2)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("r3"), element)
+	element, err = ds.LIndex(utils.GetTestKey(1), 10)
+	assert.Nil(t, err)
+	assert.Nil(t, element)
+
+	err = ds.LSet(utils.GetTestKey(1), 0, []byte("new"))
+	assert.Nil(t, err)
+	element, err = ds.LIndex(utils.GetTestKey(1), 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("new"), element)
+	err = ds.LSet(utils.GetTestKey(1), 10, []byte("new"))
+	assert.Equal(t, ErrIndexOutOfRange, err)
+	err = ds.LSet(utils.GetTestKey(2), 0, []byte("new"))
+	assert.Equal(t, ErrNoSuchKey, err)
+
+	//弹出之后数据部分也被删除
+	element, err = ds.RPop(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("r4"), element)
+	meta, err := ds.findMetadata(utils.GetTestKey(1), List)
+	assert.Nil(t, err)
+	_, err = ds.db.Get((&ListInternalKey{key: utils.GetTestKey(1), version: meta.version, index: meta.tail}).encode())
+	assert.Equal(t, JDawDB.ErrKeyNotFound, err)
+}
+
+func TestDataStructure_LTrim(t *testing.T) {
+	opts := JDawDB.DefaultOptions
+	dir, _ := os.MkdirTemp("", "JDawDB-ltrim")
+	opts.DirPath = dir
+	ds, err := NewDataStructure(opts)
+	assert.Nil(t, err)
+
+	for i := 0; i < 6; i++ {
+		_, err = ds.RPush(utils.GetTestKey(1), []byte{byte('a' + i)})
+		assert.Nil(t, err)
+	}
+
+	err = ds.LTrim(utils.GetTestKey(1), 1, -2)
+	assert.Nil(t, err)
+	elements, err := ds.LRange(utils.GetTestKey(1), 0, -1)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("b"), []byte("c"), []byte("d"), []byte("e")}, elements)
+
+	_, err = ds.LPush(utils.GetTestKey(1), []byte("z"))
+	assert.Nil(t, err)
+	element, err := ds.LIndex(utils.GetTestKey(1), 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("z"), element)
+
+	err = ds.LTrim(utils.GetTestKey(1), 10, 20)
+	assert.Nil(t, err)
+	size, err := ds.LLen(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(0), size)
+	element, err = ds.LPop(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Nil(t, element)
+}
+
+func TestDataStructure_LInsert(t *testing.T) {
+	opts := JDawDB.DefaultOptions
+	dir, _ := os.MkdirTemp("", "JDawDB-linsert")
+	opts.DirPath = dir
+	ds, err := NewDataStructure(opts)
+	assert.Nil(t, err)
+
+	res, err := ds.LInsert(utils.GetTestKey(1), true, []byte("a"), []byte("x"))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), res)
+
+	for _, e := range []string{"a", "b", "c", "d", "b"} {
+		_, err = ds.RPush(utils.GetTestKey(1), []byte(e))
+		assert.Nil(t, err)
+	}
+
+	res, err = ds.LInsert(utils.GetTestKey(1), true, []byte("b"), []byte("x"))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(6), res)
+	res, err = ds.LInsert(utils.GetTestKey(1), false, []byte("d"), []byte("y"))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(7), res)
+	res, err = ds.LInsert(utils.GetTestKey(1), false, []byte("not-exist"), []byte("y"))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(-1), res)
+
+	elements, err := ds.LRange(utils.GetTestKey(1), 0, -1)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("a"), []byte("x"), []byte("b"), []byte("c"), []byte("d"), []byte("y"), []byte("b")}, elements)
+
+	removed, err := ds.LRem(utils.GetTestKey(1), -1, []byte("b"))
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(1), removed)
+	elements, err = ds.LRange(utils.GetTestKey(1), 0, -1)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("a"), []byte("x"), []byte("b"), []byte("c"), []byte("d"), []byte("y")}, elements)
+
+	_, err = ds.RPush(utils.GetTestKey(1), []byte("x"))
+	assert.Nil(t, err)
+	removed, err = ds.LRem(utils.GetTestKey(1), 0, []byte("x"))
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(2), removed)
+	elements, err = ds.LRange(utils.GetTestKey(1), 0, -1)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("a"), []byte("b"), []byte("c"), []byte("d"), []byte("y")}, elements)
+	element, err := ds.RPop(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("y"), element)
+}
+
+func TestDataStructure_LMove(t *testing.T) {
+	opts := JDawDB.DefaultOptions
+	dir, _ := os.MkdirTemp("", "JDawDB-lmove")
+	opts.DirPath = dir
+	ds, err := NewDataStructure(opts)
+	assert.Nil(t, err)
+
+	size, err := ds.LPushX(utils.GetTestKey(1), []byte("a"))
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(0), size)
+
+	for _, e := range []string{"a", "b", "c"} {
+		_, err = ds.RPush(utils.GetTestKey(1), []byte(e))
+		assert.Nil(t, err)
+	}
+	size, err = ds.RPushX(utils.GetTestKey(1), []byte("d"))
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(4), size)
+
+	element, err := ds.LMove(utils.GetTestKey(1), utils.GetTestKey(2), false, true)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("d"), element)
+	element, err = ds.LMove(utils.GetTestKey(1), utils.GetTestKey(2), true, true)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("a"), element)
+
+	elements, err := ds.LRange(utils.GetTestKey(2), 0, -1)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("a"), []byte("d")}, elements)
+
+	//source和destination相同时旋转list
+	element, err = ds.LMove(utils.GetTestKey(1), utils.GetTestKey(1), true, false)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("b"), element)
+	elements, err = ds.LRange(utils.GetTestKey(1), 0, -1)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("c"), []byte("b")}, elements)
+
+	element, err = ds.LMove(utils.GetTestKey(3), utils.GetTestKey(1), true, true)
+	assert.Nil(t, err)
+	assert.Nil(t, element)
+}
+
+func TestDataStructure_BLPop(t *testing.T) {
+	opts := JDawDB.DefaultOptions
+	dir, _ := os.MkdirTemp("", "JDawDB-blpop")
+	opts.DirPath = dir
+	ds, err := NewDataStructure(opts)
+	assert.Nil(t, err)
+
+	//list不为空时直接返回
+	_, err = ds.RPush(utils.GetTestKey(2), []byte("val-1"))
+	assert.Nil(t, err)
+	key, element, err := ds.BLPop(time.Second, utils.GetTestKey(1), utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.Equal(t, utils.GetTestKey(2), key)
+	assert.Equal(t, []byte("val-1"), element)
+
+	//超时
+	start := time.Now()
+	key, element, err = ds.BRPop(time.Millisecond*50, utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Nil(t, key)
+	assert.Nil(t, element)
+	assert.True(t, time.Since(start) >= time.Millisecond*50)
+
+	//多个等待者，每个写入只会被一个等待者取到
+	const n = 10
+	results := make(chan []byte, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, element, err := ds.BLPop(0, utils.GetTestKey(1))
+			assert.Nil(t, err)
+			results <- element
+		}()
+	}
+	time.Sleep(time.Millisecond * 20)
+	for i := 0; i < n; i++ {
+		_, err = ds.RPush(utils.GetTestKey(1), []byte(fmt.Sprintf("val-%d", i)))
+		assert.Nil(t, err)
+	}
+	wg.Wait()
+	close(results)
+	seen := make(map[string]bool)
+	for element := range results {
+		assert.False(t, seen[string(element)])
+		seen[string(element)] = true
+	}
+	assert.Equal(t, n, len(seen))
+
+	//ctx取消后停止等待，之后的写入不会被取走
+	ctx, cancel := context.WithCancel(context.Background())
+	cancelled := make(chan error)
+	go func() {
+		_, _, err := ds.BLPopCtx(ctx, 0, utils.GetTestKey(4))
+		cancelled <- err
+	}()
+	time.Sleep(time.Millisecond * 20)
+	cancel()
+	assert.Equal(t, context.Canceled, <-cancelled)
+	_, err = ds.RPush(utils.GetTestKey(4), []byte("val-1"))
+	assert.Nil(t, err)
+	size, err := ds.LLen(utils.GetTestKey(4))
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(1), size)
+
+	//关闭时唤醒等待者
+	done := make(chan error)
+	go func() {
+		_, _, err := ds.BLPop(0, utils.GetTestKey(3))
+		done <- err
+	}()
+	time.Sleep(time.Millisecond * 20)
+	assert.Nil(t, ds.Close())
+	assert.Equal(t, ErrClosed, <-done)
+}
+
+func TestDataStructure_BLMove(t *testing.T) {
+	opts := JDawDB.DefaultOptions
+	dir, _ := os.MkdirTemp("", "JDawDB-blmove")
+	opts.DirPath = dir
+	ds, err := NewDataStructure(opts)
+	assert.Nil(t, err)
+
+	done := make(chan []byte)
+	go func() {
+		element, err := ds.BLMove(utils.GetTestKey(1), utils.GetTestKey(2), false, true, time.Second*5)
+		assert.Nil(t, err)
+		done <- element
+	}()
+	time.Sleep(time.Millisecond * 20)
+	_, err = ds.LPush(utils.GetTestKey(1), []byte("job"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("job"), <-done)
+
+	element, err := ds.LIndex(utils.GetTestKey(2), 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("job"), element)
+
+	element, err = ds.BLMove(utils.GetTestKey(1), utils.GetTestKey(2), false, true, time.Millisecond*10)
+	assert.Nil(t, err)
+	assert.Nil(t, element)
+}
+
+func TestDataStructure_ZScore(t *testing.T) {
+	opts := JDawDB.DefaultOptions
+	dir, _ := os.MkdirTemp("", "JDawDB-zset")
+	opts.DirPath = dir
+	ds, err := NewDataStructure(opts)
+	assert.Nil(t, err)
+
+	ok, err := ds.ZAdd(utils.GetTestKey(1), 113, []byte("val-1"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
 	ok, err = ds.ZAdd(utils.GetTestKey(1), 333, []byte("val-1"))
 	assert.Nil(t, err)
 	assert.False(t, ok)
@@ -262,3 +1156,456 @@ func TestDataStructure_ZScore(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, float64(98), score)
 }
+
+func TestDataStructure_ZRange(t *testing.T) {
+	opts := JDawDB.DefaultOptions
+	dir, _ := os.MkdirTemp("", "JDawDB-zrange")
+	opts.DirPath = dir
+	ds, err := NewDataStructure(opts)
+	assert.Nil(t, err)
+
+	//score按照数值排序，而不是文本
+	scores := map[string]float64{"a": 10, "b": 9, "c": -2.5, "d": 100, "e": 0}
+	for member, score := range scores {
+		_, err = ds.ZAdd(utils.GetTestKey(1), score, []byte(member))
+		assert.Nil(t, err)
+	}
+	_, err = ds.ZAdd(utils.GetTestKey(1), -20, []byte("b"))
+	assert.Nil(t, err)
+
+	members, err := ds.ZRange(utils.GetTestKey(1), 0, -1)
+	assert.Nil(t, err)
+	assert.Equal(t, []ZMember{{[]byte("b"), -20}, {[]byte("c"), -2.5}, {[]byte("e"), 0}, {[]byte("a"), 10}, {[]byte("d"), 100}}, members)
+	members, err = ds.ZRevRange(utils.GetTestKey(1), 0, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, []ZMember{{[]byte("d"), 100}, {[]byte("a"), 10}}, members)
+
+	rank, err := ds.ZRank(utils.GetTestKey(1), []byte("e"))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), rank)
+	rank, err = ds.ZRevRank(utils.GetTestKey(1), []byte("b"))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(4), rank)
+	rank, err = ds.ZRank(utils.GetTestKey(1), []byte("not-exist"))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(-1), rank)
+
+	members, err = ds.ZRangeByScore(utils.GetTestKey(1), ScoreRange{Min: -2.5, Max: 10, MinExclusive: true}, 0, -1)
+	assert.Nil(t, err)
+	assert.Equal(t, []ZMember{{[]byte("e"), 0}, {[]byte("a"), 10}}, members)
+	members, err = ds.ZRangeByScore(utils.GetTestKey(1), ScoreRange{Min: math.Inf(-1), Max: math.Inf(1)}, 1, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, []ZMember{{[]byte("c"), -2.5}, {[]byte("e"), 0}}, members)
+	members, err = ds.ZRevRangeByScore(utils.GetTestKey(1), ScoreRange{Min: 0, Max: 100, MaxExclusive: true}, 0, -1)
+	assert.Nil(t, err)
+	assert.Equal(t, []ZMember{{[]byte("a"), 10}, {[]byte("e"), 0}}, members)
+
+	count, err := ds.ZCount(utils.GetTestKey(1), ScoreRange{Min: math.Inf(-1), Max: 0})
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(3), count)
+
+	score, err := ds.ZIncrBy(utils.GetTestKey(1), 200, []byte("c"))
+	assert.Nil(t, err)
+	assert.Equal(t, 197.5, score)
+	members, err = ds.ZPopMax(utils.GetTestKey(1), 1)
+	assert.Nil(t, err)
+	assert.Equal(t, []ZMember{{[]byte("c"), 197.5}}, members)
+	members, err = ds.ZPopMin(utils.GetTestKey(1), 1)
+	assert.Nil(t, err)
+	assert.Equal(t, []ZMember{{[]byte("b"), -20}}, members)
+
+	removed, err := ds.ZRemRangeByScore(utils.GetTestKey(1), ScoreRange{Min: 5, Max: 100})
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(2), removed)
+	ok, err := ds.ZRem(utils.GetTestKey(1), []byte("e"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	size, err := ds.ZCard(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(0), size)
+	members, err = ds.ZRange(utils.GetTestKey(1), 0, -1)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(members))
+}
+
+func TestDataStructure_ZIncrBy_Concurrent(t *testing.T) {
+	opts := JDawDB.DefaultOptions
+	dir, _ := os.MkdirTemp("", "JDawDB-zincrby-concurrent")
+	opts.DirPath = dir
+	ds, err := NewDataStructure(opts)
+	assert.Nil(t, err)
+
+	// 并发的读后写不会丢失更新，score部分也不会残留旧的数据
+	wg := new(sync.WaitGroup)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				_, err := ds.ZIncrBy([]byte("zset"), 1, []byte("counter"))
+				assert.Nil(t, err)
+				_, err = ds.ZAdd([]byte("zset"), 0, []byte(fmt.Sprintf("member-%d-%d", i, j)))
+				assert.Nil(t, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	score, err := ds.ZScore([]byte("zset"), []byte("counter"))
+	assert.Nil(t, err)
+	assert.Equal(t, float64(800), score)
+	size, err := ds.ZCard([]byte("zset"))
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(801), size)
+	members, err := ds.ZRange([]byte("zset"), 0, -1)
+	assert.Nil(t, err)
+	assert.Equal(t, 801, len(members))
+}
+
+func TestDataStructure_ZRangeByLex(t *testing.T) {
+	opts := JDawDB.DefaultOptions
+	dir, _ := os.MkdirTemp("", "JDawDB-zrangebylex")
+	opts.DirPath = dir
+	ds, err := NewDataStructure(opts)
+	assert.Nil(t, err)
+
+	for _, member := range []string{"d", "a", "c", "b", "e"} {
+		_, err = ds.ZAdd(utils.GetTestKey(1), 0, []byte(member))
+		assert.Nil(t, err)
+	}
+
+	members, err := ds.ZRangeByLex(utils.GetTestKey(1), LexRange{Min: []byte("b"), Max: []byte("d"), MaxExclusive: true}, 0, -1)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("b"), []byte("c")}, members)
+	members, err = ds.ZRangeByLex(utils.GetTestKey(1), LexRange{Min: []byte("b")}, 1, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("c"), []byte("d")}, members)
+}
+
+// setBitmapBytes 按位写入value，用于构造和redis文档中相同的数据
+func setBitmapBytes(t *testing.T, ds *DataStructure, key []byte, value []byte) {
+	for i, b := range value {
+		for j := 0; j < 8; j++ {
+			_, err := ds.SetBit(key, uint64(i*8+j), b>>(7-j)&1)
+			assert.Nil(t, err)
+		}
+	}
+}
+
+func TestDataStructure_SetBit(t *testing.T) {
+	opts := JDawDB.DefaultOptions
+	dir, _ := os.MkdirTemp("", "JDawDB-redis-setbit")
+	opts.DirPath = dir
+	ds, err := NewDataStructure(opts)
+	assert.Nil(t, err)
+
+	old, err := ds.SetBit([]byte("bitmap"), 7, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, byte(0), old)
+	old, err = ds.SetBit([]byte("bitmap"), 7, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, byte(1), old)
+
+	// 很大的偏移只写入一个分段
+	_, err = ds.SetBit([]byte("bitmap"), maxBitOffset, 1)
+	assert.Nil(t, err)
+	bit, err := ds.GetBit([]byte("bitmap"), maxBitOffset)
+	assert.Nil(t, err)
+	assert.Equal(t, byte(1), bit)
+	bit, err = ds.GetBit([]byte("bitmap"), 100)
+	assert.Nil(t, err)
+	assert.Equal(t, byte(0), bit)
+	//元数据、一个分段以及zset和list编码的标记
+	assert.Equal(t, 4, len(ds.db.ListKeys()))
+
+	_, err = ds.SetBit([]byte("bitmap"), maxBitOffset+1, 1)
+	assert.Equal(t, ErrBitOffsetOutOfRange, err)
+	_, err = ds.SetBit([]byte("bitmap"), 0, 2)
+	assert.Equal(t, ErrBitValue, err)
+
+	err = ds.Set([]byte("str"), 0, []byte("v"))
+	assert.Nil(t, err)
+	_, err = ds.SetBit([]byte("str"), 0, 1)
+	assert.Equal(t, ErrWrongTypeOperation, err)
+
+	typ, err := ds.Type([]byte("bitmap"))
+	assert.Nil(t, err)
+	assert.Equal(t, "bitmap", TypeName(typ))
+}
+
+func TestDataStructure_BitCount_BitPos(t *testing.T) {
+	opts := JDawDB.DefaultOptions
+	dir, _ := os.MkdirTemp("", "JDawDB-redis-bitcount")
+	opts.DirPath = dir
+	ds, err := NewDataStructure(opts)
+	assert.Nil(t, err)
+
+	setBitmapBytes(t, ds, []byte("foobar"), []byte("foobar"))
+	countCases := []struct {
+		r     *BitRange
+		count int64
+	}{
+		{nil, 26},
+		{&BitRange{Start: 0, End: 0, HasEnd: true}, 4},
+		{&BitRange{Start: 1, End: 1, HasEnd: true}, 6},
+		{&BitRange{Start: 5, End: 30, HasEnd: true, Bit: true}, 17},
+		{&BitRange{Start: -2, End: -1, HasEnd: true}, 7},
+		{&BitRange{Start: 10, End: 20, HasEnd: true}, 0},
+	}
+	for _, c := range countCases {
+		count, err := ds.BitCount([]byte("foobar"), c.r)
+		assert.Nil(t, err)
+		assert.Equal(t, c.count, count)
+	}
+
+	setBitmapBytes(t, ds, []byte("a"), []byte{0xff, 0xf0, 0x00})
+	setBitmapBytes(t, ds, []byte("b"), []byte{0x00, 0xff, 0xf0})
+	setBitmapBytes(t, ds, []byte("c"), []byte{0xff, 0xff, 0xff})
+	posCases := []struct {
+		key string
+		bit byte
+		r   *BitRange
+		pos int64
+	}{
+		{"a", 0, nil, 12},
+		{"b", 1, &BitRange{Start: 0}, 8},
+		{"b", 1, &BitRange{Start: 2}, 16},
+		{"b", 1, &BitRange{Start: 2, End: -1, HasEnd: true}, 16},
+		{"b", 1, &BitRange{Start: 7, End: 15, HasEnd: true, Bit: true}, 8},
+		{"c", 0, nil, 24},
+		{"c", 0, &BitRange{Start: 0, End: -1, HasEnd: true}, -1},
+		{"missing", 1, nil, -1},
+		{"missing", 0, nil, 0},
+	}
+	for _, c := range posCases {
+		pos, err := ds.BitPos([]byte(c.key), c.bit, c.r)
+		assert.Nil(t, err)
+		assert.Equal(t, c.pos, pos)
+	}
+
+	// 中间有不存在的分段
+	_, err = ds.SetBit([]byte("sparse"), 0, 1)
+	assert.Nil(t, err)
+	_, err = ds.SetBit([]byte("sparse"), bitmapSegmentSize*8*3, 1)
+	assert.Nil(t, err)
+	pos, err := ds.BitPos([]byte("sparse"), 1, &BitRange{Start: 1})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(bitmapSegmentSize*3), pos/8)
+	pos, err = ds.BitPos([]byte("sparse"), 0, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), pos)
+	count, err := ds.BitCount([]byte("sparse"), nil)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), count)
+}
+
+func TestDataStructure_BitOp(t *testing.T) {
+	opts := JDawDB.DefaultOptions
+	dir, _ := os.MkdirTemp("", "JDawDB-redis-bitop")
+	opts.DirPath = dir
+	ds, err := NewDataStructure(opts)
+	assert.Nil(t, err)
+
+	setBitmapBytes(t, ds, []byte("a"), []byte{0xf0, 0x0f})
+	setBitmapBytes(t, ds, []byte("b"), []byte{0xff})
+
+	cases := []struct {
+		op    BitOperation
+		keys  []string
+		value []byte
+	}{
+		{BitAnd, []string{"a", "b"}, []byte{0xf0, 0x00}},
+		{BitOr, []string{"a", "b"}, []byte{0xff, 0x0f}},
+		{BitXor, []string{"a", "b"}, []byte{0x0f, 0x0f}},
+		{BitNot, []string{"a"}, []byte{0x0f, 0xf0}},
+		{BitAnd, []string{"a", "missing"}, []byte{0x00, 0x00}},
+	}
+	for _, c := range cases {
+		keys := make([][]byte, len(c.keys))
+		for i, key := range c.keys {
+			keys[i] = []byte(key)
+		}
+		size, err := ds.BitOp(c.op, []byte("dest"), keys...)
+		assert.Nil(t, err)
+		assert.Equal(t, int64(len(c.value)), size)
+
+		meta, err := ds.findMetadata([]byte("dest"), Bitmap)
+		assert.Nil(t, err)
+		value, err := ds.bitmapBytes([]byte("dest"), meta)
+		assert.Nil(t, err)
+		assert.Equal(t, c.value, value)
+	}
+
+	_, err = ds.BitOp(BitNot, []byte("dest"), []byte("a"), []byte("b"))
+	assert.Equal(t, ErrBitOpNotArgs, err)
+
+	// 结果为空时删除destination
+	size, err := ds.BitOp(BitOr, []byte("dest"), []byte("missing"))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), size)
+	n, err := ds.Exists([]byte("dest"))
+	assert.Nil(t, err)
+	assert.Equal(t, 0, n)
+}
+
+func TestDataStructure_BitField(t *testing.T) {
+	opts := JDawDB.DefaultOptions
+	dir, _ := os.MkdirTemp("", "JDawDB-redis-bitfield")
+	opts.DirPath = dir
+	ds, err := NewDataStructure(opts)
+	assert.Nil(t, err)
+
+	values := func(res []*int64) []interface{} {
+		out := make([]interface{}, len(res))
+		for i, v := range res {
+			if v != nil {
+				out[i] = *v
+			}
+		}
+		return out
+	}
+
+	res, err := ds.BitField([]byte("bf"), []BitFieldOp{
+		{Command: BitFieldIncrBy, Signed: true, Bits: 5, Offset: 100, Value: 1},
+		{Command: BitFieldGet, Bits: 4, Offset: 0},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []interface{}{int64(1), int64(0)}, values(res))
+
+	// 和redis文档中的溢出示例相同
+	expected := [][]interface{}{{int64(1), int64(1)}, {int64(2), int64(2)}, {int64(3), int64(3)}, {int64(0), int64(3)}}
+	for _, e := range expected {
+		res, err = ds.BitField([]byte("overflow"), []BitFieldOp{
+			{Command: BitFieldIncrBy, Bits: 2, Offset: 100, Value: 1},
+			{Command: BitFieldIncrBy, Bits: 2, Offset: 102, Value: 1, Overflow: BitFieldSat},
+		})
+		assert.Nil(t, err)
+		assert.Equal(t, e, values(res))
+	}
+	res, err = ds.BitField([]byte("overflow"), []BitFieldOp{
+		{Command: BitFieldIncrBy, Bits: 2, Offset: 102, Value: 1, Overflow: BitFieldFail},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []interface{}{nil}, values(res))
+
+	res, err = ds.BitField([]byte("signed"), []BitFieldOp{
+		{Command: BitFieldSet, Signed: true, Bits: 8, Offset: 0, Value: 127},
+		{Command: BitFieldIncrBy, Signed: true, Bits: 8, Offset: 0, Value: 1},
+		{Command: BitFieldIncrBy, Signed: true, Bits: 8, Offset: 0, Value: -1, Overflow: BitFieldSat},
+		{Command: BitFieldSet, Bits: 8, Offset: 8, Value: 300},
+		{Command: BitFieldGet, Bits: 8, Offset: 8},
+		{Command: BitFieldSet, Signed: true, Bits: 64, Offset: 16, Value: math.MinInt64},
+		{Command: BitFieldIncrBy, Signed: true, Bits: 64, Offset: 16, Value: -1, Overflow: BitFieldSat},
+		{Command: BitFieldGet, Signed: true, Bits: 64, Offset: 16},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []interface{}{int64(0), int64(-128), int64(-128), int64(0), int64(44),
+		int64(0), int64(math.MinInt64), int64(math.MinInt64)}, values(res))
+
+	_, err = ds.BitField([]byte("bf"), []BitFieldOp{{Command: BitFieldGet, Bits: 64, Offset: 0}})
+	assert.Equal(t, ErrBitFieldType, err)
+}
+
+func TestDataStructure_PFAdd_PFCount(t *testing.T) {
+	opts := JDawDB.DefaultOptions
+	dir, _ := os.MkdirTemp("", "JDawDB-redis-pfadd")
+	opts.DirPath = dir
+	ds, err := NewDataStructure(opts)
+	assert.Nil(t, err)
+
+	// 没有元素时也会创建key
+	ok, err := ds.PFAdd([]byte("hll"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	ok, err = ds.PFAdd([]byte("hll"), []byte("a"), []byte("b"), []byte("c"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	ok, err = ds.PFAdd([]byte("hll"), []byte("a"))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	count, err := ds.PFCount([]byte("hll"))
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(3), count)
+
+	typ, err := ds.Type([]byte("hll"))
+	assert.Nil(t, err)
+	assert.Equal(t, "hyperloglog", TypeName(typ))
+
+	// 误差在标准误差0.81%的3倍以内，同时覆盖sparse转换为dense
+	for _, n := range []int{1000, 100000} {
+		key := []byte(fmt.Sprintf("hll-%d", n))
+		for i := 0; i < n; i += 100 {
+			elements := make([][]byte, 0, 100)
+			for j := i; j < i+100; j++ {
+				elements = append(elements, []byte(fmt.Sprintf("element-%d", j)))
+			}
+			_, err = ds.PFAdd(key, elements...)
+			assert.Nil(t, err)
+		}
+		count, err = ds.PFCount(key)
+		assert.Nil(t, err)
+		assert.InDelta(t, n, count, float64(n)*0.0081*3)
+	}
+	encValue, err := ds.db.Get([]byte("hll-1000"))
+	assert.Nil(t, err)
+	payload, _ := decodeString(encValue)
+	assert.Equal(t, hllSparse, payload[0])
+	encValue, err = ds.db.Get([]byte("hll-100000"))
+	assert.Nil(t, err)
+	payload, _ = decodeString(encValue)
+	assert.Equal(t, hllDense, payload[0])
+
+	// 过期时间
+	_, err = ds.Expire([]byte("hll"), time.Minute)
+	assert.Nil(t, err)
+	count, err = ds.PFCount([]byte("hll"))
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(3), count)
+
+	err = ds.Set([]byte("str"), 0, []byte("v"))
+	assert.Nil(t, err)
+	_, err = ds.PFAdd([]byte("str"), []byte("a"))
+	assert.Equal(t, ErrWrongTypeOperation, err)
+	_, err = ds.PFCount([]byte("hll"), []byte("str"))
+	assert.Equal(t, ErrWrongTypeOperation, err)
+}
+
+func TestDataStructure_PFMerge(t *testing.T) {
+	opts := JDawDB.DefaultOptions
+	dir, _ := os.MkdirTemp("", "JDawDB-redis-pfmerge")
+	opts.DirPath = dir
+	ds, err := NewDataStructure(opts)
+	assert.Nil(t, err)
+
+	// 两个key各有10000个独有的元素，共享5000个元素
+	for i, key := range []string{"hll1", "hll2"} {
+		elements := make([][]byte, 0, 15000)
+		for j := 0; j < 10000; j++ {
+			elements = append(elements, []byte(fmt.Sprintf("element-%d", i*10000+j)))
+		}
+		for j := 0; j < 5000; j++ {
+			elements = append(elements, []byte(fmt.Sprintf("shared-%d", j)))
+		}
+		_, err = ds.PFAdd([]byte(key), elements...)
+		assert.Nil(t, err)
+	}
+
+	count, err := ds.PFCount([]byte("hll1"), []byte("hll2"), []byte("missing"))
+	assert.Nil(t, err)
+	assert.InDelta(t, 25000, count, 25000*0.0081*3)
+
+	_, err = ds.PFAdd([]byte("dest"), []byte("dest-only"))
+	assert.Nil(t, err)
+	err = ds.PFMerge([]byte("dest"), []byte("hll1"), []byte("hll2"))
+	assert.Nil(t, err)
+	merged, err := ds.PFCount([]byte("dest"))
+	assert.Nil(t, err)
+	assert.InDelta(t, 25001, merged, 25001*0.0081*3)
+
+	hll, _, _, err := ds.getHyperLogLog([]byte("dest"))
+	assert.Nil(t, err)
+	assert.True(t, hll.dense)
+	decoded, ok := decodeHyperLogLog(hll.encode())
+	assert.True(t, ok)
+	assert.Equal(t, hll.registers, decoded.registers)
+}
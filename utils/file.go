@@ -2,7 +2,9 @@ package utils
 
 import (
 	"io/fs"
+	"os"
 	"path/filepath"
+	"strings"
 	"syscall"
 	"unsafe"
 )
@@ -22,6 +24,45 @@ func DirSize(dirPath string) (int64, error) {
 	return size, err
 }
 
+// CopyDir 拷贝数据目录，exclude中的文件不拷贝
+func CopyDir(src, dest string, exclude []string) error {
+	if _, err := os.Stat(dest); os.IsNotExist(err) {
+		if err := os.MkdirAll(dest, os.ModePerm); err != nil {
+			return err
+		}
+	}
+
+	return filepath.Walk(src, func(path string, info fs.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		fileName := strings.Replace(path, src, "", 1)
+		if fileName == "" {
+			return nil
+		}
+
+		for _, e := range exclude {
+			matched, err := filepath.Match(e, info.Name())
+			if err != nil {
+				return err
+			}
+			if matched {
+				return nil
+			}
+		}
+
+		if info.IsDir() {
+			return os.MkdirAll(filepath.Join(dest, fileName), info.Mode())
+		}
+
+		data, err := os.ReadFile(filepath.Join(src, fileName))
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(filepath.Join(dest, fileName), data, info.Mode())
+	})
+}
+
 // AvailableDiskSizeOnWin Windows系统获取磁盘剩余可用空间大小
 func AvailableDiskSizeOnWin() (uint64, error) {
 
@@ -1,6 +1,10 @@
 package utils
 
-import "strconv"
+import (
+	"encoding/binary"
+	"math"
+	"strconv"
+)
 
 func FloatFromBytes(val []byte) float64 {
 	f, _ := strconv.ParseFloat(string(val), 64)
@@ -10,3 +14,28 @@ func FloatFromBytes(val []byte) float64 {
 func Float64ToBytes(val float64) []byte {
 	return []byte(strconv.FormatFloat(val, 'f', -1, 64))
 }
+
+// Float64ToOrderedBytes 将float64编码为8个字节，编码结果按字节比较的顺序和数值的顺序一致
+// 正数翻转符号位，负数翻转所有位，再按大端存放
+func Float64ToOrderedBytes(val float64) []byte {
+	bits := math.Float64bits(val)
+	if bits&(1<<63) != 0 {
+		bits = ^bits
+	} else {
+		bits |= 1 << 63
+	}
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, bits)
+	return buf
+}
+
+// FloatFromOrderedBytes Float64ToOrderedBytes的逆过程
+func FloatFromOrderedBytes(buf []byte) float64 {
+	bits := binary.BigEndian.Uint64(buf)
+	if bits&(1<<63) != 0 {
+		bits &^= 1 << 63
+	} else {
+		bits = ^bits
+	}
+	return math.Float64frombits(bits)
+}
@@ -0,0 +1,24 @@
+package utils
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"math"
+	"sort"
+	"testing"
+)
+
+func TestFloat64ToOrderedBytes(t *testing.T) {
+	values := []float64{math.Inf(-1), -math.MaxFloat64, -100.5, -1, -math.SmallestNonzeroFloat64, 0, math.SmallestNonzeroFloat64, 0.5, 1, 2, 100.25, math.MaxFloat64, math.Inf(1)}
+	encoded := make([][]byte, len(values))
+	for i, v := range values {
+		encoded[i] = Float64ToOrderedBytes(v)
+		assert.Equal(t, v, FloatFromOrderedBytes(encoded[i]))
+	}
+	assert.True(t, sort.SliceIsSorted(encoded, func(i, j int) bool {
+		return bytes.Compare(encoded[i], encoded[j]) < 0
+	}))
+	for i := 1; i < len(encoded); i++ {
+		assert.True(t, bytes.Compare(encoded[i-1], encoded[i]) < 0)
+	}
+}
@@ -0,0 +1,19 @@
+package utils
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestMurmurHash64A(t *testing.T) {
+	data := []byte("0123456789abcdefg")
+	seen := make(map[uint64]bool)
+	// 覆盖所有长度的剩余部分
+	for i := 0; i <= len(data); i++ {
+		h := MurmurHash64A(data[:i], 0xadc83b19)
+		assert.Equal(t, h, MurmurHash64A(data[:i], 0xadc83b19))
+		assert.False(t, seen[h])
+		seen[h] = true
+	}
+	assert.NotEqual(t, MurmurHash64A(data, 1), MurmurHash64A(data, 2))
+}